@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// adaptiveWarmup is how long -adaptive-quality measures a download's
+// speed before judging it.
+const adaptiveWarmup = 10 * time.Second
+
+// tooSlowError is a video stream that, at the speed measured, would not
+// finish within -time-budget.
+type tooSlowError struct {
+	height int
+	rate   float64 // bytes/s
+	eta    time.Duration
+}
+
+func (e *tooSlowError) Error() string {
+	return fmt.Sprintf("at %s, the %dp download would take another %s", formatRate(e.rate), e.height, formatDuration(e.eta))
+}
+
+// budgetReader fails with a tooSlowError once the job's measured speed
+// says its download won't finish within budget of the job's first
+// attempt, however many streams and retries it has taken since.
+type budgetReader struct {
+	r         io.Reader
+	job       *Job
+	height    int
+	budget    time.Duration
+	start     time.Time // when the stream started, to measure its speed
+	from      int64     // the job's bytes when the stream started
+	lastCheck time.Time
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	now := time.Now()
+	if err != nil || now.Sub(b.start) < adaptiveWarmup || now.Sub(b.lastCheck) < time.Second {
+		return n, err
+	}
+	b.lastCheck = now
+	downloaded, total := b.job.byteCounts()
+	rate := float64(downloaded-b.from) / now.Sub(b.start).Seconds()
+	if rate <= 0 || total <= downloaded {
+		return n, nil
+	}
+	eta := time.Duration(float64(total-downloaded) / rate * float64(time.Second))
+	if now.Sub(b.job.budgetStart)+eta > b.budget {
+		return n, &tooSlowError{height: b.height, rate: rate, eta: eta}
+	}
+	return n, nil
+}
+
+// watchBudget wraps the video stream of a job that has a lower resolution
+// to fall back to with -adaptive-quality.
+func (d *Downloader) watchBudget(job *Job, format *youtube.Format, stream io.Reader) io.Reader {
+	if !job.adaptive || !hasVideo(format) {
+		return stream
+	}
+	downloaded, _ := job.byteCounts()
+	return &budgetReader{r: stream, job: job, height: format.Height, budget: d.config.TimeBudget, start: time.Now(), from: downloaded}
+}
+
+// canDowngrade reports whether video can be downloaded below height,
+// which -adaptive-quality falls back to when the download is too slow.
+func (d *Downloader) canDowngrade(job *Job, video *youtube.Video, height int) bool {
+	if height <= 0 {
+		return false
+	}
+	prev := job.maxHeight
+	job.maxHeight = height - 1
+	sel, err := d.selectFormats(job, video)
+	job.maxHeight = prev
+	return err == nil && sel.video != nil && sel.video.Height < height
+}
+
+// capHeight leaves out the video formats above maxHeight, if it is set.
+func capHeight(formats youtube.FormatList, maxHeight int) youtube.FormatList {
+	if maxHeight <= 0 {
+		return formats
+	}
+	var capped youtube.FormatList
+	for _, f := range formats {
+		if !hasVideo(&f) || f.Height <= maxHeight {
+			capped = append(capped, f)
+		}
+	}
+	return capped
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// mediaTrack is an extra input of an -all-tracks merge: an audio track in
+// another language, or a subtitle track.
+type mediaTrack struct {
+	path     string
+	subtitle bool
+	lang     string // as YouTube gives it, e.g. "en" or "es-419"
+	title    string
+}
+
+// matroskaLanguages maps ISO 639-1 codes to the ISO 639-2/B codes
+// Matroska tags tracks with. Others are tagged as YouTube gives them.
+var matroskaLanguages = map[string]string{
+	"ar": "ara", "bg": "bul", "bn": "ben", "ca": "cat", "cs": "cze", "da": "dan",
+	"de": "ger", "el": "gre", "en": "eng", "es": "spa", "et": "est", "fa": "per",
+	"fi": "fin", "fr": "fre", "he": "heb", "hi": "hin", "hr": "hrv", "hu": "hun",
+	"id": "ind", "it": "ita", "ja": "jpn", "ko": "kor", "lt": "lit", "lv": "lav",
+	"ms": "may", "nl": "dut", "no": "nor", "pl": "pol", "pt": "por", "ro": "rum",
+	"ru": "rus", "sk": "slo", "sl": "slv", "sr": "srp", "sv": "swe", "ta": "tam",
+	"te": "tel", "th": "tha", "tr": "tur", "uk": "ukr", "ur": "urd", "vi": "vie",
+	"zh": "chi",
+}
+
+func matroskaLanguage(lang string) string {
+	primary, _, _ := strings.Cut(lang, "-")
+	if code, ok := matroskaLanguages[strings.ToLower(primary)]; ok {
+		return code
+	}
+	return lang
+}
+
+// otherAudioTracks returns the best audio-only format of each language
+// other than chosen's.
+func otherAudioTracks(video *youtube.Video, chosen *youtube.Format) []*youtube.Format {
+	best := make(map[string]*youtube.Format)
+	var langs []string
+	for i := range video.Formats {
+		f := &video.Formats[i]
+		lang := audioLanguage(f)
+		if lang == "" || lang == audioLanguage(chosen) || hasVideo(f) || !hasAudio(f) {
+			continue
+		}
+		prev, seen := best[lang]
+		if !seen {
+			langs = append(langs, lang)
+		}
+		if !seen || slices.Compare(formatRank(f), formatRank(prev)) > 0 {
+			best[lang] = f
+		}
+	}
+	formats := make([]*youtube.Format, len(langs))
+	for i, lang := range langs {
+		formats[i] = best[lang]
+	}
+	return formats
+}
+
+// fetchAllTracks downloads the audio tracks in other languages and the
+// subtitles -all-tracks adds to a merge. A subtitle track that fails is
+// left out; an audio track that fails fails the download, which would
+// otherwise be incomplete.
+func (d *Downloader) fetchAllTracks(ctx context.Context, job *Job, video *youtube.Video, chosen *youtube.Format, tempPath string) ([]mediaTrack, error) {
+	var tracks []mediaTrack
+	others := otherAudioTracks(video, chosen)
+	for _, f := range others {
+		job.addTotal(d.fetchLength(job, video, f))
+	}
+	for _, f := range others {
+		lang := audioLanguage(f)
+		path := tempPath + ".audio." + lang
+		job.setPhase(PhaseDownloadingAudio)
+		if _, err := d.fetchFormat(ctx, job, video, f, path, fmt.Sprintf("%s (%s audio)", video.Title, lang)); err != nil {
+			d.removePartial(path)
+			d.discardTracks(tracks)
+			return nil, err
+		}
+		tracks = append(tracks, mediaTrack{path: path, lang: lang, title: f.LanguageDisplayName()})
+	}
+
+	for _, t := range subtitleTracks(video.CaptionTracks, []string{"all"}) {
+		path := tempPath + "." + t.LanguageCode + ".vtt"
+		d.trackTemp(path)
+		if err := d.fetchSubtitle(ctx, t, path); err != nil {
+			d.logger.Printf("Leaving out %s subtitles of %s: %v", t.LanguageCode, video.Title, err)
+			d.discardTemp(path)
+			continue
+		}
+		title := t.Name.SimpleText
+		if t.Kind == "asr" && !strings.Contains(strings.ToLower(title), "auto") {
+			title = strings.TrimSpace(title + " (auto-generated)")
+		}
+		tracks = append(tracks, mediaTrack{path: path, subtitle: true, lang: t.LanguageCode, title: title})
+	}
+	if len(tracks) > 0 {
+		d.logger.Printf("Adding %d more audio and %d subtitle tracks to %s", len(others), len(tracks)-len(others), video.Title)
+	}
+	return tracks, nil
+}
+
+func (d *Downloader) discardTracks(tracks []mediaTrack) {
+	for _, t := range tracks {
+		d.discardTemp(t.path)
+	}
+}
+
+// trackArgs maps every input of an -all-tracks merge into the output,
+// tagged with its language and name, the main audio track first and
+// played by default. Inputs are numbered from 0, the video, then the
+// main audio, then the tracks.
+func trackArgs(sel formatSelection) []string {
+	if len(sel.tracks) == 0 {
+		return nil
+	}
+	args := []string{"-map", "0:v:0", "-map", "1:a:0"}
+	var meta []string
+	if lang := audioLanguage(sel.audio); lang != "" {
+		meta = append(meta, "-metadata:s:a:0", "language="+matroskaLanguage(lang), "-metadata:s:a:0", "title="+sel.audio.LanguageDisplayName())
+	}
+	audio, subs := 1, 0
+	for i, t := range sel.tracks {
+		stream := "a:" + strconv.Itoa(audio)
+		if t.subtitle {
+			args = append(args, "-map", strconv.Itoa(i+2)+":s:0")
+			stream = "s:" + strconv.Itoa(subs)
+			subs++
+		} else {
+			args = append(args, "-map", strconv.Itoa(i+2)+":a:0")
+			audio++
+		}
+		meta = append(meta, "-metadata:s:"+stream, "language="+matroskaLanguage(t.lang))
+		if t.title != "" {
+			meta = append(meta, "-metadata:s:"+stream, "title="+t.title)
+		}
+	}
+	args = append(args, meta...)
+	args = append(args, "-disposition:a", "0", "-disposition:a:0", "default")
+	if subs > 0 {
+		// SRT plays nearly everywhere MKV does
+		args = append(args, "-c:s", "srt")
+	}
+	return args
+}
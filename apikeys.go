@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiUser is one user of the -api-keys file. Users see and control only
+// their own jobs, save into their own directory and are held to their
+// quotas; admins see everything and can pause, stop or reload the server.
+type apiUser struct {
+	name    string
+	token   string
+	admin   bool
+	dir     string     // under the output directory
+	slots   *scheduler // for -max-jobs, nil for no limit
+	perHour int        // submissions allowed per hour, 0 for no limit
+
+	mu        sync.Mutex
+	submitted []time.Time // submissions within the last hour
+}
+
+// loadAPIKeys reads the -api-keys file, a mapping from user name to
+// settings:
+//
+//	alice:
+//	  token: 6f1d0c...
+//	  dir: alice
+//	  max-jobs: 2
+//	  submissions-per-hour: 20
+//	admin:
+//	  token: 0b8a7e...
+//	  admin: true
+//
+// A user's dir defaults to their name, or to the output directory itself
+// for admins.
+func loadAPIKeys(path string) ([]*apiUser, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values, err := parseYAML(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var users []*apiUser
+	tokens := make(map[string]bool)
+	for _, name := range names {
+		settings, ok := values[name].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: %s: expected token and limits", path, name)
+		}
+		user, err := newAPIUser(name, settings)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", path, name, err)
+		}
+		if tokens[user.token] {
+			return nil, fmt.Errorf("%s: %s: token is already in use", path, name)
+		}
+		tokens[user.token] = true
+		users = append(users, user)
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("%s: no users", path)
+	}
+	return users, nil
+}
+
+func newAPIUser(name string, settings map[string]any) (*apiUser, error) {
+	u := &apiUser{name: name, dir: name}
+	for key, value := range settings {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("unknown section %q", key)
+		}
+		var err error
+		switch key {
+		case "token":
+			u.token = s
+		case "admin":
+			u.admin, err = strconv.ParseBool(s)
+		case "dir":
+			u.dir = s
+		case "max-jobs":
+			var n int
+			if n, err = strconv.Atoi(s); err == nil && n > 0 {
+				u.slots = newScheduler(n)
+			}
+		case "submissions-per-hour":
+			u.perHour, err = strconv.Atoi(s)
+		default:
+			return nil, fmt.Errorf("unknown setting %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %v", key, err)
+		}
+	}
+	if len(u.token) < 16 {
+		return nil, fmt.Errorf("token must be at least 16 characters")
+	}
+	if _, ok := settings["dir"]; !ok && u.admin {
+		u.dir = ""
+	}
+	if u.dir != "" && (!filepath.IsLocal(u.dir) || strings.Contains(u.dir, `\`)) {
+		return nil, fmt.Errorf("dir must be a relative path inside the output directory")
+	}
+	return u, nil
+}
+
+// allowSubmit records a submission if the user's hourly quota allows it,
+// and otherwise returns how long until it will.
+func (u *apiUser) allowSubmit(now time.Time) time.Duration {
+	if u.perHour <= 0 {
+		return 0
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	cutoff := now.Add(-time.Hour)
+	for len(u.submitted) > 0 && !u.submitted[0].After(cutoff) {
+		u.submitted = u.submitted[1:]
+	}
+	if len(u.submitted) >= u.perHour {
+		return u.submitted[0].Sub(cutoff)
+	}
+	u.submitted = append(u.submitted, now)
+	return 0
+}
+
+// sees reports whether a request made as u may see job. Without
+// -api-keys, u is nil and everyone sees everything.
+func (u *apiUser) sees(job *Job) bool {
+	return u == nil || u.admin || job.source.User == u.name
+}
+
+// source fills in a batch with where and how u's downloads are made.
+func (u *apiUser) source(source batchSource) batchSource {
+	if u != nil {
+		source.User, source.Subdir, source.Slots = u.name, u.dir, u.slots
+	}
+	return source
+}
+
+type apiUserKey struct{}
+
+func withAPIUser(ctx context.Context, u *apiUser) context.Context {
+	return context.WithValue(ctx, apiUserKey{}, u)
+}
+
+func apiUserFrom(ctx context.Context) *apiUser {
+	u, _ := ctx.Value(apiUserKey{}).(*apiUser)
+	return u
+}
+
+// lookupToken finds the user with token, comparing in constant time.
+func (s *server) lookupToken(token string) *apiUser {
+	var found *apiUser
+	for _, u := range s.users {
+		if subtle.ConstantTimeCompare([]byte(u.token), []byte(token)) == 1 {
+			found = u
+		}
+	}
+	return found
+}
+
+// requestToken returns the token of an HTTP request, given as a bearer
+// token or, for the web UI's event stream and links, in the ytdl_token
+// cookie.
+func requestToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if c, err := r.Cookie("ytdl_token"); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// authenticate lets through requests with a known token, with their user
+// in the request context. Without -api-keys, everything is let through.
+func (s *server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.users == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		u := s.lookupToken(requestToken(r))
+		if u == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ytdl-go"`)
+			writeError(w, http.StatusUnauthorized, "missing or unknown API token")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withAPIUser(r.Context(), u)))
+	})
+}
+
+// adminOnly limits a handler for the whole server to admins.
+func adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if u := apiUserFrom(r.Context()); u != nil && !u.admin {
+			writeError(w, http.StatusForbidden, "only admins can do this")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// archiveTemplate files each upload under the year it was published.
+const archiveTemplate = "{upload_date:date(2006)}/{upload_date:date(2006-01-02)} - {title}"
+
+const (
+	archiveManifestName = "manifest.json"
+	archiveRecordName   = "archive.txt"
+)
+
+func setupArchive(flags *flag.FlagSet) func([]string) error {
+	opts := registerDownloadFlags(flags)
+	opts.tui = flags.Bool("tui", false, "Show an interactive queue with progress and controls")
+
+	return func(args []string) error {
+		if len(args) != 1 {
+			flags.Usage()
+			return &exitError{exitTotalFailure, fmt.Errorf("expected one channel")}
+		}
+
+		// Everything is kept, and defaults not given on the command line
+		// or in the config file are replaced with ones suited to a backup
+		*opts.writeInfo = true
+		*opts.writeDescription = true
+		*opts.writeThumbnail = true
+		*opts.writeSubs = true
+		set := make(map[string]bool)
+		flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if !set["output-template"] {
+			*opts.outputTemplate = archiveTemplate
+		}
+		if !set["organize"] {
+			*opts.organize = "channel"
+		}
+		if !set["sub-langs"] {
+			*opts.subLangs = "all"
+		}
+		if !set["download-archive"] {
+			// Makes running archive again fetch only new uploads
+			*opts.archivePath = filepath.Join(*opts.outputDir, archiveRecordName)
+		}
+
+		return opts.runDownloads("", func(d *Downloader) error {
+			result, err := d.ArchiveChannel(args[0])
+			if err != nil {
+				return err
+			}
+			return result.Err()
+		})
+	}
+}
+
+// ArchiveChannel downloads every upload of a channel, including shorts
+// and live stream recordings, and then writes a manifest of everything
+// archived so far.
+func (d *Downloader) ArchiveChannel(channel string) (*PlaylistResult, error) {
+	source, ids, err := d.channelArchiveIDs(channel)
+	if err != nil {
+		return nil, err
+	}
+	d.logger.Printf("Archiving %d uploads from %s", len(ids), source.Channel)
+	result := d.processBatch(source, ids, nil)
+	d.writeArchiveManifest(source)
+	return result, nil
+}
+
+// channelArchiveIDs lists a channel's uploads, newest first. The uploads
+// playlist should hold shorts and live recordings too, but the shorts
+// (UUSH) and live (UULV) playlists are merged in for any it leaves out.
+func (d *Downloader) channelArchiveIDs(channel string) (batchSource, []string, error) {
+	id, err := d.resolveChannelID(context.Background(), channel)
+	if err != nil {
+		return batchSource{}, nil, err
+	}
+	suffix := strings.TrimPrefix(id, "UC")
+	source, ids, _, err := d.listPlaylist(playlistURL("UU" + suffix))
+	if err != nil {
+		return batchSource{}, nil, err
+	}
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		seen[id] = true
+	}
+	for _, prefix := range []string{"UUSH", "UULV"} {
+		// Channels without shorts or streams have no such playlist
+		_, extra, _, err := d.listPlaylist(playlistURL(prefix + suffix))
+		if err != nil {
+			continue
+		}
+		for _, id := range extra {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return batchSource{Channel: source.Channel}, ids, nil
+}
+
+// writeArchiveManifest lists every media file in the channel's directory,
+// with checksums when -checksums is on.
+func (d *Downloader) writeArchiveManifest(source batchSource) {
+	if d.config.Dest != nil {
+		// Outputs are remote and there is no local directory to list
+		return
+	}
+	dir := d.config.OutputDir
+	if d.config.Organize == "channel" && source.Channel != "" {
+		dir = filepath.Join(dir, d.sanitizer().field(source.Channel))
+	}
+	entries, err := buildManifest(context.Background(), dir, d.config.Checksums != "off", d.history)
+	if err != nil {
+		d.logger.Printf("Failed to build manifest: %v", err)
+		return
+	}
+
+	path := filepath.Join(dir, archiveManifestName)
+	f, err := os.Create(path)
+	if err == nil {
+		err = writeManifestJSON(f, entries)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		d.logger.Printf("Failed to write %s: %v", path, err)
+		return
+	}
+	d.logger.Printf("Wrote manifest %s with %d files", path, len(entries))
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// downloaders are the values of -downloader: native fetches streams
+// itself, aria2c hands each one to an aria2c process. Formats are chosen,
+// and merged, here either way.
+var downloaders = []string{"native", "aria2c"}
+
+// useAria2 reports whether format is fetched with aria2c. A clip that
+// needs only the start of a stream is still fetched natively, as aria2c
+// always downloads whole files.
+func (d *Downloader) useAria2(job *Job, video *youtube.Video, format *youtube.Format) bool {
+	return d.config.Downloader == "aria2c" && d.fetchLength(job, video, format) == format.ContentLength
+}
+
+// fetchWithAria2 downloads format to path with aria2c, resuming a partial
+// file in continue mode. Progress is taken from the size of the file as
+// it grows, which runs a little ahead of what has been fetched while
+// aria2c fills in several segments at once.
+func (d *Downloader) fetchWithAria2(ctx context.Context, job *Job, video *youtube.Video, format *youtube.Format, path, label string) (int64, error) {
+	streamURL, err := d.client.GetStreamURLContext(ctx, video, format)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get stream for %s: %v", label, err)
+	}
+
+	input, err := d.aria2Input(video, streamURL, path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare aria2c: %v", err)
+	}
+	defer os.Remove(input)
+
+	cmd := exec.CommandContext(ctx, "aria2c", d.aria2Args(input)...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	d.trackTemp(path)
+	d.logger.Printf("Downloading %s with aria2c", label)
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("failed to start aria2c: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	var reported int64
+	report := func() {
+		if stat, err := os.Stat(path); err == nil && stat.Size() > reported {
+			job.addProgress(int(stat.Size() - reported))
+			reported = stat.Size()
+		}
+	}
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for waiting := true; waiting; {
+		select {
+		case <-ticker.C:
+			report()
+		case err = <-done:
+			waiting = false
+		}
+	}
+	report()
+
+	if err != nil {
+		if d.config.ExistingPolicy != ExistingContinue {
+			// aria2c keeps the state of an unfinished download next to it
+			os.Remove(path + ".aria2")
+		}
+		if ctx.Err() != nil {
+			return reported, ctx.Err()
+		}
+		if tail := lastLine(output.String()); tail != "" {
+			return reported, fmt.Errorf("aria2c failed to download %s: %v: %s", label, err, tail)
+		}
+		return reported, fmt.Errorf("aria2c failed to download %s: %v", label, err)
+	}
+	if format.ContentLength == 0 {
+		job.addTotal(reported)
+	}
+	return reported, checkStreamLength(label, reported, format.ContentLength, nil)
+}
+
+// aria2Input writes the aria2c input file downloading streamURL to path.
+// It holds the -add-header and -auth headers and the -proxy, which may
+// carry secrets that other users would see on a command line. The caller
+// removes it.
+func (d *Downloader) aria2Input(video *youtube.Video, streamURL, path string) (string, error) {
+	var b strings.Builder
+	b.WriteString(streamURL + "\n")
+	fmt.Fprintf(&b, "  dir=%s\n  out=%s\n", filepath.Dir(path), filepath.Base(path))
+	// A -resolver's stream hosts get nothing meant for YouTube
+	if !d.resolvedVideo(video) {
+		for name, values := range d.config.Headers {
+			for _, value := range values {
+				fmt.Fprintf(&b, "  header=%s: %s\n", name, value)
+			}
+		}
+		if u, err := url.Parse(streamURL); err == nil && d.credential != nil && credentialHost(u.Hostname()) {
+			if name, value := d.credential.header(); name != "" {
+				fmt.Fprintf(&b, "  header=%s: %s\n", name, value)
+			}
+		}
+	}
+	if d.config.Proxy != "" {
+		fmt.Fprintf(&b, "  all-proxy=%s\n", d.config.Proxy)
+	}
+
+	// CreateTemp makes the file readable by this user only
+	f, err := os.CreateTemp("", "ytdl-aria2-*.txt")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// aria2Args returns the aria2c arguments downloading what input lists,
+// with the -user-agent and -limit-rate of native downloads.
+// -downloader-args come last, so they override these.
+func (d *Downloader) aria2Args(input string) []string {
+	args := []string{
+		"--input-file=" + input,
+		"--auto-file-renaming=false",
+		"--file-allocation=none",
+		"--max-connection-per-server=16",
+		"--split=16",
+		"--min-split-size=1M",
+		"--summary-interval=0",
+		"--show-console-readout=false",
+		"--download-result=hide",
+		"--console-log-level=error",
+	}
+	if d.config.ExistingPolicy == ExistingContinue {
+		args = append(args, "--continue=true")
+	} else {
+		args = append(args, "--allow-overwrite=true", "--continue=false")
+	}
+	if d.config.UserAgent != "" {
+		args = append(args, "--user-agent="+d.config.UserAgent)
+	}
+	// A -limit-rate schedule can't be handed over, only a flat rate
+	if rate, err := parseRate(d.config.LimitRate); err == nil && rate > 0 {
+		args = append(args, fmt.Sprintf("--max-download-limit=%d", rate))
+	}
+	return append(args, d.config.DownloaderArgs...)
+}
+
+// checkAria2Proxy rejects a -proxy aria2c can't use: it speaks only to
+// HTTP proxies.
+func checkAria2Proxy(proxy string) error {
+	if proxy == "" {
+		return nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return fmt.Errorf("invalid -proxy: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("-downloader aria2c only supports HTTP proxies, not %s", u.Scheme)
+	}
+	return nil
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
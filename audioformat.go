@@ -0,0 +1,78 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// audioFormats are the values of -audio-format. best keeps the codec of
+// the best audio stream, saved without re-encoding.
+var audioFormats = []string{"best", "mp3", "opus", "m4a"}
+
+// pickAudio chooses the stream of an audio-only download from formats,
+// which all have audio. MP3 is converted from the first, as it always
+// has been; the other formats take the best audio-only stream, of the
+// codec they hold if there is one, so it can be copied as is.
+func pickAudio(formats youtube.FormatList, format string) *youtube.Format {
+	if len(formats) == 0 {
+		return nil
+	}
+	if format == "mp3" {
+		return &formats[0]
+	}
+	want := map[string]string{"opus": "opus", "m4a": "aac"}[format]
+	var best *youtube.Format
+	for i := range formats {
+		f := &formats[i]
+		if hasVideo(f) {
+			continue
+		}
+		if best == nil {
+			best = f
+			continue
+		}
+		if want != "" && (codecFamily(f.MimeType) == want) != (codecFamily(best.MimeType) == want) {
+			if codecFamily(f.MimeType) == want {
+				best = f
+			}
+			continue
+		}
+		if slices.Compare(formatRank(f), formatRank(best)) > 0 {
+			best = f
+		}
+	}
+	if best == nil {
+		return &formats[0]
+	}
+	return best
+}
+
+// audioContainer is the extension an audio-only download of source is
+// saved with: best keeps Opus as .opus and anything else as .m4a.
+func audioContainer(source *youtube.Format, format string) string {
+	if format == "best" {
+		if codecFamily(source.MimeType) == "opus" {
+			return "opus"
+		}
+		return "m4a"
+	}
+	return format
+}
+
+// audioCodecArgs returns the ffmpeg arguments saving the audio of source
+// as container: copied when it already holds the codec, unless filtered
+// by -normalize-audio, and re-encoded otherwise.
+func audioCodecArgs(source *youtube.Format, container string, filtered bool) []string {
+	family, encoder := "aac", "aac"
+	switch container {
+	case "mp3":
+		return []string{"-ab", "128k", "-ar", "44100"}
+	case "opus":
+		family, encoder = "opus", "libopus"
+	}
+	if !filtered && codecFamily(source.MimeType) == family {
+		return []string{"-c:a", "copy"}
+	}
+	return []string{"-c:a", encoder, "-b:a", "192k"}
+}
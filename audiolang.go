@@ -0,0 +1,68 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// audioLanguage is the language code of a format's audio track, such as
+// "en" or "ja-JP", or "" for a video with a single track.
+func audioLanguage(f *youtube.Format) string {
+	if f.AudioTrack == nil {
+		return ""
+	}
+	lang, _, _ := strings.Cut(f.AudioTrack.ID, ".")
+	return lang
+}
+
+// audioTrackMatches reports whether f's audio track is what one -audio-lang
+// entry asks for: a language, where "en" also takes "en-US", or
+// "original" for the track the video was made in, or "default" for the
+// one YouTube plays first.
+func audioTrackMatches(f *youtube.Format, want string) bool {
+	switch track := f.AudioTrack; {
+	case track == nil:
+		return false
+	case strings.EqualFold(want, "original"):
+		return strings.Contains(strings.ToLower(track.DisplayName), "original")
+	case strings.EqualFold(want, "default"):
+		return track.AudioIsDefault
+	}
+	lang := audioLanguage(f)
+	return strings.EqualFold(lang, want) || len(lang) > len(want) && lang[len(want)] == '-' && strings.EqualFold(lang[:len(want)], want)
+}
+
+// audioLanguageFormats returns a video's formats with the audio tracks
+// narrowed to the first -audio-lang language it has. Videos with a single
+// track, and those with none of the languages, keep all their formats.
+func (d *Downloader) audioLanguageFormats(video *youtube.Video) youtube.FormatList {
+	if len(d.config.AudioLangs) == 0 || !slices.ContainsFunc(video.Formats, func(f youtube.Format) bool { return f.AudioTrack != nil }) {
+		return video.Formats
+	}
+	for _, want := range d.config.AudioLangs {
+		if !slices.ContainsFunc(video.Formats, func(f youtube.Format) bool { return audioTrackMatches(&f, want) }) {
+			continue
+		}
+		var formats youtube.FormatList
+		for _, f := range video.Formats {
+			// Formats without a track of their own, such as muxed ones,
+			// are left to the other preferences
+			if f.AudioTrack == nil || audioTrackMatches(&f, want) {
+				formats = append(formats, f)
+			}
+		}
+		return formats
+	}
+
+	var langs []string
+	for _, f := range video.Formats {
+		if lang := audioLanguage(&f); lang != "" && !slices.Contains(langs, lang) {
+			langs = append(langs, lang)
+		}
+	}
+	d.logger.Printf("%s has no %s audio track (it has %s), using the default one",
+		video.Title, strings.Join(d.config.AudioLangs, " or "), strings.Join(langs, ", "))
+	return video.Formats
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// auditFile is a media file found in the output directory.
+type auditFile struct {
+	path string // absolute
+	rel  string
+	id   string // from the info.json sidecar or the library, if known
+	info *InfoJSON
+}
+
+// auditIssue is a problem found by audit with an optional fix.
+type auditIssue struct {
+	kind   string
+	detail string
+	paths  []string
+	fix    string
+	apply  func() error
+}
+
+type auditor struct {
+	ctx     context.Context
+	dir     string
+	san     sanitizer
+	lib     *library
+	archive *downloadArchive
+	trash   *Trash
+
+	files   []*auditFile
+	indexed map[string]LibraryEntry // by absolute path
+}
+
+func (a *auditor) scan() error {
+	a.indexed = make(map[string]LibraryEntry)
+	if a.lib != nil {
+		entries, err := a.lib.Entries(a.ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read library: %v", err)
+		}
+		for _, e := range entries {
+			a.indexed[e.Path] = e
+		}
+	}
+
+	return filepath.WalkDir(a.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != a.dir && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isMediaFile(path) || strings.HasSuffix(path, ".preview.mp4") {
+			return nil
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(a.dir, path)
+		if err != nil {
+			rel = path
+		}
+		f := &auditFile{path: abs, rel: rel}
+		if info, err := readInfoJSON(path); err == nil && info.ID != "" {
+			f.info, f.id = info, info.ID
+		} else if e, ok := a.indexed[abs]; ok {
+			f.id = e.VideoID
+		}
+		a.files = append(a.files, f)
+		return nil
+	})
+}
+
+// duplicates finds videos saved more than once. The copy the library
+// points at is kept, otherwise the largest.
+func (a *auditor) duplicates() (issues []auditIssue, extra map[*auditFile]bool) {
+	extra = make(map[*auditFile]bool)
+	byID := make(map[string][]*auditFile)
+	for _, f := range a.files {
+		if f.id != "" {
+			byID[f.id] = append(byID[f.id], f)
+		}
+	}
+	for id, group := range byID {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool { return a.keepBefore(group[i], group[j]) })
+		keep := group[0]
+		var paths []string
+		for _, f := range group {
+			paths = append(paths, f.rel)
+		}
+		var trashed []*auditFile
+		for _, f := range group[1:] {
+			extra[f] = true
+			trashed = append(trashed, f)
+		}
+		issues = append(issues, auditIssue{
+			kind:   "duplicate",
+			detail: fmt.Sprintf("video %s is saved %d times", id, len(group)),
+			paths:  paths,
+			fix:    fmt.Sprintf("keep %s and move the other copies to the trash", keep.rel),
+			apply: func() error {
+				for _, f := range trashed {
+					if err := a.trash.Move(f.path); err != nil {
+						return err
+					}
+					if a.lib != nil {
+						a.lib.Remove(a.ctx, f.path)
+					}
+				}
+				return nil
+			},
+		})
+	}
+	return issues, extra
+}
+
+func (a *auditor) keepBefore(x, y *auditFile) bool {
+	_, xi := a.indexed[x.path]
+	_, yi := a.indexed[y.path]
+	if xi != yi {
+		return xi
+	}
+	xs, ys := fileSize(x.path), fileSize(y.path)
+	if xs != ys {
+		return xs > ys
+	}
+	return x.rel < y.rel
+}
+
+func fileSize(path string) int64 {
+	st, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return st.Size()
+}
+
+// names finds files whose names are unsafe under the current sanitizer
+// settings, and files that would end up with the same name once
+// sanitized on a case-insensitive filesystem.
+func (a *auditor) names(skip map[*auditFile]bool) []auditIssue {
+	var issues []auditIssue
+	byKey := make(map[string][]*auditFile)
+	for _, f := range a.files {
+		if skip[f] {
+			continue
+		}
+		key := strings.ToLower(a.sanitized(f))
+		byKey[key] = append(byKey[key], f)
+	}
+
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		group := byKey[key]
+		// A file already named correctly keeps its name, then identified ones
+		sort.Slice(group, func(i, j int) bool {
+			x, y := group[i], group[j]
+			if xc, yc := a.sanitized(x) == x.rel, a.sanitized(y) == y.rel; xc != yc {
+				return xc
+			}
+			if (x.id != "") != (y.id != "") {
+				return x.id != ""
+			}
+			return x.rel < y.rel
+		})
+		if len(group) > 1 {
+			var paths []string
+			for _, f := range group {
+				paths = append(paths, f.rel)
+			}
+			issue := auditIssue{
+				kind:   "collision",
+				detail: fmt.Sprintf("%d files share the name %q once sanitized", len(group), a.sanitized(group[0])),
+				paths:  paths,
+			}
+			// The first file keeps its name, the rest get their video ID
+			rest := group[1:]
+			identified := true
+			for _, f := range rest {
+				identified = identified && f.id != ""
+			}
+			if identified {
+				issue.fix = "append \" [videoID]\" to the names of all but the first"
+				issue.apply = func() error {
+					for _, f := range rest {
+						ext := filepath.Ext(f.rel)
+						base := a.san.withSuffix(strings.TrimSuffix(a.sanitized(f), ext), " ["+f.id+"]")
+						if err := a.rename(f, base+ext); err != nil {
+							return err
+						}
+					}
+					return nil
+				}
+			} else {
+				issue.fix = "rename by hand; some files have no info.json to identify them"
+			}
+			issues = append(issues, issue)
+			continue
+		}
+
+		f := group[0]
+		if want := a.sanitized(f); want != f.rel {
+			issues = append(issues, auditIssue{
+				kind:   "unsafe name",
+				detail: "name contains characters or a length the sanitizer would not produce",
+				paths:  []string{f.rel},
+				fix:    fmt.Sprintf("rename to %s", want),
+				apply:  func() error { return a.rename(f, want) },
+			})
+		}
+	}
+	return issues
+}
+
+// sanitized returns the relative path f would have if downloaded now.
+func (a *auditor) sanitized(f *auditFile) string {
+	ext := filepath.Ext(f.rel)
+	fallback := f.id
+	if fallback == "" {
+		fallback = "video"
+	}
+	parts := strings.Split(filepath.ToSlash(strings.TrimSuffix(f.rel, ext)), "/")
+	for i, part := range parts {
+		parts[i] = a.san.field(part)
+	}
+	return a.san.path(strings.Join(parts, "/"), fallback) + ext
+}
+
+// rename moves f and its info.json sidecar to rel and updates the library.
+func (a *auditor) rename(f *auditFile, rel string) error {
+	dest := filepath.Join(a.dir, rel)
+	if abs, err := filepath.Abs(dest); err == nil {
+		dest = abs
+	}
+	if fileExists(dest) && !strings.EqualFold(dest, f.path) {
+		return fmt.Errorf("%s already exists", rel)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(f.path, dest); err != nil {
+		return err
+	}
+	if sidecar := infoJSONPath(f.path); fileExists(sidecar) {
+		if err := os.Rename(sidecar, infoJSONPath(dest)); err != nil {
+			return err
+		}
+	}
+	if e, ok := a.indexed[f.path]; ok && a.lib != nil {
+		a.lib.Remove(a.ctx, f.path)
+		video := &youtube.Video{ID: e.VideoID, Title: e.Title, Author: e.Channel}
+		if f.info != nil {
+			video.Description = f.info.Description
+		}
+		if err := a.lib.Add(a.ctx, video, dest, ""); err != nil {
+			return err
+		}
+	}
+	f.path, f.rel = dest, rel
+	return nil
+}
+
+// history finds files the library or download archive does not know
+// about, and library entries whose file is gone.
+func (a *auditor) history(skip map[*auditFile]bool) []auditIssue {
+	var issues []auditIssue
+	present := make(map[string]bool)
+	for _, f := range a.files {
+		present[f.path] = true
+		if skip[f] {
+			continue
+		}
+		if f.id == "" {
+			issues = append(issues, auditIssue{
+				kind:   "unidentified",
+				detail: "no info.json or library entry says which video this is",
+				paths:  []string{f.rel},
+				fix:    "re-download with -write-info-json, or delete it",
+			})
+			continue
+		}
+		if a.lib != nil {
+			if _, ok := a.indexed[f.path]; !ok && f.info != nil {
+				info := f.info
+				issues = append(issues, auditIssue{
+					kind:   "not indexed",
+					detail: fmt.Sprintf("video %s is missing from the library", f.id),
+					paths:  []string{f.rel},
+					fix:    "add it to the library from its info.json",
+					apply: func() error {
+						video := &youtube.Video{ID: info.ID, Title: info.Title, Author: info.Channel, Description: info.Description}
+						return a.lib.Add(a.ctx, video, f.path, "")
+					},
+				})
+			}
+		}
+		if a.archive != nil && !a.archive.Has(f.id) {
+			id := f.id
+			issues = append(issues, auditIssue{
+				kind:   "not archived",
+				detail: fmt.Sprintf("video %s is missing from the download archive", id),
+				paths:  []string{f.rel},
+				fix:    "add it to the download archive",
+				apply:  func() error { return a.archive.Add(id) },
+			})
+		}
+	}
+
+	// Only entries under the audited directory are ours to judge
+	dir, err := filepath.Abs(a.dir)
+	if err != nil {
+		return issues
+	}
+	var stale []string
+	for path := range a.indexed {
+		if strings.HasPrefix(path, dir+string(filepath.Separator)) && !present[path] && !fileExists(path) {
+			stale = append(stale, path)
+		}
+	}
+	sort.Strings(stale)
+	for _, path := range stale {
+		issues = append(issues, auditIssue{
+			kind:   "stale",
+			detail: "the library lists a file that no longer exists",
+			paths:  []string{path},
+			fix:    "remove it from the library",
+			apply:  func() error { return a.lib.Remove(a.ctx, path) },
+		})
+	}
+	return issues
+}
+
+func setupAudit(flags *flag.FlagSet) func([]string) error {
+	restrict := flags.Bool("restrict-filenames", false, "Check names against the ASCII-only sanitizer")
+	maxLen := flags.Int("max-filename-length", defaultMaxFilenameLength, "Check names against this length limit")
+	libraryPath := flags.String("library", defaultLibraryPath(), "Library index to compare against (empty to skip)")
+	archivePath := flags.String("download-archive", "", "Download archive to compare against")
+	fix := flags.Bool("fix", false, "Apply the suggested fixes")
+
+	return func(args []string) error {
+		dir := "downloads"
+		switch len(args) {
+		case 0:
+		case 1:
+			dir = args[0]
+		default:
+			flags.Usage()
+			return &exitError{exitTotalFailure, fmt.Errorf("expected at most one directory")}
+		}
+
+		a := &auditor{
+			ctx:   context.Background(),
+			dir:   dir,
+			san:   sanitizer{restrict: *restrict, maxLen: *maxLen},
+			trash: NewTrash(dir),
+		}
+		var err error
+		if a.lib, err = openLibrary(*libraryPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping library checks: %v\n", err)
+		}
+		if *archivePath != "" {
+			if a.archive, err = loadDownloadArchive(*archivePath); err != nil {
+				return fmt.Errorf("failed to read download archive: %v", err)
+			}
+		}
+		if err := a.scan(); err != nil {
+			return fmt.Errorf("failed to scan %s: %v", dir, err)
+		}
+
+		issues, extra := a.duplicates()
+		sort.Slice(issues, func(i, j int) bool { return issues[i].paths[0] < issues[j].paths[0] })
+		issues = append(issues, a.names(extra)...)
+		issues = append(issues, a.history(extra)...)
+
+		failed := 0
+		for _, issue := range issues {
+			fmt.Printf("%s: %s\n", issue.kind, issue.detail)
+			for _, p := range issue.paths {
+				fmt.Printf("    %s\n", p)
+			}
+			switch {
+			case issue.fix == "":
+			case *fix && issue.apply != nil:
+				if err := issue.apply(); err != nil {
+					fmt.Printf("  fix failed: %v\n", err)
+					failed++
+				} else {
+					fmt.Printf("  fixed: %s\n", issue.fix)
+				}
+			default:
+				fmt.Printf("  suggested: %s\n", issue.fix)
+			}
+		}
+
+		fmt.Printf("%d files checked, %d issues found\n", len(a.files), len(issues))
+		if failed > 0 {
+			return &exitError{exitPartialFailure, fmt.Errorf("%d fixes failed", failed)}
+		}
+		return nil
+	}
+}
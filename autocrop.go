@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+const (
+	cropSampleLength = 20 * time.Second
+	// Ignore crops that remove less than this fraction of either
+	// dimension; cropdetect rounds to 16 pixels, so 1080p always looks
+	// 8 pixels too tall
+	minCropFraction = 0.02
+)
+
+var cropPattern = regexp.MustCompile(`crop=(\d+):(\d+):(\d+):(\d+)`)
+
+// detectCrop runs ffmpeg's cropdetect on a sample from the middle of the
+// video and returns a crop filter removing black bars, or "" when there
+// are none worth removing.
+func detectCrop(ctx context.Context, ffmpeg, path string, format *youtube.Format, duration time.Duration) (string, error) {
+	start := max(duration/2-cropSampleLength/2, 0)
+	cmd := exec.CommandContext(ctx, ffmpeg, "-hide_banner", "-nostats",
+		"-ss", strconv.FormatFloat(start.Seconds(), 'f', 0, 64),
+		"-i", path,
+		"-t", strconv.FormatFloat(cropSampleLength.Seconds(), 'f', 0, 64),
+		"-vf", "cropdetect=24:16:0", "-an", "-f", "null", "-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("cropdetect failed: %v", err)
+	}
+
+	// cropdetect reports per frame; the most common answer wins, so a
+	// few dark scenes don't crop into the picture
+	votes := make(map[string]int)
+	best := ""
+	for _, m := range cropPattern.FindAllStringSubmatch(stderr.String(), -1) {
+		votes[m[0]]++
+		if votes[m[0]] > votes[best] {
+			best = m[0]
+		}
+	}
+	if best == "" {
+		return "", nil
+	}
+
+	m := cropPattern.FindStringSubmatch(best)
+	w, _ := strconv.Atoi(m[1])
+	h, _ := strconv.Atoi(m[2])
+	if format.Width > 0 && format.Height > 0 {
+		if float64(format.Width-w) < minCropFraction*float64(format.Width) &&
+			float64(format.Height-h) < minCropFraction*float64(format.Height) {
+			return "", nil
+		}
+	}
+	return best, nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// streamURLMargin is how long before its stream URLs expire a cached
+// video is fetched again, so that downloads started from it can finish.
+const streamURLMargin = 30 * time.Minute
+
+// metadataCache keeps video and playlist metadata on disk, one JSON file
+// per video or playlist, so that runs over the same playlists don't fetch
+// it all again.
+type metadataCache struct {
+	dir string
+	ttl time.Duration
+}
+
+type cacheEntry struct {
+	Expires  time.Time       `json:"expires"`
+	Client   string          `json:"client,omitempty"` // -player-client that extracted a video
+	Resolved bool            `json:"resolved,omitempty"`
+	Data     json.RawMessage `json:"data"`
+}
+
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "ytdl-go", "metadata")
+}
+
+func (c *metadataCache) path(kind, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, kind, hex.EncodeToString(sum[:16])+".json")
+}
+
+// get returns the entry for key if there is one that hasn't expired.
+func (c *metadataCache) get(kind, key string) (*cacheEntry, bool) {
+	data, err := os.ReadFile(c.path(kind, key))
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || !time.Now().Before(entry.Expires) {
+		return nil, false
+	}
+	return &entry, true
+}
+
+// put stores entry, written to a temporary file first so that concurrent
+// jobs never read half of one.
+func (c *metadataCache) put(kind, key string, entry *cacheEntry) error {
+	path := c.path(kind, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// clear removes every cached entry and returns how many there were.
+func (c *metadataCache) clear() (int, error) {
+	n := 0
+	err := filepath.WalkDir(c.dir, func(path string, e fs.DirEntry, err error) error {
+		if err == nil && !e.IsDir() && filepath.Ext(path) == ".json" {
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, os.RemoveAll(c.dir)
+}
+
+// streamURLExpiry returns when the first of a video's stream URLs
+// expires, or the zero time if none say.
+func streamURLExpiry(video *youtube.Video) time.Time {
+	var earliest time.Time
+	for _, f := range video.Formats {
+		raw := f.URL
+		if raw == "" {
+			// Ciphered formats carry their URL inside the cipher
+			if q, err := url.ParseQuery(f.Cipher); err == nil {
+				raw = q.Get("url")
+			}
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			continue
+		}
+		secs, err := strconv.ParseInt(u.Query().Get("expire"), 10, 64)
+		if err != nil {
+			continue
+		}
+		if t := time.Unix(secs, 0); earliest.IsZero() || t.Before(earliest) {
+			earliest = t
+		}
+	}
+	return earliest
+}
+
+type freshMetadataKey struct{}
+
+// withFreshMetadata makes video lookups with ctx skip the cache, for
+// when the cached stream URLs are the problem.
+func withFreshMetadata(ctx context.Context) context.Context {
+	return context.WithValue(ctx, freshMetadataKey{}, true)
+}
+
+// cachingProvider answers video and playlist lookups from the metadata
+// cache. Videos remember which player client or resolver extracted them,
+// since their stream URLs only work when fetched the same way.
+type cachingProvider struct {
+	VideoProvider
+	cache     *metadataCache
+	fallback  *clientFallbackProvider
+	resolving *resolvingProvider
+}
+
+func (p *cachingProvider) GetVideoContext(ctx context.Context, videoURL string) (*youtube.Video, error) {
+	id, err := youtube.ExtractVideoID(videoURL)
+	if err != nil {
+		return p.VideoProvider.GetVideoContext(ctx, videoURL)
+	}
+	if fresh, _ := ctx.Value(freshMetadataKey{}).(bool); !fresh {
+		if entry, ok := p.cache.get("videos", id); ok {
+			var video youtube.Video
+			if json.Unmarshal(entry.Data, &video) == nil {
+				p.restore(id, entry)
+				return &video, nil
+			}
+		}
+	}
+
+	video, err := p.VideoProvider.GetVideoContext(ctx, videoURL)
+	if err != nil {
+		return nil, err
+	}
+	// A live stream's manifest changes as it goes
+	if !isLive(video) {
+		p.storeVideo(video)
+	}
+	return video, nil
+}
+
+func (p *cachingProvider) storeVideo(video *youtube.Video) {
+	expires := time.Now().Add(p.cache.ttl)
+	if t := streamURLExpiry(video); !t.IsZero() && t.Add(-streamURLMargin).Before(expires) {
+		expires = t.Add(-streamURLMargin)
+	}
+	if !expires.After(time.Now()) {
+		return
+	}
+	data, err := json.Marshal(video)
+	if err != nil {
+		return
+	}
+	entry := &cacheEntry{Expires: expires, Data: data}
+	if p.fallback != nil {
+		if c, ok := p.fallback.clientFor(video.ID); ok {
+			entry.Client = c.name
+		}
+	}
+	if p.resolving != nil {
+		entry.Resolved = p.resolving.isResolved(video)
+	}
+	// The cache only saves time, so failing to write it is ignored
+	p.cache.put("videos", video.ID, entry)
+}
+
+// restore tells the fallbacks how a cached video was extracted.
+func (p *cachingProvider) restore(id string, entry *cacheEntry) {
+	if p.fallback != nil && entry.Client != "" {
+		p.fallback.remember(id, entry.Client)
+	}
+	if p.resolving != nil && entry.Resolved {
+		p.resolving.markResolved(id)
+	}
+}
+
+func (p *cachingProvider) GetPlaylistContext(ctx context.Context, playlistURL string) (*youtube.Playlist, error) {
+	if entry, ok := p.cache.get("playlists", playlistURL); ok {
+		var playlist youtube.Playlist
+		if json.Unmarshal(entry.Data, &playlist) == nil {
+			return &playlist, nil
+		}
+	}
+	playlist, err := p.VideoProvider.GetPlaylistContext(ctx, playlistURL)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := json.Marshal(playlist); err == nil {
+		p.cache.put("playlists", playlistURL, &cacheEntry{Expires: time.Now().Add(p.cache.ttl), Data: data})
+	}
+	return playlist, nil
+}
+
+func runCache(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: cache clear | cache path")
+	}
+	dir := defaultCacheDir()
+	if dir == "" {
+		return fmt.Errorf("no cache directory available")
+	}
+
+	switch args[0] {
+	case "clear":
+		n, err := (&metadataCache{dir: dir}).clear()
+		if err != nil {
+			return fmt.Errorf("failed to clear cache: %v", err)
+		}
+		fmt.Printf("Removed %d cached entries\n", n)
+		return nil
+	case "path":
+		fmt.Println(dir)
+		return nil
+	default:
+		return fmt.Errorf("unknown cache command %q", args[0])
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// category routes the downloads it matches to their own directory, format
+// and conversion. Categories come from the config file:
+//
+//	categories:
+//	  music:
+//	    channel: Some Band - Topic
+//	    dir: Music
+//	    mp3: true
+//	  talks:
+//	    title: "(?i)keynote|talk"
+//	    dir: Talks
+//	    quality: 1080p
+//
+// A category matches when all of its channel, playlist and title rules do,
+// and a download takes the first matching category by name.
+type category struct {
+	name     string
+	channel  string         // the video's channel or the batch's, any case
+	playlist string         // the batch's playlist title or ID
+	title    *regexp.Regexp // the video title
+	dir      string         // under the output directory
+	format   *formatSpec
+	mp3      bool
+}
+
+// parseCategories reads the categories section of the config file.
+func parseCategories(section any) ([]*category, error) {
+	if section == nil || section == "" {
+		return nil, nil
+	}
+	values, ok := section.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("expected a name for each category")
+	}
+	var categories []*category
+	for name, value := range values {
+		settings, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected rules and settings", name)
+		}
+		c, err := newCategory(name, settings)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		categories = append(categories, c)
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].name < categories[j].name })
+	return categories, nil
+}
+
+func newCategory(name string, settings map[string]any) (*category, error) {
+	c := &category{name: name}
+	var quality, format string
+	for key, value := range settings {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("unknown section %q", key)
+		}
+		var err error
+		switch key {
+		case "channel":
+			c.channel = s
+		case "playlist":
+			c.playlist = s
+		case "title":
+			if c.title, err = regexp.Compile(s); err != nil {
+				return nil, fmt.Errorf("invalid title pattern: %v", err)
+			}
+		case "dir":
+			c.dir = s
+		case "quality":
+			quality = s
+		case "format":
+			format = s
+		case "mp3":
+			c.mp3, err = strconv.ParseBool(s)
+		default:
+			return nil, fmt.Errorf("unknown setting %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %v", key, err)
+		}
+	}
+
+	if c.channel == "" && c.playlist == "" && c.title == nil {
+		return nil, fmt.Errorf("expected at least one of channel, playlist and title to match")
+	}
+	if c.dir != "" && (!filepath.IsLocal(c.dir) || strings.Contains(c.dir, `\`)) {
+		return nil, fmt.Errorf("dir must be a relative path inside the output directory")
+	}
+	if quality != "" {
+		if format != "" {
+			return nil, fmt.Errorf("quality and format can't both be set")
+		}
+		var keys []string
+		for _, p := range formatPresets {
+			keys = append(keys, p.key)
+			if p.key == quality {
+				format = p.spec
+			}
+		}
+		if format == "" && quality != "default" {
+			return nil, fmt.Errorf("unknown quality %q (expected one of %s)", quality, strings.Join(keys, ", "))
+		}
+	}
+	if format != "" {
+		var err error
+		if c.format, err = parseFormatSpec(format); err != nil {
+			return nil, fmt.Errorf("invalid format: %v", err)
+		}
+	}
+	return c, nil
+}
+
+func (c *category) matches(job *Job, video *youtube.Video) bool {
+	if c.channel != "" && !strings.EqualFold(c.channel, video.Author) && !strings.EqualFold(c.channel, job.source.Channel) {
+		return false
+	}
+	if c.playlist != "" && !strings.EqualFold(c.playlist, job.source.Title) &&
+		!(job.source.URL != "" && strings.Contains(job.source.URL, c.playlist)) {
+		return false
+	}
+	return c.title == nil || c.title.MatchString(video.Title)
+}
+
+// categorize sets the category of a job from its video.
+func (d *Downloader) categorize(job *Job, video *youtube.Video) {
+	for _, c := range d.config.Categories {
+		if c.matches(job, video) {
+			job.category = c
+			d.logger.Printf("%s: category %s", video.Title, c.name)
+			return
+		}
+	}
+}
+
+// audioOnly reports whether a job saves only audio, by -mp3, -x or its
+// category.
+func (d *Downloader) audioOnly(job *Job) bool {
+	return d.config.AudioOnly || job.category != nil && job.category.mp3
+}
+
+// audioFormat returns what an audio-only job is saved as: MP3 for a
+// category converting to it, otherwise -audio-format.
+func (d *Downloader) audioFormat(job *Job) string {
+	if job.category != nil && job.category.mp3 {
+		return "mp3"
+	}
+	return d.config.AudioFormat
+}
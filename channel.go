@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const resolveURLEndpoint = "https://www.youtube.com/youtubei/v1/navigation/resolve_url?prettyPrint=false"
+
+// channelUploadIDs lists a channel's uploads, newest first.
+func (d *Downloader) channelUploadIDs(channel string) ([]string, error) {
+	_, ids, err := d.channelUploads(channel)
+	return ids, err
+}
+
+// channelUploads lists a channel's uploads, newest first, by way of the
+// channel's auto-generated uploads playlist.
+func (d *Downloader) channelUploads(channel string) (batchSource, []string, error) {
+	id, err := d.resolveChannelID(context.Background(), channel)
+	if err != nil {
+		return batchSource{}, nil, err
+	}
+	// The uploads playlist of channel UCxxxx is UUxxxx
+	uploads, ids, _, err := d.listPlaylist(playlistURL("UU" + strings.TrimPrefix(id, "UC")))
+	if err != nil {
+		return batchSource{}, nil, err
+	}
+	return batchSource{Channel: uploads.Channel}, ids, nil
+}
+
+// resolveChannelID accepts a UC channel ID, a /channel/ URL, or a handle
+// or custom URL, which are resolved through innertube.
+func (d *Downloader) resolveChannelID(ctx context.Context, channel string) (string, error) {
+	if isChannelID(channel) {
+		return channel, nil
+	}
+
+	target := channel
+	switch {
+	case strings.HasPrefix(channel, "@"):
+		target = "https://www.youtube.com/" + channel
+	case !strings.Contains(channel, "://"):
+		target = "https://" + channel
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("invalid channel %q: %v", channel, err)
+	}
+	if id, ok := strings.CutPrefix(u.Path, "/channel/"); ok {
+		id, _, _ = strings.Cut(id, "/")
+		if isChannelID(id) {
+			return id, nil
+		}
+	}
+
+	resp, err := d.postInnertube(ctx, resolveURLEndpoint, map[string]any{
+		"context": innertubeContext(),
+		"url":     target,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve channel %s: %v", channel, err)
+	}
+	var id string
+	walkJSON(resp, func(key string, value map[string]any) {
+		if key == "browseEndpoint" && id == "" {
+			if browseID, ok := value["browseId"].(string); ok && isChannelID(browseID) {
+				id = browseID
+			}
+		}
+	})
+	if id == "" {
+		return "", fmt.Errorf("could not find a channel at %s", channel)
+	}
+	return id, nil
+}
+
+func isChannelID(s string) bool {
+	return len(s) == 24 && strings.HasPrefix(s, "UC")
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// checksumsFile collects the SHA-256 of every output with -checksums
+// file, in the format sha256sum -c reads.
+const checksumsFile = "checksums.txt"
+
+// checksumModes are the -checksums choices.
+var checksumModes = []string{"off", "file", "sidecar"}
+
+// checksumsMu serializes appends to checksums.txt between jobs.
+var checksumsMu sync.Mutex
+
+func sha256Path(mediaPath string) string {
+	return mediaPath + ".sha256"
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeChecksum records the SHA-256 of a finished output, either in a
+// .sha256 next to it or in checksums.txt in the output directory, before
+// it is moved to -dest. sum is the checksum of an output streamed to
+// -dest, which has no local file to hash, or "". It returns the checksum,
+// or "" with -checksums off.
+func (d *Downloader) writeChecksum(path, sum string) (string, error) {
+	if d.config.Checksums == "" || d.config.Checksums == "off" {
+		return "", nil
+	}
+	if sum == "" {
+		var err error
+		if sum, err = hashFile(path); err != nil {
+			return "", fmt.Errorf("failed to hash %s: %v", filepath.Base(path), err)
+		}
+	}
+
+	if d.config.Checksums == "sidecar" {
+		line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))
+		return sum, os.WriteFile(sha256Path(path), []byte(line), 0644)
+	}
+
+	checksumsMu.Lock()
+	defer checksumsMu.Unlock()
+	f, err := os.OpenFile(filepath.Join(d.config.OutputDir, checksumsFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return sum, err
+	}
+	// Paths are relative to the output directory, as sha256sum -c expects
+	line := fmt.Sprintf("%s  %s\n", sum, strings.ReplaceAll(d.storageName(path), "\n", " "))
+	if _, err := f.WriteString(line); err != nil {
+		f.Close()
+		return sum, err
+	}
+	return sum, f.Close()
+}
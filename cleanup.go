@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// cleanupRegistry tracks temporary files so they can be removed when the
+// process is interrupted or a download panics, instead of being left
+// scattered through the output directory.
+type cleanupRegistry struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+func newCleanupRegistry() *cleanupRegistry {
+	return &cleanupRegistry{paths: make(map[string]bool)}
+}
+
+func (r *cleanupRegistry) add(path string) {
+	r.mu.Lock()
+	r.paths[path] = true
+	r.mu.Unlock()
+}
+
+// forget stops tracking path, once it has been renamed or removed.
+func (r *cleanupRegistry) forget(path string) {
+	r.mu.Lock()
+	delete(r.paths, path)
+	r.mu.Unlock()
+}
+
+// removeAll deletes every tracked file and returns how many existed.
+func (r *cleanupRegistry) removeAll() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	removed := 0
+	for path := range r.paths {
+		if os.Remove(path) == nil {
+			removed++
+		}
+		delete(r.paths, path)
+	}
+	return removed
+}
+
+// trackTemp registers a partial stream file. In continue mode partial
+// files are kept for the next run, so they are not tracked.
+func (d *Downloader) trackTemp(path string) {
+	if d.config.ExistingPolicy != ExistingContinue {
+		d.temps.add(path)
+	}
+}
+
+// discardTemp removes a temp file that is no longer needed.
+func (d *Downloader) discardTemp(path string) {
+	os.Remove(path)
+	d.temps.forget(path)
+}
+
+func partPath(path string) string {
+	return path + ".part"
+}
+
+// commitPart atomically moves a finished .part file to its final name.
+func (d *Downloader) commitPart(path string) error {
+	part := partPath(path)
+	defer d.temps.forget(part)
+	if err := os.Rename(part, path); err != nil {
+		os.Remove(part)
+		return fmt.Errorf("failed to finalise %s: %v", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// ffmpegMuxers maps output extensions to ffmpeg muxers, which must be named
+// explicitly since a .part file's extension says nothing about its format.
+var ffmpegMuxers = map[string]string{
+	".mp4":  "mp4",
+	".m4a":  "mp4",
+	".webm": "webm",
+	".mkv":  "matroska",
+	".mp3":  "mp3",
+	".opus": "opus",
+}
+
+// writePart runs an FFmpeg operation writing to a .part file next to
+// outputPath, which replaces outputPath only once it has succeeded.
+func (d *Downloader) writePart(outputPath string, run func(mediaOutput) error) error {
+	part := partPath(outputPath)
+	d.temps.add(part)
+
+	start := time.Now()
+	err := run(mediaOutput{path: part, format: ffmpegMuxers[strings.ToLower(filepath.Ext(outputPath))]})
+	d.ffmpegTimes.observe(time.Since(start))
+	if err != nil {
+		os.Remove(part)
+		d.temps.forget(part)
+		return err
+	}
+	return d.commitPart(outputPath)
+}
+
+// handleInterrupts cancels all jobs on the first SIGINT/SIGTERM so they
+// unwind and clean up after themselves; a second signal removes the
+// remaining temp files and exits immediately. SIGQUIT (Ctrl-\) instead
+// stops once the running downloads finish.
+func (d *Downloader) handleInterrupts() (stop func()) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+	done := make(chan struct{})
+
+	go func() {
+		interrupted := false
+		for {
+			select {
+			case sig := <-signals:
+				if sig == syscall.SIGQUIT {
+					d.StopAfterCurrent()
+					continue
+				}
+				if interrupted {
+					d.temps.removeAll()
+					os.Exit(exitTotalFailure)
+				}
+				interrupted = true
+				d.logger.Printf("Interrupted, canceling downloads (interrupt again to quit now)")
+				d.CancelAll()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+		if n := d.temps.removeAll(); n > 0 {
+			d.logger.Printf("Removed %d temporary files", n)
+		}
+	}
+}
@@ -0,0 +1,1196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"yt-dl-go/internal/fakeyt"
+)
+
+const progName = "youtube-downloader"
+
+// command is a subcommand. Commands with setup get a flag set that is
+// preloaded from the config file; the rest parse their own arguments.
+type command struct {
+	name    string
+	args    string
+	summary string
+	setup   func(flags *flag.FlagSet) func(args []string) error
+	run     func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	// Assigned in init because the help command refers back to the table
+	commands = []command{
+		{name: "download", args: "<url|id|ytsearchN:query>...", summary: "Download videos (the default when no command is given)", setup: setupDownload},
+		{name: "playlist", args: "<playlist url|id>", summary: "Download a playlist", setup: setupPlaylist},
+		{name: "channel", args: "<channel url|@handle|UC id>", summary: "Download a channel's uploads", setup: setupChannel},
+		{name: "archive", args: "<channel url|@handle|UC id>", summary: "Back up a whole channel with its metadata, thumbnails and subtitles in dated directories", setup: setupArchive},
+		{name: "watch", args: "<channel|playlist|feed url>...", summary: "Keep checking channels and playlists and download new uploads", setup: setupWatch},
+		{name: "info", args: "<url|id>", summary: "Show a video's metadata", setup: setupInfo},
+		{name: "history", args: "[-has] [terms|video ids]...", summary: "List or search recorded downloads, or check whether videos were downloaded", setup: setupHistory},
+		{name: "search", args: "<query>", summary: "Search titles, descriptions and transcripts of downloaded videos", setup: setupSearch},
+		{name: "formats", args: "<url|id>", summary: "List a video's available formats", setup: setupFormats},
+		{name: "serve", args: "", summary: "Run a download daemon with an HTTP API", setup: setupServe},
+		{name: "stream", args: "<url|id>", summary: "Serve a video over HTTP for players on the network, without downloading it first", setup: setupStream},
+		{name: "bot", args: "", summary: "Run a Telegram bot that downloads the links it is sent", setup: setupBot},
+		{name: "config", args: "init|path", summary: "Manage the config file", run: runConfig},
+		{name: "manifest", args: "[-format csv|json] [-o file] [-history db] <dir>", summary: "Write a manifest of downloaded files", run: runManifest},
+		{name: "cache", args: "clear|path", summary: "Manage the video and playlist metadata cache", run: runCache},
+		{name: "auth", args: "add|list|remove", summary: "Manage stored credentials", run: runAuth},
+		{name: "audit", args: "[dir]", summary: "Find name collisions, duplicates and files missing from history", setup: setupAudit},
+		{name: "trash", args: "list|empty|restore", summary: "Manage deleted downloads", run: runTrash},
+		{name: "export-job", args: "[-no-data] <video id> [file]", summary: "Bundle an interrupted download", run: runExportJob},
+		{name: "import-job", args: "<bundle>", summary: "Restore a bundled download", run: runImportJob},
+		{name: "import-history", args: "[-replace] <watch-history.json>", summary: "Import a Google Takeout watch history for -skip-watched", run: runImportHistory},
+		{name: "doctor", args: "", summary: "Check ffmpeg, network, output directory and credentials for common problems", setup: setupDoctor},
+		{name: "help", args: "[command]", summary: "Show help for a command", run: runHelp},
+	}
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// runCommand parses args for cmd, applying config file defaults first.
+func runCommand(cmd *command, args []string) error {
+	if cmd.setup == nil {
+		return cmd.run(args)
+	}
+	flags, run, err := parseCommandFlags(cmd, args, flag.ExitOnError)
+	if err != nil {
+		return err
+	}
+	return run(flags.Args())
+}
+
+// parseCommandFlags builds cmd's flag set, applies the config file and
+// then parses args so the command line takes precedence.
+func parseCommandFlags(cmd *command, args []string, handling flag.ErrorHandling) (*flag.FlagSet, func([]string) error, error) {
+	flags := flag.NewFlagSet(cmd.name, handling)
+	flags.String("config", "", "Config file (default $YTDL_CONFIG or ~/.config/ytdl-go/config.yaml); any flag can also be set with YTDL_<FLAG>, e.g. YTDL_LIMIT_RATE")
+	flags.String("profile", "", "Apply a named set of settings: music, archive, podcast or one from the config file's profiles section")
+	flags.BoolVar(&rawUnits, "raw-units", false, "Print sizes in bytes and durations in seconds instead of human-readable units")
+	run := cmd.setup(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [flags] %s\n\n%s.\n\nFlags:\n", progName, cmd.name, cmd.args, cmd.summary)
+		flags.PrintDefaults()
+	}
+
+	// The environment comes first, then the config file, then the
+	// profile, then the command line, each overriding the one before
+	if err := applyEnv(flags); err != nil {
+		return nil, nil, fmt.Errorf("failed to read environment: %v", err)
+	}
+	configPath := argFlagValue(args, "config")
+	if configPath == "" {
+		configPath = envConfigPath()
+	}
+	values, err := loadConfigFile(flags, configPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load config: %v", err)
+	}
+	profile := argFlagValue(args, "profile")
+	if profile == "" {
+		profile = flags.Lookup("profile").Value.String()
+	}
+	if err := applyProfile(flags, profile, values["profiles"]); err != nil {
+		return nil, nil, err
+	}
+	resetRepeatedFlags(flags, args)
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	return flags, run, nil
+}
+
+// knownSetting reports whether any command defines a flag called name, so
+// the config file can hold settings for every command.
+func knownSetting(name string) bool {
+	for _, cmd := range commands {
+		if cmd.setup == nil {
+			continue
+		}
+		flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		cmd.setup(flags)
+		if flags.Lookup(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func printUsage() {
+	fmt.Printf("Usage: %s <command> [flags] [args]\n\nCommands:\n", progName)
+	for _, cmd := range commands {
+		fmt.Printf("  %-11s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Printf("\nRun \"%s help <command>\" for a command's flags.\n", progName)
+}
+
+func runHelp(args []string) error {
+	if len(args) == 0 {
+		printUsage()
+		return nil
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	if cmd.setup == nil {
+		fmt.Printf("Usage: %s %s %s\n\n%s.\n", progName, cmd.name, cmd.args, cmd.summary)
+		return nil
+	}
+	return runCommand(cmd, []string{"-h"})
+}
+
+// exitError carries a process exit code out of a command.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+
+func exitWith(err error) {
+	var exit *exitError
+	if errors.As(err, &exit) {
+		log.Print(exit.err)
+		os.Exit(exit.code)
+	}
+	log.Print(err)
+	os.Exit(exitTotalFailure)
+}
+
+// clientOptions are the flags for commands that talk to YouTube.
+type clientOptions struct {
+	proxy    *string
+	authName *string
+	resolver *string
+	players  *string
+	ipv4     *bool
+	ipv6     *bool
+	source   *string
+	iface    *string
+	agent    *string
+	headers  stringList
+	geo      *string
+	maxRPM   *int
+	rpmBurst *int
+	sleepReq *time.Duration
+	idle     *int
+	idleHost *int
+	hostConn *string
+	http2    *bool
+	tcpAlive *time.Duration
+	testMode *bool
+}
+
+func registerClientFlags(flags *flag.FlagSet) *clientOptions {
+	o := &clientOptions{
+		proxy:    flags.String("proxy", "", "HTTP, HTTPS or SOCKS5 proxy URL"),
+		authName: flags.String("auth", "", "Use a stored credential (see the auth command)"),
+		resolver: flags.String("resolver", "", "Fallback when a video can't be extracted: invidious:URL, piped:URL, a URL with {} for the video ID, or a command printing JSON"),
+		players:  flags.String("player-client", defaultPlayerClients, "Comma-separated innertube clients to extract with, each tried when the one before fails ("+strings.Join(playerClientNames(), ", ")+")"),
+		maxRPM:   flags.Int("max-rpm", 0, "Make at most this many requests a minute to YouTube's pages and API, not counting media downloads (0 for no limit)"),
+		rpmBurst: flags.Int("rpm-burst", 5, "Requests allowed at once under -max-rpm after a quiet spell"),
+		sleepReq: flags.Duration("sleep-requests", 0, "Pause for around this long, randomized, between requests to YouTube's pages and API, such as playlist page fetches"),
+		ipv4:     flags.Bool("force-ipv4", false, "Connect over IPv4 only"),
+		ipv6:     flags.Bool("force-ipv6", false, "Connect over IPv6 only"),
+		source:   flags.String("source-address", "", "Connect from this local IP address"),
+		iface:    flags.String("interface", "", "Connect from this network interface's address, e.g. eth1"),
+		agent:    flags.String("user-agent", "", "User-Agent for every request, except those made as a -player-client"),
+		geo:      flags.String("geo-bypass-country", "", "Claim to be in this country, e.g. US, with a made-up X-Forwarded-For address"),
+		idle:     flags.Int("max-idle-conns", 100, "Idle connections kept open for reuse, across all hosts"),
+		idleHost: flags.Int("max-idle-conns-per-host", 16, "Idle connections kept open for reuse to each host"),
+		hostConn: flags.String("max-conns-per-host", "0", "Connections open at once to each host, and to the hosts of a domain, e.g. \"8,googlevideo.com=32\"; 0 for no limit"),
+		http2:    flags.Bool("http2", true, "Use HTTP/2 where the server supports it"),
+		tcpAlive: flags.Duration("tcp-keepalive", 30*time.Second, "Interval of TCP keep-alive probes; 0 to turn them off"),
+		testMode: flags.Bool("test-mode", false, "Use a built-in offline fake YouTube (try fakevideo01, PLfakeplaylist0001 or channel UCfakechannel00000000000)"),
+	}
+	flags.Var(&o.headers, "add-header", "Send this header with every request, as Name:value (repeatable)")
+	return o
+}
+
+// newDownloader builds a downloader for config with the client options
+// applied. The returned function releases the test-mode server.
+func (o *clientOptions) newDownloader(config Config) (*Downloader, func(), error) {
+	config.Proxy = *o.proxy
+	config.Resolver = *o.resolver
+	config.PlayerClients = *o.players
+	config.ForceIPv4 = *o.ipv4
+	config.ForceIPv6 = *o.ipv6
+	config.SourceAddress = *o.source
+	config.Interface = *o.iface
+	config.UserAgent = *o.agent
+	config.GeoBypassCountry = *o.geo
+	headers, err := parseHeaders(o.headers)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid -add-header: %v", err)
+	}
+	config.Headers = headers
+	config.MaxRPM = *o.maxRPM
+	config.RPMBurst = *o.rpmBurst
+	config.SleepRequests = *o.sleepReq
+	if *o.idle < 0 || *o.idleHost < 0 {
+		return nil, nil, fmt.Errorf("-max-idle-conns and -max-idle-conns-per-host must not be negative")
+	}
+	config.MaxIdleConns, config.MaxIdleConnsPerHost = *o.idle, *o.idleHost
+	if config.MaxConnsPerHost, config.HostConns, err = parseHostConns(*o.hostConn); err != nil {
+		return nil, nil, fmt.Errorf("invalid -max-conns-per-host: %v", err)
+	}
+	config.DisableHTTP2 = !*o.http2
+	config.TCPKeepAlive = *o.tcpAlive
+	if config.TCPKeepAlive <= 0 {
+		config.TCPKeepAlive = -1
+	}
+	downloader, err := NewDownloader(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	if config.DumpJSON != nil || config.toStdout() {
+		downloader.logger.SetOutput(os.Stderr)
+	}
+
+	if *o.authName != "" {
+		credential, err := findCredential(*o.authName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load credential: %v", err)
+		}
+		downloader.http.Transport = &authTransport{
+			base:       downloader.http.Transport,
+			credential: credential,
+		}
+		downloader.credential = &credential
+	}
+
+	closeFn := func() {}
+	if *o.testMode {
+		fake := fakeyt.New()
+		// The fake server's URLs die with it, so they are never cached
+		downloader.cache = nil
+		downloader.setClient(fake.Provider())
+		downloader.logger.Printf("Test mode: serving fake videos from %s", fake.URL)
+		closeFn = fake.Close
+	}
+	return downloader, closeFn, nil
+}
+
+// downloadOptions are the flags shared by every command that downloads.
+type downloadOptions struct {
+	*clientOptions
+	flags *flag.FlagSet // for the config file's categories
+
+	mp3               *bool
+	extractAudio      *bool
+	audioFormat       *string
+	outputDir         *string
+	concurrency       *int
+	metaConcurrency   *int
+	ffmpegConcurrency *int
+	writeInfo         *bool
+	writeNFO          *bool
+	writeDescription  *bool
+	writeComments     *bool
+	maxComments       *int
+	writeThumbnail    *bool
+	writeSubs         *bool
+	subLangs          *string
+	checksums         *string
+	embedMetadata     *bool
+	skipExisting      *bool
+	overwrite         *bool
+	continueFlag      *bool
+	verifyExisting    *bool
+	liveFromStart     *bool
+	waitForLive       *bool
+	waitInterval      *time.Duration
+	preview           *bool
+	previewSeconds    *int
+	previewUpgrade    *bool
+	notifyURL         *string
+	discordWebhook    *string
+	plexURL           *string
+	plexToken         *string
+	plexSection       *string
+	jellyfinURL       *string
+	jellyfinAPIKey    *string
+	execCmd           *string
+	execBefore        *string
+	quality           *string
+	formatSpec        *string
+	progress          *string
+	organize          *string
+	dedupe            *string
+	outputTemplate    *string
+	archivePath       *string
+	libraryPath       *string
+	historyPath       *string
+	noCache           *bool
+	cacheTTL          *time.Duration
+	skipWatched       *bool
+	videoCodec        *string
+	audioCodec        *string
+	remuxTo           *string
+	restrictNames     *bool
+	maxNameLength     *int
+	container         *string
+	autoCrop          *bool
+	limitRate         *string
+	throttledRate     *string
+	adaptiveQuality   *bool
+	timeBudget        *time.Duration
+	bufferSize        *string
+	fsyncEvery        *string
+	preallocate       *bool
+	downloader        *string
+	downloaderArgs    *string
+	sleepInterval     *time.Duration
+	maxSleepInterval  *time.Duration
+	noCheckSpace      *bool
+	paranoid          *bool
+	paranoidRanges    *int
+	copyTo            stringList
+	sections          stringList
+	dest              *string
+	outputFile        *string
+	summaryJSON       *string
+	dumpJSON          *bool
+	maxFailures       *int
+	jobRetention      *time.Duration // set by the commands that keep running
+	dateFormat        *string
+	verbose           *bool
+	ffmpegPath        *string
+	hwaccel           *string
+	normalizeAudio    *bool
+	splitBy           *time.Duration
+	splitSilence      *bool
+	splitSilenceMin   *time.Duration
+	audioLang         *string
+	allTracks         *bool
+	keepSeparate      *bool
+	loudnessTarget    *float64
+	ffmpegArgs        *string
+	dateTimezone      *string
+	minDuration       *time.Duration
+	maxDuration       *time.Duration
+	uploadedAfter     *string
+	uploadedBefore    *string
+	minViews          *int
+	maxViews          *int
+	maxFilesize       *string
+	maxTotalSize      *string
+	matchTitle        *string
+	rejectTitle       *string
+	matchDescription  *string
+	rejectDescription *string
+
+	// Registered by the commands that support it
+	tui *bool
+}
+
+// registerJobRetention adds -job-retention to the commands that keep
+// running, whose job lists would otherwise grow for as long as they do.
+func (o *downloadOptions) registerJobRetention(flags *flag.FlagSet) {
+	o.jobRetention = flags.Duration("job-retention", time.Hour, "Forget finished downloads this long after the request or check they were part of ends (0 to keep them all)")
+}
+
+func registerDownloadFlags(flags *flag.FlagSet) *downloadOptions {
+	o := &downloadOptions{
+		clientOptions:     registerClientFlags(flags),
+		flags:             flags,
+		mp3:               flags.Bool("mp3", false, "Download as MP3 (audio only)"),
+		extractAudio:      flags.Bool("x", false, "Download audio only, in the format of -audio-format"),
+		audioFormat:       flags.String("audio-format", "", "Format of -x downloads: best (the source's Opus or AAC, not re-encoded), mp3, opus or m4a (default best)"),
+		outputDir:         flags.String("output", "downloads", "Output directory"),
+		concurrency:       flags.Int("concurrency", 3, "Maximum number of concurrent stream downloads"),
+		metaConcurrency:   flags.Int("metadata-concurrency", 5, "Maximum number of concurrent metadata fetches"),
+		ffmpegConcurrency: flags.Int("ffmpeg-concurrency", runtime.NumCPU(), "Maximum number of concurrent ffmpeg merge/convert jobs"),
+		writeInfo:         flags.Bool("write-info-json", false, "Write video metadata to a .info.json sidecar"),
+		writeNFO:          flags.Bool("write-nfo", false, "Write a Kodi/Jellyfin .nfo file next to each download"),
+		writeDescription:  flags.Bool("write-description", false, "Write the video description to a .description file"),
+		writeComments:     flags.Bool("write-comments", false, "Write the top comments, with author, likes and time, to a .comments.json file"),
+		maxComments:       flags.Int("max-comments", 100, "How many comments -write-comments fetches"),
+		writeThumbnail:    flags.Bool("write-thumbnail", false, "Save the video's largest thumbnail next to each download"),
+		writeSubs:         flags.Bool("write-subs", false, "Save subtitles as .<lang>.vtt files, preferring uploaded over automatic captions"),
+		subLangs:          flags.String("sub-langs", "en", "Comma-separated subtitle languages for -write-subs, or \"all\""),
+		checksums:         flags.String("checksums", "off", "Record the SHA-256 of each download: off, file (checksums.txt in the output directory) or sidecar (a .sha256 per file)"),
+		embedMetadata:     flags.Bool("embed-metadata", false, "Tag files with title, channel, category and license metadata"),
+		skipExisting:      flags.Bool("skip-existing", true, "Skip videos whose output file already exists"),
+		overwrite:         flags.Bool("overwrite", false, "Re-download existing output files, moving the old ones to the trash"),
+		continueFlag:      flags.Bool("continue", false, "Resume partially downloaded files instead of starting over"),
+		verifyExisting:    flags.Bool("verify-existing", false, "Check existing files' duration with ffprobe before skipping them"),
+		liveFromStart:     flags.Bool("live-from-start", false, "Record live streams from the start of the DVR window"),
+		waitForLive:       flags.Bool("wait-for-live", false, "Wait for scheduled streams and premieres to start"),
+		waitInterval:      flags.Duration("wait-interval", time.Minute, "How often to check a scheduled stream with -wait-for-live"),
+		preview:           flags.Bool("preview", false, "Download a quick low-quality preview instead of the full video"),
+		previewSeconds:    flags.Int("preview-seconds", 0, "Limit the preview to the first N seconds (requires ffmpeg)"),
+		previewUpgrade:    flags.Bool("preview-upgrade", false, "After the preview, download the full-quality video and remove the preview"),
+		notifyURL:         flags.String("notify-url", "", "POST a JSON notification to this URL when each download finishes"),
+		discordWebhook:    flags.String("discord-webhook", "", "Discord webhook URL to post each finished or failed download to"),
+		plexURL:           flags.String("plex-url", "", "Plex server to refresh after a batch downloads new files, e.g. http://localhost:32400"),
+		plexToken:         flags.String("plex-token", "", "Plex authentication token for -plex-url"),
+		plexSection:       flags.String("plex-section", "", "Plex library section ID to refresh (default all sections)"),
+		jellyfinURL:       flags.String("jellyfin-url", "", "Jellyfin server to refresh after a batch downloads new files, e.g. http://localhost:8096"),
+		jellyfinAPIKey:    flags.String("jellyfin-api-key", "", "Jellyfin API key for -jellyfin-url"),
+		execCmd:           flags.String("exec", "", "Run a shell command on each completed file, with {} replaced by its path"),
+		execBefore:        flags.String("exec-before", "", "Run a shell command before each download, with {} replaced by the output path"),
+		quality:           flags.String("quality", "best", "Preferred video quality (e.g. hd1080, hd720, medium)"),
+		organize:          flags.String("organize", "flat", "Put playlist and channel downloads in a directory named after the playlist or channel, numbered by playlist position: flat, playlist or channel"),
+		dedupe:            flags.String("dedupe", "off", "Download a video listed by several playlists in one run only once and put it in the other places as a hardlink, symlink or copy, or skip it there: off, hardlink, symlink, copy or skip"),
+		progress:          flags.String("progress", "none", "Report download progress on stderr: none, bar or json (one JSON object per line)"),
+		formatSpec:        flags.String("f", "", "Format selector, overriding -quality and the codec flags, e.g. \"bestvideo[height<=1080][vcodec^=avc1]+bestaudio[acodec=opus]/best\""),
+		outputTemplate:    flags.String("output-template", defaultOutputTemplate, "Filename template without extension, e.g. \"{artist|channel}/{index?%02d - }{title:truncate(80)}\"; functions: upper, lower, slugify, truncate(n), date(layout)"),
+		archivePath:       flags.String("download-archive", "", "Skip videos listed in this file and record new downloads in it"),
+		skipWatched:       flags.Bool("skip-watched", false, "Skip videos in the watch history imported with import-history"),
+		libraryPath:       flags.String("library", defaultLibraryPath(), "Index downloads in this full-text search library (empty to disable)"),
+		historyPath:       flags.String("history", defaultHistoryPath(), "Record downloads in this SQLite history, which may be shared between machines (empty to disable)"),
+		noCache:           flags.Bool("no-cache", false, "Don't read or write the video and playlist metadata cache"),
+		cacheTTL:          flags.Duration("cache-ttl", time.Hour, "How long cached video and playlist metadata is used; videos are fetched again before their stream URLs expire"),
+		videoCodec:        flags.String("video-codec", "", "Preferred video codec: h264, vp9 or av1"),
+		audioCodec:        flags.String("audio-codec", "", "Preferred audio codec: aac or opus"),
+		autoCrop:          flags.Bool("autocrop", false, "Detect black bars with ffmpeg cropdetect and re-encode the video without them"),
+		remuxTo:           flags.String("remux-to", "", "Always merge into this container (mp4 or mkv) without re-encoding"),
+		restrictNames:     flags.Bool("restrict-filenames", false, "Restrict filenames to ASCII letters, digits, \"-\", \"_\" and \".\", without spaces"),
+		maxNameLength:     flags.Int("max-filename-length", defaultMaxFilenameLength, "Truncate file and directory names to this many bytes, excluding the extension"),
+		container:         flags.String("container", "", "Output container: mp4, webm or mkv; codecs are chosen to fit and re-encoded only if unavoidable"),
+		limitRate:         flags.String("limit-rate", "", "Bandwidth limit in bytes/s, optionally per time of day, e.g. \"1M@09:00-17:00,unlimited\""),
+		sleepInterval:     flags.Duration("sleep-interval", 0, "Wait at least this long between starting one download and the next"),
+		maxSleepInterval:  flags.Duration("max-sleep-interval", 0, "With -sleep-interval, wait a random time up to this long instead"),
+		adaptiveQuality:   flags.Bool("adaptive-quality", false, "Restart a download at a lower resolution when its speed says it would take longer than -time-budget"),
+		timeBudget:        flags.Duration("time-budget", 10*time.Minute, "How long a download may take with -adaptive-quality"),
+		bufferSize:        flags.String("buffer-size", "1M", "Write downloads to disk in chunks of this size, e.g. 256K or 4M"),
+		fsyncEvery:        flags.String("fsync-every", "0", "Sync downloads to disk after every this many bytes, e.g. 64M, for network filesystems; 0 to leave it to the OS"),
+		preallocate:       flags.Bool("preallocate", true, "Reserve the disk space of each download up front to reduce fragmentation"),
+		downloader:        flags.String("downloader", "native", "Fetch streams natively or with aria2c (native, aria2c); formats are still chosen and merged here"),
+		downloaderArgs:    flags.String("downloader-args", "", "Extra aria2c arguments, e.g. \"-x 8 -k 2M\""),
+		throttledRate:     flags.String("throttled-rate", "100K", "Re-resolve a stream's URL when it is served slower than this many bytes/s for 10s; 0 to disable"),
+		paranoid:          flags.Bool("paranoid", false, "Download each stream a second time and compare hashes before finalizing"),
+		paranoidRanges:    flags.Int("paranoid-ranges", 0, "With -paranoid, re-fetch only this many random ranges instead of the whole stream"),
+		summaryJSON:       flags.String("summary-json", "", "Write the end-of-run summary to this file as JSON"),
+		minDuration:       flags.Duration("min-duration", 0, "Skip videos shorter than this, e.g. 2m"),
+		maxDuration:       flags.Duration("max-duration", 0, "Skip videos longer than this, e.g. 1h"),
+		uploadedAfter:     flags.String("uploaded-after", "", "Skip videos uploaded before this date (YYYY-MM-DD, or an age like 30d)"),
+		uploadedBefore:    flags.String("uploaded-before", "", "Skip videos uploaded after this date (YYYY-MM-DD, or an age like 30d)"),
+		minViews:          flags.Int("min-views", 0, "Skip videos with fewer views"),
+		maxViews:          flags.Int("max-views", 0, "Skip videos with more views"),
+		maxFilesize:       flags.String("max-filesize", "0", "Skip videos estimated at more than this size, e.g. 2G, after trying lower resolutions; 0 for no cap"),
+		maxTotalSize:      flags.String("max-total-size", "0", "Stop starting downloads once the run is estimated to pass this size, e.g. 50G; 0 for no cap"),
+		matchTitle:        flags.String("match-title", "", "Only download videos whose title matches this regular expression, e.g. \"(?i)episode \\d+\""),
+		rejectTitle:       flags.String("reject-title", "", "Skip videos whose title matches this regular expression"),
+		matchDescription:  flags.String("match-description", "", "Only download videos whose description matches this regular expression"),
+		rejectDescription: flags.String("reject-description", "", "Skip videos whose description matches this regular expression"),
+		keepSeparate:      flags.Bool("keep-separate", false, "Save the video and audio streams as separate files instead of merging them (no ffmpeg needed)"),
+		normalizeAudio:    flags.Bool("normalize-audio", false, "Normalize the loudness of audio downloads with two-pass EBU R128 loudnorm"),
+		loudnessTarget:    flags.Float64("loudness-target", -16, "Loudness -normalize-audio aims for, in LUFS"),
+		splitBy:           flags.Duration("split-by", 0, "Split downloads into numbered parts of this length, e.g. 10m; with -split-silence, cut at the silence nearest each mark"),
+		splitSilence:      flags.Bool("split-silence", false, "Split downloads into numbered parts at silences"),
+		audioLang:         flags.String("audio-lang", "", "Preferred audio tracks of videos with several, in order, e.g. \"ja,en\"; \"original\" is the track the video was made in"),
+		allTracks:         flags.Bool("all-tracks", false, "Merge every audio language and all subtitles into one MKV, each track tagged with its language"),
+		splitSilenceMin:   flags.Duration("split-silence-min", 2*time.Second, "How long a silence -split-silence cuts at must last"),
+		hwaccel:           flags.String("hwaccel", "", "Encode video in hardware when re-encoding: nvenc, qsv, videotoolbox or vaapi (falls back to software if unavailable)"),
+		ffmpegPath:        flags.String("ffmpeg-path", "", "ffmpeg binary, or the directory holding ffmpeg and ffprobe, if not in PATH"),
+		ffmpegArgs:        flags.String("ffmpeg-args", "", "Extra ffmpeg arguments for merging and MP3 conversion, e.g. \"-af loudnorm -movflags +faststart\""),
+		verbose:           flags.Bool("verbose", false, "Log ffmpeg's output as it runs"),
+		dateFormat:        flags.String("date-format", "", "Upload date layout in filenames and tags: compact (20240501), iso (2024-05-01) or a Go layout such as \"Jan 2, 2006\""),
+		dateTimezone:      flags.String("date-timezone", "UTC", "Time zone for upload times: UTC, local or a name such as Europe/Berlin"),
+		maxFailures:       flags.Int("max-failures", 0, "Stop starting new downloads in a batch after this many fail (0 for no limit)"),
+		noCheckSpace:      flags.Bool("no-check-space", false, "Only warn instead of failing when a download may not fit on the output volume"),
+	}
+	flags.Var(&o.sections, "section", "Download only this part of each video, e.g. 00:10:30-00:15:00 or 10:30- for the rest (repeatable, one file per section)")
+	flags.Var(&o.copyTo, "copy-to", "Also copy finished files to this directory, s3://bucket/prefix, gs://bucket/prefix, sftp://user@host/path or webdav[s]://host/path (repeatable)")
+	o.dest = flags.String("dest", "", "Store finished files in s3://, gs://, sftp:// or webdav[s]:// storage instead of the output directory, which then only holds temp files")
+	o.outputFile = flags.String("o", "", "Same as -output-template, or - to write the download to stdout for a player, e.g. -o - | mpv -")
+	o.dumpJSON = flags.Bool("J", false, "Print a yt-dlp style JSON object for each downloaded video on stdout, with logs on stderr")
+	flags.BoolVar(o.dumpJSON, "dump-json", false, "Same as -J")
+	return o
+}
+
+// Config validates the parsed flags and builds the downloader config.
+func (o *downloadOptions) Config() (Config, error) {
+	if *o.concurrency < 1 || *o.metaConcurrency < 1 || *o.ffmpegConcurrency < 1 {
+		return Config{}, fmt.Errorf("concurrency values must be at least 1")
+	}
+	if *o.maxFailures < 0 {
+		return Config{}, fmt.Errorf("-max-failures must not be negative")
+	}
+	filter := videoFilter{
+		MinDuration: *o.minDuration,
+		MaxDuration: *o.maxDuration,
+		MinViews:    *o.minViews,
+		MaxViews:    *o.maxViews,
+	}
+	var err error
+	if filter.UploadedAfter, err = parseDateFlag(*o.uploadedAfter); err != nil {
+		return Config{}, fmt.Errorf("invalid -uploaded-after: %v", err)
+	}
+	if filter.UploadedBefore, err = parseDateFlag(*o.uploadedBefore); err != nil {
+		return Config{}, fmt.Errorf("invalid -uploaded-before: %v", err)
+	}
+	dates, err := parseDateFormat(*o.dateFormat, *o.dateTimezone)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid -date-format or -date-timezone: %v", err)
+	}
+	for _, re := range []struct {
+		flag  string
+		value string
+		dst   **regexp.Regexp
+	}{
+		{"match-title", *o.matchTitle, &filter.MatchTitle},
+		{"reject-title", *o.rejectTitle, &filter.RejectTitle},
+		{"match-description", *o.matchDescription, &filter.MatchDescription},
+		{"reject-description", *o.rejectDescription, &filter.RejectDescription},
+	} {
+		if re.value == "" {
+			continue
+		}
+		if *re.dst, err = regexp.Compile(re.value); err != nil {
+			return Config{}, fmt.Errorf("invalid -%s: %v", re.flag, err)
+		}
+	}
+	if filter.MinDuration < 0 || filter.MaxDuration < 0 || filter.MinViews < 0 || filter.MaxViews < 0 {
+		return Config{}, fmt.Errorf("filter values must not be negative")
+	}
+	extraArgs, err := splitArgs(*o.ffmpegArgs)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid -ffmpeg-args: %v", err)
+	}
+	if *o.ffmpegPath != "" {
+		if _, err := exec.LookPath(ffmpegBinary(*o.ffmpegPath, "ffmpeg")); err != nil {
+			return Config{}, fmt.Errorf("invalid -ffmpeg-path: %v", err)
+		}
+	}
+	audioOnly, audioFormat := *o.mp3 || *o.extractAudio, *o.audioFormat
+	if err := validateChoice("audio-format", audioFormat, audioFormats); err != nil {
+		return Config{}, err
+	}
+	switch {
+	case *o.mp3 && audioFormat != "" && audioFormat != "mp3":
+		return Config{}, fmt.Errorf("-mp3 converts to MP3; use -x -audio-format %s instead", audioFormat)
+	case *o.mp3:
+		audioFormat = "mp3"
+	case audioFormat != "" && !audioOnly:
+		return Config{}, fmt.Errorf("-audio-format applies to audio downloads and needs -x")
+	case audioFormat == "":
+		audioFormat = "best"
+	}
+	if audioOnly || *o.previewSeconds > 0 || len(extraArgs) > 0 || *o.splitBy > 0 || *o.splitSilence || *o.allTracks {
+		if _, err := exec.LookPath(ffmpegBinary(*o.ffmpegPath, "ffmpeg")); err != nil {
+			return Config{}, fmt.Errorf("%w; it is required for audio downloads, -preview-seconds, -ffmpeg-args, -all-tracks and splitting", ErrFFmpegMissing)
+		}
+	}
+	if *o.keepSeparate {
+		for _, other := range []struct {
+			flag string
+			set  bool
+		}{
+			{"mp3", *o.mp3},
+			{"x", *o.extractAudio},
+			{"remux-to", *o.remuxTo != ""},
+			{"container", *o.container != ""},
+			{"autocrop", *o.autoCrop},
+			{"section", len(o.sections) > 0},
+			{"split-by", *o.splitBy > 0},
+			{"split-silence", *o.splitSilence},
+			{"all-tracks", *o.allTracks},
+		} {
+			if other.set {
+				return Config{}, fmt.Errorf("-keep-separate doesn't merge streams and cannot be combined with -%s", other.flag)
+			}
+		}
+	}
+	if *o.allTracks {
+		switch {
+		case audioOnly:
+			return Config{}, fmt.Errorf("-all-tracks merges video and cannot be combined with -mp3 or -x")
+		case *o.remuxTo != "" && *o.remuxTo != "mkv", *o.container != "" && *o.container != "mkv":
+			return Config{}, fmt.Errorf("-all-tracks always writes MKV")
+		}
+	}
+	if *o.splitBy < 0 || (*o.splitBy > 0 && *o.splitBy < time.Minute) {
+		return Config{}, fmt.Errorf("-split-by must be at least 1m")
+	}
+	if *o.splitSilenceMin < 100*time.Millisecond {
+		return Config{}, fmt.Errorf("-split-silence-min must be at least 100ms")
+	}
+	if (*o.splitBy > 0 || *o.splitSilence) && *o.dest != "" {
+		return Config{}, fmt.Errorf("-split-by and -split-silence need local files and cannot be combined with -dest")
+	}
+	if *o.normalizeAudio && !audioOnly {
+		return Config{}, fmt.Errorf("-normalize-audio applies to audio downloads and needs -mp3 or -x")
+	}
+	if *o.loudnessTarget < -70 || *o.loudnessTarget > -5 {
+		return Config{}, fmt.Errorf("-loudness-target must be between -70 and -5 LUFS")
+	}
+	if *o.writeSubs && len(splitList(*o.subLangs)) == 0 {
+		return Config{}, fmt.Errorf("-write-subs needs at least one -sub-langs language")
+	}
+	if *o.maxComments < 1 {
+		return Config{}, fmt.Errorf("-max-comments must be at least 1")
+	}
+	if *o.maxNameLength < minFilenameLength {
+		return Config{}, fmt.Errorf("-max-filename-length must be at least %d", minFilenameLength)
+	}
+	if *o.waitInterval <= 0 {
+		return Config{}, fmt.Errorf("-wait-interval must be positive")
+	}
+	if *o.sleepInterval < 0 {
+		return Config{}, fmt.Errorf("-sleep-interval must not be negative")
+	}
+	if *o.maxSleepInterval != 0 {
+		if *o.sleepInterval == 0 {
+			return Config{}, fmt.Errorf("-max-sleep-interval needs -sleep-interval")
+		}
+		if *o.maxSleepInterval < *o.sleepInterval {
+			return Config{}, fmt.Errorf("-max-sleep-interval must not be less than -sleep-interval")
+		}
+	}
+
+	if *o.adaptiveQuality && *o.timeBudget < 2*adaptiveWarmup {
+		return Config{}, fmt.Errorf("-time-budget must be at least %s", 2*adaptiveWarmup)
+	}
+	bufferSize, err := parseSize(*o.bufferSize)
+	if err != nil || bufferSize < 4<<10 || bufferSize > 1<<30 {
+		return Config{}, fmt.Errorf("-buffer-size must be between 4K and 1G")
+	}
+	fsyncEvery, err := parseSize(*o.fsyncEvery)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid -fsync-every: %v", err)
+	}
+	maxFilesize, err := parseSize(*o.maxFilesize)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid -max-filesize: %v", err)
+	}
+	maxTotalSize, err := parseSize(*o.maxTotalSize)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid -max-total-size: %v", err)
+	}
+	if err := validateChoice("downloader", *o.downloader, downloaders); err != nil {
+		return Config{}, err
+	}
+	downloaderArgs, err := splitArgs(*o.downloaderArgs)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid -downloader-args: %v", err)
+	}
+	if *o.downloader == "aria2c" {
+		if _, err := exec.LookPath("aria2c"); err != nil {
+			return Config{}, fmt.Errorf("-downloader aria2c: %v", err)
+		}
+	} else if len(downloaderArgs) > 0 {
+		return Config{}, fmt.Errorf("-downloader-args needs -downloader aria2c")
+	}
+	throttledRate, err := parseRate(*o.throttledRate)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid -throttled-rate: %v", err)
+	}
+
+	existingPolicy := ExistingSkip
+	switch {
+	case *o.overwrite && *o.continueFlag:
+		return Config{}, fmt.Errorf("-overwrite and -continue cannot be combined")
+	case *o.overwrite:
+		existingPolicy = ExistingOverwrite
+	case *o.continueFlag:
+		existingPolicy = ExistingContinue
+	case !*o.skipExisting:
+		existingPolicy = ExistingOverwrite
+	}
+
+	if err := validateChoice("video-codec", *o.videoCodec, videoCodecs); err != nil {
+		return Config{}, err
+	}
+	if err := validateChoice("audio-codec", *o.audioCodec, audioCodecs); err != nil {
+		return Config{}, err
+	}
+	if err := validateChoice("hwaccel", *o.hwaccel, hwAccelNames); err != nil {
+		return Config{}, err
+	}
+	if err := validateChoice("remux-to", *o.remuxTo, []string{"mp4", "mkv"}); err != nil {
+		return Config{}, err
+	}
+	if err := validateChoice("container", *o.container, []string{"mp4", "webm", "mkv"}); err != nil {
+		return Config{}, err
+	}
+	if *o.remuxTo != "" && *o.container != "" {
+		return Config{}, fmt.Errorf("-remux-to and -container cannot be combined")
+	}
+	if *o.autoCrop && *o.remuxTo != "" {
+		return Config{}, fmt.Errorf("-autocrop re-encodes the video and cannot be combined with -remux-to")
+	}
+
+	if *o.plexURL != "" && *o.plexToken == "" {
+		return Config{}, fmt.Errorf("-plex-url needs -plex-token")
+	}
+	if *o.jellyfinURL != "" && *o.jellyfinAPIKey == "" {
+		return Config{}, fmt.Errorf("-jellyfin-url needs -jellyfin-api-key")
+	}
+	if err := validateChoice("checksums", *o.checksums, checksumModes); err != nil {
+		return Config{}, err
+	}
+	if err := validateChoice("organize", *o.organize, organizeModes); err != nil {
+		return Config{}, err
+	}
+	if *o.cacheTTL < 0 {
+		return Config{}, fmt.Errorf("-cache-ttl must not be negative")
+	}
+	if err := validateChoice("dedupe", *o.dedupe, dedupeModes); err != nil {
+		return Config{}, err
+	}
+	if err := validateChoice("progress", *o.progress, progressReporters); err != nil {
+		return Config{}, err
+	}
+	// The TUI drives the terminal with stty
+	if o.tui != nil && *o.tui && runtime.GOOS == "windows" {
+		return Config{}, fmt.Errorf("-tui is not supported on Windows")
+	}
+	if o.tui != nil && *o.tui && *o.progress != "none" {
+		return Config{}, fmt.Errorf("-progress cannot be combined with -tui, which shows its own")
+	}
+	if o.tui != nil && *o.tui && *o.dumpJSON {
+		return Config{}, fmt.Errorf("-J cannot be combined with -tui, which draws on stdout")
+	}
+
+	var spec *formatSpec
+	if *o.formatSpec != "" {
+		if spec, err = parseFormatSpec(*o.formatSpec); err != nil {
+			return Config{}, err
+		}
+	}
+
+	outputTemplate, toStdout := *o.outputTemplate, *o.outputFile == "-"
+	if *o.outputFile != "" && !toStdout {
+		if outputTemplate != defaultOutputTemplate {
+			return Config{}, fmt.Errorf("-o and -output-template cannot be combined")
+		}
+		outputTemplate = *o.outputFile
+	}
+	if err := validateTemplate(outputTemplate); err != nil {
+		return Config{}, fmt.Errorf("invalid -output-template: %v", err)
+	}
+	if toStdout {
+		// Logs, progress and the summary then all go to stderr
+		for _, other := range []struct {
+			flag string
+			set  bool
+		}{
+			{"dest", *o.dest != ""},
+			{"copy-to", len(o.copyTo) > 0},
+			{"J", *o.dumpJSON},
+			{"tui", o.tui != nil && *o.tui},
+			{"continue", *o.continueFlag},
+			{"keep-separate", *o.keepSeparate},
+			{"section", len(o.sections) > 1},
+			{"split-by", *o.splitBy > 0},
+			{"split-silence", *o.splitSilence},
+			{"preview-upgrade", *o.previewUpgrade},
+			{"write-info-json", *o.writeInfo},
+			{"write-nfo", *o.writeNFO},
+			{"write-description", *o.writeDescription},
+			{"write-comments", *o.writeComments},
+			{"write-thumbnail", *o.writeThumbnail},
+			{"write-subs", *o.writeSubs},
+			{"checksums sidecar", *o.checksums == "sidecar"},
+		} {
+			if other.set {
+				return Config{}, fmt.Errorf("-o - writes one file to stdout and cannot be combined with -%s", other.flag)
+			}
+		}
+		// Nothing is left locally to skip or resume
+		existingPolicy = ExistingOverwrite
+	}
+
+	config := Config{
+		OutputDir:             *o.outputDir,
+		MaxConcurrent:         *o.concurrency,
+		MetadataConcurrent:    *o.metaConcurrency,
+		PostProcessConcurrent: *o.ffmpegConcurrency,
+		Quality:               *o.quality,
+		Format:                spec,
+		Progress:              newProgressReporter(*o.progress, os.Stderr),
+		Organize:              *o.organize,
+		Dedupe:                *o.dedupe,
+		AudioOnly:             audioOnly,
+		AudioFormat:           audioFormat,
+		WriteInfoJSON:         *o.writeInfo,
+		WriteNFO:              *o.writeNFO,
+		WriteDescription:      *o.writeDescription,
+		WriteComments:         *o.writeComments,
+		MaxComments:           *o.maxComments,
+		WriteThumbnail:        *o.writeThumbnail,
+		WriteSubs:             *o.writeSubs,
+		SubLangs:              splitList(*o.subLangs),
+		Checksums:             *o.checksums,
+		EmbedMetadata:         *o.embedMetadata,
+		ExistingPolicy:        existingPolicy,
+		VerifyExisting:        *o.verifyExisting,
+		LiveFromStart:         *o.liveFromStart,
+		WaitForLive:           *o.waitForLive,
+		LiveWaitInterval:      *o.waitInterval,
+		NotifyURL:             *o.notifyURL,
+		DiscordWebhook:        *o.discordWebhook,
+		PlexURL:               *o.plexURL,
+		PlexToken:             *o.plexToken,
+		PlexSection:           *o.plexSection,
+		JellyfinURL:           *o.jellyfinURL,
+		JellyfinAPIKey:        *o.jellyfinAPIKey,
+		Preview:               *o.preview || *o.previewSeconds > 0 || *o.previewUpgrade,
+		PreviewSeconds:        *o.previewSeconds,
+		PreviewUpgrade:        *o.previewUpgrade,
+		VideoCodec:            *o.videoCodec,
+		AudioCodec:            *o.audioCodec,
+		RemuxTo:               *o.remuxTo,
+		RestrictFilenames:     *o.restrictNames,
+		MaxFilenameLength:     *o.maxNameLength,
+		Container:             *o.container,
+		AutoCrop:              *o.autoCrop,
+		MaxFailures:           *o.maxFailures,
+		Filter:                filter,
+		MaxFilesize:           maxFilesize,
+		MaxTotalSize:          maxTotalSize,
+		Dates:                 dates,
+		Verbose:               *o.verbose,
+		FFmpegPath:            *o.ffmpegPath,
+		HWAccel:               *o.hwaccel,
+		NormalizeAudio:        *o.normalizeAudio,
+		SplitBy:               *o.splitBy,
+		SplitSilence:          *o.splitSilence,
+		SplitSilenceMin:       *o.splitSilenceMin,
+		AudioLangs:            splitList(*o.audioLang),
+		AllTracks:             *o.allTracks,
+		KeepSeparate:          *o.keepSeparate,
+		LoudnessTarget:        *o.loudnessTarget,
+		ExtraFFmpegArgs:       extraArgs,
+		LimitRate:             *o.limitRate,
+		ThrottledRate:         throttledRate,
+		AdaptiveQuality:       *o.adaptiveQuality,
+		TimeBudget:            *o.timeBudget,
+		BufferSize:            int(bufferSize),
+		FsyncEvery:            fsyncEvery,
+		Preallocate:           *o.preallocate,
+		Downloader:            *o.downloader,
+		DownloaderArgs:        downloaderArgs,
+		SleepInterval:         *o.sleepInterval,
+		MaxSleepInterval:      *o.maxSleepInterval,
+		CheckSpace:            !*o.noCheckSpace,
+		Paranoid:              *o.paranoid || *o.paranoidRanges > 0,
+		ParanoidRanges:        *o.paranoidRanges,
+		OutputTemplate:        outputTemplate,
+		DownloadArchive:       *o.archivePath,
+		LibraryPath:           *o.libraryPath,
+		HistoryPath:           *o.historyPath,
+		CacheTTL:              *o.cacheTTL,
+		SkipWatched:           *o.skipWatched,
+	}
+	if *o.dumpJSON {
+		config.DumpJSON = os.Stdout
+	}
+	if o.jobRetention != nil {
+		config.JobRetention = *o.jobRetention
+	}
+	if !*o.noCache && *o.cacheTTL > 0 {
+		config.CacheDir = defaultCacheDir()
+	}
+	for _, spec := range o.sections {
+		section, err := parseSection(spec)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid -section: %v", err)
+		}
+		config.Sections = append(config.Sections, section)
+	}
+	for _, spec := range o.copyTo {
+		dest, err := parseStorage(spec)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid -copy-to: %v", err)
+		}
+		config.Destinations = append(config.Destinations, dest)
+	}
+	if *o.dest != "" {
+		if len(config.Destinations) > 0 {
+			return Config{}, fmt.Errorf("-copy-to cannot be combined with -dest")
+		}
+		dest, err := parseStorage(*o.dest)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid -dest: %v", err)
+		}
+		config.Dest = dest
+	}
+	hookOut := io.Writer(os.Stdout)
+	if toStdout {
+		config.Dest = &stdoutStorage{w: os.Stdout}
+		hookOut = os.Stderr
+	}
+	if *o.execBefore != "" {
+		config.Hooks.BeforeDownload = execHook(*o.execBefore, hookOut)
+	}
+	if *o.execCmd != "" {
+		config.Hooks.AfterDownload = execHook(*o.execCmd, hookOut)
+	}
+	if o.flags != nil && o.flags.Lookup("config") != nil {
+		path, values, err := readConfigFile(commandConfigPath(o.flags))
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to load config: %v", err)
+		}
+		if config.Categories, err = parseCategories(values["categories"]); err != nil {
+			return Config{}, fmt.Errorf("%s: categories: %v", path, err)
+		}
+	}
+
+	if err := os.MkdirAll(config.OutputDir, 0755); err != nil {
+		return Config{}, fmt.Errorf("failed to create output directory: %v", err)
+	}
+	return config, nil
+}
+
+// runDownloads runs process with the configured downloader, optionally in
+// the TUI, then prints the run reports, writes failed.txt and converts the
+// outcome into an exit code.
+func (o *downloadOptions) runDownloads(retryFailed string, process func(d *Downloader) error) error {
+	config, err := o.Config()
+	if err != nil {
+		return err
+	}
+	downloader, closeFn, err := o.newDownloader(config)
+	if err != nil {
+		return err
+	}
+
+	// With -J, stdout is kept for the JSON, and with -o - for the download
+	out := io.Writer(os.Stdout)
+	if config.DumpJSON != nil || config.toStdout() {
+		out = os.Stderr
+	}
+	if config.toStdout() {
+		// A player that quits fails the write instead of killing the
+		// process, so temp files are still cleaned up
+		signal.Ignore(syscall.SIGPIPE)
+	}
+
+	started := time.Now()
+	stopInterrupts := downloader.handleInterrupts()
+	stopPauseSignals := downloader.handlePauseSignals()
+	if o.tui != nil && *o.tui {
+		err = runTUI(downloader, func() error { return process(downloader) })
+	} else {
+		err = process(downloader)
+	}
+	stopInterrupts()
+	stopPauseSignals()
+	closeFn()
+
+	downloader.sizes.Report(downloader.logger)
+	downloader.pacing.Report(downloader.logger)
+	reportPhases(downloader.Jobs(), downloader.logger)
+	downloader.destStats.Report(config.Destinations, downloader.logger)
+
+	jobs := downloader.Jobs()
+	summary := buildSummary(jobs, started)
+	summary.Print(out)
+	if *o.summaryJSON != "" {
+		if werr := summary.WriteJSON(*o.summaryJSON); werr != nil {
+			log.Printf("Failed to write %s: %v", *o.summaryJSON, werr)
+		}
+	}
+
+	failed := failedJobs(jobs)
+	reportPath := filepath.Join(config.OutputDir, failedReportName)
+	if len(failed) > 0 {
+		if werr := writeFailedReport(reportPath, failed); werr != nil {
+			log.Printf("Failed to write %s: %v", reportPath, werr)
+		} else {
+			log.Printf("Wrote %d failed downloads to %s (rerun with -retry-failed)", len(failed), reportPath)
+		}
+	} else if retryFailed != "" && sameFile(retryFailed, reportPath) {
+		// Every retried download succeeded, so the report is stale
+		os.Remove(reportPath)
+	}
+
+	switch {
+	case err == nil:
+		fmt.Fprintln(out, "Download completed successfully!")
+		return nil
+	case downloader.Stopping() && !slices.ContainsFunc(failed, func(j JobSnapshot) bool { return j.Status == JobFailed }):
+		fmt.Fprintf(out, "Stopped after the current downloads; %d not started\n", len(failed))
+		return nil
+	case len(failed) > 0 && len(failed) < len(jobs):
+		return &exitError{exitPartialFailure, fmt.Errorf("%d of %d downloads failed: %v", len(failed), len(jobs), err)}
+	default:
+		return &exitError{exitTotalFailure, fmt.Errorf("Error processing: %v", err)}
+	}
+}
+
+func setupDownload(flags *flag.FlagSet) func([]string) error {
+	opts := registerDownloadFlags(flags)
+	opts.tui = flags.Bool("tui", false, "Show an interactive queue with progress and controls (not on Windows)")
+	search := flags.String("search", "", "Search YouTube and download the results")
+	searchResults := flags.Int("results", 5, "Number of search results to use with -search")
+	pick := flags.Bool("pick", false, "List search results and choose which to download")
+	retryFailed := flags.String("retry-failed", "", "Retry the videos listed in a failed.txt report")
+
+	return func(args []string) error {
+		if *search != "" {
+			args = append(args, fmt.Sprintf("ytsearch%d:%s", *searchResults, *search))
+		}
+		if len(args) == 0 && *retryFailed == "" {
+			flags.Usage()
+			return &exitError{exitTotalFailure, fmt.Errorf("no videos given")}
+		}
+		if *opts.tui && *pick {
+			return fmt.Errorf("-pick cannot be combined with -tui")
+		}
+
+		return opts.runDownloads(*retryFailed, func(d *Downloader) error {
+			if *retryFailed != "" {
+				ids, err := readFailedReport(*retryFailed)
+				if err != nil {
+					return fmt.Errorf("failed to read %s: %v", *retryFailed, err)
+				}
+				args = append(args, ids...)
+			}
+
+			var ids []string
+			var errs []error
+			for _, arg := range args {
+				var err error
+				switch query, limit, ok := parseSearchURL(arg); {
+				case ok:
+					err = d.processSearch(query, limit, *pick)
+				case strings.Contains(arg, "playlist?list="):
+					var result *PlaylistResult
+					if result, err = d.ProcessPlaylist(arg); err == nil {
+						err = result.Err()
+					}
+				case isFeedURL(arg):
+					var feed []string
+					if feed, err = d.feedIDs(context.Background(), arg); err == nil {
+						ids = append(ids, feed...)
+					}
+				default:
+					ids = append(ids, arg)
+				}
+				if err != nil {
+					errs = append(errs, err)
+				}
+			}
+			if len(ids) > 0 {
+				if err := d.ProcessVideos(ids); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			return errors.Join(errs...)
+		})
+	}
+}
+
+func setupPlaylist(flags *flag.FlagSet) func([]string) error {
+	opts := registerDownloadFlags(flags)
+	opts.tui = flags.Bool("tui", false, "Show an interactive queue with progress and controls (not on Windows)")
+	items := flags.String("items", "", "Only download these playlist positions, e.g. \"1-3,7\"")
+
+	return func(args []string) error {
+		if len(args) != 1 {
+			flags.Usage()
+			return &exitError{exitTotalFailure, fmt.Errorf("expected one playlist")}
+		}
+		selected, err := parseItems(*items)
+		if err != nil {
+			return fmt.Errorf("invalid -items: %v", err)
+		}
+
+		return opts.runDownloads("", func(d *Downloader) error {
+			source, ids, positions, err := d.listPlaylist(playlistURL(args[0]))
+			if err != nil {
+				return err
+			}
+			if selected != nil {
+				var kept []string
+				var keptPositions []int
+				for i, id := range ids {
+					if selected[positions[i]] {
+						kept = append(kept, id)
+						keptPositions = append(keptPositions, positions[i])
+					}
+				}
+				ids, positions = kept, keptPositions
+			}
+			return d.processBatch(source, ids, positions).Err()
+		})
+	}
+}
+
+func setupChannel(flags *flag.FlagSet) func([]string) error {
+	opts := registerDownloadFlags(flags)
+	opts.tui = flags.Bool("tui", false, "Show an interactive queue with progress and controls (not on Windows)")
+	limit := flags.Int("limit", 0, "Only download the newest N uploads")
+
+	return func(args []string) error {
+		if len(args) != 1 {
+			flags.Usage()
+			return &exitError{exitTotalFailure, fmt.Errorf("expected one channel")}
+		}
+
+		return opts.runDownloads("", func(d *Downloader) error {
+			result, err := d.ProcessChannel(args[0], *limit)
+			if err != nil {
+				return err
+			}
+			return result.Err()
+		})
+	}
+}
+
+// playlistURL accepts a bare playlist ID as well as a URL.
+func playlistURL(s string) string {
+	if strings.Contains(s, "list=") {
+		return s
+	}
+	return "https://www.youtube.com/playlist?list=" + s
+}
+
+// parseItems parses a playlist position list such as "1-3,7". An empty
+// spec selects everything and returns nil.
+func parseItems(spec string) (map[int]bool, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	selected := make(map[int]bool)
+	for _, part := range strings.Split(spec, ",") {
+		var from, to int
+		if lo, hi, ok := strings.Cut(part, "-"); ok {
+			if _, err := fmt.Sscanf(lo+" "+hi, "%d %d", &from, &to); err != nil {
+				return nil, fmt.Errorf("bad range %q", part)
+			}
+		} else if _, err := fmt.Sscanf(part, "%d", &from); err != nil {
+			return nil, fmt.Errorf("bad position %q", part)
+		} else {
+			to = from
+		}
+		if from < 1 || to < from {
+			return nil, fmt.Errorf("bad range %q", part)
+		}
+		for i := from; i <= to; i++ {
+			selected[i] = true
+		}
+	}
+	return selected, nil
+}
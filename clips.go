@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+var (
+	clipURLPattern     = regexp.MustCompile(`^(?:https?://)?(?:www\.|m\.)?youtube\.com/clip/([\w-]+)`)
+	clipConfigPattern  = regexp.MustCompile(`"clipConfig":\{"postId":"[^"]*","startTimeMs":"(\d+)","endTimeMs":"(\d+)"`)
+	clipVideoIDPattern = regexp.MustCompile(`"videoDetails":\{"videoId":"([\w-]{11})"`)
+)
+
+// clipRange is the part of a video that a /clip/ URL or a -section
+// points at.
+type clipRange struct {
+	videoID    string // empty for a -section
+	start, end time.Duration
+}
+
+// parseSection parses a -section such as "10:30-15:00" or "1:02:03-".
+// A missing end means the end of the video, and is filled in once the
+// video's duration is known.
+func parseSection(s string) (clipRange, error) {
+	startText, endText, ok := strings.Cut(s, "-")
+	if !ok {
+		return clipRange{}, fmt.Errorf("expected START-END, e.g. 00:10:30-00:15:00, got %q", s)
+	}
+	start, err := parseClipTime(startText)
+	if err != nil {
+		return clipRange{}, err
+	}
+	var end time.Duration
+	if endText != "" {
+		if end, err = parseClipTime(endText); err != nil {
+			return clipRange{}, err
+		}
+		if end <= start {
+			return clipRange{}, fmt.Errorf("section %q ends before it starts", s)
+		}
+	}
+	return clipRange{start: start, end: end}, nil
+}
+
+// parseClipTime parses an offset as [[hh:]mm:]ss[.fff] or as a Go
+// duration such as 1m30s.
+func parseClipTime(s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return d, nil
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	var total float64
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		// Only the seconds may have a fraction
+		if err != nil || n < 0 || (i < len(parts)-1 && n != float64(int(n))) {
+			return 0, fmt.Errorf("invalid time %q", s)
+		}
+		total = total*60 + n
+	}
+	return time.Duration(total * float64(time.Second)), nil
+}
+
+func isClipURL(s string) bool {
+	return clipURLPattern.MatchString(s)
+}
+
+// resolveClip reads a clip page for the parent video and the clip's
+// start and end.
+func (d *Downloader) resolveClip(ctx context.Context, clipURL string) (*clipRange, error) {
+	if !strings.Contains(clipURL, "://") {
+		clipURL = "https://" + clipURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, clipURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get clip page: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get clip page: %s", resp.Status)
+	}
+	page, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clip page: %v", err)
+	}
+
+	config := clipConfigPattern.FindSubmatch(page)
+	id := clipVideoIDPattern.FindSubmatch(page)
+	if config == nil || id == nil {
+		return nil, fmt.Errorf("no clip found at %s", clipURL)
+	}
+	startMs, _ := strconv.ParseInt(string(config[1]), 10, 64)
+	endMs, _ := strconv.ParseInt(string(config[2]), 10, 64)
+	if endMs <= startMs {
+		return nil, fmt.Errorf("clip at %s has an empty range", clipURL)
+	}
+	return &clipRange{
+		videoID: string(id[1]),
+		start:   time.Duration(startMs) * time.Millisecond,
+		end:     time.Duration(endMs) * time.Millisecond,
+	}, nil
+}
+
+// fitSection checks a -section against the video's duration, and ends
+// an open or overlong section at the end of the video.
+func fitSection(c *clipRange, video *youtube.Video) error {
+	if video.Duration <= 0 {
+		return fmt.Errorf("can't cut a section from %s, which has no duration", video.Title)
+	}
+	if c.start >= video.Duration {
+		return fmt.Errorf("section starting at %s is past the end of %s (%s)", clipTime(c.start), video.Title, formatDuration(video.Duration))
+	}
+	if c.end == 0 || c.end > video.Duration {
+		c.end = video.Duration
+	}
+	return nil
+}
+
+// inputArgs are the ffmpeg input options that read only the clip. They
+// are empty for a nil clip.
+func (c *clipRange) inputArgs() []string {
+	if c == nil {
+		return nil
+	}
+	return []string{"-ss", ffmpegTime(c.start), "-to", ffmpegTime(c.end)}
+}
+
+func (c *clipRange) length() time.Duration {
+	return c.end - c.start
+}
+
+// label names the clip in its output filename, so it doesn't collide
+// with the full video.
+func (c *clipRange) label() string {
+	kind := "clip"
+	if c.videoID == "" {
+		kind = "section"
+	}
+	return fmt.Sprintf("%s %s-%s", kind, clipTime(c.start), clipTime(c.end))
+}
+
+// fetchLength is how much of format a download needs: all of it, or for a
+// clip that ends early, only the start of the stream up to a little past
+// the clip's end. Both container formats YouTube serves keep their index
+// at the start, so ffmpeg can cut from the truncated file.
+func (d *Downloader) fetchLength(job *Job, video *youtube.Video, format *youtube.Format) int64 {
+	c := job.clip
+	// -paranoid compares whole streams
+	if c == nil || d.config.Paranoid || format.ContentLength <= 0 || video.Duration <= 0 {
+		return format.ContentLength
+	}
+	// Bitrates vary over a video, so keep a margin past the end
+	end := c.end + max(c.end/10, 30*time.Second)
+	if end >= video.Duration {
+		return format.ContentLength
+	}
+	return int64(float64(format.ContentLength) * float64(end) / float64(video.Duration))
+}
+
+func ffmpegTime(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// clipTime formats an offset as e.g. "1m05s" or "1h02m05s".
+func clipTime(d time.Duration) string {
+	d = d.Round(time.Second)
+	h, m, s := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	switch {
+	case h > 0:
+		return fmt.Sprintf("%dh%02dm%02ds", h, m, s)
+	case m > 0:
+		return fmt.Sprintf("%dm%02ds", m, s)
+	}
+	return fmt.Sprintf("%ds", s)
+}
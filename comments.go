@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// Comment is one top-level comment in a .comments.json sidecar. YouTube
+// only shows how long ago a comment was posted, so Timestamp is worked
+// out from that and is as coarse as the text.
+type Comment struct {
+	ID         string `json:"id"`
+	Author     string `json:"author"`
+	AuthorID   string `json:"author_id,omitempty"`
+	Text       string `json:"text"`
+	Likes      int64  `json:"likes"`
+	Replies    int64  `json:"replies"`
+	Published  string `json:"published"`
+	Timestamp  int64  `json:"timestamp,omitempty"`
+	ByUploader bool   `json:"by_uploader,omitempty"`
+	Pinned     bool   `json:"pinned,omitempty"`
+	Edited     bool   `json:"edited,omitempty"`
+	Hearted    bool   `json:"hearted,omitempty"`
+}
+
+type commentsFile struct {
+	VideoID  string    `json:"video_id"`
+	Fetched  string    `json:"fetched"`
+	Comments []Comment `json:"comments"`
+}
+
+func descriptionPath(mediaPath string) string {
+	return strings.TrimSuffix(mediaPath, filepath.Ext(mediaPath)) + ".description"
+}
+
+func commentsPath(mediaPath string) string {
+	return strings.TrimSuffix(mediaPath, filepath.Ext(mediaPath)) + ".comments.json"
+}
+
+func (d *Downloader) writeDescription(video *youtube.Video, mediaPath string) {
+	if err := os.WriteFile(descriptionPath(mediaPath), []byte(video.Description), 0644); err != nil {
+		d.logger.Printf("Failed to write description for %s: %v", video.Title, err)
+	}
+}
+
+func (d *Downloader) writeComments(ctx context.Context, video *youtube.Video, mediaPath string) {
+	// The innertube endpoints are only reachable with the real client
+	if _, ok := d.baseClient().(*youtube.Client); !ok {
+		d.logger.Printf("Skipping comments for %s: not available from this source", video.Title)
+		return
+	}
+	comments, err := d.FetchComments(ctx, video.ID, d.config.MaxComments)
+	if err != nil {
+		d.logger.Printf("Failed to fetch comments for %s: %v", video.Title, err)
+		return
+	}
+	file := commentsFile{VideoID: video.ID, Fetched: time.Now().UTC().Format(time.RFC3339), Comments: comments}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err == nil {
+		err = os.WriteFile(commentsPath(mediaPath), data, 0644)
+	}
+	if err != nil {
+		d.logger.Printf("Failed to write comments for %s: %v", video.Title, err)
+	}
+}
+
+// FetchComments returns up to limit of a video's top comments, in the
+// order YouTube ranks them. Replies are not fetched.
+func (d *Downloader) FetchComments(ctx context.Context, videoID string, limit int) ([]Comment, error) {
+	resp, err := d.postInnertube(ctx, nextEndpoint, map[string]any{"context": innertubeContext(), "videoId": videoID})
+	if err != nil {
+		return nil, err
+	}
+	token := commentsToken(resp)
+	if token == "" {
+		// Comments are turned off, or the video has none
+		return nil, nil
+	}
+
+	now := time.Now()
+	var comments []Comment
+	for token != "" && len(comments) < limit {
+		resp, err := d.postInnertube(ctx, nextEndpoint, map[string]any{"context": innertubeContext(), "continuation": token})
+		if err != nil {
+			if len(comments) > 0 {
+				d.logger.Printf("Stopped fetching comments for %s after %d: %v", videoID, len(comments), err)
+				break
+			}
+			return nil, err
+		}
+		var page []Comment
+		page, token = parseCommentPage(resp, now)
+		comments = append(comments, page...)
+	}
+	if len(comments) > limit {
+		comments = comments[:limit]
+	}
+	return comments, nil
+}
+
+// commentsToken finds the continuation that loads the comment section
+// of a watch page.
+func commentsToken(resp any) string {
+	var token string
+	walkJSON(resp, func(key string, value map[string]any) {
+		if key != "itemSectionRenderer" || token != "" {
+			return
+		}
+		if id, _ := value["sectionIdentifier"].(string); id != "comment-item-section" {
+			return
+		}
+		token = continuationToken(value)
+	})
+	return token
+}
+
+func continuationToken(node any) string {
+	var token string
+	walkJSON(node, func(key string, value map[string]any) {
+		if key == "continuationCommand" && token == "" {
+			token, _ = value["token"].(string)
+		}
+	})
+	return token
+}
+
+// parseCommentPage returns the comments on one page and the continuation
+// for the next. Threads hold either a commentRenderer or, in newer
+// responses, a view model keyed to a commentEntityPayload.
+func parseCommentPage(resp any, now time.Time) ([]Comment, string) {
+	entities := make(map[string]map[string]any)
+	var items []any
+	walkJSON(resp, func(key string, value map[string]any) {
+		switch key {
+		case "commentEntityPayload":
+			if props, ok := value["properties"].(map[string]any); ok {
+				if id, _ := props["commentId"].(string); id != "" {
+					entities[id] = value
+				}
+			}
+		case "reloadContinuationItemsCommand", "appendContinuationItemsAction":
+			if list, ok := value["continuationItems"].([]any); ok {
+				items = append(items, list...)
+			}
+		}
+	})
+
+	var comments []Comment
+	var next string
+	for _, item := range items {
+		obj, _ := item.(map[string]any)
+		if r, ok := obj["continuationItemRenderer"]; ok {
+			next = continuationToken(r)
+			continue
+		}
+		thread, ok := obj["commentThreadRenderer"].(map[string]any)
+		if !ok {
+			continue
+		}
+		var c Comment
+		if r, ok := jsonPath(thread, "comment", "commentRenderer").(map[string]any); ok {
+			c = commentFromRenderer(r)
+		} else if vm, ok := jsonPath(thread, "commentViewModel", "commentViewModel").(map[string]any); ok {
+			id, _ := vm["commentId"].(string)
+			entity, ok := entities[id]
+			if !ok {
+				continue
+			}
+			c = commentFromEntity(entity)
+			_, c.Pinned = vm["pinnedText"]
+		} else {
+			continue
+		}
+		if replies, ok := jsonPath(thread, "replies", "commentRepliesRenderer").(map[string]any); ok && c.Replies == 0 {
+			c.Replies = parseCount(jsonText(replies["moreText"]))
+		}
+		c.Edited = strings.Contains(c.Published, "(edited)")
+		c.Published = strings.TrimSpace(strings.TrimSuffix(c.Published, "(edited)"))
+		if t, ok := parseTimeAgo(c.Published, now); ok {
+			c.Timestamp = t.Unix()
+		}
+		comments = append(comments, c)
+	}
+	return comments, next
+}
+
+func commentFromRenderer(r map[string]any) Comment {
+	c := Comment{
+		Author:     jsonText(r["authorText"]),
+		Text:       jsonText(r["contentText"]),
+		Likes:      parseCount(jsonText(r["voteCount"])),
+		Published:  jsonText(r["publishedTimeText"]),
+		Replies:    jsonNumber(r["replyCount"]),
+		ByUploader: r["authorIsChannelOwner"] == true,
+	}
+	c.ID, _ = r["commentId"].(string)
+	c.AuthorID, _ = jsonPath(r, "authorEndpoint", "browseEndpoint", "browseId").(string)
+	_, c.Pinned = r["pinnedCommentBadge"]
+	_, c.Hearted = jsonPath(r, "actionButtons", "commentActionButtonsRenderer", "creatorHeart").(map[string]any)
+	return c
+}
+
+func commentFromEntity(e map[string]any) Comment {
+	var c Comment
+	c.ID, _ = jsonPath(e, "properties", "commentId").(string)
+	c.Text, _ = jsonPath(e, "properties", "content", "content").(string)
+	c.Published, _ = jsonPath(e, "properties", "publishedTime").(string)
+	c.Author, _ = jsonPath(e, "author", "displayName").(string)
+	c.AuthorID, _ = jsonPath(e, "author", "channelId").(string)
+	c.ByUploader = jsonPath(e, "author", "isCreator") == true
+	likes, _ := jsonPath(e, "toolbar", "likeCountNotliked").(string)
+	c.Likes = parseCount(likes)
+	replies, _ := jsonPath(e, "toolbar", "replyCount").(string)
+	c.Replies = parseCount(replies)
+	c.Hearted = jsonPath(e, "toolbar", "heartState") == "TOOLBAR_HEART_STATE_HEARTED"
+	return c
+}
+
+// jsonPath follows keys through nested objects, returning nil if any is
+// missing.
+func jsonPath(node any, keys ...string) any {
+	for _, key := range keys {
+		obj, ok := node.(map[string]any)
+		if !ok {
+			return nil
+		}
+		node = obj[key]
+	}
+	return node
+}
+
+func jsonNumber(node any) int64 {
+	n, _ := node.(float64)
+	return int64(n)
+}
+
+// parseCount parses the abbreviated counts YouTube shows, such as "1.2K"
+// or "15 replies".
+func parseCount(s string) int64 {
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", "")
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		s = s[:i]
+	}
+	if s == "" {
+		return 0
+	}
+	multiplier := 1.0
+	switch s[len(s)-1] {
+	case 'K':
+		multiplier = 1e3
+	case 'M':
+		multiplier = 1e6
+	case 'B':
+		multiplier = 1e9
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return int64(n * multiplier)
+}
+
+// parseTimeAgo turns "3 weeks ago" into a time before now.
+func parseTimeAgo(s string, now time.Time) (time.Time, bool) {
+	fields := strings.Fields(s)
+	if len(fields) < 3 || fields[2] != "ago" {
+		return time.Time{}, false
+	}
+	n, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return time.Time{}, false
+	}
+	switch strings.TrimSuffix(fields[1], "s") {
+	case "second":
+		return now.Add(-time.Duration(n) * time.Second), true
+	case "minute":
+		return now.Add(-time.Duration(n) * time.Minute), true
+	case "hour":
+		return now.Add(-time.Duration(n) * time.Hour), true
+	case "day":
+		return now.AddDate(0, 0, -n), true
+	case "week":
+		return now.AddDate(0, 0, -7*n), true
+	case "month":
+		return now.AddDate(0, -n, 0), true
+	case "year":
+		return now.AddDate(-n, 0, 0), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const configFileName = "config.yaml"
+
+const starterConfig = `# ytdl-go configuration
+#
+# Every setting is the name of a command-line flag of any command; flags
+# given on the command line override the values here. Remove the leading "#" to enable
+# a setting.
+
+# Where downloads are saved
+# output: downloads
+
+# Filename template relative to the output directory, without extension.
+# Fields: {id} {title} {channel} {author} {artist} {channel_id}
+# {upload_date} {duration} {index}; see -help for functions and fallbacks
+# output-template: "{channel}/{upload_date} - {title}"
+
+# Upload date layout in filenames and tags: compact, iso or a Go layout
+# date-format: iso
+
+# Preferred video quality: best, hd1080, hd720, medium, ...
+# quality: best
+
+# Parallel stream downloads, metadata fetches and ffmpeg jobs
+# concurrency: 3
+# metadata-concurrency: 5
+# ffmpeg-concurrency: 4
+
+# HTTP(S) or SOCKS5 proxy for all requests
+# proxy: socks5://127.0.0.1:1080
+
+# Record finished video IDs here and skip them on later runs
+# download-archive: ~/.config/ytdl-go/archive.txt
+
+# mp3: false
+# x: false
+# audio-format: best
+# write-info-json: false
+
+# Named sets of settings for -profile; music, podcast and archive are
+# built in and can be replaced here
+# profiles:
+#   lectures:
+#     quality: hd720
+#     output-template: "{channel}/{index} - {title}"
+
+# Channels and playlists that watch and serve check on a cron schedule
+# (minute hour day month weekday). A check missed while the program wasn't
+# running is made as soon as it starts again.
+# subscriptions:
+#   news:
+#     url: https://www.youtube.com/@example
+#     schedule: "0 3 * * *"
+
+# Route downloads by channel, playlist (title or ID) or a title pattern to
+# their own directory, quality (1080p, 720p, 480p, audio) or -f format and
+# MP3 conversion. The first category by name whose rules all match is used.
+# categories:
+#   music:
+#     channel: Some Band - Topic
+#     dir: Music
+#     mp3: true
+#   talks:
+#     title: "(?i)keynote|talk"
+#     dir: Talks
+#     quality: 1080p
+`
+
+func defaultConfigPath() (string, error) {
+	dir, err := ytdlConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, configFileName), nil
+}
+
+// argFlagValue finds a flag like -config in args ahead of flag parsing,
+// since the file it names has to be applied before the command line can
+// override it.
+func argFlagValue(args []string, flagName string) string {
+	value, _ := argFlag(args, flagName)
+	return value
+}
+
+// argFlag is argFlagValue that also reports whether the flag was given.
+func argFlag(args []string, flagName string) (string, bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if value, ok := strings.CutPrefix(name, flagName+"="); ok {
+			return value, true
+		}
+		if name == flagName {
+			if i+1 < len(args) {
+				return args[i+1], true
+			}
+			return "", true
+		}
+	}
+	return "", false
+}
+
+// resetRepeatedFlags clears the values that the environment, config file
+// and profile gave repeatable flags which args set again, so the command
+// line replaces them rather than adding to them.
+func resetRepeatedFlags(flags *flag.FlagSet, args []string) {
+	flags.VisitAll(func(f *flag.Flag) {
+		if list, ok := f.Value.(*stringList); ok {
+			if _, given := argFlag(args, f.Name); given {
+				*list = nil
+			}
+		}
+	})
+}
+
+// configSections are the config file's sections that aren't flags.
+var configSections = map[string]bool{
+	"subscriptions": true,
+	"categories":    true,
+	"profiles":      true,
+}
+
+// loadConfigFile applies the settings in the config file as flag defaults
+// and returns them. A missing file is only an error when the path was
+// given explicitly.
+func loadConfigFile(flags *flag.FlagSet, path string) (map[string]any, error) {
+	path, values, err := readConfigFile(path)
+	if err != nil || values == nil {
+		return nil, err
+	}
+	if err := applyConfig(flags, values); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return values, nil
+}
+
+// readConfigFile parses the config file at path, or the default one if
+// path is empty, and returns the path it read. Without a default config
+// file, values is nil.
+func readConfigFile(path string) (string, map[string]any, error) {
+	explicit := path != ""
+	if !explicit {
+		var err error
+		if path, err = defaultConfigPath(); err != nil {
+			return "", nil, nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return path, nil, nil
+		}
+		return path, nil, err
+	}
+
+	values, err := parseYAML(string(data))
+	if err != nil {
+		return path, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return path, values, nil
+}
+
+// commandConfigPath is the config file a command was run with.
+func commandConfigPath(flags *flag.FlagSet) string {
+	if path := flags.Lookup("config").Value.String(); path != "" {
+		return path
+	}
+	return envConfigPath()
+}
+
+// configFilePath is the config file a command reads: the one it was run
+// with or else the default.
+func configFilePath(flags *flag.FlagSet) (string, error) {
+	if path := commandConfigPath(flags); path != "" {
+		return path, nil
+	}
+	return defaultConfigPath()
+}
+
+func applyConfig(flags *flag.FlagSet, values map[string]any) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if configSections[key] {
+			continue
+		}
+		value, ok := values[key].(string)
+		if !ok {
+			return fmt.Errorf("unknown section %q", key)
+		}
+		name := strings.ReplaceAll(key, "_", "-")
+		if name == "config" {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+		if flags.Lookup(name) == nil {
+			// Settings for other commands are fine, typos are not
+			if knownSetting(name) {
+				continue
+			}
+			return fmt.Errorf("unknown setting %q", key)
+		}
+		if err := flags.Set(name, expandHome(value)); err != nil {
+			return fmt.Errorf("invalid value for %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+func expandHome(path string) string {
+	rest, ok := strings.CutPrefix(path, "~/")
+	if !ok {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, rest)
+}
+
+// parseYAML reads the subset of YAML used by the config file: "key: value"
+// pairs, comments, quoted strings and indented nested mappings. Values are
+// returned as strings or map[string]any. Anything else, such as lists or
+// stray indentation, is an error naming its line.
+func parseYAML(data string) (map[string]any, error) {
+	type level struct {
+		indent int
+		values map[string]any
+	}
+	root := map[string]any{}
+	stack := []level{{indent: 0, values: root}}
+	var pending string // key of a mapping waiting for its first child
+
+	for n, raw := range strings.Split(data, "\n") {
+		line := stripYAMLComment(raw)
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if strings.Contains(line, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", n+1)
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+
+		if pending != "" {
+			parent := stack[len(stack)-1]
+			if indent > parent.indent {
+				child := map[string]any{}
+				parent.values[pending] = child
+				stack = append(stack, level{indent: indent, values: child})
+			} else {
+				parent.values[pending] = ""
+			}
+			pending = ""
+		}
+		for indent < stack[len(stack)-1].indent {
+			stack = stack[:len(stack)-1]
+		}
+		current := stack[len(stack)-1]
+		if indent != current.indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", n+1)
+		}
+		if item := strings.TrimSpace(line); item == "-" || strings.HasPrefix(item, "- ") {
+			return nil, fmt.Errorf("line %d: lists are not supported", n+1)
+		}
+
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", n+1)
+		}
+		key = strings.TrimSpace(key)
+		if _, dup := current.values[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", n+1, key)
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			pending = key
+			continue
+		}
+		unquoted, err := unquoteYAML(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n+1, err)
+		}
+		current.values[key] = unquoted
+	}
+	if pending != "" {
+		stack[len(stack)-1].values[pending] = ""
+	}
+	return root, nil
+}
+
+// stripYAMLComment removes a trailing comment, leaving "#" inside quotes.
+func stripYAMLComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' '):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquoteYAML(value string) (string, error) {
+	if len(value) < 2 {
+		return value, nil
+	}
+	switch q := value[0]; q {
+	case '"', '\'':
+		if value[len(value)-1] != q {
+			return "", fmt.Errorf("unterminated string %s", value)
+		}
+		inner := value[1 : len(value)-1]
+		if q == '\'' {
+			return strings.ReplaceAll(inner, "''", "'"), nil
+		}
+		return strings.NewReplacer(`\"`, `"`, `\\`, `\`, `\n`, "\n", `\t`, "\t").Replace(inner), nil
+	}
+	return value, nil
+}
+
+// runConfig implements the config subcommand.
+func runConfig(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: config init [-force] [-config file] | config path [-config file]")
+	}
+
+	flags := flag.NewFlagSet("config "+args[0], flag.ExitOnError)
+	flags.String("config", "", "Config file (default $YTDL_CONFIG or ~/.config/ytdl-go/config.yaml)")
+	switch args[0] {
+	case "init":
+		force := flags.Bool("force", false, "Overwrite an existing config file")
+		flags.Parse(args[1:])
+
+		path, err := configFilePath(flags)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); err == nil && !*force {
+			return fmt.Errorf("%s already exists (use -force to replace it)", path)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(starterConfig), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s\n", path)
+		return nil
+	case "path":
+		flags.Parse(args[1:])
+
+		path, err := configFilePath(flags)
+		if err != nil {
+			return err
+		}
+		fmt.Println(path)
+		return nil
+	default:
+		return fmt.Errorf("unknown config command %q", args[0])
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	data := `# comment
+output: ~/Videos   # trailing comment
+output-template: "{channel} # {title}"
+quoted: 'it''s'
+empty:
+profiles:
+  lectures:
+    quality: hd720
+
+  music:
+    mp3: "true"
+concurrency: 4
+`
+	want := map[string]any{
+		"output":          "~/Videos",
+		"output-template": "{channel} # {title}",
+		"quoted":          "it's",
+		"empty":           "",
+		"profiles": map[string]any{
+			"lectures": map[string]any{"quality": "hd720"},
+			"music":    map[string]any{"mp3": "true"},
+		},
+		"concurrency": "4",
+	}
+	got, err := parseYAML(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseYAML = %v, want %v", got, want)
+	}
+	if got, err := parseYAML(starterConfig); err != nil || len(got) != 0 {
+		t.Errorf("parseYAML(starterConfig) = %v, %v, want nothing set", got, err)
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name, data, want string
+	}{
+		{"indented first line", "  output: x\n", "line 1: unexpected indentation"},
+		{"indented after a section", "profiles:\n    a:\n      quality: best\n  b: x\n", "line 4: unexpected indentation"},
+		{"deeper sibling", "profiles:\n  a: x\n    b: y\n", "line 3: unexpected indentation"},
+		{"list", "add-header:\n  - \"X-A: 1\"\n", "line 2: lists are not supported"},
+		{"bare dash", "output: x\n-\n", "line 2: lists are not supported"},
+		{"tab", "profiles:\n\ta: x\n", "line 2: tabs are not allowed"},
+		{"no colon", "output x\n", "line 1: expected"},
+		{"duplicate", "output: a\noutput: b\n", `line 2: duplicate key "output"`},
+		{"unterminated", "output: \"x\n", "line 1: unterminated string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseYAML(tt.data); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseYAML(%q) error = %v, want %q", tt.data, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepeatedFlagsOverrideConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	config := filepath.Join(home, "config.yaml")
+	if err := os.WriteFile(config, []byte("add-header: \"X-Config: 1\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("YTDL_CONFIG", config)
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "X-Config: 1"},
+		{[]string{"-add-header", "X-Cli: 1", "-add-header=X-Cli: 2"}, "X-Cli: 1,X-Cli: 2"},
+	}
+	for _, tt := range tests {
+		flags, _, err := parseCommandFlags(findCommand("download"), tt.args, flag.ContinueOnError)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := flags.Lookup("add-header").Value.String(); got != tt.want {
+			t.Errorf("%q: add-header = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	encryptNone       = "none"
+	encryptPassphrase = "passphrase"
+	encryptKeychain   = "keychain"
+
+	pbkdf2Iterations = 200000
+	keychainService  = "ytdl-go"
+	keychainAccount  = "credentials"
+)
+
+type Credential struct {
+	Name  string    `json:"name"`
+	Kind  string    `json:"kind"` // "cookies" or "oauth"
+	Value string    `json:"value"`
+	Added time.Time `json:"added"`
+}
+
+// credentialFile is the on-disk layout. Plain stores keep the credentials
+// inline; encrypted stores keep them as AES-GCM ciphertext.
+type credentialFile struct {
+	Encryption  string       `json:"encryption"`
+	Salt        []byte       `json:"salt,omitempty"`
+	Nonce       []byte       `json:"nonce,omitempty"`
+	Ciphertext  []byte       `json:"ciphertext,omitempty"`
+	Credentials []Credential `json:"credentials,omitempty"`
+}
+
+func ytdlConfigDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ytdl-go"), nil
+}
+
+func credentialStorePath() (string, error) {
+	dir, err := ytdlConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "credentials.json"), nil
+}
+
+// credentialLock is how a store is encrypted: its mode and, once the store
+// is unlocked or created, its key and salt. Saving with the same lock
+// doesn't ask for the passphrase again.
+type credentialLock struct {
+	mode string
+	salt []byte
+	key  []byte
+}
+
+// loadCredentials returns the stored credentials and the lock of the
+// store, decrypting it if necessary. A missing store has an empty mode.
+func loadCredentials(path string) ([]Credential, credentialLock, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, credentialLock{}, nil
+	}
+	if err != nil {
+		return nil, credentialLock{}, err
+	}
+
+	var file credentialFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, credentialLock{}, fmt.Errorf("corrupt credential store: %v", err)
+	}
+	if file.Encryption == encryptNone || file.Encryption == "" {
+		return file.Credentials, credentialLock{mode: encryptNone}, nil
+	}
+
+	lock := credentialLock{mode: file.Encryption, salt: file.Salt}
+	if lock.key, err = credentialKey(lock.mode, lock.salt, false); err != nil {
+		return nil, credentialLock{}, err
+	}
+	plain, err := decryptGCM(lock.key, file.Nonce, file.Ciphertext)
+	if err != nil {
+		return nil, credentialLock{}, fmt.Errorf("failed to decrypt credential store (wrong passphrase?): %v", err)
+	}
+
+	var creds []Credential
+	if err := json.Unmarshal(plain, &creds); err != nil {
+		return nil, credentialLock{}, fmt.Errorf("corrupt credential store: %v", err)
+	}
+	return creds, lock, nil
+}
+
+// saveCredentials writes creds under lock. A lock without a key, for a new
+// store or a change of encryption, gets a new one, asking for a new
+// passphrase twice.
+func saveCredentials(path string, creds []Credential, lock credentialLock) error {
+	file := credentialFile{Encryption: lock.mode}
+
+	if lock.mode == encryptNone {
+		file.Credentials = creds
+	} else {
+		if lock.key == nil {
+			lock.salt = make([]byte, 16)
+			if _, err := rand.Read(lock.salt); err != nil {
+				return err
+			}
+			var err error
+			if lock.key, err = credentialKey(lock.mode, lock.salt, true); err != nil {
+				return err
+			}
+		}
+		file.Salt = lock.salt
+		plain, err := json.Marshal(creds)
+		if err != nil {
+			return err
+		}
+		if file.Nonce, file.Ciphertext, err = encryptGCM(lock.key, plain); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// credentialKey derives the store key from a passphrase or fetches it from
+// the OS keychain. With create set the passphrase is a new one, entered
+// twice, and a missing keychain entry is created.
+func credentialKey(mode string, salt []byte, create bool) ([]byte, error) {
+	switch mode {
+	case encryptPassphrase:
+		var passphrase string
+		var err error
+		if create {
+			passphrase, err = readNewPassphrase()
+		} else {
+			passphrase, err = readPassphrase("Credential store passphrase: ")
+		}
+		if err != nil {
+			return nil, err
+		}
+		return pbkdf2SHA256([]byte(passphrase), salt, pbkdf2Iterations, 32), nil
+	case encryptKeychain:
+		if key, err := keychainGet(); err == nil {
+			return key, nil
+		} else if !create {
+			return nil, err
+		}
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		return key, keychainSet(key)
+	default:
+		return nil, fmt.Errorf("unknown encryption mode %q", mode)
+	}
+}
+
+// readNewPassphrase asks for a new passphrase and for it again, so that a
+// typo can't lock the store away.
+func readNewPassphrase() (string, error) {
+	passphrase, err := readPassphrase("New credential store passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	again, err := readPassphrase("Repeat the passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if again != passphrase {
+		return "", fmt.Errorf("passphrases don't match")
+	}
+	return passphrase, nil
+}
+
+func readPassphrase(prompt string) (string, error) {
+	if p := os.Getenv("YTDL_PASSPHRASE"); p != "" {
+		return p, nil
+	}
+
+	// The terminal rather than stdin, which auth add may have read the
+	// credential from
+	ttyPath := "/dev/tty"
+	if runtime.GOOS == "windows" {
+		ttyPath = "CONIN$"
+	}
+	tty, err := os.Open(ttyPath)
+	if err != nil {
+		return "", fmt.Errorf("no terminal to read the passphrase from; set YTDL_PASSPHRASE: %v", err)
+	}
+	defer tty.Close()
+	ttyStty := func(args ...string) (string, error) {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = tty
+		out, err := cmd.Output()
+		return strings.TrimSpace(string(out)), err
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	if state, err := ttyStty("-g"); err == nil {
+		ttyStty("-echo")
+		defer ttyStty(state)
+	}
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	fmt.Fprintln(os.Stderr)
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("no passphrase provided: %v", err)
+	}
+	return line, nil
+}
+
+func encryptGCM(key, plain []byte) (nonce, ciphertext []byte, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return nonce, gcm.Seal(nil, nonce, plain, nil), nil
+}
+
+func decryptGCM(key, nonce, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// pbkdf2SHA256 implements PBKDF2 (RFC 8018) with HMAC-SHA256.
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+
+	var counter [4]byte
+	dk := make([]byte, 0, blocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+
+		for n := 2; n <= iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
+
+func keychainGet() ([]byte, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keychainService, "-a", keychainAccount, "-w")
+	case "linux":
+		cmd = exec.Command("secret-tool", "lookup", "service", keychainService, "account", keychainAccount)
+	default:
+		return nil, fmt.Errorf("keychain storage is not supported on %s", runtime.GOOS)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key from keychain: %v", err)
+	}
+	return hex.DecodeString(strings.TrimSpace(string(out)))
+}
+
+func keychainSet(key []byte) error {
+	secret := hex.EncodeToString(key)
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		// security -i reads the command from stdin, keeping the key off
+		// the command line where ps would show it
+		cmd = exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n", keychainService, keychainAccount, secret))
+	case "linux":
+		cmd = exec.Command("secret-tool", "store", "--label=ytdl-go credentials", "service", keychainService, "account", keychainAccount)
+		cmd.Stdin = strings.NewReader(secret)
+	default:
+		return fmt.Errorf("keychain storage is not supported on %s", runtime.GOOS)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to store key in keychain: %v: %s", err, out)
+	}
+	return nil
+}
+
+// cookieHeader converts a Netscape cookies.txt file into a Cookie header
+// value. Values that are not in cookies.txt format are used verbatim.
+func cookieHeader(value string) string {
+	if !strings.Contains(value, "\t") {
+		return strings.TrimSpace(value)
+	}
+
+	var pairs []string
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimPrefix(strings.TrimSpace(line), "#HttpOnly_")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			continue
+		}
+		if domain := strings.TrimPrefix(fields[0], "."); !strings.HasSuffix(domain, "youtube.com") && !strings.HasSuffix(domain, "google.com") {
+			continue
+		}
+		pairs = append(pairs, fields[5]+"="+fields[6])
+	}
+	return strings.Join(pairs, "; ")
+}
+
+// credentialDomains are the only hosts a stored credential is sent to,
+// so it never reaches a -resolver instance or any other third party.
+var credentialDomains = []string{"youtube.com", "googlevideo.com", "google.com"}
+
+func credentialHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, domain := range credentialDomains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// header returns the header carrying c, e.g. Cookie for cookies.
+func (c Credential) header() (name, value string) {
+	switch c.Kind {
+	case "cookies":
+		return "Cookie", cookieHeader(c.Value)
+	case "oauth":
+		return "Authorization", "Bearer " + strings.TrimSpace(c.Value)
+	}
+	return "", ""
+}
+
+// authTransport attaches a stored credential to requests to YouTube and
+// Google.
+type authTransport struct {
+	base       http.RoundTripper
+	credential Credential
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if name, value := t.credential.header(); name != "" && credentialHost(req.URL.Hostname()) {
+		req = req.Clone(req.Context())
+		req.Header.Set(name, value)
+	}
+	return t.base.RoundTrip(req)
+}
+
+func findCredential(name string) (Credential, error) {
+	path, err := credentialStorePath()
+	if err != nil {
+		return Credential{}, err
+	}
+	creds, _, err := loadCredentials(path)
+	if err != nil {
+		return Credential{}, err
+	}
+	for _, c := range creds {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return Credential{}, fmt.Errorf("no stored credential named %q", name)
+}
+
+func runAuth(args []string) error {
+	usage := fmt.Errorf("usage: youtube-downloader auth add|list|remove [flags]")
+	if len(args) == 0 {
+		return usage
+	}
+
+	path, err := credentialStorePath()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "add":
+		flags := flag.NewFlagSet("auth add", flag.ExitOnError)
+		name := flags.String("name", "default", "Credential name")
+		kind := flags.String("kind", "cookies", "Credential kind: cookies or oauth")
+		file := flags.String("file", "", "Read the credential from a file (default: stdin)")
+		encrypt := flags.String("encrypt", "", "Store encryption: none, passphrase or keychain (default: keep current, or passphrase)")
+		flags.Parse(args[1:])
+
+		if *kind != "cookies" && *kind != "oauth" {
+			return fmt.Errorf("unknown credential kind %q", *kind)
+		}
+
+		var value []byte
+		if *file != "" {
+			value, err = os.ReadFile(*file)
+		} else {
+			value, err = io.ReadAll(os.Stdin)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read credential: %v", err)
+		}
+
+		creds, lock, err := loadCredentials(path)
+		if err != nil {
+			return err
+		}
+		// A new or changed encryption gets a new key
+		if *encrypt != "" {
+			lock = credentialLock{mode: *encrypt}
+		} else if lock.mode == "" {
+			lock = credentialLock{mode: encryptPassphrase}
+		}
+
+		kept := creds[:0]
+		for _, c := range creds {
+			if c.Name != *name {
+				kept = append(kept, c)
+			}
+		}
+		creds = append(kept, Credential{Name: *name, Kind: *kind, Value: string(value), Added: time.Now()})
+		if err := saveCredentials(path, creds, lock); err != nil {
+			return fmt.Errorf("failed to save credentials: %v", err)
+		}
+		fmt.Printf("Stored %s credential %q (%s)\n", *kind, *name, lock.mode)
+
+	case "list":
+		creds, lock, err := loadCredentials(path)
+		if err != nil {
+			return err
+		}
+		sort.Slice(creds, func(i, j int) bool { return creds[i].Name < creds[j].Name })
+		fmt.Printf("Store: %s (encryption: %s)\n", path, lock.mode)
+		for _, c := range creds {
+			fmt.Printf("  %-20s %-8s added %s\n", c.Name, c.Kind, c.Added.Format(time.RFC3339))
+		}
+
+	case "remove":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: youtube-downloader auth remove <name>")
+		}
+		creds, lock, err := loadCredentials(path)
+		if err != nil {
+			return err
+		}
+		kept := creds[:0]
+		for _, c := range creds {
+			if c.Name != args[1] {
+				kept = append(kept, c)
+			}
+		}
+		if len(kept) == len(creds) {
+			return fmt.Errorf("no stored credential named %q", args[1])
+		}
+		if err := saveCredentials(path, kept, lock); err != nil {
+			return fmt.Errorf("failed to save credentials: %v", err)
+		}
+		fmt.Printf("Removed credential %q\n", args[1])
+
+	default:
+		return usage
+	}
+	return nil
+}
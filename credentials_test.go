@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// RFC 7914 section 11 and the RFC 6070 inputs run through SHA-256
+	tests := []struct {
+		password, salt string
+		iterations     int
+		want           string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, "348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1c635518c7dac47e9"},
+	}
+	for _, tt := range tests {
+		want, _ := hex.DecodeString(tt.want)
+		if got := pbkdf2SHA256([]byte(tt.password), []byte(tt.salt), tt.iterations, len(want)); !bytes.Equal(got, want) {
+			t.Errorf("pbkdf2SHA256(%q, %q, %d) = %x, want %s", tt.password, tt.salt, tt.iterations, got, tt.want)
+		}
+	}
+}
+
+func TestGCMRoundTrip(t *testing.T) {
+	key := make([]byte, 32)
+	plain := []byte(`[{"name":"default"}]`)
+	nonce, ciphertext, err := encryptGCM(key, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decryptGCM(key, nonce, ciphertext)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("decryptGCM = %q, %v, want %q", got, err, plain)
+	}
+
+	ciphertext[0] ^= 1
+	if _, err := decryptGCM(key, nonce, ciphertext); err == nil {
+		t.Error("a tampered ciphertext decrypted")
+	}
+}
+
+func TestCredentialStore(t *testing.T) {
+	creds := []Credential{{Name: "default", Kind: "cookies", Value: "SID=1", Added: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}}
+
+	t.Run("plain", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "credentials.json")
+		if err := saveCredentials(path, creds, credentialLock{mode: encryptNone}); err != nil {
+			t.Fatal(err)
+		}
+		got, lock, err := loadCredentials(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lock.mode != encryptNone || !reflect.DeepEqual(got, creds) {
+			t.Errorf("loaded %v (%s), want %v", got, lock.mode, creds)
+		}
+	})
+
+	t.Run("passphrase", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "credentials.json")
+		t.Setenv("YTDL_PASSPHRASE", "correct horse")
+		if err := saveCredentials(path, creds, credentialLock{mode: encryptPassphrase}); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("SID=1")) {
+			t.Error("the credential is stored in the clear")
+		}
+		var file credentialFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			t.Fatal(err)
+		}
+		if file.Encryption != encryptPassphrase || len(file.Salt) != 16 || len(file.Nonce) == 0 {
+			t.Errorf("stored encryption %q with a %d-byte salt and %d-byte nonce", file.Encryption, len(file.Salt), len(file.Nonce))
+		}
+
+		got, lock, err := loadCredentials(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, creds) {
+			t.Errorf("loaded %v, want %v", got, creds)
+		}
+
+		// Saving again reuses the unlocked key, without the passphrase
+		t.Setenv("YTDL_PASSPHRASE", "")
+		if err := saveCredentials(path, append(got, Credential{Name: "second", Kind: "oauth"}), lock); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("YTDL_PASSPHRASE", "correct horse")
+		if got, _, err := loadCredentials(path); err != nil || len(got) != 2 {
+			t.Errorf("loaded %v, %v after saving again, want 2 credentials", got, err)
+		}
+
+		t.Setenv("YTDL_PASSPHRASE", "wrong horse")
+		if _, _, err := loadCredentials(path); err == nil {
+			t.Error("loaded the store with the wrong passphrase")
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		got, lock, err := loadCredentials(filepath.Join(t.TempDir(), "credentials.json"))
+		if err != nil || got != nil || lock.mode != "" {
+			t.Errorf("loadCredentials = %v, %q, %v, want nothing", got, lock.mode, err)
+		}
+	})
+}
+
+func TestCookieHeader(t *testing.T) {
+	jar := "# Netscape HTTP Cookie File\n" +
+		".youtube.com\tTRUE\t/\tTRUE\t0\tSID\tabc\n" +
+		"#HttpOnly_.google.com\tTRUE\t/\tTRUE\t0\tHSID\tdef\n" +
+		".example.com\tTRUE\t/\tTRUE\t0\tOTHER\tghi\n"
+	tests := []struct {
+		value, want string
+	}{
+		{jar, "SID=abc; HSID=def"},
+		{"  SID=abc; HSID=def\n", "SID=abc; HSID=def"},
+	}
+	for _, tt := range tests {
+		if got := cookieHeader(tt.value); got != tt.want {
+			t.Errorf("cookieHeader(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestCredentialHost(t *testing.T) {
+	for host, want := range map[string]bool{
+		"www.youtube.com":             true,
+		"YouTube.com":                 true,
+		"rr1---sn-x.googlevideo.com":  true,
+		"accounts.google.com":         true,
+		"notyoutube.com":              false,
+		"youtube.com.example.com":     false,
+		"invidious.example.org":       false,
+		"googlevideo.com.attacker.io": false,
+	} {
+		if got := credentialHost(host); got != want {
+			t.Errorf("credentialHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Upload date layouts used when -date-format isn't given: compact in
+// filenames and info.json, ISO 8601 in embedded tags.
+const (
+	compactDateLayout = "20060102"
+	isoDateLayout     = "2006-01-02"
+)
+
+// dateFormat is how upload dates are written in filenames, tags and
+// sidecars (-date-format and -date-timezone).
+type dateFormat struct {
+	layout string         // empty for each writer's default
+	loc    *time.Location // nil for UTC
+}
+
+// parseDateFormat accepts "compact", "iso" or a Go time layout, and
+// "utc", "local" or an IANA zone name such as "Europe/Berlin".
+func parseDateFormat(layout, zone string) (dateFormat, error) {
+	var f dateFormat
+	switch strings.ToLower(layout) {
+	case "":
+	case "compact":
+		f.layout = compactDateLayout
+	case "iso":
+		f.layout = isoDateLayout
+	default:
+		sample := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+		if sample.Format(layout) == layout {
+			return f, fmt.Errorf("%q is not a Go time layout such as 2006-01-02", layout)
+		}
+		f.layout = layout
+	}
+
+	switch strings.ToLower(zone) {
+	case "", "utc":
+	case "local":
+		f.loc = time.Local
+	default:
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			return f, err
+		}
+		f.loc = loc
+	}
+	return f, nil
+}
+
+// in converts t to the configured zone. Dates without a time of day,
+// which is what YouTube reports, are calendar dates and stay as they are
+// rather than moving to the previous or next day.
+func (f dateFormat) in(t time.Time) time.Time {
+	if f.loc == nil || t.Equal(t.UTC().Truncate(24*time.Hour)) {
+		return t
+	}
+	return t.In(f.loc)
+}
+
+// format writes t with the configured layout, or defaultLayout.
+func (f dateFormat) format(t time.Time, defaultLayout string) string {
+	if t.IsZero() {
+		return ""
+	}
+	layout := f.layout
+	if layout == "" {
+		layout = defaultLayout
+	}
+	return f.in(t).Format(layout)
+}
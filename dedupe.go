@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// dedupeModes are the -dedupe choices: what a job does with a video that
+// another job in the same run has already downloaded.
+var dedupeModes = []string{"off", "hardlink", "symlink", "copy", "skip"}
+
+// downloadClaim is the first job of a run to download a video. done is
+// closed once it finishes, and path is then its output, or "" if it
+// failed.
+type downloadClaim struct {
+	done chan struct{}
+	path string
+}
+
+func (d *Downloader) dedupeEnabled() bool {
+	return d.config.Dedupe != "" && d.config.Dedupe != "off"
+}
+
+// claimVideo returns the output of an earlier download of videoID in
+// this run, waiting for it if it is still in progress. If there is none,
+// the caller is to download the video and call release with its output,
+// or "" if it fails, so that jobs waiting on it can go ahead.
+func (d *Downloader) claimVideo(ctx context.Context, videoID string) (first string, release func(path string), err error) {
+	for {
+		d.claimsMu.Lock()
+		if d.downloads == nil {
+			d.downloads = make(map[string]*downloadClaim)
+		}
+		claim, ok := d.downloads[videoID]
+		if !ok {
+			claim = &downloadClaim{done: make(chan struct{})}
+			d.downloads[videoID] = claim
+			d.claimsMu.Unlock()
+			return "", func(path string) {
+				d.claimsMu.Lock()
+				claim.path = path
+				if path == "" {
+					// Let the next job for it try again
+					delete(d.downloads, videoID)
+				}
+				d.claimsMu.Unlock()
+				close(claim.done)
+			}, nil
+		}
+		d.claimsMu.Unlock()
+
+		select {
+		case <-claim.done:
+		case <-ctx.Done():
+			return "", nil, ctx.Err()
+		}
+		if claim.path != "" {
+			return claim.path, nil, nil
+		}
+	}
+}
+
+// noteDownloaded records an output left by an earlier run as the video's
+// download for this run.
+func (d *Downloader) noteDownloaded(videoID, path string) {
+	d.claimsMu.Lock()
+	defer d.claimsMu.Unlock()
+	if d.downloads == nil {
+		d.downloads = make(map[string]*downloadClaim)
+	}
+	if _, ok := d.downloads[videoID]; !ok {
+		claim := &downloadClaim{done: make(chan struct{}), path: path}
+		close(claim.done)
+		d.downloads[videoID] = claim
+	}
+}
+
+// reuseDownload puts the file an earlier job downloaded, with its
+// sidecars, at finalPath as -dedupe says, instead of downloading it again.
+func (d *Downloader) reuseDownload(ctx context.Context, job *Job, video *youtube.Video, first, finalPath string) error {
+	if first == finalPath {
+		return errSkipped
+	}
+	if d.config.Dedupe == "skip" || !fileExists(first) {
+		// A file moved to -dest can't be linked to either
+		d.logger.Printf("Skipping %s: already downloaded in this run as %s", video.Title, first)
+		return errSkipped
+	}
+
+	sources := append([]string{first}, d.sidecarPaths(video, first)...)
+	targets := append([]string{finalPath}, d.sidecarPaths(video, finalPath)...)
+	for i, src := range sources {
+		if !fileExists(src) {
+			continue
+		}
+		if err := d.placeCopy(src, targets[i]); err != nil {
+			return fmt.Errorf("failed to %s %s: %v", d.config.Dedupe, filepath.Base(targets[i]), err)
+		}
+	}
+	d.logger.Printf("Reused %s for %s (%s)", first, video.Title, d.config.Dedupe)
+	return d.finishOutput(ctx, job, video, finalPath)
+}
+
+// placeCopy makes dst a hard link to, symlink to or copy of src. A hard
+// link that can't be made, such as across filesystems, becomes a copy.
+func (d *Downloader) placeCopy(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	os.Remove(dst)
+	switch d.config.Dedupe {
+	case "hardlink":
+		err := os.Link(src, dst)
+		if err == nil {
+			return nil
+		}
+		d.logger.Printf("Copying instead of hard linking %s: %v", filepath.Base(dst), err)
+	case "symlink":
+		// Relative, so the output directory can be moved as a whole
+		target, err := filepath.Rel(filepath.Dir(dst), src)
+		if err != nil {
+			target, err = filepath.Abs(src)
+			if err != nil {
+				return err
+			}
+		}
+		return os.Symlink(target, dst)
+	}
+	return copyFile(src, dst)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sshStorage streams files over ssh, which every SFTP server also
+// accepts, so no SFTP client library is needed.
+type sshStorage struct {
+	url *url.URL
+}
+
+func (s sshStorage) String() string { return s.url.Redacted() }
+
+func (s sshStorage) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	target := s.target(name)
+	cmd := s.command(ctx, fmt.Sprintf("mkdir -p %s && cat > %s", shellQuote(path.Dir(target)), shellQuote(target)))
+	cmd.Stdin = r
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ssh: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (s sshStorage) Stat(ctx context.Context, name string) (int64, error) {
+	target := shellQuote(s.target(name))
+	cmd := s.command(ctx, fmt.Sprintf("if [ -f %s ]; then wc -c < %s; else echo missing; fi", target, target))
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("ssh: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if strings.TrimSpace(string(out)) == "missing" {
+		return 0, fs.ErrNotExist
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+}
+
+// target is the remote path of the file stored under name.
+func (s sshStorage) target(name string) string {
+	target := path.Join(s.url.Path, name)
+	if strings.HasPrefix(target, "/~/") {
+		target = target[len("/~/"):]
+	}
+	return target
+}
+
+// command runs script on the host.
+func (s sshStorage) command(ctx context.Context, script string) *exec.Cmd {
+	args := []string{"-o", "BatchMode=yes"}
+	if port := s.url.Port(); port != "" {
+		args = append(args, "-p", port)
+	}
+	host := s.url.Hostname()
+	if s.url.User != nil {
+		host = s.url.User.Username() + "@" + host
+	}
+	return exec.CommandContext(ctx, "ssh", append(args, host, script)...)
+}
+
+// s3Storage uploads with a single SigV4-signed PUT, or a multipart upload
+// when the size is not known in advance. Credentials come from the usual
+// AWS_* environment variables; AWS_ENDPOINT_URL selects an S3-compatible
+// service such as MinIO.
+type s3Storage struct {
+	scheme         string
+	bucket, prefix string
+	region         string
+	endpoint       *url.URL
+	pathStyle      bool
+	accessKey      string
+	secretKey      string
+	sessionToken   string
+	client         *http.Client
+}
+
+func (s *s3Storage) setHTTPClient(client *http.Client) { s.client = client }
+
+func newS3Storage(bucket, prefix string) (*s3Storage, error) {
+	s := &s3Storage{
+		scheme:       "s3",
+		bucket:       bucket,
+		prefix:       prefix,
+		region:       os.Getenv("AWS_REGION"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       http.DefaultClient,
+	}
+	if s.region == "" {
+		s.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("s3://%s: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set", bucket)
+	}
+
+	endpoint := os.Getenv("AWS_ENDPOINT_URL")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, s.region)
+	} else {
+		s.pathStyle = true
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AWS_ENDPOINT_URL: %v", err)
+	}
+	s.endpoint = u
+	return s, nil
+}
+
+func (s *s3Storage) String() string {
+	return s.scheme + "://" + path.Join(s.bucket, s.prefix)
+}
+
+func (s *s3Storage) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	key := path.Join(s.prefix, name)
+	if size < 0 {
+		return s.putMultipart(ctx, key, r)
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, nil, r, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Storage) Stat(ctx context.Context, name string) (int64, error) {
+	resp, err := s.do(ctx, http.MethodHead, path.Join(s.prefix, name), nil, nil, 0)
+	var statusErr *s3StatusError
+	if errors.As(err, &statusErr) && statusErr.code == http.StatusNotFound {
+		return 0, fs.ErrNotExist
+	}
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+// s3StatusError is a request the service answered with an error status.
+type s3StatusError struct {
+	code int
+	msg  string
+}
+
+func (e *s3StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d: %s", e.code, e.msg)
+}
+
+// do sends a signed request for key and returns the response if it
+// succeeded.
+func (s *s3Storage) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	objectPath := "/" + key
+	if s.pathStyle {
+		objectPath = "/" + s.bucket + "/" + key
+	}
+	escaped := s3EscapePath(objectPath)
+	rawQuery := s3CanonicalQuery(query)
+
+	target := strings.TrimSuffix(s.endpoint.String(), "/") + escaped
+	if rawQuery != "" {
+		target += "?" + rawQuery
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	s.sign(req, escaped, rawQuery, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, &s3StatusError{resp.StatusCode, strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+// s3PartSize is the multipart chunk size; S3 requires at least 5 MiB for
+// every part but the last.
+const s3PartSize = 8 << 20
+
+// putMultipart uploads a stream of unknown length, such as ffmpeg's
+// output, in s3PartSize parts.
+func (s *s3Storage) putMultipart(ctx context.Context, key string, r io.Reader) error {
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, 0)
+	if err != nil {
+		return fmt.Errorf("failed to start multipart upload: %v", err)
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to start multipart upload: %v", err)
+	}
+	upload := url.Values{"uploadId": {initiated.UploadID}}
+
+	type part struct {
+		Number int    `xml:"PartNumber"`
+		ETag   string `xml:"ETag"`
+	}
+	var parts []part
+	err = func() error {
+		buf := make([]byte, s3PartSize)
+		for n := 1; ; n++ {
+			read, rerr := io.ReadFull(r, buf)
+			if read == 0 && n > 1 {
+				return nil
+			}
+			if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+				return rerr
+			}
+			query := url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": upload["uploadId"]}
+			resp, err := s.do(ctx, http.MethodPut, key, query, bytes.NewReader(buf[:read]), int64(read))
+			if err != nil {
+				return fmt.Errorf("part %d: %v", n, err)
+			}
+			resp.Body.Close()
+			parts = append(parts, part{Number: n, ETag: resp.Header.Get("ETag")})
+			if rerr != nil {
+				return nil
+			}
+		}
+	}()
+	if err == nil {
+		var body []byte
+		body, err = xml.Marshal(struct {
+			XMLName xml.Name `xml:"CompleteMultipartUpload"`
+			Parts   []part   `xml:"Part"`
+		}{Parts: parts})
+		if err == nil {
+			resp, err = s.do(ctx, http.MethodPost, key, upload, bytes.NewReader(body), int64(len(body)))
+			if err == nil {
+				resp.Body.Close()
+				return nil
+			}
+		}
+	}
+
+	// Don't leave stored parts behind, which would be billed
+	if resp, aerr := s.do(context.Background(), http.MethodDelete, key, upload, nil, 0); aerr == nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
+// s3CanonicalQuery encodes query sorted by key, as SigV4 requires.
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			pairs = append(pairs, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func (s *s3Storage) sign(req *http.Request, escapedPath, canonicalQuery string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	values := []string{req.URL.Host, "UNSIGNED-PAYLOAD", amzDate}
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+		headers = append(headers, "x-amz-security-token")
+		values = append(values, s.sessionToken)
+	}
+
+	var canonicalHeaders strings.Builder
+	for i, h := range headers {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", h, values[i])
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	canonical := strings.Join([]string{
+		req.Method, escapedPath, canonicalQuery, canonicalHeaders.String(), signedHeaders, "UNSIGNED-PAYLOAD",
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3EscapePath percent-encodes everything except unreserved characters
+// and slashes, as SigV4 requires.
+func s3EscapePath(p string) string {
+	return s3EscapeExcept(p, '/')
+}
+
+func s3Escape(s string) string {
+	return s3EscapeExcept(s, '~')
+}
+
+func s3EscapeExcept(p string, keep byte) string {
+	var sb strings.Builder
+	for _, b := range []byte(p) {
+		switch {
+		case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~', b == keep:
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+// destinationStats counts uploads per destination for the final report.
+type destinationStats struct {
+	mu      sync.Mutex
+	success map[string]int
+	failed  map[string]int
+}
+
+func (s *destinationStats) record(dest Storage, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.success == nil {
+		s.success = make(map[string]int)
+		s.failed = make(map[string]int)
+	}
+	if err != nil {
+		s.failed[dest.String()]++
+	} else {
+		s.success[dest.String()]++
+	}
+}
+
+func (s *destinationStats) Report(dests []Storage, logger *log.Logger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, dest := range dests {
+		name := dest.String()
+		if s.success[name]+s.failed[name] == 0 {
+			continue
+		}
+		logger.Printf("Destination %s: %d copied, %d failed", name, s.success[name], s.failed[name])
+	}
+}
+
+// pipeWriter forwards to a destination's pipe until that destination
+// fails, after which writes are dropped so the other copies continue.
+type pipeWriter struct {
+	w      *io.PipeWriter
+	failed bool
+}
+
+func (p *pipeWriter) Write(b []byte) (int, error) {
+	if !p.failed {
+		if _, err := p.w.Write(b); err != nil {
+			p.failed = true
+		}
+	}
+	return len(b), nil
+}
+
+// fanOut copies a finished file to every destination concurrently,
+// reading it from disk only once. The download itself succeeded, so a
+// destination that fails is only logged and counted for the report at
+// the end of the run.
+func (d *Downloader) fanOut(ctx context.Context, job *Job, file string) error {
+	if len(d.config.Destinations) == 0 {
+		return nil
+	}
+	name := d.storageName(file)
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	job.setPhase(PhaseUploading)
+
+	errs := make([]error, len(d.config.Destinations))
+	writers := make([]io.Writer, len(d.config.Destinations))
+	pipes := make([]*io.PipeWriter, len(d.config.Destinations))
+	var wg sync.WaitGroup
+	for i, dest := range d.config.Destinations {
+		pr, pw := io.Pipe()
+		pipes[i] = pw
+		writers[i] = &pipeWriter{w: pw}
+		wg.Add(1)
+		go func(i int, dest Storage) {
+			defer wg.Done()
+			err := dest.Put(ctx, name, pr, info.Size())
+			// Unblock the writer if Put stopped reading early
+			pr.CloseWithError(fmt.Errorf("destination closed"))
+			errs[i] = err
+		}(i, dest)
+	}
+
+	_, copyErr := io.Copy(io.MultiWriter(writers...), f)
+	for _, pw := range pipes {
+		pw.CloseWithError(copyErr)
+	}
+	wg.Wait()
+
+	for i, dest := range d.config.Destinations {
+		err := errs[i]
+		if err == nil && copyErr != nil {
+			err = copyErr
+		}
+		d.destStats.record(dest, err)
+		if err != nil {
+			d.logger.Printf("Failed to copy %s to %s: %v", name, dest, err)
+		} else {
+			d.logger.Printf("Copied %s to %s", name, dest)
+		}
+	}
+	// Unless the job was canceled while copying
+	return ctx.Err()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// newDialer returns the dial function for -force-ipv4, -force-ipv6,
+// -source-address, -interface and -tcp-keepalive, or nil when none is
+// given. An interface is bound by its address, which needs no privileges.
+func newDialer(config Config) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	if !config.ForceIPv4 && !config.ForceIPv6 && config.SourceAddress == "" && config.Interface == "" && config.TCPKeepAlive == 0 {
+		return nil, nil
+	}
+	if config.ForceIPv4 && config.ForceIPv6 {
+		return nil, fmt.Errorf("-force-ipv4 cannot be combined with -force-ipv6")
+	}
+	if config.SourceAddress != "" && config.Interface != "" {
+		return nil, fmt.Errorf("-source-address cannot be combined with -interface")
+	}
+
+	family := ""
+	switch {
+	case config.ForceIPv4:
+		family = "4"
+	case config.ForceIPv6:
+		family = "6"
+	}
+
+	var local net.IP
+	if config.SourceAddress != "" {
+		if local = net.ParseIP(config.SourceAddress); local == nil {
+			return nil, fmt.Errorf("invalid -source-address %q", config.SourceAddress)
+		}
+	}
+	if config.Interface != "" {
+		var err error
+		if local, err = interfaceAddress(config.Interface, family); err != nil {
+			return nil, err
+		}
+	}
+	if local != nil {
+		// A source address only reaches servers of its own family
+		localFamily := "6"
+		if local.To4() != nil {
+			localFamily = "4"
+		}
+		if family != "" && family != localFamily {
+			return nil, fmt.Errorf("source address %s is not IPv%s", local, family)
+		}
+		family = localFamily
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if config.TCPKeepAlive != 0 {
+		dialer.KeepAlive = config.TCPKeepAlive
+	}
+	if local != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: local}
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if network == "tcp" {
+			network += family
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}, nil
+}
+
+// interfaceAddress returns the first unicast address of the named
+// interface in family, "4" or "6", or of either, IPv4 first, when family
+// is empty. Link-local IPv6 addresses can't reach YouTube and are passed
+// over.
+func interfaceAddress(name, family string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -interface: %v", err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses of %s: %v", name, err)
+	}
+	var v6 net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			if family != "6" {
+				return ipnet.IP, nil
+			}
+		} else if v6 == nil && family != "4" {
+			v6 = ipnet.IP
+		}
+	}
+	if v6 == nil {
+		if family == "" {
+			return nil, fmt.Errorf("interface %s has no usable address", name)
+		}
+		return nil, fmt.Errorf("interface %s has no IPv%s address", name, family)
+	}
+	return v6, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// Embed colors for -discord-webhook messages.
+const (
+	discordColorDone   = 0x3c9d4e
+	discordColorFailed = 0xcc4444
+)
+
+type discordMessage struct {
+	Username string         `json:"username,omitempty"`
+	Embeds   []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	URL         string         `json:"url,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Color       int            `json:"color"`
+	Thumbnail   *discordImage  `json:"thumbnail,omitempty"`
+	Fields      []discordField `json:"fields,omitempty"`
+	Timestamp   string         `json:"timestamp"`
+}
+
+type discordImage struct {
+	URL string `json:"url"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// notifyDiscord posts a finished or failed download to -discord-webhook
+// as an embed with the video's thumbnail, duration and file size.
+func (d *Downloader) notifyDiscord(snap JobSnapshot) {
+	if d.config.DiscordWebhook == "" || (snap.Status != JobDone && snap.Status != JobFailed) {
+		return
+	}
+	embed := discordEmbed{
+		Title:     snap.Title,
+		Color:     discordColorDone,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	// Discord rejects titles over 256 characters
+	if r := []rune(embed.Title); len(r) > 256 {
+		embed.Title = string(r[:255]) + "…"
+	}
+	if snap.VideoID != "" {
+		embed.URL = "https://www.youtube.com/watch?v=" + snap.VideoID
+		embed.Thumbnail = &discordImage{URL: "https://i.ytimg.com/vi/" + snap.VideoID + "/hqdefault.jpg"}
+	}
+
+	status := "Downloaded"
+	if snap.Status == JobFailed {
+		status = "Failed"
+		embed.Color = discordColorFailed
+		if snap.Err != nil {
+			embed.Description = snap.Err.Error()
+		}
+	}
+	embed.Fields = append(embed.Fields, discordField{Name: "Status", Value: status, Inline: true})
+	if snap.Duration > 0 {
+		embed.Fields = append(embed.Fields, discordField{Name: "Duration", Value: formatDuration(snap.Duration), Inline: true})
+	}
+	if info, err := os.Stat(snap.OutputPath); err == nil && snap.Status == JobDone {
+		embed.Fields = append(embed.Fields, discordField{Name: "Size", Value: formatBytes(info.Size()), Inline: true})
+	}
+
+	if err := postJSON(d.config.DiscordWebhook, discordMessage{Username: progName, Embeds: []discordEmbed{embed}}); err != nil {
+		d.logger.Printf("Discord notification for %s failed: %v", snap.Title, err)
+	}
+}
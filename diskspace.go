@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var errSpaceUnsupported = errors.New("free space check not supported on this platform")
+
+// spaceReserver tracks the disk space promised to downloads in flight, so
+// concurrent downloads don't each count the same free space.
+type spaceReserver struct {
+	mu       sync.Mutex
+	reserved int64
+}
+
+// reserve checks that need bytes are free on the volume holding dir,
+// after earlier reservations, and reserves them. The returned func
+// releases the reservation.
+func (s *spaceReserver) reserve(dir string, need int64) (func(), error) {
+	free, err := diskFree(dir)
+	if err != nil {
+		return func() {}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if avail := int64(free) - s.reserved; need > avail {
+		return func() {}, fmt.Errorf("not enough disk space in %s: need %s, %s available", dir, formatBytes(need), formatBytes(max(avail, 0)))
+	}
+	s.reserved += need
+	return func() {
+		s.mu.Lock()
+		s.reserved -= need
+		s.mu.Unlock()
+	}, nil
+}
+
+// checkSpace reserves room for a download of size bytes. The streams and
+// the merged or converted output exist side by side until the temp files
+// are removed, so twice the size is needed at the peak, unless the output
+// goes to -dest. With -no-check-space a shortfall is only logged.
+func (d *Downloader) checkSpace(dir, title string, size int64) (func(), error) {
+	if size <= 0 {
+		return func() {}, nil
+	}
+	if d.config.Dest == nil {
+		size *= 2
+	}
+	release, err := d.space.reserve(dir, size)
+	switch {
+	case err == nil:
+		return release, nil
+	case errors.Is(err, errSpaceUnsupported):
+		return release, nil
+	case !d.config.CheckSpace:
+		d.logger.Printf("Warning: %v (downloading %s anyway)", err, title)
+		return release, nil
+	}
+	return release, err
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+)
+
+// diskWriter buffers the writes of a stream download in -buffer-size
+// chunks and, with -fsync-every, syncs them to disk as it goes, so a
+// network filesystem isn't left to flush the whole file at the end.
+type diskWriter struct {
+	f         *os.File
+	buf       *bufio.Writer
+	syncEvery int64
+	unsynced  int64
+}
+
+// newDiskWriter wraps f, whose data starts at offset and is expected to
+// reach size, 0 if unknown. The rest is preallocated with -preallocate.
+func (d *Downloader) newDiskWriter(f *os.File, offset, size int64) *diskWriter {
+	if d.config.Preallocate && size > offset {
+		if err := preallocate(f, offset, size); err != nil && !errors.Is(err, errors.ErrUnsupported) && d.config.Verbose {
+			d.logger.Printf("Not preallocating %s: %v", f.Name(), err)
+		}
+	}
+	return &diskWriter{f: f, buf: bufio.NewWriterSize(f, d.config.BufferSize), syncEvery: d.config.FsyncEvery}
+}
+
+func (w *diskWriter) Write(p []byte) (int, error) {
+	n, err := w.buf.Write(p)
+	w.unsynced += int64(n)
+	if err == nil && w.syncEvery > 0 && w.unsynced >= w.syncEvery {
+		err = w.sync()
+	}
+	return n, err
+}
+
+func (w *diskWriter) sync() error {
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	w.unsynced = 0
+	return w.f.Sync()
+}
+
+// Close writes out what is buffered, even after a failed download, so a
+// later run can continue from it.
+func (w *diskWriter) Close() error {
+	err := w.buf.Flush()
+	if err == nil && w.syncEvery > 0 {
+		err = w.f.Sync()
+	}
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	doctorOK   = "OK"
+	doctorWarn = "WARN"
+	doctorFail = "FAIL"
+)
+
+// lowSpaceWarning is the free space below which the doctor warns; a
+// single 1080p video is often several hundred MiB.
+const lowSpaceWarning = 2 << 30
+
+// doctor runs environment checks and prints each result with a fix.
+type doctor struct {
+	client     *http.Client
+	timeout    time.Duration
+	ffmpegPath string // -ffmpeg-path
+	failed     int
+	warned     int
+}
+
+func (dr *doctor) report(status, name, detail, fix string) {
+	switch status {
+	case doctorFail:
+		dr.failed++
+	case doctorWarn:
+		dr.warned++
+	}
+	fmt.Printf("[%-4s] %s: %s\n", status, name, detail)
+	if fix != "" && status != doctorOK {
+		fmt.Printf("       fix: %s\n", fix)
+	}
+}
+
+func (dr *doctor) checkFFmpeg() {
+	ffmpeg := ffmpegBinary(dr.ffmpegPath, "ffmpeg")
+	if _, err := exec.LookPath(ffmpeg); err != nil {
+		dr.report(doctorWarn, "ffmpeg", "not found in PATH; only H.264/AV1 + AAC downloads can be merged, into MP4",
+			"install ffmpeg (https://ffmpeg.org/download.html) and make sure it is in PATH")
+		return
+	}
+	out, err := exec.Command(ffmpeg, "-version").Output()
+	if err != nil {
+		dr.report(doctorFail, "ffmpeg", fmt.Sprintf("failed to run: %v", err), "reinstall ffmpeg")
+		return
+	}
+	version, _, _ := strings.Cut(string(out), "\n")
+	if version = strings.TrimSpace(version); version == "" {
+		version = "found"
+	}
+	dr.report(doctorOK, "ffmpeg", version, "")
+
+	out, err = exec.Command(ffmpeg, "-hide_banner", "-encoders").Output()
+	if err != nil {
+		dr.report(doctorWarn, "ffmpeg encoders", fmt.Sprintf("failed to list: %v", err), "reinstall ffmpeg")
+		return
+	}
+	encoders := make(map[string]bool)
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 {
+			encoders[fields[1]] = true
+		}
+	}
+	// The encoders used when re-encoding for a container or to MP3
+	var missing []string
+	for _, name := range []string{"libx264", "aac", "libvpx-vp9", "libopus", "libmp3lame"} {
+		if !encoders[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		dr.report(doctorWarn, "ffmpeg encoders", "missing "+strings.Join(missing, ", "),
+			"install a full ffmpeg build; these are needed for -video-codec, -container, -autocrop and -mp3")
+	} else {
+		dr.report(doctorOK, "ffmpeg encoders", "libx264, aac, libvpx-vp9, libopus and libmp3lame available", "")
+	}
+
+	if _, err := exec.LookPath(ffmpegBinary(dr.ffmpegPath, "ffprobe")); err != nil {
+		dr.report(doctorWarn, "ffprobe", "not found in PATH", "install ffprobe (it ships with ffmpeg); -verify-existing needs it")
+	} else {
+		dr.report(doctorOK, "ffprobe", "found", "")
+	}
+}
+
+// checkDNS resolves the YouTube hosts and flags answers that point at
+// this machine or a private network, which is how DNS blockers and
+// captive portals usually respond.
+func (dr *doctor) checkDNS(ctx context.Context) {
+	for _, host := range []string{"www.youtube.com", "redirector.googlevideo.com"} {
+		lctx, cancel := context.WithTimeout(ctx, dr.timeout)
+		addrs, err := net.DefaultResolver.LookupIPAddr(lctx, host)
+		cancel()
+		if err != nil {
+			dr.report(doctorFail, "dns "+host, err.Error(),
+				"check your network connection and DNS server (try 8.8.8.8 or 1.1.1.1)")
+			continue
+		}
+		var bogus []string
+		for _, addr := range addrs {
+			if addr.IP.IsLoopback() || addr.IP.IsUnspecified() || addr.IP.IsPrivate() {
+				bogus = append(bogus, addr.IP.String())
+			}
+		}
+		if len(bogus) > 0 {
+			dr.report(doctorFail, "dns "+host, "resolves to "+strings.Join(bogus, ", "),
+				"a DNS blocker or hosts file entry is hiding YouTube; allow the host or use another DNS server")
+			continue
+		}
+		dr.report(doctorOK, "dns "+host, fmt.Sprintf("%d addresses", len(addrs)), "")
+	}
+}
+
+// checkReachable makes a request to url and returns the response with the
+// body read up to limit bytes, or nil if the request failed.
+func (dr *doctor) checkReachable(ctx context.Context, name, url string, limit int64) (*http.Response, []byte) {
+	rctx, cancel := context.WithTimeout(ctx, dr.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(rctx, http.MethodGet, url, nil)
+	if err != nil {
+		dr.report(doctorFail, name, err.Error(), "")
+		return nil, nil
+	}
+	start := time.Now()
+	resp, err := dr.client.Do(req)
+	if err != nil {
+		dr.report(doctorFail, name, err.Error(),
+			"check your connection, firewall and -proxy; some networks block googlevideo.com")
+		return nil, nil
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, limit))
+	elapsed := formatDuration(time.Since(start))
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		dr.report(doctorWarn, name, fmt.Sprintf("HTTP %d after %s", resp.StatusCode, elapsed),
+			"YouTube is rate limiting this IP; wait, lower -concurrency or use -proxy")
+	case resp.StatusCode >= 500:
+		dr.report(doctorWarn, name, fmt.Sprintf("HTTP %d after %s", resp.StatusCode, elapsed),
+			"the server had a problem; try again later")
+	default:
+		dr.report(doctorOK, name, fmt.Sprintf("HTTP %d in %s", resp.StatusCode, elapsed), "")
+	}
+	return resp, body
+}
+
+// checkClock compares the local clock with the server's Date header.
+// Large skew breaks cookie and OAuth sessions and signed S3 uploads.
+func (dr *doctor) checkClock(resp *http.Response) {
+	if resp == nil {
+		dr.report(doctorWarn, "clock", "not checked, YouTube was unreachable", "")
+		return
+	}
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		dr.report(doctorWarn, "clock", "server sent no usable Date header", "")
+		return
+	}
+	skew := time.Since(serverTime).Round(time.Second)
+	if skew < 0 {
+		skew = -skew
+	}
+	fix := "sync the system clock (e.g. timedatectl set-ntp true, or enable automatic time)"
+	switch {
+	case skew > 5*time.Minute:
+		dr.report(doctorFail, "clock", fmt.Sprintf("off by %s", formatDuration(skew)), fix)
+	case skew > 30*time.Second:
+		dr.report(doctorWarn, "clock", fmt.Sprintf("off by %s", formatDuration(skew)), fix)
+	default:
+		dr.report(doctorOK, "clock", fmt.Sprintf("within %s of YouTube", formatDuration(max(skew, time.Second))), "")
+	}
+}
+
+// checkOutputDir checks that files can be created in dir, or in the
+// nearest existing parent when dir has not been created yet.
+func (dr *doctor) checkOutputDir(dir string) {
+	target := dir
+	for {
+		if _, err := os.Stat(target); err == nil || filepath.Dir(target) == target {
+			break
+		}
+		target = filepath.Dir(target)
+	}
+	name := "output " + dir
+	if target != dir {
+		dr.report(doctorOK, name, fmt.Sprintf("does not exist yet, will be created in %s", target), "")
+	}
+
+	f, err := os.CreateTemp(target, ".ytdl-doctor-*")
+	if err != nil {
+		dr.report(doctorFail, name, fmt.Sprintf("not writable: %v", err),
+			"choose another -output or fix the directory's permissions (chmod/chown)")
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+	dr.report(doctorOK, name, "writable", "")
+
+	free, err := diskFree(target)
+	switch {
+	case errors.Is(err, errSpaceUnsupported):
+		return
+	case err != nil:
+		dr.report(doctorWarn, "free space", err.Error(), "")
+	case free < lowSpaceWarning:
+		dr.report(doctorWarn, "free space", formatBytes(int64(free))+" available",
+			"free up space or choose another -output; downloads need about twice the video size while merging")
+	default:
+		dr.report(doctorOK, "free space", formatBytes(int64(free))+" available", "")
+	}
+}
+
+// checkCredential looks for YouTube's logged-in marker in a page fetched
+// with the stored credential.
+func (dr *doctor) checkCredential(authName string, resp *http.Response, body []byte) {
+	name := "credential " + authName
+	switch {
+	case authName == "":
+		dr.report(doctorOK, "credential", "none used (pass -auth to check a stored one)", "")
+	case resp == nil:
+		dr.report(doctorWarn, name, "not checked, YouTube was unreachable", "")
+	case strings.Contains(string(body), `"LOGGED_IN":true`):
+		dr.report(doctorOK, name, "signed in", "")
+	default:
+		dr.report(doctorFail, name, "YouTube did not accept it as signed in",
+			fmt.Sprintf("export fresh cookies from a signed-in browser and run: %s auth add %s <file>", progName, authName))
+	}
+}
+
+func setupDoctor(flags *flag.FlagSet) func([]string) error {
+	opts := &clientOptions{
+		proxy:    flags.String("proxy", "", "HTTP, HTTPS or SOCKS5 proxy URL"),
+		authName: flags.String("auth", "", "Check a stored credential (see the auth command)"),
+		resolver: new(string),
+		players:  new(string),
+		ipv4:     new(bool),
+		ipv6:     new(bool),
+		source:   new(string),
+		iface:    new(string),
+		agent:    new(string),
+		geo:      new(string),
+		maxRPM:   new(int),
+		rpmBurst: new(int),
+		sleepReq: new(time.Duration),
+		testMode: new(bool),
+	}
+	outputDir := flags.String("output", "downloads", "Output directory to check")
+	timeout := flags.Duration("timeout", 10*time.Second, "Timeout for each network check")
+	ffmpegPath := flags.String("ffmpeg-path", "", "ffmpeg binary, or the directory holding ffmpeg and ffprobe, if not in PATH")
+
+	return func(args []string) error {
+		if len(args) != 0 {
+			flags.Usage()
+			return &exitError{exitTotalFailure, fmt.Errorf("doctor takes no arguments")}
+		}
+		downloader, closeFn, err := opts.newDownloader(Config{MaxConcurrent: 1, MetadataConcurrent: 1, PostProcessConcurrent: 1})
+		if err != nil {
+			return err
+		}
+		defer closeFn()
+
+		fmt.Printf("%s on %s/%s (%s)\n", progName, runtime.GOOS, runtime.GOARCH, runtime.Version())
+		if *opts.proxy != "" {
+			fmt.Printf("Using proxy %s\n", *opts.proxy)
+		}
+		fmt.Println()
+
+		ctx := context.Background()
+		dr := &doctor{client: downloader.http, timeout: *timeout, ffmpegPath: *ffmpegPath}
+		dr.checkFFmpeg()
+		dr.checkDNS(ctx)
+		resp, body := dr.checkReachable(ctx, "https www.youtube.com", "https://www.youtube.com/", 4<<20)
+		dr.checkReachable(ctx, "https googlevideo.com", "https://redirector.googlevideo.com/report_mapping", 64<<10)
+		dr.checkClock(resp)
+		dr.checkOutputDir(*outputDir)
+		dr.checkCredential(*opts.authName, resp, body)
+
+		fmt.Printf("\n%d failed, %d warnings\n", dr.failed, dr.warned)
+		if dr.failed > 0 {
+			return &exitError{exitTotalFailure, fmt.Errorf("%d checks failed", dr.failed)}
+		}
+		return nil
+	}
+}
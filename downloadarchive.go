@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// downloadArchive records the IDs of finished downloads, one
+// "youtube <id>" line each, so later runs can skip them without fetching
+// any metadata.
+type downloadArchive struct {
+	mu   sync.Mutex
+	path string
+	ids  map[string]bool
+}
+
+func loadDownloadArchive(path string) (*downloadArchive, error) {
+	a := &downloadArchive{path: path, ids: make(map[string]bool)}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "youtube" {
+			a.ids[fields[1]] = true
+		}
+	}
+	return a, scanner.Err()
+}
+
+func (a *downloadArchive) Has(id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.ids[id]
+}
+
+func (a *downloadArchive) Add(id string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.ids[id] {
+		return nil
+	}
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "youtube %s\n", id); err != nil {
+		return err
+	}
+	a.ids[id] = true
+	return nil
+}
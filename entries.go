@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// PlaylistEntries enumerates a playlist lazily, fetching each video's
+// metadata only when the caller asks for the next entry, so huge
+// playlists can be filtered or cut short before anything is downloaded.
+//
+// The result has the signature of iter.Seq2, so callers on Go 1.23 or
+// later can range over it:
+//
+//	for video, err := range d.PlaylistEntries(ctx, url) {
+//		if err != nil { ... }
+//	}
+//
+// A playlist that can't be read yields a single error. A video whose
+// metadata can't be fetched yields an error and enumeration continues
+// with the next one.
+func (d *Downloader) PlaylistEntries(ctx context.Context, playlistURL string) func(yield func(*youtube.Video, error) bool) {
+	return func(yield func(*youtube.Video, error) bool) {
+		playlist, err := d.client.GetPlaylistContext(ctx, playlistURL)
+		if err != nil {
+			yield(nil, fmt.Errorf("failed to get playlist: %v", err))
+			return
+		}
+		for _, entry := range playlist.Videos {
+			if err := ctx.Err(); err != nil {
+				yield(nil, err)
+				return
+			}
+			video, err := d.client.GetVideoContext(ctx, entry.ID)
+			if err != nil {
+				err = fmt.Errorf("failed to get video %s: %w", entry.ID, classifyVideoError(err))
+			}
+			if !yield(video, err) {
+				return
+			}
+		}
+	}
+}
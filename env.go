@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// envPrefix starts the environment variables that set flags: YTDL_OUTPUT
+// sets -output and YTDL_LIMIT_RATE sets -limit-rate. They are the lowest
+// precedence, below the config file and then the command line, so a
+// container image can set defaults that a mounted config file overrides.
+const envPrefix = "YTDL_"
+
+// envAliases are other names for settings, as containers often call them.
+var envAliases = map[string]string{
+	"YTDL_OUTPUT_DIR": "output",
+}
+
+// envConfigPath is the config file named by YTDL_CONFIG, used when
+// -config isn't given.
+func envConfigPath() string {
+	return os.Getenv(envPrefix + "CONFIG")
+}
+
+// applyEnv sets flags from YTDL_ environment variables. Flags that can be
+// repeated take one value per line.
+func applyEnv(flags *flag.FlagSet) error {
+	env := os.Environ()
+	sort.Strings(env)
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		rest, ok := strings.CutPrefix(name, envPrefix)
+		if !ok || rest == "" {
+			continue
+		}
+		setting, ok := envAliases[name]
+		if !ok {
+			setting = strings.ToLower(strings.ReplaceAll(rest, "_", "-"))
+		}
+		if setting == "config" {
+			continue
+		}
+		f := flags.Lookup(setting)
+		if f == nil {
+			// Settings for other commands are fine, typos are not
+			if knownSetting(setting) {
+				continue
+			}
+			return fmt.Errorf("unknown setting %s", name)
+		}
+		values := []string{value}
+		if _, ok := f.Value.(*stringList); ok {
+			values = strings.Split(strings.TrimSpace(value), "\n")
+		}
+		for _, v := range values {
+			if err := flags.Set(setting, expandHome(strings.TrimSpace(v))); err != nil {
+				return fmt.Errorf("invalid value for %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// Failure classes, for callers to test with errors.Is. The errors
+// returned keep their detailed messages and wrap one of these.
+var (
+	ErrNoFormats     = errors.New("no suitable formats")
+	ErrAgeRestricted = errors.New("video is age-restricted")
+	ErrPrivateVideo  = errors.New("video is private")
+	ErrGeoBlocked    = errors.New("video is not available in this country")
+	ErrRemoved       = errors.New("video has been removed")
+	ErrCopyright     = errors.New("video was taken down over a copyright claim")
+	ErrFFmpegMissing = errors.New("ffmpeg not found")
+)
+
+// FFmpegError is a failed ffmpeg run. Stderr is the end of what ffmpeg
+// printed, which is where it explains itself.
+type FFmpegError struct {
+	Err    error
+	Stderr string
+}
+
+func (e *FFmpegError) Error() string {
+	if e.Stderr == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v: %s", e.Err, e.Stderr)
+}
+
+func (e *FFmpegError) Unwrap() error { return e.Err }
+
+// classifiedError tags err with a failure class, and states the class
+// before YouTube's explanation. Both stay reachable with errors.Is and
+// errors.As.
+type classifiedError struct {
+	class  error
+	err    error
+	detail string
+}
+
+func (e *classifiedError) Error() string {
+	if e.detail == "" {
+		return e.class.Error()
+	}
+	return e.class.Error() + ": " + e.detail
+}
+
+func (e *classifiedError) Unwrap() []error { return []error{e.class, e.err} }
+
+// classifyVideoError maps the playability status YouTube gives for
+// videos that can't be downloaded to a failure class. Other errors are
+// returned as they are.
+func classifyVideoError(err error) error {
+	var class error
+	detail := err.Error()
+	var status youtube.ErrPlayabiltyStatus
+	switch {
+	case errors.Is(err, youtube.ErrLoginRequired):
+		class = ErrAgeRestricted
+	case errors.Is(err, youtube.ErrVideoPrivate):
+		class = ErrPrivateVideo
+	case errors.As(err, &status):
+		detail = status.Reason
+		reason := strings.ToLower(status.Reason)
+		switch {
+		case strings.Contains(reason, "private"):
+			class = ErrPrivateVideo
+		case strings.Contains(reason, "copyright"):
+			class = ErrCopyright
+		case strings.Contains(reason, "confirm your age") || strings.Contains(reason, "inappropriate"):
+			class = ErrAgeRestricted
+		case strings.Contains(reason, "country") || strings.Contains(reason, "region"):
+			class = ErrGeoBlocked
+		case status.Status == "ERROR" || strings.Contains(reason, "removed") ||
+			strings.Contains(reason, "no longer available") || strings.Contains(reason, "terminated"):
+			class = ErrRemoved
+		}
+	}
+	if class == nil {
+		return err
+	}
+	return &classifiedError{class: class, err: err, detail: detail}
+}
+
+// isUnavailable reports whether err means YouTube won't serve the video
+// at all, as opposed to a failure that might go away on a retry.
+func isUnavailable(err error) bool {
+	for _, class := range []error{ErrAgeRestricted, ErrPrivateVideo, ErrGeoBlocked, ErrRemoved, ErrCopyright} {
+		if errors.Is(err, class) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipError is why a job was skipped rather than failed, such as the
+// video being private. Skipped jobs don't count towards -max-failures.
+type skipError struct {
+	err error
+}
+
+func (e *skipError) Error() string   { return e.err.Error() }
+func (e *skipError) Unwrap() []error { return []error{errSkipped, e.err} }
+
+// errorHint suggests what to do about a failure, or returns "".
+func errorHint(err error) string {
+	switch {
+	case errors.Is(err, ErrAgeRestricted):
+		return "age-restricted videos need a signed-in account; see the auth command and -auth"
+	case errors.Is(err, ErrPrivateVideo):
+		return "private videos can only be downloaded with an account that was given access (-auth)"
+	case errors.Is(err, ErrGeoBlocked):
+		return "try a -proxy in a country where the video is available"
+	case errors.Is(err, ErrFFmpegMissing):
+		return "install ffmpeg or point -ffmpeg-path at it"
+	case errors.Is(err, ErrNoFormats):
+		return "loosen -f, -quality or the codec options"
+	}
+	return ""
+}
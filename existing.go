@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// What to do when the output file of a video already exists.
+const (
+	ExistingSkip      = "skip"
+	ExistingOverwrite = "overwrite"
+	ExistingContinue  = "continue"
+)
+
+// Allowed difference between the expected and probed duration of an
+// existing file before it is considered incomplete.
+const durationTolerance = 2 * time.Second
+
+// existingOutputValid reports whether finalPath already holds a usable copy
+// of a video. When VerifyExisting is set the file is also probed with
+// ffprobe and its duration compared with expected. With -dest the file is
+// looked up in storage instead, where it can't be probed.
+func (d *Downloader) existingOutputValid(ctx context.Context, finalPath string, expected time.Duration) (bool, string) {
+	if d.config.Dest != nil {
+		size, err := d.config.Dest.Stat(ctx, d.storageName(finalPath))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return false, ""
+		case err != nil:
+			return false, fmt.Sprintf("could not look up existing file in %s: %v", d.config.Dest, err)
+		case size == 0:
+			return false, "existing file is empty"
+		}
+		return true, ""
+	}
+
+	stat, err := os.Stat(finalPath)
+	if err != nil {
+		return false, ""
+	}
+	if stat.Size() == 0 {
+		return false, "existing file is empty"
+	}
+	if !d.config.VerifyExisting || expected == 0 {
+		return true, ""
+	}
+
+	duration, err := probeDuration(ffmpegBinary(d.config.FFmpegPath, "ffprobe"), finalPath)
+	if err != nil {
+		return false, fmt.Sprintf("could not probe existing file: %v", err)
+	}
+	if diff := duration - expected; math.Abs(float64(diff)) > float64(durationTolerance) {
+		return false, fmt.Sprintf("existing file is %s long, expected %s", formatDuration(duration), formatDuration(expected))
+	}
+	return true, ""
+}
+
+func probeDuration(ffprobe, path string) (time.Duration, error) {
+	out, err := exec.Command(ffprobe,
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	).Output()
+	if err != nil {
+		return 0, err
+	}
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// fetchFormat downloads one format to path and, with -paranoid, checks
+// it against a second fetch.
+func (d *Downloader) fetchFormat(ctx context.Context, job *Job, video *youtube.Video, format *youtube.Format, path, label string) (int64, error) {
+	n, err := d.fetchStream(ctx, job, video, format, path, label)
+	if err == nil && d.config.Paranoid {
+		err = d.verifyFormat(ctx, job, video, format, path, label)
+	}
+	var slow *tooSlowError
+	if errors.As(err, &slow) {
+		// Another format will be fetched in its place, even with -continue
+		d.discardTemp(path)
+	}
+	return n, err
+}
+
+// fetchStream downloads one format to path. In continue mode an existing
+// partial file is resumed with a range request instead of starting over,
+// and a throttled stream is resumed from a fresh URL.
+func (d *Downloader) fetchStream(ctx context.Context, job *Job, video *youtube.Video, format *youtube.Format, path, label string) (int64, error) {
+	if d.useAria2(job, video, format) {
+		return d.fetchWithAria2(ctx, job, video, format, path, label)
+	}
+	var offset int64
+	if d.config.ExistingPolicy == ExistingContinue {
+		if stat, err := os.Stat(path); err == nil {
+			offset = stat.Size()
+		}
+	}
+
+	limit := d.fetchLength(job, video, format)
+	if offset > 0 && format.ContentLength > 0 {
+		if offset >= limit {
+			d.logger.Printf("Already downloaded %s", label)
+			job.addProgress(int(offset))
+			return offset, nil
+		}
+
+		stream, err := d.openStreamAt(ctx, video, format, offset)
+		if err != nil {
+			return 0, fmt.Errorf("failed to resume %s: %v", label, err)
+		}
+		defer stream.Close()
+
+		d.logger.Printf("Resuming %s at %s", label, formatBytes(offset))
+		job.addProgress(int(offset))
+		n, err := d.downloadStreamToFile(job, d.watchBudget(job, format, d.watchThrottle(io.LimitReader(stream, limit-offset))), path, label, offset, limit)
+		return d.resumeThrottled(ctx, job, video, format, path, label, n, limit, checkStreamLength(label, n, limit, err))
+	}
+
+	stream, size, err := d.client.GetStreamContext(ctx, video, format)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get stream for %s: %v", label, err)
+	}
+	defer stream.Close()
+	if format.ContentLength == 0 {
+		job.addTotal(size)
+		limit = size
+	}
+	if limit < format.ContentLength {
+		d.logger.Printf("Fetching the first %s of %s for %s", formatBytes(limit), label, job.clip.label())
+		stream = io.NopCloser(io.LimitReader(stream, limit))
+	}
+
+	n, err := d.downloadStreamToFile(job, d.watchBudget(job, format, d.watchThrottle(stream)), path, label, 0, limit)
+	return d.resumeThrottled(ctx, job, video, format, path, label, n, limit, checkStreamLength(label, n, limit, err))
+}
+
+// checkStreamLength turns a stream that ended before its expected length
+// into an error, since a truncated MP4 or WebM would otherwise merge
+// into a file that fails part way through playback. expected is 0 when
+// the length isn't known.
+func checkStreamLength(label string, n, expected int64, err error) error {
+	if err != nil || expected <= 0 || n >= expected {
+		return err
+	}
+	return fmt.Errorf("%s is truncated: got %s of %s", label, formatBytes(n), formatBytes(expected))
+}
+
+func (d *Downloader) openStreamAt(ctx context.Context, video *youtube.Video, format *youtube.Format, offset int64) (io.ReadCloser, error) {
+	url, err := d.client.GetStreamURLContext(ctx, video, format)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+
+	resp, err := d.streamHTTP(video).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("server does not support resuming (status %d)", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// removePartial deletes an incomplete temp file unless it is being kept
+// around so a later run can continue it.
+func (d *Downloader) removePartial(path string) {
+	if d.config.ExistingPolicy != ExistingContinue {
+		d.discardTemp(path)
+	}
+}
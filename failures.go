@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Process exit codes, so scripts can tell a partial playlist failure from
+// a run where nothing could be downloaded.
+const (
+	exitSuccess        = 0
+	exitTotalFailure   = 1
+	exitPartialFailure = 2
+)
+
+const failedReportName = "failed.txt"
+
+func failedJobs(jobs []*Job) []JobSnapshot {
+	var failed []JobSnapshot
+	for _, job := range jobs {
+		snap := job.Snapshot()
+		if snap.Status == JobFailed || snap.Status == JobCanceled {
+			failed = append(failed, snap)
+		}
+	}
+	return failed
+}
+
+// writeFailedReport writes one "<url>  # <reason>" line per failed job.
+// The file can be passed back with -retry-failed.
+func writeFailedReport(path string, failed []JobSnapshot) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, job := range failed {
+		reason := string(job.Status)
+		if job.Err != nil {
+			reason = strings.ReplaceAll(job.Err.Error(), "\n", " ")
+		}
+		fmt.Fprintf(w, "%s  # %s: %s\n", job.URL, job.Title, reason)
+	}
+	return w.Flush()
+}
+
+// readFailedReport returns the video IDs/URLs listed in a failed.txt file,
+// ignoring comments and blank lines.
+func readFailedReport(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ids []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		ids = append(ids, line)
+	}
+	return ids, scanner.Err()
+}
+
+func sameFile(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ai, bi)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
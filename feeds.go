@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// YouTube publishes an Atom feed of the latest 15 uploads for every
+// channel and playlist, which is far cheaper to poll than the uploads
+// playlist itself.
+const feedBaseURL = "https://www.youtube.com/feeds/videos.xml"
+
+type atomFeed struct {
+	Title   string `xml:"title"`
+	Entries []struct {
+		VideoID string `xml:"http://www.youtube.com/xml/schemas/2015 videoId"`
+		Title   string `xml:"title"`
+	} `xml:"entry"`
+}
+
+func isFeedURL(s string) bool {
+	return strings.Contains(s, "/feeds/videos.xml")
+}
+
+func channelFeedURL(channelID string) string {
+	return feedBaseURL + "?channel_id=" + channelID
+}
+
+// feedIDs returns the video IDs in a channel or playlist feed, newest
+// first.
+func (d *Downloader) feedIDs(ctx context.Context, url string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch feed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch feed: unexpected status code: %d", resp.StatusCode)
+	}
+
+	var feed atomFeed
+	if err := xml.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("failed to parse feed: %v", err)
+	}
+	ids := make([]string, 0, len(feed.Entries))
+	for _, e := range feed.Entries {
+		if e.VideoID != "" {
+			ids = append(ids, e.VideoID)
+		}
+	}
+	return ids, nil
+}
+
+type opmlOutline struct {
+	Title    string        `xml:"title,attr"`
+	Text     string        `xml:"text,attr"`
+	XMLURL   string        `xml:"xmlUrl,attr"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+// loadOPML returns the YouTube feed URLs in an OPML subscription list,
+// as exported by feed readers and YouTube's old subscription manager.
+// Outlines may be nested in categories.
+func loadOPML(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var doc struct {
+		Outlines []opmlOutline `xml:"body>outline"`
+	}
+	if err := xml.NewDecoder(f).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	var urls []string
+	var walk func([]opmlOutline)
+	walk = func(outlines []opmlOutline) {
+		for _, o := range outlines {
+			if isFeedURL(o.XMLURL) {
+				urls = append(urls, o.XMLURL)
+			}
+			walk(o.Outlines)
+		}
+	}
+	walk(doc.Outlines)
+	return urls, nil
+}
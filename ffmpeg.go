@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// FFmpeg performs the muxing and transcoding steps of a download. Each
+// method writes to the output described by out.
+type FFmpeg interface {
+	// Merge combines separate video and audio streams.
+	Merge(ctx context.Context, m mergeSpec, out mediaOutput) error
+	// Convert saves the audio of inputPath with codecArgs, through
+	// audioFilter if it is set.
+	Convert(ctx context.Context, inputPath string, clip *clipRange, codecArgs []string, audioFilter string, tags []string, out mediaOutput) error
+	// MeasureLoudness runs the first pass of -normalize-audio.
+	MeasureLoudness(ctx context.Context, inputPath string, clip *clipRange, target float64) (*loudnessStats, error)
+	// Remux copies the streams of input, a path or URL, into out's
+	// container, stopping after limit if it is non-zero.
+	Remux(ctx context.Context, input string, limit time.Duration, out mediaOutput) error
+	// DetectSilence finds the silences of at least minLength in
+	// inputPath, for -split-silence.
+	DetectSilence(ctx context.Context, inputPath string, minLength time.Duration) ([]silence, error)
+	// Extract copies the part of inputPath in clip, with its tags and
+	// then tags.
+	Extract(ctx context.Context, inputPath string, clip *clipRange, tags []string, out mediaOutput) error
+	// String describes the implementation, e.g. "ffmpeg 6.1.1".
+	String() string
+}
+
+// mergeSpec is one merge of a video and an audio stream.
+type mergeSpec struct {
+	videoPath string
+	audioPath string
+	sel       formatSelection
+	codecArgs []string // from mergeCodecArgs
+	tags      []string
+}
+
+// mediaOutput is where an FFmpeg operation writes: the file at path, or
+// w when the output is streamed to a -dest. format is the ffmpeg muxer,
+// since a .part file's extension says nothing about its format.
+type mediaOutput struct {
+	path   string
+	w      io.Writer
+	format string
+	// progress, if set, is called with how much of the media has been
+	// written so far
+	progress func(time.Duration)
+}
+
+// args are ffmpeg's output options for o.
+func (o mediaOutput) args() []string {
+	var args []string
+	if o.format != "" {
+		args = append(args, "-f", o.format)
+	}
+	if o.w == nil {
+		return append(args, "-y", o.path)
+	}
+	if o.format == "mp4" {
+		// A pipe can't be seeked back to write the index at the start
+		args = append(args, "-movflags", "frag_keyframe+empty_moov")
+	}
+	return append(args, "-y", "pipe:1")
+}
+
+// ffmpegBinary returns the command for an ffmpeg tool such as
+// "ffprobe". location is -ffmpeg-path: the ffmpeg binary, the directory
+// holding it, or empty to search PATH.
+func ffmpegBinary(location, tool string) string {
+	if location == "" {
+		return tool
+	}
+	if st, err := os.Stat(location); err == nil && st.IsDir() {
+		return filepath.Join(location, tool)
+	}
+	if tool == "ffmpeg" {
+		return location
+	}
+	// The other tools are installed next to ffmpeg
+	return filepath.Join(filepath.Dir(location), tool+filepath.Ext(location))
+}
+
+// splitArgs splits -ffmpeg-args into arguments like a shell would, so
+// single and double quotes and backslashes group words.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case unicode.IsSpace(r):
+			if inWord {
+				args = append(args, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", s)
+	}
+	if inWord {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// detectFFmpeg finds the ffmpeg binary and its version. Without one,
+// merges fall back to the built-in MP4 muxer. With -verbose, ffmpeg's
+// output is logged as it runs.
+func detectFFmpeg(logger *log.Logger, config Config) FFmpeg {
+	path, err := exec.LookPath(ffmpegBinary(config.FFmpegPath, "ffmpeg"))
+	if err != nil {
+		logger.Printf("ffmpeg not found; merging with the built-in muxer, which only copies H.264/AV1 and AAC into MP4")
+		return &goMuxer{logger: logger}
+	}
+	f := &execFFmpeg{path: path, extraArgs: config.ExtraFFmpegArgs}
+	if config.Verbose {
+		f.debug = logger
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if out, err := exec.CommandContext(ctx, path, "-version").Output(); err == nil {
+		// "ffmpeg version 6.1.1-3ubuntu5 Copyright ..."
+		if fields := strings.Fields(string(out)); len(fields) > 2 && fields[1] == "version" {
+			f.version = fields[2]
+		}
+	}
+	return f
+}
+
+// execFFmpeg runs the ffmpeg binary.
+type execFFmpeg struct {
+	path      string
+	version   string      // empty if ffmpeg -version couldn't be parsed
+	debug     *log.Logger // nil unless -verbose
+	extraArgs []string    // -ffmpeg-args, for merges and conversions
+}
+
+func (f *execFFmpeg) String() string {
+	if f.version == "" {
+		return "ffmpeg"
+	}
+	return "ffmpeg " + f.version
+}
+
+func (f *execFFmpeg) Merge(ctx context.Context, m mergeSpec, out mediaOutput) error {
+	args := append(m.sel.clip.inputArgs(), "-i", m.videoPath)
+	args = append(append(args, m.sel.clip.inputArgs()...), "-i", m.audioPath)
+	for _, t := range m.sel.tracks {
+		args = append(append(args, m.sel.clip.inputArgs()...), "-i", t.path)
+	}
+	args = append(args, m.codecArgs...)
+	args = append(args, trackArgs(m.sel)...)
+	args = append(args, m.tags...)
+	args = append(args, "-strict", "experimental")
+	args = append(args, f.extraArgs...)
+	return f.run(ctx, args, out)
+}
+
+func (f *execFFmpeg) Convert(ctx context.Context, inputPath string, clip *clipRange, codecArgs []string, audioFilter string, tags []string, out mediaOutput) error {
+	args := append(clip.inputArgs(), "-i", inputPath, "-vn")
+	args = append(args, codecArgs...)
+	if audioFilter != "" {
+		args = append(args, "-af", audioFilter)
+	}
+	args = append(args, tags...)
+	args = append(args, f.extraArgs...)
+	return f.run(ctx, args, out)
+}
+
+func (f *execFFmpeg) Remux(ctx context.Context, input string, limit time.Duration, out mediaOutput) error {
+	var args []string
+	if limit > 0 {
+		args = []string{"-t", strconv.FormatFloat(limit.Seconds(), 'f', -1, 64)}
+	}
+	args = append(args, "-i", input, "-c", "copy")
+	return f.run(ctx, args, out)
+}
+
+// run runs ffmpeg, and on failure returns the end of what it printed,
+// which is where ffmpeg explains itself.
+func (f *execFFmpeg) run(ctx context.Context, args []string, out mediaOutput) error {
+	level := "error"
+	if f.debug != nil {
+		level = "info"
+	}
+	pre := []string{"-hide_banner", "-nostdin", "-loglevel", level}
+	if out.progress != nil {
+		// Machine-readable progress, interleaved with the log on stderr
+		pre = append(pre, "-nostats", "-progress", "pipe:2")
+	}
+	args = append(pre, args...)
+	cmd := exec.CommandContext(ctx, f.path, append(args, out.args()...)...)
+	stderr := &ffmpegLog{debug: f.debug, progress: out.progress}
+	cmd.Stderr = stderr
+	cmd.Stdout = out.w
+	if err := cmd.Run(); err != nil {
+		return &FFmpegError{Err: err, Stderr: stderr.tail()}
+	}
+	return nil
+}
+
+// ffmpegTailLines is how many lines of ffmpeg's output an error quotes.
+const ffmpegTailLines = 5
+
+// progressLine matches the key=value lines of ffmpeg -progress.
+var progressLine = regexp.MustCompile(`^([a-z0-9_]+)=(\S*)$`)
+
+// ffmpegLog reads ffmpeg's stderr line by line. Progress reports go to
+// progress; other lines are logged with -verbose, and the last few kept
+// for errors.
+type ffmpegLog struct {
+	debug    *log.Logger
+	progress func(time.Duration)
+	partial  []byte
+	lines    []string
+}
+
+func (l *ffmpegLog) Write(p []byte) (int, error) {
+	l.partial = append(l.partial, p...)
+	for {
+		// ffmpeg ends status lines with \r to redraw them
+		i := bytes.IndexAny(l.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		l.line(string(l.partial[:i]))
+		l.partial = l.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (l *ffmpegLog) line(s string) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return
+	}
+	if m := progressLine.FindStringSubmatch(s); m != nil {
+		if m[1] == "out_time_us" && l.progress != nil {
+			if us, err := strconv.ParseInt(m[2], 10, 64); err == nil && us >= 0 {
+				l.progress(time.Duration(us) * time.Microsecond)
+			}
+		}
+		return
+	}
+	if l.debug != nil {
+		l.debug.Printf("ffmpeg: %s", s)
+	}
+	l.lines = append(l.lines, s)
+	if len(l.lines) > ffmpegTailLines {
+		l.lines = l.lines[1:]
+	}
+}
+
+// tail returns the last lines ffmpeg printed, joined into one line.
+func (l *ffmpegLog) tail() string {
+	if len(l.partial) > 0 {
+		l.line(string(l.partial))
+		l.partial = nil
+	}
+	return strings.Join(l.lines, "; ")
+}
+
+// goMuxer stands in for a missing ffmpeg. It can merge fragmented MP4
+// video and AAC audio into MP4 as they are, which is what the default
+// format selection picks without ffmpeg; anything needing a re-encode
+// or another container fails with an explanation.
+type goMuxer struct {
+	logger *log.Logger
+}
+
+func (g *goMuxer) String() string { return "built-in MP4 muxer" }
+
+func (g *goMuxer) Merge(ctx context.Context, m mergeSpec, out mediaOutput) error {
+	switch {
+	case out.format != "mp4":
+		return fmt.Errorf("%w; it is needed to write %s files", ErrFFmpegMissing, m.sel.container)
+	case m.sel.clip != nil || m.sel.crop != "":
+		return fmt.Errorf("%w; it is needed to cut or crop videos", ErrFFmpegMissing)
+	}
+	for i := 1; i < len(m.codecArgs); i += 2 {
+		if m.codecArgs[i] != "copy" {
+			return fmt.Errorf("%w; it is needed to re-encode to %s", ErrFFmpegMissing, m.codecArgs[i])
+		}
+	}
+	if len(m.tags) > 0 {
+		g.logger.Printf("Not embedding metadata without ffmpeg")
+	}
+
+	if out.w != nil {
+		return muxFragmentedMP4(ctx, m.videoPath, m.audioPath, out.w, out.progress)
+	}
+	f, err := os.Create(out.path)
+	if err != nil {
+		return err
+	}
+	if err := muxFragmentedMP4(ctx, m.videoPath, m.audioPath, f, out.progress); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (g *goMuxer) Convert(ctx context.Context, inputPath string, clip *clipRange, codecArgs []string, audioFilter string, tags []string, out mediaOutput) error {
+	return fmt.Errorf("%w; it is needed to extract audio", ErrFFmpegMissing)
+}
+
+func (g *goMuxer) MeasureLoudness(ctx context.Context, inputPath string, clip *clipRange, target float64) (*loudnessStats, error) {
+	return nil, fmt.Errorf("%w; it is needed to measure loudness", ErrFFmpegMissing)
+}
+
+func (g *goMuxer) Remux(ctx context.Context, input string, limit time.Duration, out mediaOutput) error {
+	return fmt.Errorf("%w; it is needed to remux %s", ErrFFmpegMissing, input)
+}
+
+func (g *goMuxer) DetectSilence(ctx context.Context, inputPath string, minLength time.Duration) ([]silence, error) {
+	return nil, fmt.Errorf("%w; it is needed to find silences", ErrFFmpegMissing)
+}
+
+func (g *goMuxer) Extract(ctx context.Context, inputPath string, clip *clipRange, tags []string, out mediaOutput) error {
+	return fmt.Errorf("%w; it is needed to split files", ErrFFmpegMissing)
+}
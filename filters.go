@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// videoFilter skips videos by their metadata, which is checked before
+// any stream is fetched. Zero fields don't filter, and a video missing
+// the data a filter needs is kept.
+type videoFilter struct {
+	MinDuration    time.Duration
+	MaxDuration    time.Duration
+	UploadedAfter  time.Time // inclusive
+	UploadedBefore time.Time // inclusive
+	MinViews       int
+	MaxViews       int
+
+	MatchTitle        *regexp.Regexp
+	RejectTitle       *regexp.Regexp
+	MatchDescription  *regexp.Regexp
+	RejectDescription *regexp.Regexp
+}
+
+// rejectTitle applies the title filters, which playlist listings can
+// check without fetching each video.
+func (f videoFilter) rejectTitle(title string) string {
+	if f.MatchTitle != nil && !f.MatchTitle.MatchString(title) {
+		return "title doesn't match -match-title"
+	}
+	if f.RejectTitle != nil && f.RejectTitle.MatchString(title) {
+		return "title matches -reject-title"
+	}
+	return ""
+}
+
+// reject returns why video is filtered out, or "" to download it.
+func (f videoFilter) reject(video *youtube.Video) string {
+	// Live streams have no duration yet
+	if video.Duration > 0 {
+		if f.MinDuration > 0 && video.Duration < f.MinDuration {
+			return fmt.Sprintf("shorter than %s", formatDuration(f.MinDuration))
+		}
+		if f.MaxDuration > 0 && video.Duration > f.MaxDuration {
+			return fmt.Sprintf("longer than %s", formatDuration(f.MaxDuration))
+		}
+	}
+	if published := video.PublishDate; !published.IsZero() {
+		if !f.UploadedAfter.IsZero() && published.Before(f.UploadedAfter) {
+			return fmt.Sprintf("uploaded %s, before %s", published.Format("2006-01-02"), f.UploadedAfter.Format("2006-01-02"))
+		}
+		if !f.UploadedBefore.IsZero() && !published.Before(f.UploadedBefore.AddDate(0, 0, 1)) {
+			return fmt.Sprintf("uploaded %s, after %s", published.Format("2006-01-02"), f.UploadedBefore.Format("2006-01-02"))
+		}
+	}
+	if reason := f.rejectTitle(video.Title); reason != "" {
+		return reason
+	}
+	if f.MatchDescription != nil && !f.MatchDescription.MatchString(video.Description) {
+		return "description doesn't match -match-description"
+	}
+	if f.RejectDescription != nil && f.RejectDescription.MatchString(video.Description) {
+		return "description matches -reject-description"
+	}
+	if f.MinViews > 0 && video.Views < f.MinViews {
+		return fmt.Sprintf("%d views, fewer than %d", video.Views, f.MinViews)
+	}
+	if f.MaxViews > 0 && video.Views > f.MaxViews {
+		return fmt.Sprintf("%d views, more than %d", video.Views, f.MaxViews)
+	}
+	return ""
+}
+
+// parseDateFlag parses a date as YYYY-MM-DD or as an age such as "30d"
+// or "12h", which is counted back from now.
+func parseDateFlag(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	age, err := parseDuration(s)
+	if err != nil || age < 0 {
+		return time.Time{}, fmt.Errorf("expected YYYY-MM-DD or an age like 30d, got %q", s)
+	}
+	return time.Now().Add(-age).UTC().Truncate(24 * time.Hour), nil
+}
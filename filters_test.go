@@ -0,0 +1,113 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+func TestVideoFilterReject(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	video := &youtube.Video{
+		Duration:    10 * time.Minute,
+		PublishDate: day("2024-03-15"),
+		Views:       1000,
+	}
+
+	tests := []struct {
+		name   string
+		filter videoFilter
+		video  *youtube.Video
+		reject bool
+	}{
+		{"no filters", videoFilter{}, video, false},
+		{"long enough", videoFilter{MinDuration: 10 * time.Minute}, video, false},
+		{"too short", videoFilter{MinDuration: 11 * time.Minute}, video, true},
+		{"short enough", videoFilter{MaxDuration: 10 * time.Minute}, video, false},
+		{"too long", videoFilter{MaxDuration: 9 * time.Minute}, video, true},
+		{"live without a duration", videoFilter{MinDuration: time.Hour}, &youtube.Video{}, false},
+		// Both ends of the date range are inclusive
+		{"uploaded on the first day", videoFilter{UploadedAfter: day("2024-03-15")}, video, false},
+		{"uploaded too early", videoFilter{UploadedAfter: day("2024-03-16")}, video, true},
+		{"uploaded on the last day", videoFilter{UploadedBefore: day("2024-03-15")}, video, false},
+		{"uploaded too late", videoFilter{UploadedBefore: day("2024-03-14")}, video, true},
+		{"no upload date", videoFilter{UploadedAfter: day("2024-03-16")}, &youtube.Video{}, false},
+		{"enough views", videoFilter{MinViews: 1000}, video, false},
+		{"too few views", videoFilter{MinViews: 1001}, video, true},
+		{"too many views", videoFilter{MaxViews: 999}, video, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := tt.filter.reject(tt.video)
+			if got := reason != ""; got != tt.reject {
+				t.Errorf("reject = %q, want rejected %v", reason, tt.reject)
+			}
+		})
+	}
+}
+
+func TestParseDateFlag(t *testing.T) {
+	if got, err := parseDateFlag(""); err != nil || !got.IsZero() {
+		t.Errorf(`parseDateFlag("") = %v, %v, want the zero time`, got, err)
+	}
+	if got, err := parseDateFlag("2024-03-15"); err != nil || !got.Equal(time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf(`parseDateFlag("2024-03-15") = %v, %v`, got, err)
+	}
+
+	// Ages count back from now to the start of that day
+	got, err := parseDateFlag("30d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Now().AddDate(0, 0, -30).UTC().Truncate(24 * time.Hour)
+	if diff := got.Sub(want); diff < -24*time.Hour || diff > 24*time.Hour || !got.Equal(got.Truncate(24*time.Hour)) {
+		t.Errorf(`parseDateFlag("30d") = %v, want about %v`, got, want)
+	}
+
+	for _, s := range []string{"yesterday", "2024-13-01", "-5d"} {
+		if _, err := parseDateFlag(s); err == nil {
+			t.Errorf("parseDateFlag(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestVideoFilterRegexps(t *testing.T) {
+	video := &youtube.Video{Title: "Live at Wembley (Full Concert)", Description: "Recorded in 1986. #sponsored"}
+	tests := []struct {
+		name   string
+		filter videoFilter
+		reject bool
+	}{
+		{"title matches", videoFilter{MatchTitle: regexp.MustCompile(`(?i)full concert`)}, false},
+		{"title doesn't match", videoFilter{MatchTitle: regexp.MustCompile(`^Live$`)}, true},
+		{"title rejected", videoFilter{RejectTitle: regexp.MustCompile(`\(Full`)}, true},
+		{"title not rejected", videoFilter{RejectTitle: regexp.MustCompile(`(?i)trailer`)}, false},
+		{"description matches", videoFilter{MatchDescription: regexp.MustCompile(`19\d\d`)}, false},
+		{"description doesn't match", videoFilter{MatchDescription: regexp.MustCompile(`20\d\d`)}, true},
+		{"description rejected", videoFilter{RejectDescription: regexp.MustCompile(`#sponsored`)}, true},
+		// A rejection wins over a match
+		{"matched and rejected", videoFilter{MatchTitle: regexp.MustCompile(`Live`), RejectTitle: regexp.MustCompile(`Wembley`)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := tt.filter.reject(video)
+			if got := reason != ""; got != tt.reject {
+				t.Errorf("reject = %q, want rejected %v", reason, tt.reject)
+			}
+			// Playlist listings check the title alone
+			if tt.filter.MatchDescription == nil && tt.filter.RejectDescription == nil {
+				if got := tt.filter.rejectTitle(video.Title) != ""; got != tt.reject {
+					t.Errorf("rejectTitle = %v, want %v", got, tt.reject)
+				}
+			}
+		})
+	}
+}
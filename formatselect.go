@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// Codec families as accepted by -video-codec and -audio-codec.
+var (
+	videoCodecs = []string{"h264", "vp9", "av1"}
+	audioCodecs = []string{"aac", "opus"}
+)
+
+// codecFamily maps the codecs parameter of a format's MIME type, e.g.
+// `video/mp4; codecs="avc1.4d401f"`, to a codec family.
+func codecFamily(mimeType string) string {
+	_, params, _ := strings.Cut(mimeType, "codecs=")
+	codec := strings.ToLower(strings.Trim(strings.TrimSpace(params), `"`))
+	switch {
+	case strings.HasPrefix(codec, "avc1"):
+		return "h264"
+	case strings.HasPrefix(codec, "vp9"), strings.HasPrefix(codec, "vp09"):
+		return "vp9"
+	case strings.HasPrefix(codec, "av01"):
+		return "av1"
+	case strings.HasPrefix(codec, "mp4a"):
+		return "aac"
+	case strings.HasPrefix(codec, "opus"):
+		return "opus"
+	}
+	return codec
+}
+
+// containerCodecs lists the codecs each output container can hold without
+// re-encoding, most preferred first. mkv accepts anything.
+var containerCodecs = map[string]struct{ video, audio []string }{
+	"mp4":  {video: []string{"h264", "av1", "vp9"}, audio: []string{"aac"}},
+	"webm": {video: []string{"vp9", "av1"}, audio: []string{"opus"}},
+	"mkv":  {},
+}
+
+// containerEncoders are used when a stream has to be re-encoded to fit.
+var containerEncoders = map[string]struct{ video, audio string }{
+	"mp4":  {video: "libx264", audio: "aac"},
+	"webm": {video: "libvpx-vp9", audio: "libopus"},
+}
+
+// mergeCodecArgs returns the ffmpeg codec arguments for merging sel,
+// copying streams the container can hold and re-encoding the rest.
+func mergeCodecArgs(sel formatSelection, remux bool) []string {
+	codecs, ok := containerCodecs[sel.container]
+	// Cropping, and cutting a clip on the exact frame, need a re-encode
+	if sel.crop != "" || (sel.clip != nil && !remux) {
+		encoder, audio := "libx264", "copy"
+		if enc, ok := containerEncoders[sel.container]; ok {
+			encoder = enc.video
+			if !slices.Contains(codecs.audio, codecFamily(sel.audio.MimeType)) {
+				audio = enc.audio
+			}
+		}
+		var args []string
+		if sel.crop != "" {
+			args = []string{"-vf", sel.crop}
+		}
+		return append(args, "-c:v", encoder, "-c:a", audio)
+	}
+	if remux || !ok || sel.container == "mkv" {
+		return []string{"-c", "copy"}
+	}
+	args := []string{"-c:v", "copy", "-c:a", "copy"}
+	if !slices.Contains(codecs.video, codecFamily(sel.video.MimeType)) {
+		args[1] = containerEncoders[sel.container].video
+	}
+	if !slices.Contains(codecs.audio, codecFamily(sel.audio.MimeType)) {
+		args[3] = containerEncoders[sel.container].audio
+	}
+	return args
+}
+
+// containerFor picks the container that can hold both codecs as-is,
+// preferring mp4, then webm, then mkv which accepts anything.
+func containerFor(videoCodec, audioCodec string) string {
+	switch {
+	case (videoCodec == "h264" || videoCodec == "av1") && audioCodec == "aac":
+		return "mp4"
+	case (videoCodec == "vp9" || videoCodec == "av1") && audioCodec == "opus":
+		return "webm"
+	default:
+		return "mkv"
+	}
+}
+
+// formatSelection is the pair of streams chosen for a download and the
+// container they will be merged into. With -f, one of them may be nil
+// for a single format saved as is.
+type formatSelection struct {
+	video     *youtube.Format
+	audio     *youtube.Format
+	container string
+	crop      string // -autocrop filter, applied by re-encoding the video
+	clip      *clipRange
+	tracks    []mediaTrack // more audio and subtitles, with -all-tracks
+}
+
+// selectFormats chooses the video and audio streams for a job's video.
+// Quality takes precedence over codec: the codec preferences choose
+// between formats of the best available quality.
+func (d *Downloader) selectFormats(job *Job, video *youtube.Video) (formatSelection, error) {
+	if spec := d.formatSpec(job); spec != nil {
+		return d.selectBySpec(job, video, spec)
+	}
+	available := capHeight(d.audioLanguageFormats(video), job.maxHeight)
+	if d.audioOnly(job) {
+		format := pickAudio(available.WithAudioChannels(), d.audioFormat(job))
+		if format == nil {
+			return formatSelection{}, fmt.Errorf("%w with audio for %s", ErrNoFormats, video.Title)
+		}
+		return formatSelection{audio: format, container: audioContainer(format, d.audioFormat(job))}, nil
+	}
+
+	qualities := []string{"hd720", "medium"}
+	if d.config.Quality != "" && d.config.Quality != "best" {
+		qualities = append([]string{d.config.Quality}, qualities...)
+	}
+	var videoFormats youtube.FormatList
+	for _, quality := range qualities {
+		for _, format := range available {
+			if format.Quality == quality && format.AudioChannels == 0 && strings.HasPrefix(format.MimeType, "video/") {
+				videoFormats = append(videoFormats, format)
+			}
+		}
+		if len(videoFormats) > 0 {
+			break
+		}
+	}
+
+	var audioFormats youtube.FormatList
+	for _, format := range available {
+		if strings.HasPrefix(format.MimeType, "audio/") {
+			audioFormats = append(audioFormats, format)
+		}
+	}
+
+	// An explicit container steers codec choice towards what it can hold
+	videoPrefs := []string{d.config.VideoCodec}
+	audioPrefs := []string{d.config.AudioCodec}
+	if codecs, ok := containerCodecs[d.config.Container]; ok {
+		videoPrefs = append(videoPrefs, codecs.video...)
+		audioPrefs = append(audioPrefs, codecs.audio...)
+	}
+	if d.config.Container == "" && d.config.RemuxTo == "" && !d.config.KeepSeparate && isGoMuxer(d.media()) {
+		// Without ffmpeg, only what fits MP4 as is can be merged
+		videoPrefs = append(videoPrefs, containerCodecs["mp4"].video...)
+		audioPrefs = append(audioPrefs, containerCodecs["mp4"].audio...)
+	}
+	audioPrefs = append(audioPrefs, "aac")
+
+	sel := formatSelection{
+		video: preferCodec(videoFormats, videoPrefs...),
+		audio: preferCodec(audioFormats, audioPrefs...),
+	}
+	if sel.video == nil || sel.audio == nil {
+		return formatSelection{}, fmt.Errorf("%w of video and audio for %s", ErrNoFormats, video.Title)
+	}
+
+	sel.container = containerFor(codecFamily(sel.video.MimeType), codecFamily(sel.audio.MimeType))
+	switch {
+	case d.config.RemuxTo != "":
+		sel.container = d.config.RemuxTo
+	case d.config.Container != "":
+		sel.container = d.config.Container
+	}
+	return sel, nil
+}
+
+func isGoMuxer(f FFmpeg) bool {
+	_, ok := f.(*goMuxer)
+	return ok
+}
+
+// streamExtension is the file extension for a format saved as it was
+// downloaded.
+func streamExtension(format *youtube.Format) string {
+	switch {
+	case strings.HasPrefix(format.MimeType, "audio/mp4"):
+		return "m4a"
+	case strings.HasSuffix(strings.Split(format.MimeType, ";")[0], "/webm"):
+		return "webm"
+	}
+	return "mp4"
+}
+
+// preferCodec returns the first format whose codec matches the first of
+// codecs that any format has, or the first format if none match.
+func preferCodec(formats youtube.FormatList, codecs ...string) *youtube.Format {
+	if len(formats) == 0 {
+		return nil
+	}
+	for _, codec := range codecs {
+		if codec == "" {
+			continue
+		}
+		for i := range formats {
+			if codecFamily(formats[i].MimeType) == codec {
+				return &formats[i]
+			}
+		}
+	}
+	return &formats[0]
+}
+
+func validateChoice(name, value string, allowed []string) error {
+	if value == "" {
+		return nil
+	}
+	for _, a := range allowed {
+		if value == a {
+			return nil
+		}
+	}
+	return fmt.Errorf("-%s must be one of %s", name, strings.Join(allowed, ", "))
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// formatSpec is a parsed -f selector such as
+// "bestvideo[height<=1080][vcodec^=avc1]+bestaudio/best". Alternatives
+// separated by "/" are tried in order; the first that every part of
+// matches is used.
+type formatSpec struct {
+	raw          string
+	alternatives [][]formatSelector // one selector, or video+audio
+}
+
+// formatSelector picks one format: the best or worst of a kind, an itag,
+// or the best format with an extension, among those passing filters.
+type formatSelector struct {
+	kind    string // best, worst, bestvideo, ..., itag, ext
+	value   string // the itag or extension
+	filters []formatFilter
+}
+
+// formatFilter is one [key op value] condition.
+type formatFilter struct {
+	key    string
+	op     string
+	value  string
+	number float64
+	// optional filters, written with "?" after the operator, also match
+	// formats that don't report the field
+	optional bool
+}
+
+var formatSelectorAliases = map[string]string{
+	"b": "best", "w": "worst",
+	"bv": "bestvideo", "wv": "worstvideo",
+	"ba": "bestaudio", "wa": "worstaudio",
+}
+
+var formatSelectorKinds = []string{"best", "worst", "bestvideo", "worstvideo", "bestaudio", "worstaudio"}
+
+// Fields filters can test. Bitrates are in kbit/s and asr in Hz.
+var (
+	numericFormatFields = []string{"height", "width", "fps", "tbr", "abr", "vbr", "asr", "audio_channels", "filesize"}
+	stringFormatFields  = []string{"ext", "vcodec", "acodec", "format_id", "language"}
+)
+
+// formatFilterOps, longest first so "<=" isn't read as "<".
+var formatFilterOps = []string{"<=", ">=", "!=", "^=", "$=", "*=", "<", ">", "="}
+
+func parseFormatSpec(s string) (*formatSpec, error) {
+	spec := &formatSpec{raw: s}
+	for _, alt := range splitOutsideBrackets(s, '/') {
+		parts := splitOutsideBrackets(alt, '+')
+		if len(parts) > 2 {
+			return nil, fmt.Errorf("-f %s: only a video and an audio format can be merged", s)
+		}
+		var selectors []formatSelector
+		for _, part := range parts {
+			sel, err := parseFormatSelector(strings.TrimSpace(part))
+			if err != nil {
+				return nil, fmt.Errorf("-f %s: %v", s, err)
+			}
+			selectors = append(selectors, sel)
+		}
+		spec.alternatives = append(spec.alternatives, selectors)
+	}
+	return spec, nil
+}
+
+// splitOutsideBrackets splits s at sep, except inside [filters].
+func splitOutsideBrackets(s string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func parseFormatSelector(s string) (formatSelector, error) {
+	base, rest, _ := strings.Cut(s, "[")
+	if rest != "" {
+		rest = "[" + rest
+	}
+	var sel formatSelector
+	switch base = strings.ToLower(strings.TrimSpace(base)); {
+	case base == "":
+		return sel, fmt.Errorf("empty format selector in %q", s)
+	case formatSelectorAliases[base] != "":
+		sel.kind = formatSelectorAliases[base]
+	case slices.Contains(formatSelectorKinds, base):
+		sel.kind = base
+	case strings.Trim(base, "0123456789") == "":
+		sel.kind, sel.value = "itag", base
+	case slices.Contains([]string{"mp4", "webm", "m4a"}, base):
+		sel.kind, sel.value = "ext", base
+	default:
+		return sel, fmt.Errorf("unknown format %q", base)
+	}
+
+	for rest != "" {
+		end := strings.IndexByte(rest, ']')
+		if rest[0] != '[' || end < 0 {
+			return sel, fmt.Errorf("malformed filter in %q", s)
+		}
+		filter, err := parseFormatFilter(rest[1:end])
+		if err != nil {
+			return sel, err
+		}
+		sel.filters = append(sel.filters, filter)
+		rest = rest[end+1:]
+	}
+	return sel, nil
+}
+
+func parseFormatFilter(s string) (formatFilter, error) {
+	var f formatFilter
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < 'a' || r > 'z') && r != '_' })
+	if i <= 0 {
+		return f, fmt.Errorf("malformed filter [%s]", s)
+	}
+	f.key = s[:i]
+	rest := strings.TrimSpace(s[i:])
+	for _, op := range formatFilterOps {
+		if strings.HasPrefix(rest, op) {
+			f.op = op
+			break
+		}
+	}
+	if f.op == "" {
+		return f, fmt.Errorf("missing operator in [%s]", s)
+	}
+	rest = rest[len(f.op):]
+	if strings.HasPrefix(rest, "?") {
+		f.optional = true
+		rest = rest[1:]
+	}
+	f.value = strings.TrimSpace(rest)
+	if f.value == "" {
+		return f, fmt.Errorf("missing value in [%s]", s)
+	}
+
+	switch {
+	case slices.Contains(numericFormatFields, f.key):
+		if strings.ContainsAny(f.op, "^$*") {
+			return f, fmt.Errorf("[%s]: %s only applies to text fields", s, f.op)
+		}
+		n, err := parseFormatNumber(f.key, f.value)
+		if err != nil {
+			return f, fmt.Errorf("[%s]: %v", s, err)
+		}
+		f.number = n
+	case slices.Contains(stringFormatFields, f.key):
+		if strings.ContainsAny(f.op, "<>") {
+			return f, fmt.Errorf("[%s]: %s only applies to numeric fields", s, f.op)
+		}
+	default:
+		return f, fmt.Errorf("unknown field %q in [%s]", f.key, s)
+	}
+	return f, nil
+}
+
+// parseFormatNumber accepts "720p" for heights and K, M and G suffixes
+// for file sizes.
+func parseFormatNumber(key, value string) (float64, error) {
+	scale := 1.0
+	switch {
+	case key == "height":
+		value = strings.TrimSuffix(strings.ToLower(value), "p")
+	case key == "filesize":
+		units := map[string]float64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30}
+		if u, ok := units[strings.ToUpper(value[len(value)-1:])]; ok {
+			scale, value = u, value[:len(value)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", value)
+	}
+	return n * scale, nil
+}
+
+// choose evaluates the spec against formats. A single format comes back
+// as video if it has a picture and as audio otherwise.
+func (spec *formatSpec) choose(formats youtube.FormatList) (video, audio *youtube.Format, err error) {
+	for _, alt := range spec.alternatives {
+		if len(alt) == 1 {
+			f := alt[0].pick(formats)
+			if f == nil {
+				continue
+			}
+			if hasVideo(f) {
+				return f, nil, nil
+			}
+			return nil, f, nil
+		}
+		v, a := alt[0].pick(formats), alt[1].pick(formats)
+		if v != nil && a != nil && hasVideo(v) && hasAudio(a) {
+			return v, a, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("%w match -f %s", ErrNoFormats, spec.raw)
+}
+
+func (s formatSelector) pick(formats youtube.FormatList) *youtube.Format {
+	var candidates []*youtube.Format
+	for i := range formats {
+		f := &formats[i]
+		if s.wants(f) && s.passes(f) {
+			candidates = append(candidates, f)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	slices.SortStableFunc(candidates, func(a, b *youtube.Format) int {
+		return slices.Compare(formatRank(a), formatRank(b))
+	})
+	if strings.HasPrefix(s.kind, "worst") {
+		return candidates[0]
+	}
+	return candidates[len(candidates)-1]
+}
+
+// wants reports whether f is of the selector's kind, before filtering.
+func (s formatSelector) wants(f *youtube.Format) bool {
+	switch s.kind {
+	case "best", "worst":
+		return hasVideo(f) && hasAudio(f)
+	case "bestvideo", "worstvideo":
+		return hasVideo(f) && !hasAudio(f)
+	case "bestaudio", "worstaudio":
+		return !hasVideo(f) && hasAudio(f)
+	case "itag":
+		return strconv.Itoa(f.ItagNo) == s.value
+	default:
+		return streamExtension(f) == s.value
+	}
+}
+
+func (s formatSelector) passes(f *youtube.Format) bool {
+	for _, filter := range s.filters {
+		if !filter.matches(f) {
+			return false
+		}
+	}
+	return true
+}
+
+func (filter formatFilter) matches(f *youtube.Format) bool {
+	if slices.Contains(numericFormatFields, filter.key) {
+		n := formatNumber(f, filter.key)
+		if n == 0 {
+			return filter.optional
+		}
+		switch filter.op {
+		case "<":
+			return n < filter.number
+		case "<=":
+			return n <= filter.number
+		case ">":
+			return n > filter.number
+		case ">=":
+			return n >= filter.number
+		case "!=":
+			return n != filter.number
+		default:
+			return n == filter.number
+		}
+	}
+
+	v := formatString(f, filter.key)
+	if v == "" {
+		return filter.optional
+	}
+	switch filter.op {
+	case "^=":
+		return strings.HasPrefix(v, filter.value)
+	case "$=":
+		return strings.HasSuffix(v, filter.value)
+	case "*=":
+		return strings.Contains(v, filter.value)
+	case "!=":
+		return v != filter.value
+	default:
+		return v == filter.value
+	}
+}
+
+// formatNumber returns a numeric field of f, or 0 if it is unknown.
+func formatNumber(f *youtube.Format, key string) float64 {
+	bitrate := f.AverageBitrate
+	if bitrate == 0 {
+		bitrate = f.Bitrate
+	}
+	switch key {
+	case "height":
+		return float64(f.Height)
+	case "width":
+		return float64(f.Width)
+	case "fps":
+		return float64(f.FPS)
+	case "tbr":
+		return float64(bitrate) / 1000
+	case "abr":
+		if hasVideo(f) {
+			return 0
+		}
+		return float64(bitrate) / 1000
+	case "vbr":
+		if hasAudio(f) {
+			return 0
+		}
+		return float64(bitrate) / 1000
+	case "asr":
+		n, _ := strconv.ParseFloat(f.AudioSampleRate, 64)
+		return n
+	case "audio_channels":
+		return float64(f.AudioChannels)
+	case "filesize":
+		return float64(f.ContentLength)
+	}
+	return 0
+}
+
+// formatString returns a text field of f. Codecs are "none" for a
+// stream f doesn't have.
+func formatString(f *youtube.Format, key string) string {
+	switch key {
+	case "ext":
+		return streamExtension(f)
+	case "format_id":
+		return strconv.Itoa(f.ItagNo)
+	case "language":
+		return audioLanguage(f)
+	}
+
+	_, params, _ := strings.Cut(f.MimeType, "codecs=")
+	var codecs []string
+	for _, c := range strings.Split(strings.Trim(strings.TrimSpace(params), `"`), ",") {
+		codecs = append(codecs, strings.TrimSpace(c))
+	}
+	// Muxed formats list the video codec first
+	vcodec, acodec := "none", "none"
+	switch {
+	case hasVideo(f) && hasAudio(f):
+		vcodec = codecs[0]
+		if len(codecs) > 1 {
+			acodec = codecs[1]
+		}
+	case hasVideo(f):
+		vcodec = codecs[0]
+	default:
+		acodec = codecs[0]
+	}
+	if key == "vcodec" {
+		return vcodec
+	}
+	return acodec
+}
+
+// formatRank orders formats from worst to best: by resolution, frame
+// rate and bitrate for video, by bitrate and sample rate for audio.
+func formatRank(f *youtube.Format) []float64 {
+	if hasVideo(f) {
+		return []float64{formatNumber(f, "height"), formatNumber(f, "fps"), formatNumber(f, "tbr")}
+	}
+	return []float64{formatNumber(f, "tbr"), formatNumber(f, "asr")}
+}
+
+func hasVideo(f *youtube.Format) bool {
+	return strings.HasPrefix(f.MimeType, "video/")
+}
+
+func hasAudio(f *youtube.Format) bool {
+	return f.AudioChannels > 0 || strings.HasPrefix(f.MimeType, "audio/")
+}
+
+// formatSpec returns the -f selector for job: the one its batch was
+// submitted with, if any, then its category's, then the configured one.
+func (d *Downloader) formatSpec(job *Job) *formatSpec {
+	if job.source.Format != nil {
+		return job.source.Format
+	}
+	if job.category != nil && job.category.format != nil {
+		return job.category.format
+	}
+	return d.config.Format
+}
+
+// selectBySpec chooses formats with -f, which replaces -quality and the
+// codec preferences.
+func (d *Downloader) selectBySpec(job *Job, video *youtube.Video, spec *formatSpec) (formatSelection, error) {
+	v, a, err := spec.choose(capHeight(d.audioLanguageFormats(video), job.maxHeight))
+	if err != nil {
+		return formatSelection{}, fmt.Errorf("%w for %s", err, video.Title)
+	}
+
+	if d.audioOnly(job) {
+		if a == nil {
+			if !hasAudio(v) {
+				return formatSelection{}, fmt.Errorf("%w: -f %s picked itag %d for %s, which has no audio to extract", ErrNoFormats, spec.raw, v.ItagNo, video.Title)
+			}
+			a = v
+		}
+		return formatSelection{audio: a, container: audioContainer(a, d.audioFormat(job))}, nil
+	}
+
+	sel := formatSelection{video: v, audio: a}
+	switch {
+	case v != nil && a != nil:
+		sel.container = containerFor(codecFamily(v.MimeType), codecFamily(a.MimeType))
+	case v != nil:
+		sel.container = streamExtension(v)
+	default:
+		sel.container = streamExtension(a)
+	}
+	switch {
+	case d.config.RemuxTo != "":
+		sel.container = d.config.RemuxTo
+	case d.config.Container != "":
+		sel.container = d.config.Container
+	}
+	return sel, nil
+}
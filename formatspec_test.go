@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFormatSpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    [][]formatSelector
+		wantErr bool
+	}{
+		{spec: "best", want: [][]formatSelector{{{kind: "best"}}}},
+		{spec: "bv+ba", want: [][]formatSelector{{{kind: "bestvideo"}, {kind: "bestaudio"}}}},
+		{spec: "137+140/18", want: [][]formatSelector{
+			{{kind: "itag", value: "137"}, {kind: "itag", value: "140"}},
+			{{kind: "itag", value: "18"}},
+		}},
+		{spec: "WebM", want: [][]formatSelector{{{kind: "ext", value: "webm"}}}},
+		{spec: "bv[height<=720p][vcodec^=avc1]+ba", want: [][]formatSelector{{
+			{kind: "bestvideo", filters: []formatFilter{
+				{key: "height", op: "<=", value: "720p", number: 720},
+				{key: "vcodec", op: "^=", value: "avc1"},
+			}},
+			{kind: "bestaudio"},
+		}}},
+		{spec: "b[filesize<?50M]", want: [][]formatSelector{{
+			{kind: "best", filters: []formatFilter{{key: "filesize", op: "<", value: "50M", number: 50 << 20, optional: true}}},
+		}}},
+		// The "/" and "+" inside filters don't split the spec
+		{spec: "b[format_id=a/b]", want: [][]formatSelector{{
+			{kind: "best", filters: []formatFilter{{key: "format_id", op: "=", value: "a/b"}}},
+		}}},
+		{spec: "bv+ba+b", wantErr: true},
+		{spec: "", wantErr: true},
+		{spec: "bv+", wantErr: true},
+		{spec: "flac", wantErr: true},
+		{spec: "b[height]", wantErr: true},
+		{spec: "b[height<]", wantErr: true},
+		{spec: "b[height<=tall]", wantErr: true},
+		{spec: "b[height^=7]", wantErr: true},
+		{spec: "b[ext>mp4]", wantErr: true},
+		{spec: "b[color=red]", wantErr: true},
+		{spec: "b[height<=720", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseFormatSpec(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseFormatSpec(%q) = %+v, want an error", tt.spec, got.alternatives)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseFormatSpec(%q): %v", tt.spec, err)
+			}
+			if !reflect.DeepEqual(got.alternatives, tt.want) {
+				t.Errorf("parseFormatSpec(%q) = %+v, want %+v", tt.spec, got.alternatives, tt.want)
+			}
+		})
+	}
+}
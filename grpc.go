@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"yt-dl-go/ytdlpb"
+)
+
+// grpcServer serves the gRPC form of the HTTP API, see ytdlpb/ytdl.proto.
+type grpcServer struct {
+	ytdlpb.UnimplementedDownloaderServer
+	s *server
+}
+
+func newGRPCServer(s *server) *grpc.Server {
+	var opts []grpc.ServerOption
+	if s.users != nil {
+		opts = append(opts, grpc.UnaryInterceptor(s.authenticateUnary), grpc.StreamInterceptor(s.authenticateStream))
+	}
+	g := grpc.NewServer(opts...)
+	ytdlpb.RegisterDownloaderServer(g, &grpcServer{s: s})
+	return g
+}
+
+// grpcUser finds the user of a call from its "authorization: Bearer"
+// metadata, as -api-keys requires.
+func (s *server) grpcUser(ctx context.Context) (*apiUser, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if token, ok := strings.CutPrefix(value, "Bearer "); ok {
+			if u := s.lookupToken(strings.TrimSpace(token)); u != nil {
+				return u, nil
+			}
+		}
+	}
+	return nil, status.Error(codes.Unauthenticated, "missing or unknown API token")
+}
+
+func (s *server) authenticateUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	u, err := s.grpcUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(withAPIUser(ctx, u), req)
+}
+
+func (s *server) authenticateStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	u, err := s.grpcUser(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &userStream{ServerStream: stream, ctx: withAPIUser(stream.Context(), u)})
+}
+
+// userStream carries the authenticated user in its context.
+type userStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *userStream) Context() context.Context { return s.ctx }
+
+func newJobMessage(snap JobSnapshot) *ytdlpb.Job {
+	m := &ytdlpb.Job{
+		Id:           int32(snap.ID),
+		Url:          snap.URL,
+		VideoId:      snap.VideoID,
+		Title:        snap.Title,
+		OutputPath:   snap.OutputPath,
+		Status:       string(snap.Status),
+		Phase:        string(snap.Phase),
+		Paused:       snap.Paused,
+		Downloaded:   snap.Downloaded,
+		Total:        snap.Total,
+		Speed:        snap.Speed,
+		Elapsed:      snap.Elapsed.Seconds(),
+		Processed:    snap.Processed.Seconds(),
+		ProcessTotal: snap.ProcessTotal.Seconds(),
+	}
+	if snap.Err != nil {
+		m.Error = snap.Err.Error()
+	}
+	return m
+}
+
+func (g *grpcServer) EnqueueDownload(ctx context.Context, req *ytdlpb.EnqueueDownloadRequest) (*ytdlpb.EnqueueDownloadResponse, error) {
+	if req.Url == "" {
+		return nil, status.Error(codes.InvalidArgument, "url is required")
+	}
+	kind := map[ytdlpb.Kind]string{
+		ytdlpb.Kind_KIND_VIDEO:    "video",
+		ytdlpb.Kind_KIND_PLAYLIST: "playlist",
+		ytdlpb.Kind_KIND_CHANNEL:  "channel",
+	}[req.Kind]
+	if kind == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown kind %v", req.Kind)
+	}
+	switch err := g.s.enqueue(apiUserFrom(ctx), submitRequest{URL: req.Url, Kind: kind, Format: req.Format}); {
+	case errors.Is(err, errStopping):
+		return nil, status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, errQuota):
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &ytdlpb.EnqueueDownloadResponse{}, nil
+}
+
+func (g *grpcServer) ListJobs(ctx context.Context, req *ytdlpb.ListJobsRequest) (*ytdlpb.ListJobsResponse, error) {
+	resp := &ytdlpb.ListJobsResponse{}
+	for _, job := range g.s.jobs(apiUserFrom(ctx)) {
+		resp.Jobs = append(resp.Jobs, newJobMessage(job.Snapshot()))
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) Cancel(ctx context.Context, req *ytdlpb.CancelRequest) (*ytdlpb.Job, error) {
+	job := g.job(ctx, int(req.JobId))
+	if job == nil {
+		return nil, status.Errorf(codes.NotFound, "no such job %d", req.JobId)
+	}
+	job.Cancel()
+	return newJobMessage(job.Snapshot()), nil
+}
+
+func (g *grpcServer) job(ctx context.Context, id int) *Job {
+	for _, job := range g.s.jobs(apiUserFrom(ctx)) {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// GetProgress sends each followed job when first seen and then whenever
+// it changes.
+func (g *grpcServer) GetProgress(req *ytdlpb.GetProgressRequest, stream grpc.ServerStreamingServer[ytdlpb.Job]) error {
+	var want map[int]bool
+	if len(req.JobIds) > 0 {
+		want = make(map[int]bool)
+		for _, id := range req.JobIds {
+			if g.job(stream.Context(), int(id)) == nil {
+				return status.Errorf(codes.NotFound, "no such job %d", id)
+			}
+			want[int(id)] = true
+		}
+	}
+
+	sent := make(map[int]*ytdlpb.Job)
+	return g.s.follow(stream.Context(), apiUserFrom(stream.Context()), want, func(snap JobSnapshot) error {
+		m := newJobMessage(snap)
+		if prev, ok := sent[snap.ID]; ok && proto.Equal(prev, m) {
+			return nil
+		}
+		sent[snap.ID] = m
+		return stream.Send(m)
+	})
+}
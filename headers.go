@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// geoBlocks holds an address block in use in each country -geo-bypass-country
+// accepts. YouTube takes X-Forwarded-For into account for some geo
+// checks, so claiming an address from one of these can get past them.
+var geoBlocks = map[string]string{
+	"AU": "1.128.0.0/11",
+	"BR": "179.128.0.0/10",
+	"CA": "99.224.0.0/11",
+	"DE": "53.0.0.0/8",
+	"ES": "88.0.0.0/11",
+	"FR": "90.0.0.0/8",
+	"GB": "25.0.0.0/8",
+	"IN": "117.192.0.0/10",
+	"IT": "79.0.0.0/10",
+	"JP": "133.0.0.0/8",
+	"KR": "175.192.0.0/10",
+	"MX": "187.192.0.0/11",
+	"NL": "145.0.0.0/8",
+	"PL": "83.0.0.0/11",
+	"SE": "78.64.0.0/12",
+	"US": "6.0.0.0/8",
+}
+
+// geoBypassAddress picks a random address in country's block.
+func geoBypassAddress(country string) (string, error) {
+	block, ok := geoBlocks[strings.ToUpper(country)]
+	if !ok {
+		countries := make([]string, 0, len(geoBlocks))
+		for c := range geoBlocks {
+			countries = append(countries, c)
+		}
+		sort.Strings(countries)
+		return "", fmt.Errorf("unsupported country %q (supported: %s)", country, strings.Join(countries, ", "))
+	}
+	_, network, err := net.ParseCIDR(block)
+	if err != nil {
+		return "", err
+	}
+	ip := make(net.IP, len(network.IP))
+	for i := range ip {
+		ip[i] = network.IP[i] | ^network.Mask[i]&byte(rand.Intn(256))
+	}
+	return ip.String(), nil
+}
+
+// parseHeaders parses -add-header values of the form "Name: value".
+func parseHeaders(specs []string) (http.Header, error) {
+	header := make(http.Header)
+	for _, spec := range specs {
+		name, value, ok := strings.Cut(spec, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid header %q (want Name:value)", spec)
+		}
+		header.Add(name, strings.TrimSpace(value))
+	}
+	return header, nil
+}
+
+// headerTransport sets -user-agent and -add-header on every request. It
+// sits above the -player-client transport, whose clients need their own
+// User-Agent to be believed.
+type headerTransport struct {
+	base   http.RoundTripper
+	header http.Header
+}
+
+func newHeaderTransport(base http.RoundTripper, userAgent string, extra http.Header, geoAddress string) *headerTransport {
+	header := make(http.Header)
+	if userAgent != "" {
+		header.Set("User-Agent", userAgent)
+	}
+	if geoAddress != "" {
+		header.Set("X-Forwarded-For", geoAddress)
+	}
+	for name, values := range extra {
+		header[textproto.CanonicalMIMEHeaderKey(name)] = values
+	}
+	return &headerTransport{base: base, header: header}
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, values := range t.header {
+		req.Header[name] = values
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// The history is an SQLite log of every completed download, kept apart
+// from the library so that it can live on a share and be written by
+// several machines.
+
+const historySchema = `CREATE TABLE IF NOT EXISTS history (
+	id INTEGER PRIMARY KEY,
+	video_id TEXT NOT NULL,
+	title TEXT NOT NULL,
+	channel TEXT NOT NULL,
+	format TEXT NOT NULL,
+	path TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	sha256 TEXT NOT NULL,
+	host TEXT NOT NULL,
+	downloaded TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS history_video_id ON history (video_id);`
+
+type history struct {
+	db   *sql.DB
+	host string
+}
+
+// HistoryEntry is one recorded download.
+type HistoryEntry struct {
+	VideoID    string `json:"video_id"`
+	Title      string `json:"title"`
+	Channel    string `json:"channel"`
+	Format     string `json:"format"`
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	SHA256     string `json:"sha256"`
+	Host       string `json:"host"`
+	Downloaded string `json:"downloaded"`
+}
+
+func defaultHistoryPath() string {
+	dir, err := ytdlConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "history.db")
+}
+
+// openHistory returns nil when path is empty, which disables recording.
+func openHistory(path string) (*history, error) {
+	if path == "" {
+		return nil, nil
+	}
+	// Other machines may be writing to a shared file, so wait out their
+	// locks for longer than the library does
+	db, err := openSQLite(path, 30*time.Second, historySchema)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	return &history{db: db, host: host}, nil
+}
+
+// Close closes the database.
+func (h *history) Close() error {
+	return h.db.Close()
+}
+
+// Add records a completed download.
+func (h *history) Add(ctx context.Context, e HistoryEntry) error {
+	_, err := h.db.ExecContext(ctx, `INSERT INTO history (video_id, title, channel, format, path, size, sha256, host, downloaded)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.VideoID, e.Title, e.Channel, e.Format, e.Path, e.Size, e.SHA256, h.host, e.Downloaded)
+	return err
+}
+
+const historyColumns = `video_id, title, channel, format, path, size, sha256, host, downloaded`
+
+// Find returns the downloads of a video, newest first.
+func (h *history) Find(ctx context.Context, videoID string) ([]HistoryEntry, error) {
+	return h.query(ctx, `SELECT `+historyColumns+` FROM history WHERE video_id = ? ORDER BY downloaded DESC`, videoID)
+}
+
+// Entries returns every recorded download, newest first.
+func (h *history) Entries(ctx context.Context) ([]HistoryEntry, error) {
+	return h.query(ctx, `SELECT `+historyColumns+` FROM history ORDER BY downloaded DESC`)
+}
+
+// Search returns the newest downloads whose title, channel, video ID or
+// path contain every term, or the newest of all with no terms.
+func (h *history) Search(ctx context.Context, terms []string, limit int) ([]HistoryEntry, error) {
+	where := "1"
+	var args []any
+	for _, term := range terms {
+		where += ` AND (title LIKE ? ESCAPE '\' OR channel LIKE ? ESCAPE '\'
+	OR video_id LIKE ? ESCAPE '\' OR path LIKE ? ESCAPE '\')`
+		like := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(term) + "%"
+		args = append(args, like, like, like, like)
+	}
+	args = append(args, limit)
+	return h.query(ctx, `SELECT `+historyColumns+` FROM history WHERE `+where+` ORDER BY downloaded DESC LIMIT ?`, args...)
+}
+
+// query runs a query selecting historyColumns.
+func (h *history) query(ctx context.Context, query string, args ...any) ([]HistoryEntry, error) {
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []HistoryEntry{}
+	for rows.Next() {
+		var e HistoryEntry
+		if err := rows.Scan(&e.VideoID, &e.Title, &e.Channel, &e.Format, &e.Path, &e.Size, &e.SHA256, &e.Host, &e.Downloaded); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// formatIDs describes the formats a download was made from the way -f
+// takes them, e.g. "136+140".
+func formatIDs(video, audio *youtube.Format) string {
+	var ids []string
+	for _, f := range []*youtube.Format{video, audio} {
+		if f != nil {
+			ids = append(ids, strconv.Itoa(f.ItagNo))
+		}
+	}
+	return strings.Join(ids, "+")
+}
+
+// recordHistory logs a finished download. size and sum are of the local
+// file, taken before it was moved to -dest. Failures are logged; the
+// download itself has already succeeded.
+func (d *Downloader) recordHistory(ctx context.Context, job *Job, video *youtube.Video, location string, size int64, sum string) {
+	if d.history == nil {
+		return
+	}
+	if !strings.Contains(location, "://") {
+		if abs, err := filepath.Abs(location); err == nil {
+			location = abs
+		}
+	}
+	entry := HistoryEntry{
+		VideoID:    video.ID,
+		Title:      video.Title,
+		Channel:    video.Author,
+		Format:     job.Snapshot().Format,
+		Path:       location,
+		Size:       size,
+		SHA256:     sum,
+		Downloaded: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := d.history.Add(ctx, entry); err != nil {
+		d.logger.Printf("Failed to record %s in history: %v", video.Title, err)
+	}
+}
+
+func setupHistory(flags *flag.FlagSet) func([]string) error {
+	historyPath := flags.String("history", defaultHistoryPath(), "History database to query")
+	limit := flags.Int("max-results", 50, "Maximum number of results")
+	has := flags.Bool("has", false, "Check whether each argument, a video ID or URL, was downloaded; exits 1 if any wasn't")
+	asJSON := flags.Bool("json", false, "Print results as JSON")
+
+	return func(args []string) error {
+		if *has && len(args) == 0 {
+			flags.Usage()
+			return &exitError{exitTotalFailure, fmt.Errorf("-has needs video IDs or URLs")}
+		}
+		h, err := openHistory(*historyPath)
+		if err != nil {
+			return fmt.Errorf("failed to open history: %v", err)
+		}
+		if h == nil {
+			return fmt.Errorf("no history configured")
+		}
+		defer h.Close()
+		ctx := context.Background()
+
+		entries := []HistoryEntry{}
+		missing := 0
+		if *has {
+			for _, arg := range args {
+				id, err := youtube.ExtractVideoID(arg)
+				if err != nil {
+					return fmt.Errorf("invalid video %q: %v", arg, err)
+				}
+				found, err := h.Find(ctx, id)
+				if err != nil {
+					return fmt.Errorf("history lookup failed: %v", err)
+				}
+				if len(found) == 0 {
+					missing++
+					if !*asJSON {
+						fmt.Printf("%s\tnot downloaded\n", id)
+					}
+				}
+				entries = append(entries, found...)
+			}
+		} else if entries, err = h.Search(ctx, args, *limit); err != nil {
+			return fmt.Errorf("history search failed: %v", err)
+		}
+
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(entries); err != nil {
+				return err
+			}
+		} else if len(entries) == 0 && !*has {
+			fmt.Println("No downloads recorded.")
+		} else {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			for _, e := range entries {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.VideoID, e.Downloaded, e.Host, e.Format, formatBytes(e.Size), e.Title, e.Path)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+		if missing > 0 {
+			return &exitError{exitTotalFailure, fmt.Errorf("%d of %d videos not downloaded", missing, len(args))}
+		}
+		return nil
+	}
+}
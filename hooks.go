@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// Hooks are optional callbacks run around each download. path is the
+// file the download will produce (BeforeDownload) or has produced
+// (AfterDownload). An error from BeforeDownload aborts that download;
+// errors from AfterDownload are logged and leave the file in place.
+type Hooks struct {
+	BeforeDownload func(ctx context.Context, video *youtube.Video, path string) error
+	AfterDownload  func(ctx context.Context, video *youtube.Video, path string) error
+}
+
+// execHook returns a hook that runs command through the shell, with every
+// {} replaced by the quoted file path. Video details are passed in the
+// environment as YTDL_VIDEO_ID, YTDL_TITLE and YTDL_CHANNEL. The
+// command's output goes to stdout, unless that carries the download.
+func execHook(command string, stdout io.Writer) func(context.Context, *youtube.Video, string) error {
+	return func(ctx context.Context, video *youtube.Video, path string) error {
+		line := strings.ReplaceAll(command, "{}", shellQuote(path))
+
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", line)
+		} else {
+			cmd = exec.CommandContext(ctx, "sh", "-c", line)
+		}
+		cmd.Stdout = stdout
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(),
+			"YTDL_VIDEO_ID="+video.ID,
+			"YTDL_TITLE="+video.Title,
+			"YTDL_CHANNEL="+video.Author,
+		)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%q failed: %v", line, err)
+		}
+		return nil
+	}
+}
+
+func shellQuote(s string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func (d *Downloader) beforeDownload(ctx context.Context, video *youtube.Video, path string) error {
+	if d.config.Hooks.BeforeDownload == nil {
+		return nil
+	}
+	if err := d.config.Hooks.BeforeDownload(ctx, video, path); err != nil {
+		return fmt.Errorf("before-download hook: %v", err)
+	}
+	return nil
+}
+
+func (d *Downloader) afterDownload(ctx context.Context, video *youtube.Video, path string) {
+	if d.config.Hooks.AfterDownload == nil {
+		return
+	}
+	if err := d.config.Hooks.AfterDownload(ctx, video, path); err != nil {
+		d.logger.Printf("After-download hook for %s: %v", video.Title, err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// hwAccel is a -hwaccel method: the hardware encoders replacing the
+// software ones, and what ffmpeg needs to feed them.
+type hwAccel struct {
+	encoders map[string]string
+	args     []string // global options, e.g. the device to open
+	filter   string   // appended to the video filter chain
+}
+
+// vaapiDevice is the render node VA-API encodes on.
+const vaapiDevice = "/dev/dri/renderD128"
+
+// hwAccels are the -hwaccel methods. Software encoders a method has no
+// replacement for, such as VP9 on NVENC, keep encoding in software.
+// Audio is always encoded in software.
+var hwAccels = map[string]hwAccel{
+	"nvenc":        {encoders: map[string]string{"libx264": "h264_nvenc"}},
+	"qsv":          {encoders: map[string]string{"libx264": "h264_qsv", "libvpx-vp9": "vp9_qsv"}},
+	"videotoolbox": {encoders: map[string]string{"libx264": "h264_videotoolbox"}},
+	"vaapi": {
+		encoders: map[string]string{"libx264": "h264_vaapi", "libvpx-vp9": "vp9_vaapi"},
+		args:     []string{"-vaapi_device", vaapiDevice},
+		// Frames have to be uploaded to the GPU in a format it encodes
+		filter: "format=nv12,hwupload",
+	},
+}
+
+var hwAccelNames = []string{"nvenc", "qsv", "videotoolbox", "vaapi"}
+
+// hwEncodeArgs rewrites codec arguments from mergeCodecArgs to encode
+// video with accel, returning the hardware encoder used, or nil if the
+// arguments don't re-encode video with anything accel replaces.
+func hwEncodeArgs(args []string, accel hwAccel) ([]string, string) {
+	out := slices.Clone(args)
+	encoder := ""
+	filterAt := -1
+	for i := 0; i+1 < len(out); i++ {
+		switch out[i] {
+		case "-c:v":
+			if enc, ok := accel.encoders[out[i+1]]; ok {
+				out[i+1] = enc
+				encoder = enc
+			}
+		case "-vf":
+			filterAt = i + 1
+		}
+	}
+	if encoder == "" {
+		return nil, ""
+	}
+	if accel.filter != "" {
+		if filterAt >= 0 {
+			out[filterAt] += "," + accel.filter
+		} else {
+			out = append(out, "-vf", accel.filter)
+		}
+	}
+	return append(slices.Clone(accel.args), out...), encoder
+}
+
+// hwCodecArgs returns codecArgs switched to -hwaccel encoding, or nil to
+// stay in software: -hwaccel isn't set, nothing is re-encoded, or the
+// encoder failed its probe.
+func (d *Downloader) hwCodecArgs(codecArgs []string) []string {
+	accel, ok := hwAccels[d.config.HWAccel]
+	if !ok {
+		return nil
+	}
+	args, encoder := hwEncodeArgs(codecArgs, accel)
+	if args == nil {
+		return nil
+	}
+	f, ok := d.media().(*execFFmpeg)
+	if !ok || !d.hwEncoderWorks(f, encoder, accel) {
+		return nil
+	}
+	return args
+}
+
+// hwEncoderWorks encodes a frame of black with encoder the first time it
+// is asked about, since ffmpeg lists encoders whose hardware or driver
+// is missing.
+func (d *Downloader) hwEncoderWorks(f *execFFmpeg, encoder string, accel hwAccel) bool {
+	d.hwMu.Lock()
+	defer d.hwMu.Unlock()
+	if ok, probed := d.hwProbed[encoder]; probed {
+		return ok
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	args := append(slices.Clone(accel.args), "-f", "lavfi", "-i", "color=c=black:s=256x256:d=0.1", "-frames:v", "1")
+	if accel.filter != "" {
+		args = append(args, "-vf", accel.filter)
+	}
+	args = append(args, "-c:v", encoder)
+	err := f.run(ctx, args, mediaOutput{path: "-", format: "null"})
+	if err != nil {
+		d.logger.Printf("Hardware encoder %s unavailable, encoding in software: %v", encoder, err)
+	} else {
+		d.logger.Printf("Encoding video with %s", encoder)
+	}
+	if d.hwProbed == nil {
+		d.hwProbed = make(map[string]bool)
+	}
+	d.hwProbed[encoder] = err == nil
+	return err == nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// fetchVideo loads one video's metadata for the info and formats commands.
+func (o *clientOptions) fetchVideo(url string) (*youtube.Video, error) {
+	downloader, closeFn, err := o.newDownloader(Config{MaxConcurrent: 1, MetadataConcurrent: 1, PostProcessConcurrent: 1})
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+
+	video, err := downloader.client.GetVideoContext(context.Background(), url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get video %s: %w", url, classifyVideoError(err))
+	}
+	return video, nil
+}
+
+func setupInfo(flags *flag.FlagSet) func([]string) error {
+	opts := registerClientFlags(flags)
+	asJSON := flags.Bool("json", false, "Print the metadata as info.json")
+
+	return func(args []string) error {
+		if len(args) != 1 {
+			flags.Usage()
+			return &exitError{exitTotalFailure, fmt.Errorf("expected one video")}
+		}
+		video, err := opts.fetchVideo(args[0])
+		if err != nil {
+			return err
+		}
+
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(newInfoJSON(video, ""))
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "ID:\t%s\n", video.ID)
+		fmt.Fprintf(w, "Title:\t%s\n", video.Title)
+		fmt.Fprintf(w, "Channel:\t%s (%s)\n", video.Author, video.ChannelID)
+		fmt.Fprintf(w, "Duration:\t%s\n", formatDuration(video.Duration))
+		fmt.Fprintf(w, "Views:\t%d\n", video.Views)
+		if !video.PublishDate.IsZero() {
+			fmt.Fprintf(w, "Published:\t%s\n", video.PublishDate.Format("2006-01-02"))
+		}
+		fmt.Fprintf(w, "Formats:\t%d\n", len(video.Formats))
+		w.Flush()
+		if video.Description != "" {
+			fmt.Printf("\n%s\n", video.Description)
+		}
+		return nil
+	}
+}
+
+func setupFormats(flags *flag.FlagSet) func([]string) error {
+	opts := registerClientFlags(flags)
+
+	return func(args []string) error {
+		if len(args) != 1 {
+			flags.Usage()
+			return &exitError{exitTotalFailure, fmt.Errorf("expected one video")}
+		}
+		video, err := opts.fetchVideo(args[0])
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ITAG\tTYPE\tQUALITY\tRESOLUTION\tFPS\tBITRATE\tSIZE\tCHANNELS")
+		for _, f := range video.Formats {
+			mime, _, _ := strings.Cut(f.MimeType, ";")
+			quality := f.QualityLabel
+			if quality == "" {
+				quality = f.Quality
+			}
+			resolution := "audio only"
+			if f.Width > 0 {
+				resolution = fmt.Sprintf("%dx%d", f.Width, f.Height)
+			}
+			size := "-"
+			if f.ContentLength > 0 {
+				size = formatBytes(f.ContentLength)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%s\t%s\t%d\n",
+				f.ItagNo, mime, quality, resolution, f.FPS, formatBitrate(f.Bitrate), size, f.AudioChannels)
+		}
+		return w.Flush()
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// InfoJSON is the sidecar written next to each download as <name>.info.json
+type InfoJSON struct {
+	ID          string  `json:"id"`
+	Title       string  `json:"title"`
+	Channel     string  `json:"channel"`
+	ChannelID   string  `json:"channel_id"`
+	Duration    float64 `json:"duration"`
+	UploadDate  string  `json:"upload_date,omitempty"`
+	ViewCount   int     `json:"view_count"`
+	Description string  `json:"description"`
+	Filename    string  `json:"filename"`
+
+	// Only known when fetched for a download
+	License         string `json:"license,omitempty"`
+	CreativeCommons bool   `json:"creative_commons,omitempty"`
+	Category        string `json:"category,omitempty"`
+	Attribution     string `json:"attribution,omitempty"`
+	ChannelURL      string `json:"channel_url,omitempty"`
+}
+
+func infoJSONPath(mediaPath string) string {
+	return strings.TrimSuffix(mediaPath, filepath.Ext(mediaPath)) + ".info.json"
+}
+
+func newInfoJSON(video *youtube.Video, mediaPath string) InfoJSON {
+	info := InfoJSON{
+		ID:          video.ID,
+		Title:       video.Title,
+		Channel:     video.Author,
+		ChannelID:   video.ChannelID,
+		Duration:    video.Duration.Seconds(),
+		ViewCount:   video.Views,
+		Description: video.Description,
+		Filename:    filepath.Base(mediaPath),
+	}
+	if !video.PublishDate.IsZero() {
+		info.UploadDate = video.PublishDate.Format(compactDateLayout)
+	}
+	return info
+}
+
+func (info *InfoJSON) setRights(video *youtube.Video, rights videoRights) {
+	info.License = rights.License
+	info.CreativeCommons = rights.CreativeCommons
+	info.Category = rights.Category
+	info.Attribution = attribution(video, rights)
+	if video.ChannelID != "" {
+		info.ChannelURL = "https://www.youtube.com/channel/" + video.ChannelID
+	}
+}
+
+func writeInfoJSON(info InfoJSON, mediaPath string) error {
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(infoJSONPath(mediaPath), data, 0644)
+}
+
+func readInfoJSON(mediaPath string) (*InfoJSON, error) {
+	data, err := os.ReadFile(infoJSONPath(mediaPath))
+	if err != nil {
+		return nil, err
+	}
+	var info InfoJSON
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
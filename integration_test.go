@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+
+	"yt-dl-go/internal/fakeyt"
+)
+
+// stubFFmpeg stands in for ffmpeg, which the tests can't count on: a merge
+// writes the video input followed by the audio input, and every merge is
+// recorded.
+type stubFFmpeg struct {
+	mu     sync.Mutex
+	merges []mergeSpec
+}
+
+func (s *stubFFmpeg) Merge(ctx context.Context, m mergeSpec, out mediaOutput) error {
+	s.mu.Lock()
+	s.merges = append(s.merges, m)
+	s.mu.Unlock()
+	return writeStub(out, m.videoPath, m.audioPath)
+}
+
+func (s *stubFFmpeg) Convert(ctx context.Context, inputPath string, clip *clipRange, codecArgs []string, audioFilter string, tags []string, out mediaOutput) error {
+	return writeStub(out, inputPath)
+}
+
+func (s *stubFFmpeg) MeasureLoudness(ctx context.Context, inputPath string, clip *clipRange, target float64) (*loudnessStats, error) {
+	return nil, errors.New("not supported by the stub")
+}
+
+func (s *stubFFmpeg) Remux(ctx context.Context, input string, limit time.Duration, out mediaOutput) error {
+	return writeStub(out, input)
+}
+
+func (s *stubFFmpeg) DetectSilence(ctx context.Context, inputPath string, minLength time.Duration) ([]silence, error) {
+	return nil, nil
+}
+
+func (s *stubFFmpeg) Extract(ctx context.Context, inputPath string, clip *clipRange, tags []string, out mediaOutput) error {
+	return writeStub(out, inputPath)
+}
+
+func (s *stubFFmpeg) String() string { return "stub" }
+
+func writeStub(out mediaOutput, inputs ...string) error {
+	var data []byte
+	for _, input := range inputs {
+		b, err := os.ReadFile(input)
+		if err != nil {
+			return err
+		}
+		data = append(data, b...)
+	}
+	if out.w != nil {
+		_, err := out.w.Write(data)
+		return err
+	}
+	return os.WriteFile(out.path, data, 0644)
+}
+
+// newTestDownloader returns a Downloader configured by the download flags
+// in args, fetching from a fakeyt server into a temporary directory.
+func newTestDownloader(t *testing.T, args ...string) (*Downloader, *fakeyt.Server, *stubFFmpeg) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+
+	flags := flag.NewFlagSet("download", flag.ContinueOnError)
+	opts := registerDownloadFlags(flags)
+	base := []string{"-output", t.TempDir(), "-no-cache", "-library", "", "-history", ""}
+	if err := flags.Parse(append(base, args...)); err != nil {
+		t.Fatal(err)
+	}
+	config, err := opts.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDownloader(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.logger.SetOutput(io.Discard)
+
+	fake := fakeyt.New()
+	t.Cleanup(fake.Close)
+	d.setClient(fake.Provider())
+	stub := &stubFFmpeg{}
+	d.ffmpeg = stub
+	return d, fake, stub
+}
+
+// streamBytes fetches the stream of itag that fake serves for id.
+func streamBytes(t *testing.T, fake *fakeyt.Server, id string, itag int) []byte {
+	t.Helper()
+	video, err := fake.Provider().GetVideoContext(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := slices.IndexFunc(video.Formats, func(f youtube.Format) bool { return f.ItagNo == itag })
+	if i < 0 {
+		t.Fatalf("%s has no itag %d", id, itag)
+	}
+	resp, err := http.Get(video.Formats[i].URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDownloadSelectsAndMerges(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		file      string
+		video     int // 0 for none
+		audio     int
+		wantMerge bool
+	}{
+		{"best", nil, "Fake Video 1.mp4", fakeyt.ItagVideo, fakeyt.ItagAudio, true},
+		{"webm", []string{"-f", "247+251"}, "Fake Video 1.webm", fakeyt.ItagVideoWebM, fakeyt.ItagAudioWebM, true},
+		{"muxed", []string{"-f", "18"}, "Fake Video 1.mp4", fakeyt.ItagMuxed, 0, false},
+		{"audio format", []string{"-f", "140"}, "Fake Video 1.m4a", 0, fakeyt.ItagAudio, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, fake, stub := newTestDownloader(t, tt.args...)
+			if err := d.ProcessVideos([]string{"fakevideo01"}); err != nil {
+				t.Fatal(err)
+			}
+
+			var want []byte
+			if tt.video != 0 {
+				want = append(want, streamBytes(t, fake, "fakevideo01", tt.video)...)
+			}
+			if tt.audio != 0 {
+				want = append(want, streamBytes(t, fake, "fakevideo01", tt.audio)...)
+			}
+			got, err := os.ReadFile(filepath.Join(d.config.OutputDir, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s holds %d bytes, want the %d of the selected streams", tt.file, len(got), len(want))
+			}
+
+			if !tt.wantMerge {
+				if len(stub.merges) != 0 {
+					t.Errorf("got %d merges, want none", len(stub.merges))
+				}
+				return
+			}
+			if len(stub.merges) != 1 {
+				t.Fatalf("got %d merges, want 1", len(stub.merges))
+			}
+			sel := stub.merges[0].sel
+			if sel.video.ItagNo != tt.video || sel.audio.ItagNo != tt.audio {
+				t.Errorf("merged %d+%d, want %d+%d", sel.video.ItagNo, sel.audio.ItagNo, tt.video, tt.audio)
+			}
+		})
+	}
+}
+
+func TestDownloadChunked(t *testing.T) {
+	d, fake, _ := newTestDownloader(t, "-buffer-size", "4K")
+	if err := d.ProcessVideos([]string{"fakevideo02"}); err != nil {
+		t.Fatal(err)
+	}
+	want := slices.Concat(streamBytes(t, fake, "fakevideo02", fakeyt.ItagVideo), streamBytes(t, fake, "fakevideo02", fakeyt.ItagAudio))
+	got, err := os.ReadFile(filepath.Join(d.config.OutputDir, "Fake Video 2.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("written in 4K chunks, the output holds %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestDownloadResumesPartialStreams(t *testing.T) {
+	d, fake, _ := newTestDownloader(t, "-continue")
+	video := streamBytes(t, fake, "fakevideo03", fakeyt.ItagVideo)
+	audio := streamBytes(t, fake, "fakevideo03", fakeyt.ItagAudio)
+
+	// What an interrupted run left: half the video, none of the audio. It
+	// is zeroed so that a download that starts over shows.
+	partial := make([]byte, len(video)/2)
+	temp := filepath.Join(d.config.OutputDir, "Fake Video 3_temp.mp4.video")
+	if err := os.WriteFile(temp, partial, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ProcessVideos([]string{"fakevideo03"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(d.config.OutputDir, "Fake Video 3.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := slices.Concat(partial, video[len(partial):], audio)
+	if !bytes.Equal(got, want) {
+		t.Errorf("resumed output holds %d bytes, want the partial video, its remaining %d bytes and the audio", len(got), len(video)-len(partial))
+	}
+}
+
+func TestDownloadEmbedsTags(t *testing.T) {
+	d, _, stub := newTestDownloader(t, "-embed-metadata")
+	if err := d.ProcessVideos([]string{"fakevideo01"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(stub.merges) != 1 {
+		t.Fatalf("got %d merges, want 1", len(stub.merges))
+	}
+	tags := stub.merges[0].tags
+	for _, want := range []string{
+		"title=Fake Video 1",
+		"artist=Fake Channel",
+		"comment=https://www.youtube.com/watch?v=fakevideo01",
+		"date=2024-01-01",
+	} {
+		if !slices.Contains(tags, want) {
+			t.Errorf("tags %q are missing %q", tags, want)
+		}
+	}
+}
+
+func TestDownloadToDest(t *testing.T) {
+	dest := t.TempDir()
+	d, fake, stub := newTestDownloader(t, "-dest", dest, "-checksums", "file", "-history", filepath.Join(t.TempDir(), "history.db"))
+	for range 2 {
+		if err := d.ProcessVideos([]string{"fakevideo01"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The second run finds the file in storage
+	if len(stub.merges) != 1 {
+		t.Errorf("got %d merges, want 1", len(stub.merges))
+	}
+
+	want := slices.Concat(streamBytes(t, fake, "fakevideo01", fakeyt.ItagVideo), streamBytes(t, fake, "fakevideo01", fakeyt.ItagAudio))
+	got, err := os.ReadFile(filepath.Join(dest, "Fake Video 1.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("stored file holds %d bytes, want %d", len(got), len(want))
+	}
+	sums, err := os.ReadFile(filepath.Join(d.config.OutputDir, checksumsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(want)
+	if line := hex.EncodeToString(sum[:]) + "  Fake Video 1.mp4\n"; string(sums) != line {
+		t.Errorf("%s = %q, want %q", checksumsFile, sums, line)
+	}
+
+	entries, err := d.history.Find(context.Background(), "fakevideo01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Size != int64(len(want)) || entries[0].SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("history = %+v, want one download of %d bytes", entries, len(want))
+	}
+}
+
+func TestCopyToFailureKeepsDownload(t *testing.T) {
+	// A regular file where the destination directory should be
+	blocked := filepath.Join(t.TempDir(), "blocked")
+	if err := os.WriteFile(blocked, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	copyTo := t.TempDir()
+	d, _, _ := newTestDownloader(t, "-copy-to", blocked, "-copy-to", copyTo)
+	if err := d.ProcessVideos([]string{"fakevideo01"}); err != nil {
+		t.Fatalf("a failed copy failed the download: %v", err)
+	}
+	if snap := d.Jobs()[0].Snapshot(); snap.Status != JobDone {
+		t.Errorf("job is %s, want done", snap.Status)
+	}
+	if !fileExists(filepath.Join(copyTo, "Fake Video 1.mp4")) {
+		t.Error("the other destination got no copy")
+	}
+}
@@ -0,0 +1,347 @@
+// Package fakeyt is an offline stand-in for YouTube. It serves canned video
+// and playlist metadata plus synthetic streams from an httptest server, and
+// exposes a Provider that satisfies the downloader's VideoProvider interface.
+package fakeyt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+const (
+	ItagVideo     = 136 // 720p h264 video-only mp4
+	ItagAudio     = 140 // aac audio-only mp4
+	ItagVideoWebM = 247 // 720p vp9 video-only webm
+	ItagAudioWebM = 251 // opus audio-only webm
+	ItagMuxed     = 18  // 360p mp4 with audio
+
+	// ChannelID is the channel every fake video belongs to. Its uploads
+	// playlist, "UU" followed by the rest of the ID, lists the three
+	// playable videos.
+	ChannelID = "UCfakechannel00000000000"
+
+	// Size of the synthetic streams served when ffmpeg is not available
+	syntheticSize = 256 * 1024
+)
+
+// Server serves fake metadata under /videos/<id> and /playlists/<id>, and
+// stream bytes (with Range support) under /streams/<id>/<itag>.
+type Server struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	videos     map[string]*youtube.Video
+	unplayable map[string]youtube.ErrPlayabiltyStatus
+	playlists  map[string]*youtube.Playlist
+	streams    map[string][]byte
+	mediaDir   string
+}
+
+// New starts a server preloaded with three videos and a playlist holding
+// them, plus a private and a removed video and a second playlist mixing
+// them in. When ffmpeg is installed the streams are real (tiny) media
+// files, so merging and conversion can be exercised too.
+func New() *Server {
+	s := &Server{
+		videos:     make(map[string]*youtube.Video),
+		unplayable: make(map[string]youtube.ErrPlayabiltyStatus),
+		playlists:  make(map[string]*youtube.Playlist),
+		streams:    make(map[string][]byte),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+
+	var entries []*youtube.PlaylistEntry
+	for i := 1; i <= 3; i++ {
+		v := s.AddVideo(fmt.Sprintf("fakevideo%02d", i), fmt.Sprintf("Fake Video %d", i), 2*time.Second)
+		entries = append(entries, &youtube.PlaylistEntry{ID: v.ID, Title: v.Title, Author: v.Author, Duration: v.Duration})
+	}
+	s.AddPlaylist(&youtube.Playlist{ID: "PLfakeplaylist0001", Title: "Fake Playlist", Author: "Fake Channel", Videos: entries})
+	s.AddPlaylist(&youtube.Playlist{ID: "UU" + strings.TrimPrefix(ChannelID, "UC"), Title: "Uploads from Fake Channel", Author: "Fake Channel", Videos: entries})
+
+	s.AddUnplayable("fakeprivat1", youtube.ErrPlayabiltyStatus{Status: "LOGIN_REQUIRED", Reason: "This video is private"})
+	s.AddUnplayable("fakeremove1", youtube.ErrPlayabiltyStatus{Status: "ERROR", Reason: "This video has been removed by the uploader"})
+	mixed := []*youtube.PlaylistEntry{
+		entries[0],
+		{ID: "fakeprivat1", Title: "[Private video]"},
+		{ID: "fakeremove1", Title: "[Deleted video]"},
+		entries[1],
+	}
+	s.AddPlaylist(&youtube.Playlist{ID: "PLfakeplaylist0002", Title: "Fake Mixed Playlist", Author: "Fake Channel", Videos: mixed})
+	return s
+}
+
+// AddUnplayable registers a video whose metadata fetch fails with status,
+// as YouTube's player response does for private or removed videos.
+func (s *Server) AddUnplayable(id string, status youtube.ErrPlayabiltyStatus) {
+	s.mu.Lock()
+	s.unplayable[id] = status
+	s.mu.Unlock()
+}
+
+// Close stops the server and removes any generated media.
+func (s *Server) Close() {
+	s.Server.Close()
+	if s.mediaDir != "" {
+		os.RemoveAll(s.mediaDir)
+	}
+}
+
+// AddVideo registers a video with video-only, audio-only and muxed formats.
+func (s *Server) AddVideo(id, title string, duration time.Duration) *youtube.Video {
+	v := &youtube.Video{
+		ID:          id,
+		Title:       title,
+		Author:      "Fake Channel",
+		ChannelID:   ChannelID,
+		Description: "Synthetic video served by fakeyt",
+		Duration:    duration,
+		Views:       1000,
+		PublishDate: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Thumbnails:  youtube.Thumbnails{{URL: s.URL + "/thumbnails/" + id + ".jpg", Width: 320, Height: 180}},
+		CaptionTracks: []youtube.CaptionTrack{
+			{BaseURL: s.URL + "/captions/" + id + "?lang=en", LanguageCode: "en", Kind: "asr"},
+		},
+	}
+
+	formats := []struct {
+		itag     int
+		mime     string
+		quality  string
+		channels int
+		width    int
+		height   int
+	}{
+		{ItagVideo, `video/mp4; codecs="avc1.4d401f"`, "hd720", 0, 1280, 720},
+		{ItagAudio, `audio/mp4; codecs="mp4a.40.2"`, "tiny", 2, 0, 0},
+		{ItagVideoWebM, `video/webm; codecs="vp9"`, "hd720", 0, 1280, 720},
+		{ItagAudioWebM, `audio/webm; codecs="opus"`, "tiny", 2, 0, 0},
+		{ItagMuxed, `video/mp4; codecs="avc1.42001E, mp4a.40.2"`, "medium", 2, 640, 360},
+	}
+	for _, f := range formats {
+		data := s.streamData(id, f.itag, duration)
+		s.mu.Lock()
+		s.streams[streamKey(id, f.itag)] = data
+		s.mu.Unlock()
+
+		v.Formats = append(v.Formats, youtube.Format{
+			ItagNo:        f.itag,
+			URL:           fmt.Sprintf("%s/streams/%s/%d", s.URL, id, f.itag),
+			MimeType:      f.mime,
+			Quality:       f.quality,
+			AudioChannels: f.channels,
+			Width:         f.width,
+			Height:        f.height,
+			Bitrate:       int(float64(len(data)*8) / duration.Seconds()),
+			ContentLength: int64(len(data)),
+		})
+	}
+
+	s.mu.Lock()
+	s.videos[id] = v
+	s.mu.Unlock()
+	return v
+}
+
+func (s *Server) AddPlaylist(p *youtube.Playlist) {
+	s.mu.Lock()
+	s.playlists[p.ID] = p
+	s.mu.Unlock()
+}
+
+func streamKey(id string, itag int) string {
+	return fmt.Sprintf("%s/%d", id, itag)
+}
+
+// streamData renders real media with ffmpeg when possible, falling back to
+// deterministic pseudo-random bytes.
+func (s *Server) streamData(id string, itag int, duration time.Duration) []byte {
+	if data, err := s.renderMedia(id, itag, duration); err == nil {
+		return data
+	}
+	data := make([]byte, syntheticSize)
+	rand.New(rand.NewSource(int64(itag))).Read(data)
+	return data
+}
+
+func (s *Server) renderMedia(id string, itag int, duration time.Duration) ([]byte, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, err
+	}
+	if s.mediaDir == "" {
+		dir, err := os.MkdirTemp("", "fakeyt")
+		if err != nil {
+			return nil, err
+		}
+		s.mediaDir = dir
+	}
+
+	seconds := fmt.Sprintf("%.1f", duration.Seconds())
+	out := filepath.Join(s.mediaDir, fmt.Sprintf("%s-%d.mp4", id, itag))
+	args := []string{"-hide_banner", "-loglevel", "error", "-y"}
+	switch itag {
+	case ItagVideo:
+		args = append(args, "-f", "lavfi", "-i", "testsrc=size=1280x720:rate=25:duration="+seconds, "-c:v", "libx264", "-an")
+	case ItagAudio:
+		args = append(args, "-f", "lavfi", "-i", "sine=frequency=440:duration="+seconds, "-c:a", "aac", "-vn")
+	case ItagVideoWebM:
+		args = append(args, "-f", "lavfi", "-i", "testsrc=size=1280x720:rate=25:duration="+seconds, "-c:v", "libvpx-vp9", "-an", "-f", "webm")
+	case ItagAudioWebM:
+		args = append(args, "-f", "lavfi", "-i", "sine=frequency=440:duration="+seconds, "-c:a", "libopus", "-vn", "-f", "webm")
+	default:
+		args = append(args,
+			"-f", "lavfi", "-i", "testsrc=size=640x360:rate=25:duration="+seconds,
+			"-f", "lavfi", "-i", "sine=frequency=440:duration="+seconds,
+			"-c:v", "libx264", "-c:a", "aac", "-shortest")
+	}
+	if err := exec.Command("ffmpeg", append(args, out)...).Run(); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(out)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case len(parts) == 2 && parts[0] == "videos":
+		if status, ok := s.unplayable[parts[1]]; ok {
+			w.WriteHeader(http.StatusGone)
+			json.NewEncoder(w).Encode(status)
+			return
+		}
+		v, ok := s.videos[parts[1]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(v)
+	case len(parts) == 2 && parts[0] == "playlists":
+		p, ok := s.playlists[parts[1]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(p)
+	case len(parts) == 2 && parts[0] == "thumbnails":
+		if _, ok := s.videos[strings.TrimSuffix(parts[1], ".jpg")]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		// Just the JPEG start and end markers, enough to be recognised
+		w.Write([]byte{0xff, 0xd8, 0xff, 0xd9})
+	case len(parts) == 2 && parts[0] == "captions":
+		v, ok := s.videos[parts[1]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "WEBVTT\n\n00:00:00.000 --> 00:00:01.000\n%s\n", v.Title)
+	case len(parts) == 3 && parts[0] == "streams":
+		data, ok := s.streams[parts[1]+"/"+parts[2]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		// ServeContent handles Range requests for resumed downloads
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(string(data)))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Provider fetches everything from a Server over HTTP.
+type Provider struct {
+	server *Server
+	client *http.Client
+}
+
+func (s *Server) Provider() *Provider {
+	return &Provider{server: s, client: s.Client()}
+}
+
+func (p *Provider) getJSON(ctx context.Context, path string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.server.URL+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		var status youtube.ErrPlayabiltyStatus
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			return err
+		}
+		return status
+	}
+	if resp.StatusCode != http.StatusOK {
+		return youtube.ErrUnexpectedStatusCode(resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (p *Provider) GetVideoContext(ctx context.Context, url string) (*youtube.Video, error) {
+	id, err := youtube.ExtractVideoID(url)
+	if err != nil {
+		return nil, err
+	}
+	var v youtube.Video
+	if err := p.getJSON(ctx, "/videos/"+id, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (p *Provider) GetPlaylistContext(ctx context.Context, url string) (*youtube.Playlist, error) {
+	id := url
+	if i := strings.Index(url, "list="); i >= 0 {
+		id = url[i+len("list="):]
+	}
+	if i := strings.Index(id, "&"); i >= 0 {
+		id = id[:i]
+	}
+	var pl youtube.Playlist
+	if err := p.getJSON(ctx, "/playlists/"+id, &pl); err != nil {
+		return nil, err
+	}
+	return &pl, nil
+}
+
+func (p *Provider) GetStreamContext(ctx context.Context, video *youtube.Video, format *youtube.Format) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, format.URL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, 0, youtube.ErrUnexpectedStatusCode(resp.StatusCode)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+func (p *Provider) GetStreamURLContext(ctx context.Context, video *youtube.Video, format *youtube.Format) (string, error) {
+	return format.URL, nil
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// errSkipped is returned by a download that found nothing to do.
+var errSkipped = errors.New("skipped")
+
+type JobStatus string
+
+const (
+	JobQueued   JobStatus = "queued"
+	JobRunning  JobStatus = "running"
+	JobDone     JobStatus = "done"
+	JobSkipped  JobStatus = "skipped"
+	JobFailed   JobStatus = "failed"
+	JobCanceled JobStatus = "canceled"
+)
+
+// Job tracks a single video through the download pipeline. All mutable
+// state is guarded by mu so the TUI can read it while workers update it.
+type Job struct {
+	ID    int
+	URL   string
+	Index int // position in the playlist, or 0 outside one
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Set by the worker that owns the job when its URL is a clip
+	clip *clipRange
+	// Set by the worker from the job's video, if a category matches
+	category *category
+	// Set by the worker with -adaptive-quality: whether the download is
+	// watched against -time-budget, when the first attempt started, which
+	// the budget runs from, and the resolution it fell back to
+	adaptive    bool
+	budgetStart time.Time
+	maxHeight   int
+	// The playlist or channel the job came from, if any, and the batch
+	// it was queued in
+	source batchSource
+	batch  int
+
+	reporter ProgressReporter
+
+	mu           sync.Mutex
+	resumed      *sync.Cond
+	videoID      string
+	title        string
+	outputPath   string
+	format       string // itags, e.g. "136+140"
+	duration     time.Duration
+	started      time.Time
+	finished     time.Time
+	status       JobStatus
+	phase        JobPhase
+	phaseStart   time.Time
+	phaseTimes   map[JobPhase]time.Duration
+	paused       bool
+	downloaded   int64
+	total        int64
+	processed    time.Duration // of processTotal, while merging or converting
+	processTotal time.Duration
+	speed        float64
+	peakSpeed    float64
+	err          error
+	sampleAt     time.Time
+	sampleBytes  int64
+	reportedAt   time.Time
+}
+
+// JobSnapshot is a point-in-time copy of a job's state for display.
+type JobSnapshot struct {
+	ID           int
+	URL          string
+	VideoID      string
+	Title        string
+	OutputPath   string
+	Format       string
+	Duration     time.Duration
+	Elapsed      time.Duration
+	Status       JobStatus
+	Phase        JobPhase
+	Phases       []PhaseTiming
+	Priority     jobPriority
+	Paused       bool
+	Downloaded   int64
+	Total        int64
+	Processed    time.Duration // how far merging or converting has got
+	ProcessTotal time.Duration
+	Speed        float64
+	PeakSpeed    float64
+	Err          error
+}
+
+func newJob(id int, url string, reporter ProgressReporter) *Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &Job{
+		ID:       id,
+		URL:      url,
+		ctx:      ctx,
+		cancel:   cancel,
+		reporter: reporter,
+		title:    url,
+		status:   JobQueued,
+	}
+	j.resumed = sync.NewCond(&j.mu)
+	return j
+}
+
+func (j *Job) Snapshot() JobSnapshot {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return JobSnapshot{
+		ID:           j.ID,
+		URL:          j.URL,
+		VideoID:      j.videoID,
+		Title:        j.title,
+		OutputPath:   j.outputPath,
+		Format:       j.format,
+		Duration:     j.duration,
+		Elapsed:      j.elapsed(),
+		Status:       j.status,
+		Phase:        j.phase,
+		Phases:       j.phaseTimings(),
+		Priority:     j.source.Priority,
+		Paused:       j.paused,
+		Downloaded:   j.downloaded,
+		Total:        j.total,
+		Processed:    j.processed,
+		ProcessTotal: j.processTotal,
+		Speed:        j.speed,
+		PeakSpeed:    j.peakSpeed,
+		Err:          j.err,
+	}
+}
+
+// finishedAt returns when the job finished, or the zero time if it hasn't.
+func (j *Job) finishedAt() time.Time {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.finished
+}
+
+func (j *Job) elapsed() time.Duration {
+	if j.started.IsZero() {
+		return 0
+	}
+	if j.finished.IsZero() {
+		return time.Since(j.started)
+	}
+	return j.finished.Sub(j.started)
+}
+
+func (j *Job) setVideo(video *youtube.Video) {
+	j.mu.Lock()
+	j.videoID = video.ID
+	j.title = video.Title
+	j.duration = video.Duration
+	j.mu.Unlock()
+}
+
+// processProgress returns a callback recording how far a merge or
+// conversion of total worth of media has got.
+func (j *Job) processProgress(total time.Duration) func(time.Duration) {
+	if total <= 0 {
+		return nil
+	}
+	return func(done time.Duration) {
+		j.mu.Lock()
+		j.processed = min(done, total)
+		j.processTotal = total
+		report := j.reportDue(time.Now())
+		bytes, size := j.downloaded, j.total
+		j.mu.Unlock()
+		if report {
+			j.reporter.Update(j, bytes, size)
+		}
+	}
+}
+
+func (j *Job) setOutputPath(path string) {
+	j.mu.Lock()
+	j.outputPath = path
+	j.mu.Unlock()
+}
+
+func (j *Job) setFormat(format string) {
+	j.mu.Lock()
+	j.format = format
+	j.mu.Unlock()
+}
+
+func (j *Job) setStatus(status JobStatus) {
+	j.mu.Lock()
+	j.status = status
+	starting := status == JobRunning && j.started.IsZero()
+	if starting {
+		j.started = time.Now()
+	}
+	j.mu.Unlock()
+	if starting {
+		j.reporter.Start(j)
+	}
+}
+
+func (j *Job) addTotal(n int64) {
+	j.mu.Lock()
+	j.total += n
+	j.mu.Unlock()
+}
+
+func (j *Job) byteCounts() (downloaded, total int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.downloaded, j.total
+}
+
+// resetProgress starts the job's byte counts over, for a download that is
+// started again with other formats.
+func (j *Job) resetProgress() {
+	j.mu.Lock()
+	j.downloaded, j.total = 0, 0
+	j.speed, j.sampleAt, j.sampleBytes = 0, time.Time{}, 0
+	j.mu.Unlock()
+}
+
+func (j *Job) finish(err error) {
+	var skip *skipError
+	j.mu.Lock()
+	j.endPhase()
+	j.err = err
+	j.speed = 0
+	j.finished = time.Now()
+	switch {
+	case err == nil:
+		j.status = JobDone
+	case err == errSkipped:
+		j.err = nil
+		j.status = JobSkipped
+	case errors.As(err, &skip):
+		j.err = skip.err
+		j.status = JobSkipped
+	case errors.Is(err, context.Canceled):
+		j.status = JobCanceled
+	default:
+		j.status = JobFailed
+	}
+	err = j.err
+	j.mu.Unlock()
+	j.reporter.Done(j, err)
+}
+
+func (j *Job) Pause() {
+	j.mu.Lock()
+	j.paused = true
+	j.speed = 0
+	j.mu.Unlock()
+}
+
+func (j *Job) Resume() {
+	j.mu.Lock()
+	j.paused = false
+	j.resumed.Broadcast()
+	j.mu.Unlock()
+}
+
+func (j *Job) Cancel() {
+	j.cancel()
+	j.mu.Lock()
+	j.resumed.Broadcast()
+	j.mu.Unlock()
+}
+
+// waitIfPaused blocks while the job is paused and reports cancellation.
+func (j *Job) waitIfPaused() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	waited := false
+	for j.paused && j.ctx.Err() == nil {
+		j.resumed.Wait()
+		waited = true
+	}
+	if waited {
+		// Time spent paused should not drag down the measured speed
+		j.sampleAt = time.Time{}
+	}
+	return j.ctx.Err()
+}
+
+func (j *Job) addProgress(n int) {
+	j.mu.Lock()
+	now := time.Now()
+	j.downloaded += int64(n)
+	j.sampleBytes += int64(n)
+	if j.sampleAt.IsZero() {
+		j.sampleAt = now
+		j.sampleBytes = 0
+	} else if elapsed := now.Sub(j.sampleAt); elapsed >= time.Second {
+		j.speed = float64(j.sampleBytes) / elapsed.Seconds()
+		j.peakSpeed = max(j.peakSpeed, j.speed)
+		j.sampleAt = now
+		j.sampleBytes = 0
+	}
+	report := j.reportDue(now)
+	bytes, total := j.downloaded, j.total
+	j.mu.Unlock()
+
+	// Reporters read the job, so they are called without holding mu
+	if report {
+		j.reporter.Update(j, bytes, total)
+	}
+}
+
+// reportDue reports whether progressInterval has passed since the last
+// Update. j.mu must be held.
+func (j *Job) reportDue(now time.Time) bool {
+	if now.Sub(j.reportedAt) < progressInterval {
+		return false
+	}
+	j.reportedAt = now
+	return true
+}
+
+// progressReader reports bytes read to its job, honours pause/cancel and
+// applies the bandwidth limit.
+type progressReader struct {
+	job     *Job
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	if err := p.job.waitIfPaused(); err != nil {
+		return 0, err
+	}
+	n, err := p.r.Read(b)
+	p.job.addProgress(n)
+	if lerr := p.limiter.wait(p.job.ctx, n); lerr != nil && err == nil {
+		err = lerr
+	}
+	return n, err
+}
+
+// scheduler hands out download slots to waiting jobs, which unlike a
+// plain channel semaphore allows the queue to be reordered. The next job
+// is the one of highest priority; between equals, the one whose batch has
+// the fewest downloads running and then was served longest ago, so
+// playlists queued together take turns; and then the first in queue order.
+type scheduler struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	limit   int
+	active  int
+	waiting []*Job
+	running map[int]int    // active downloads by batch
+	served  map[int]uint64 // when each batch last got a slot, by turn
+	turn    uint64
+}
+
+func newScheduler(limit int) *scheduler {
+	s := &scheduler{limit: limit, running: make(map[int]int), served: make(map[int]uint64)}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// next returns the waiting job to run next.
+func (s *scheduler) next() *Job {
+	var best *Job
+	for _, job := range s.waiting {
+		switch {
+		case best == nil:
+			best = job
+		case job.source.Priority != best.source.Priority:
+			if job.source.Priority > best.source.Priority {
+				best = job
+			}
+		case s.running[job.batch] != s.running[best.batch]:
+			if s.running[job.batch] < s.running[best.batch] {
+				best = job
+			}
+		case s.served[job.batch] < s.served[best.batch]:
+			best = job
+		}
+	}
+	return best
+}
+
+func (s *scheduler) acquire(job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.waiting = append(s.waiting, job)
+	stop := context.AfterFunc(job.ctx, func() {
+		s.mu.Lock()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+	defer stop()
+
+	for {
+		if err := job.ctx.Err(); err != nil {
+			s.remove(job)
+			s.cond.Broadcast()
+			return err
+		}
+		if s.active < s.limit && s.next() == job {
+			s.remove(job)
+			s.active++
+			s.running[job.batch]++
+			s.turn++
+			s.served[job.batch] = s.turn
+			s.cond.Broadcast()
+			return nil
+		}
+		s.cond.Wait()
+	}
+}
+
+func (s *scheduler) release(job *Job) {
+	s.mu.Lock()
+	s.active--
+	if s.running[job.batch]--; s.running[job.batch] <= 0 {
+		delete(s.running, job.batch)
+		if !slices.ContainsFunc(s.waiting, func(w *Job) bool { return w.batch == job.batch }) {
+			delete(s.served, job.batch)
+		}
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+func (s *scheduler) remove(job *Job) {
+	for i, w := range s.waiting {
+		if w == job {
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+// move shifts a waiting job delta places towards the front (negative) or
+// back (positive) of the queue.
+func (s *scheduler) move(job *Job, delta int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, w := range s.waiting {
+		if w != job {
+			continue
+		}
+		target := i + delta
+		if target < 0 || target >= len(s.waiting) {
+			return false
+		}
+		s.waiting[i], s.waiting[target] = s.waiting[target], s.waiting[i]
+		s.cond.Broadcast()
+		return true
+	}
+	return false
+}
+
+// setLimit changes the number of concurrent downloads. Running downloads
+// are unaffected; a lower limit takes effect as they finish.
+func (s *scheduler) setLimit(limit int) {
+	s.mu.Lock()
+	s.limit = limit
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+func (s *scheduler) capacity() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limit
+}
+
+// semaphore is a counting semaphore whose limit can be changed while in use.
+type semaphore struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+}
+
+func newSemaphore(limit int) *semaphore {
+	s := &semaphore{limit: limit}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+func (s *semaphore) acquire() {
+	s.mu.Lock()
+	for s.active >= s.limit {
+		s.cond.Wait()
+	}
+	s.active++
+	s.mu.Unlock()
+}
+
+func (s *semaphore) release() {
+	s.mu.Lock()
+	s.active--
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+func (s *semaphore) setLimit(limit int) {
+	s.mu.Lock()
+	s.limit = limit
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+func (s *semaphore) capacity() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limit
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPruneJobs(t *testing.T) {
+	d := &Downloader{config: Config{Progress: noProgress{}, JobRetention: time.Hour}}
+	now := time.Now()
+	finish := func(job *Job, err error, at time.Time) {
+		job.finish(err)
+		job.mu.Lock()
+		job.finished = at
+		job.mu.Unlock()
+	}
+
+	// Batch 1 ended two hours ago, batch 2 has a job that did but is
+	// still running another, and batch 3 ended ten minutes ago
+	old := d.addJob("a", batchSource{}, 1)
+	finish(old, nil, now.Add(-2*time.Hour))
+	failed := d.addJob("b", batchSource{}, 1)
+	finish(failed, errors.New("gone"), now.Add(-3*time.Hour))
+	early := d.addJob("c", batchSource{}, 2)
+	finish(early, nil, now.Add(-2*time.Hour))
+	running := d.addJob("d", batchSource{}, 2)
+	recent := d.addJob("e", batchSource{}, 3)
+	finish(recent, nil, now.Add(-10*time.Minute))
+
+	d.prunedAt = time.Time{}
+	d.pruneJobs(now)
+	jobs := d.Jobs()
+	if len(jobs) != 3 || jobs[0] != early || jobs[1] != running || jobs[2] != recent {
+		t.Fatalf("kept jobs %v, want c, d and e", jobs)
+	}
+
+	// IDs keep counting up past pruned jobs, and the metrics still count them
+	if job := d.addJob("f", batchSource{}, 4); job.ID != 6 {
+		t.Errorf("next job ID = %d, want 6", job.ID)
+	}
+	totals := d.jobTotals()
+	if totals.counts[JobDone] != 3 || totals.counts[JobFailed] != 1 || totals.counts[JobQueued] != 2 {
+		t.Errorf("totals = %v, want 3 done, 1 failed and 2 queued", totals.counts)
+	}
+
+	// Without a retention nothing is pruned
+	d.config.JobRetention = 0
+	d.prunedAt = time.Time{}
+	d.pruneJobs(now.Add(24 * time.Hour))
+	if n := len(d.Jobs()); n != 4 {
+		t.Errorf("kept %d jobs without a retention, want 4", n)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+const (
+	jobStateDirName = ".jobs"
+	jobBundleState  = "job.json"
+	jobBundleData   = "data/"
+)
+
+// videoIDPattern matches a YouTube video ID, which names a job's state file.
+var videoIDPattern = regexp.MustCompile(`^[\w-]{11}$`)
+
+// JobState describes an in-progress download on disk so it can be resumed
+// later, possibly on another machine via export-job/import-job.
+type JobState struct {
+	VideoID     string        `json:"video_id"`
+	URL         string        `json:"url"`
+	Title       string        `json:"title"`
+	MP3Only     bool          `json:"mp3_only"`               // set for any audio-only download
+	AudioFormat string        `json:"audio_format,omitempty"` // -audio-format, missing from older states
+	FinalPath   string        `json:"final_path"`
+	Formats     []FormatState `json:"formats"`
+	Updated     time.Time     `json:"updated"`
+}
+
+// FormatState is one selected stream. Streams are fetched sequentially, so
+// the downloaded part is always the first Downloaded bytes of Path.
+type FormatState struct {
+	Role          string `json:"role"` // "video" or "audio"
+	Itag          int    `json:"itag"`
+	MimeType      string `json:"mime_type"`
+	ContentLength int64  `json:"content_length"`
+	Path          string `json:"path"`
+	Downloaded    int64  `json:"downloaded"`
+}
+
+func jobStatePath(outputDir, videoID string) string {
+	return filepath.Join(outputDir, jobStateDirName, videoID+".json")
+}
+
+func saveJobState(outputDir string, st *JobState) error {
+	st.Updated = time.Now()
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := jobStatePath(outputDir, st.VideoID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func loadJobState(outputDir, videoID string) (*JobState, error) {
+	data, err := os.ReadFile(jobStatePath(outputDir, videoID))
+	if err != nil {
+		return nil, err
+	}
+	var st JobState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// refresh updates the downloaded byte counts from the partial files and
+// returns the formats that have data on disk.
+func (st *JobState) refresh(outputDir string) []FormatState {
+	var partial []FormatState
+	for i := range st.Formats {
+		stat, err := os.Stat(filepath.Join(outputDir, st.Formats[i].Path))
+		if err != nil {
+			st.Formats[i].Downloaded = 0
+			continue
+		}
+		st.Formats[i].Downloaded = stat.Size()
+		partial = append(partial, st.Formats[i])
+	}
+	return partial
+}
+
+// newJobState starts the state of a download, which is audio-only in
+// audioFormat unless that is empty.
+func newJobState(outputDir string, video *youtube.Video, url, finalPath, audioFormat string) *JobState {
+	return &JobState{
+		VideoID:     video.ID,
+		URL:         url,
+		Title:       video.Title,
+		MP3Only:     audioFormat != "",
+		AudioFormat: audioFormat,
+		FinalPath:   relPath(outputDir, finalPath),
+	}
+}
+
+// resumeFlags are the flags that resume the download as it was started.
+func (st *JobState) resumeFlags() string {
+	switch {
+	case st.AudioFormat == "mp3":
+		return " -mp3"
+	case st.AudioFormat != "" && st.AudioFormat != "best":
+		return " -x -audio-format " + st.AudioFormat
+	case st.MP3Only:
+		return " -x"
+	}
+	return ""
+}
+
+func (st *JobState) addFormat(outputDir, role string, format *youtube.Format, path string) {
+	st.Formats = append(st.Formats, FormatState{
+		Role:          role,
+		Itag:          format.ItagNo,
+		MimeType:      format.MimeType,
+		ContentLength: format.ContentLength,
+		Path:          relPath(outputDir, path),
+	})
+}
+
+// formatFor returns the format previously chosen for role, so a resumed
+// download appends to the same stream it started with.
+func (st *JobState) formatFor(role string, formats youtube.FormatList) *youtube.Format {
+	for _, fs := range st.Formats {
+		if fs.Role != role {
+			continue
+		}
+		if matches := formats.Itag(fs.Itag); len(matches) > 0 {
+			return &matches[0]
+		}
+	}
+	return nil
+}
+
+func relPath(base, path string) string {
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func runExportJob(args []string) error {
+	flags := flag.NewFlagSet("export-job", flag.ExitOnError)
+	outputDir := flags.String("output", "downloads", "Output directory the job was downloading into")
+	bundle := flags.String("o", "", "Bundle file to write (default: <id>.ytdljob.tar.gz)")
+	noData := flags.Bool("no-data", false, "Only export the job state, not the partial data")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		// Without an ID, list the jobs that can be exported
+		entries, _ := os.ReadDir(filepath.Join(*outputDir, jobStateDirName))
+		for _, e := range entries {
+			id := strings.TrimSuffix(e.Name(), ".json")
+			if st, err := loadJobState(*outputDir, id); err == nil {
+				fmt.Printf("%s  %s\n", st.VideoID, st.Title)
+			}
+		}
+		return nil
+	}
+
+	id := flags.Arg(0)
+	st, err := loadJobState(*outputDir, id)
+	if err != nil {
+		return fmt.Errorf("no resumable job for %s: %v", id, err)
+	}
+	partial := st.refresh(*outputDir)
+
+	if *bundle == "" {
+		*bundle = id + ".ytdljob.tar.gz"
+	}
+	f, err := os.Create(*bundle)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	state, _ := json.MarshalIndent(st, "", "  ")
+	if err := tw.WriteHeader(&tar.Header{Name: jobBundleState, Mode: 0644, Size: int64(len(state)), ModTime: st.Updated}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(state); err != nil {
+		return err
+	}
+
+	if !*noData {
+		for _, fs := range partial {
+			if err := addFileToTar(tw, filepath.Join(*outputDir, fs.Path), jobBundleData+fs.Path); err != nil {
+				return fmt.Errorf("failed to add %s: %v", fs.Path, err)
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %s (%d partial files) to %s\n", st.Title, len(partial), *bundle)
+	return nil
+}
+
+func addFileToTar(tw *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: stat.Size(), ModTime: stat.ModTime()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func runImportJob(args []string) error {
+	flags := flag.NewFlagSet("import-job", flag.ExitOnError)
+	outputDir := flags.String("output", "downloads", "Output directory to resume the job in")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: youtube-downloader import-job [-output dir] <bundle>")
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("invalid job bundle: %v", err)
+	}
+	tr := tar.NewReader(gz)
+
+	var st *JobState
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid job bundle: %v", err)
+		}
+
+		switch {
+		case hdr.Name == jobBundleState:
+			st = &JobState{}
+			if err := json.NewDecoder(tr).Decode(st); err != nil {
+				return fmt.Errorf("invalid job state: %v", err)
+			}
+			// The ID becomes a file name, so it mustn't lead out of .jobs
+			if !videoIDPattern.MatchString(st.VideoID) {
+				return fmt.Errorf("invalid job state: bad video ID %q", st.VideoID)
+			}
+			if st.AudioFormat != "" && !slices.Contains(audioFormats, st.AudioFormat) {
+				return fmt.Errorf("invalid job state: bad audio format %q", st.AudioFormat)
+			}
+		case strings.HasPrefix(hdr.Name, jobBundleData):
+			// Only the job's own partial files are extracted, which
+			// export-job writes after the state
+			if st == nil {
+				return fmt.Errorf("invalid job bundle: %s comes before %s", hdr.Name, jobBundleState)
+			}
+			rel := strings.TrimPrefix(hdr.Name, jobBundleData)
+			if !slices.ContainsFunc(st.Formats, func(fs FormatState) bool { return fs.Path == rel }) {
+				return fmt.Errorf("refusing to extract %s, which isn't one of the job's streams", hdr.Name)
+			}
+			rel = filepath.Clean(filepath.FromSlash(rel))
+			if !filepath.IsLocal(rel) {
+				return fmt.Errorf("refusing to extract %s outside the output directory", hdr.Name)
+			}
+			dest := filepath.Join(*outputDir, rel)
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return err
+			}
+			out, err := os.Create(dest)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			out.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if st == nil {
+		return fmt.Errorf("job bundle has no %s", jobBundleState)
+	}
+	if err := saveJobState(*outputDir, st); err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %s. Resume with:\n  youtube-downloader -continue%s -output %s %s\n", st.Title, st.resumeFlags(), *outputDir, st.URL)
+	return nil
+}
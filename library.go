@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+	_ "modernc.org/sqlite"
+)
+
+// The library is an SQLite FTS5 index of everything downloaded. SQLite is
+// embedded through a pure-Go driver, so the binary stays free of cgo and
+// needs no sqlite3 tool.
+
+const librarySchema = `CREATE VIRTUAL TABLE IF NOT EXISTS library USING fts5(
+	video_id UNINDEXED, path UNINDEXED, downloaded UNINDEXED,
+	title, channel, description, transcript,
+	tokenize = 'unicode61 remove_diacritics 2'
+);`
+
+type library struct {
+	db *sql.DB
+}
+
+// LibraryEntry is one search hit.
+type LibraryEntry struct {
+	VideoID    string `json:"video_id"`
+	Path       string `json:"path"`
+	Title      string `json:"title"`
+	Channel    string `json:"channel"`
+	Downloaded string `json:"downloaded"`
+	Snippet    string `json:"snippet"`
+}
+
+func defaultLibraryPath() string {
+	dir, err := ytdlConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "library.db")
+}
+
+// openLibrary returns nil when path is empty, which disables indexing.
+func openLibrary(path string) (*library, error) {
+	if path == "" {
+		return nil, nil
+	}
+	db, err := openSQLite(path, 5*time.Second, librarySchema)
+	if err != nil {
+		return nil, err
+	}
+	return &library{db: db}, nil
+}
+
+// openSQLite opens the database at path, creating it and its directory as
+// needed, and applies schema. Writers wait up to busyTimeout for another
+// connection's lock.
+func openSQLite(path string, busyTimeout time.Duration, schema string) (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", fmt.Sprintf("%s?_pragma=busy_timeout(%d)", path, busyTimeout.Milliseconds()))
+	if err != nil {
+		return nil, err
+	}
+	// One connection keeps the downloader's own writers from contending
+	// for the file lock
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Close closes the index.
+func (l *library) Close() error {
+	return l.db.Close()
+}
+
+// Add indexes a downloaded file, replacing any earlier entry for the video.
+func (l *library) Add(ctx context.Context, video *youtube.Video, path, transcript string) error {
+	// Files stored with -dest are recorded by URL
+	if !strings.Contains(path, "://") {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+	}
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM library WHERE video_id = ?`, video.ID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO library (video_id, path, downloaded, title, channel, description, transcript)
+VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		video.ID, path, time.Now().UTC().Format(time.RFC3339),
+		video.Title, video.Author, video.Description, transcript); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Search runs an FTS5 query, best matches first.
+func (l *library) Search(ctx context.Context, query string, limit int) ([]LibraryEntry, error) {
+	return l.query(ctx, `SELECT video_id, path, title, channel, downloaded,
+	snippet(library, -1, '[', ']', '...', 12)
+FROM library WHERE library MATCH ? ORDER BY rank LIMIT ?`, query, limit)
+}
+
+// transcriptProvider is implemented by youtube.Client but not by the
+// test-mode fake, so transcripts are fetched only when available.
+type transcriptProvider interface {
+	GetTranscriptCtx(ctx context.Context, video *youtube.Video, lang string) (youtube.VideoTranscript, error)
+}
+
+// indexOutput adds a finished download to the library. Failures are
+// logged; the download itself has already succeeded.
+func (d *Downloader) indexOutput(ctx context.Context, video *youtube.Video, path string) {
+	if d.library == nil {
+		return
+	}
+	var transcript string
+	if tp, ok := d.baseClient().(transcriptProvider); ok {
+		if t, err := tp.GetTranscriptCtx(ctx, video, "en"); err == nil {
+			transcript = t.String()
+		}
+	}
+	if err := d.library.Add(ctx, video, path, transcript); err != nil {
+		d.logger.Printf("Failed to index %s in library: %v", video.Title, err)
+	}
+}
+
+func setupSearch(flags *flag.FlagSet) func([]string) error {
+	libraryPath := flags.String("library", defaultLibraryPath(), "Library index to search")
+	limit := flags.Int("max-results", 20, "Maximum number of results")
+	asJSON := flags.Bool("json", false, "Print results as JSON")
+
+	return func(args []string) error {
+		if len(args) == 0 {
+			flags.Usage()
+			return &exitError{exitTotalFailure, fmt.Errorf("expected a query")}
+		}
+		lib, err := openLibrary(*libraryPath)
+		if err != nil {
+			return fmt.Errorf("failed to open library: %v", err)
+		}
+		if lib == nil {
+			return fmt.Errorf("no library configured")
+		}
+		defer lib.Close()
+		entries, err := lib.Search(context.Background(), strings.Join(args, " "), *limit)
+		if err != nil {
+			return fmt.Errorf("search failed: %v", err)
+		}
+
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(entries)
+		}
+		if len(entries) == 0 {
+			fmt.Println("No matches.")
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, e := range entries {
+			missing := ""
+			if !strings.Contains(e.Path, "://") && !fileExists(e.Path) {
+				missing = " (missing)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s%s\n", e.Title, e.Channel, e.Path, missing)
+			fmt.Fprintf(w, "\t%s\n", strings.Join(strings.Fields(e.Snippet), " "))
+		}
+		return w.Flush()
+	}
+}
+
+// Entries returns every indexed download.
+func (l *library) Entries(ctx context.Context) ([]LibraryEntry, error) {
+	return l.query(ctx, `SELECT video_id, path, title, channel, downloaded, '' FROM library`)
+}
+
+// query runs a query selecting the columns of LibraryEntry in order.
+func (l *library) query(ctx context.Context, query string, args ...any) ([]LibraryEntry, error) {
+	rows, err := l.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []LibraryEntry
+	for rows.Next() {
+		var e LibraryEntry
+		if err := rows.Scan(&e.VideoID, &e.Path, &e.Title, &e.Channel, &e.Downloaded, &e.Snippet); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Remove drops the entry for path.
+func (l *library) Remove(ctx context.Context, path string) error {
+	_, err := l.db.ExecContext(ctx, `DELETE FROM library WHERE path = ?`, path)
+	return err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// isLive reports whether video is a broadcast in progress. Live streams
+// carry an HLS manifest but no fixed length.
+func isLive(video *youtube.Video) bool {
+	return video.HLSManifestURL != "" && video.Duration == 0
+}
+
+// isUpcoming reports whether err means the video is a scheduled premiere
+// or stream that has not started yet.
+func isUpcoming(err error) bool {
+	var status *youtube.ErrPlayabiltyStatus
+	return errors.As(err, &status) && status.Status == "LIVE_STREAM_OFFLINE"
+}
+
+// waitForLive polls an upcoming stream until it becomes playable.
+func (d *Downloader) waitForLive(ctx context.Context, url string) (*youtube.Video, error) {
+	ticker := time.NewTicker(d.config.LiveWaitInterval)
+	defer ticker.Stop()
+
+	for {
+		d.logger.Printf("Waiting for %s to go live, checking again in %s", url, formatDuration(d.config.LiveWaitInterval))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+
+		video, err := d.client.GetVideoContext(ctx, url)
+		if err == nil || !isUpcoming(err) {
+			return video, err
+		}
+	}
+}
+
+// recordLive captures a live broadcast from its HLS manifest until the
+// stream ends or the job is canceled. Fragmented MP4 keeps the recording
+// playable even if ffmpeg is interrupted.
+func (d *Downloader) recordLive(ctx context.Context, video *youtube.Video, finalPath string, mp3 bool) error {
+	args := []string{"-hide_banner", "-loglevel", "error"}
+	if d.config.LiveFromStart {
+		// Start at the oldest segment still in the DVR window
+		args = append(args, "-live_start_index", "0")
+	}
+	args = append(args, "-i", video.HLSManifestURL)
+	if mp3 {
+		args = append(args, "-vn", "-ab", "128k", "-ar", "44100")
+	} else {
+		args = append(args, "-c", "copy", "-movflags", "+frag_keyframe+empty_moov")
+	}
+	args = append(args, "-y", finalPath)
+
+	cmd := exec.CommandContext(ctx, ffmpegBinary(d.config.FFmpegPath, "ffmpeg"), args...)
+	// Let ffmpeg finalise the file on cancel rather than killing it outright
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = 10 * time.Second
+
+	d.logger.Printf("Recording live stream: %s", video.Title)
+	if err := cmd.Run(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("live recording failed: %v", err)
+	}
+
+	d.logger.Printf("Live recording saved: %s", finalPath)
+	return ctx.Err()
+}
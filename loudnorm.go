@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// -normalize-audio aims for the target loudness with these limits, as
+// the EBU R128 recommendation and most streaming services do.
+const (
+	loudnessTruePeak = -1.5 // dBTP
+	loudnessRange    = 11   // LU
+)
+
+// loudnessStats is what the first loudnorm pass measures, in the form
+// ffmpeg prints it.
+type loudnessStats struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+// usable reports whether every measurement is a finite number; silence
+// measures as -inf, which the second pass can't use.
+func (s *loudnessStats) usable() bool {
+	for _, v := range []string{s.InputI, s.InputTP, s.InputLRA, s.InputThresh, s.TargetOffset} {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+			return false
+		}
+	}
+	return true
+}
+
+// loudnormFilter is the loudnorm filter for target LUFS. With the first
+// pass's measurements it normalizes linearly, which keeps the dynamics
+// of the track; without them it falls back to loudnorm's dynamic mode.
+func loudnormFilter(target float64, measured *loudnessStats) string {
+	filter := fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%g", target, loudnessTruePeak, float64(loudnessRange))
+	if measured != nil {
+		filter += fmt.Sprintf(":measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+			measured.InputI, measured.InputTP, measured.InputLRA, measured.InputThresh, measured.TargetOffset)
+	}
+	return filter
+}
+
+func (f *execFFmpeg) MeasureLoudness(ctx context.Context, inputPath string, clip *clipRange, target float64) (*loudnessStats, error) {
+	args := []string{"-hide_banner", "-nostdin", "-nostats"}
+	args = append(append(args, clip.inputArgs()...), "-i", inputPath, "-vn")
+	args = append(args, "-af", loudnormFilter(target, nil)+":print_format=json", "-f", "null", "-")
+	cmd := exec.CommandContext(ctx, f.path, args...)
+	// The measurements are printed at the end of the log
+	var stderr bytes.Buffer
+	log := &ffmpegLog{debug: f.debug}
+	cmd.Stderr = io.MultiWriter(&stderr, log)
+	if err := cmd.Run(); err != nil {
+		if tail := log.tail(); tail != "" {
+			return nil, fmt.Errorf("%v: %s", err, tail)
+		}
+		return nil, err
+	}
+
+	out := stderr.String()
+	start, end := strings.LastIndex(out, "{"), strings.LastIndex(out, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("loudnorm printed no measurements")
+	}
+	var stats loudnessStats
+	if err := json.Unmarshal([]byte(out[start:end+1]), &stats); err != nil {
+		return nil, fmt.Errorf("failed to parse loudnorm measurements: %v", err)
+	}
+	return &stats, nil
+}
+
+// loudnessFilter measures inputPath and returns the filter normalizing
+// it to -loudness-target, or "" without -normalize-audio.
+func (d *Downloader) loudnessFilter(ctx context.Context, inputPath string, clip *clipRange) string {
+	if !d.config.NormalizeAudio {
+		return ""
+	}
+	stats, err := d.media().MeasureLoudness(ctx, inputPath, clip, d.config.LoudnessTarget)
+	switch {
+	case err != nil:
+		d.logger.Printf("Failed to measure loudness, normalizing in one pass: %v", err)
+		return loudnormFilter(d.config.LoudnessTarget, nil)
+	case !stats.usable():
+		d.logger.Printf("Loudness of %s can't be measured (silent?), normalizing in one pass", inputPath)
+		return loudnormFilter(d.config.LoudnessTarget, nil)
+	}
+	d.logger.Printf("Measured %s LUFS, normalizing to %g LUFS", stats.InputI, d.config.LoudnessTarget)
+	return loudnormFilter(d.config.LoudnessTarget, stats)
+}
@@ -2,13 +2,14 @@ package main
 
 import (
 	"context"
-	"flag"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -17,11 +18,114 @@ import (
 )
 
 type Config struct {
-	OutputDir     string
-	MaxConcurrent int
-	Quality       string
-	MetadataOnly  bool
-	MP3Only       bool
+	OutputDir             string
+	MaxConcurrent         int
+	MetadataConcurrent    int
+	PostProcessConcurrent int
+	Quality               string
+	Format                *formatSpec // -f, nil to select by Quality
+	Progress              ProgressReporter
+	Organize              string // -organize: flat, playlist or channel
+	Dedupe                string // -dedupe: off, hardlink, symlink, copy or skip
+	MetadataOnly          bool
+	AudioOnly             bool
+	AudioFormat           string // -audio-format of audio-only downloads: best, mp3, opus or m4a
+	WriteInfoJSON         bool
+	WriteNFO              bool // Kodi/Jellyfin .nfo next to each download
+	WriteDescription      bool
+	WriteComments         bool
+	MaxComments           int
+	WriteThumbnail        bool
+	WriteSubs             bool
+	SubLangs              []string // caption languages for WriteSubs, or "all"
+	Checksums             string   // -checksums: off, file or sidecar
+	EmbedMetadata         bool
+	ExistingPolicy        string
+	VerifyExisting        bool
+	LiveFromStart         bool
+	WaitForLive           bool
+	LiveWaitInterval      time.Duration
+	NotifyURL             string
+	DiscordWebhook        string
+	Categories            []*category // from the config file
+	SplitBy               time.Duration
+	SplitSilence          bool
+	SplitSilenceMin       time.Duration
+	AudioLangs            []string // preferred audio tracks, in order
+	AllTracks             bool     // merge every audio track and subtitle into MKV
+	PlexURL               string
+	PlexToken             string
+	PlexSection           string // library section ID, empty for all
+	JellyfinURL           string
+	JellyfinAPIKey        string
+	Preview               bool
+	PreviewSeconds        int
+	PreviewUpgrade        bool
+	VideoCodec            string
+	AudioCodec            string
+	RemuxTo               string
+	RestrictFilenames     bool
+	MaxFilenameLength     int
+	Container             string
+	AutoCrop              bool
+	MaxFailures           int
+	Verbose               bool
+	FFmpegPath            string // -ffmpeg-path, empty to search PATH
+	HWAccel               string // -hwaccel method, empty for software
+	NormalizeAudio        bool
+	KeepSeparate          bool     // save the video and audio streams unmerged
+	LoudnessTarget        float64  // LUFS
+	ExtraFFmpegArgs       []string // added to merges and conversions
+	Filter                videoFilter
+	MaxFilesize           int64       // estimated bytes over which a video is skipped, 0 for no cap
+	MaxTotalSize          int64       // estimated bytes after which the run stops, 0 for no cap
+	Sections              []clipRange // -section, each downloaded separately
+	Dates                 dateFormat
+	LimitRate             string
+	ThrottledRate         int64 // bytes/s below which a stream's URL is re-resolved, 0 to never
+	AdaptiveQuality       bool  // fall back to a lower resolution when over TimeBudget
+	TimeBudget            time.Duration
+	BufferSize            int   // bytes buffered before writing a download to disk
+	FsyncEvery            int64 // bytes written between syncs to disk, 0 to never
+	Preallocate           bool
+	Downloader            string        // "native", or "aria2c" to fetch streams with aria2c
+	DownloaderArgs        []string      // added to aria2c's arguments
+	SleepInterval         time.Duration // between starting downloads
+	MaxSleepInterval      time.Duration // randomizes SleepInterval up to this when set
+	CheckSpace            bool
+	Paranoid              bool
+	ParanoidRanges        int
+	OutputTemplate        string
+	Proxy                 string
+	Resolver              string
+	PlayerClients         string // comma-separated fallback order, see playerClients
+	ForceIPv4             bool
+	ForceIPv6             bool
+	SourceAddress         string // local address to connect from
+	Interface             string // network interface to connect from, by its address
+	DisableHTTP2          bool
+	MaxIdleConns          int            // idle connections kept across all hosts, 0 for Go's default
+	MaxIdleConnsPerHost   int            // 0 for Go's default
+	MaxConnsPerHost       int            // 0 for no limit
+	HostConns             map[string]int // MaxConnsPerHost by domain
+	TCPKeepAlive          time.Duration  // 0 for 30s, negative to turn off
+	UserAgent             string
+	Headers               http.Header // sent with every request
+	GeoBypassCountry      string      // two-letter code, see geoBlocks
+	MaxRPM                int         // API requests per minute, 0 for no limit
+	RPMBurst              int
+	SleepRequests         time.Duration
+	DownloadArchive       string
+	LibraryPath           string
+	HistoryPath           string // SQLite log of completed downloads, empty to disable
+	CacheDir              string // metadata cache, empty to disable
+	CacheTTL              time.Duration
+	SkipWatched           bool
+	DumpJSON              io.Writer // -J: a yt-dlp style JSON line per downloaded video
+	Dest                  Storage
+	Destinations          []Storage
+	Hooks                 Hooks
+	JobRetention          time.Duration // how long finished batches stay in Jobs, 0 to keep them all
 }
 
 type VideoInfo struct {
@@ -31,27 +135,271 @@ type VideoInfo struct {
 	Description string
 }
 
+// VideoProvider is the part of the YouTube client the downloader depends on,
+// so tests and the offline test mode can substitute a fake.
+type VideoProvider interface {
+	GetVideoContext(ctx context.Context, url string) (*youtube.Video, error)
+	GetPlaylistContext(ctx context.Context, url string) (*youtube.Playlist, error)
+	GetStreamContext(ctx context.Context, video *youtube.Video, format *youtube.Format) (io.ReadCloser, int64, error)
+	GetStreamURLContext(ctx context.Context, video *youtube.Video, format *youtube.Format) (string, error)
+}
+
 type Downloader struct {
-	client *youtube.Client
-	config Config
-	guard  chan struct{}
-	logger *log.Logger
+	client   VideoProvider
+	resolver externalResolver
+	// resolveHTTP reaches -resolver instances and the streams they return
+	// over the bare transport, without credentials or custom headers.
+	resolveHTTP *http.Client
+	players     []playerClient
+	credential  *Credential // from -auth, for downloads outside the client
+	http        *http.Client
+	config      Config
+	sched       *scheduler
+	metaGuard   *semaphore
+	ffmpegGuard *semaphore
+	sizes       *sizeTracker
+	runSize     *runBudget
+	pacing      *pacingTransport
+	archive     *downloadArchive
+	library     *library
+	history     *history
+	cache       *metadataCache
+	watched     map[string]bool
+	destStats   destinationStats
+	limiter     *rateLimiter
+	temps       *cleanupRegistry
+	space       spaceReserver
+	ffmpegTimes ffmpegTimings
+	ffmpegOnce  sync.Once
+	ffmpeg      FFmpeg
+	hwMu        sync.Mutex
+	hwProbed    map[string]bool // -hwaccel encoder -> whether it works
+	logger      *log.Logger
+
+	jobsMu   sync.Mutex
+	jobs     []*Job
+	lastJob  int  // ID of the newest job, which pruning leaves in place
+	paused   bool // by PauseAll, which also pauses new jobs
+	batches  int  // processBatch calls, which number their jobs' batch
+	prunedAt time.Time
+	retired  jobTotals // of the jobs pruned from jobs
+
+	jsonMu sync.Mutex // serializes DumpJSON lines
+
+	sleepMu   sync.Mutex
+	nextStart time.Time // earliest start of the next download under -sleep-interval
+
+	claimsMu  sync.Mutex
+	claims    map[string]string         // lowercased output path -> video ID
+	downloads map[string]*downloadClaim // video ID -> first download this run, for -dedupe
+
+	streamedMu sync.Mutex
+	streamed   map[string]streamedOutput // output path -> what writeOutput sent to -dest
+
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+func NewDownloader(config Config) (*Downloader, error) {
+	if config.Downloader == "aria2c" {
+		if err := checkAria2Proxy(config.Proxy); err != nil {
+			return nil, err
+		}
+	}
+	base, err := newTransport(config)
+	if err != nil {
+		return nil, err
+	}
+	// Remote destinations go through -proxy and the dialer too, but
+	// without the credentials and headers meant for YouTube
+	storageClient := &http.Client{Transport: base}
+	for _, dest := range append([]Storage{config.Dest}, config.Destinations...) {
+		if dest, ok := dest.(httpStorage); ok {
+			dest.setHTTPClient(storageClient)
+		}
+	}
+
+	var archive *downloadArchive
+	if config.DownloadArchive != "" {
+		var err error
+		if archive, err = loadDownloadArchive(config.DownloadArchive); err != nil {
+			return nil, fmt.Errorf("failed to read download archive: %v", err)
+		}
+	}
+
+	schedule, err := parseRateSchedule(config.LimitRate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -limit-rate: %v", err)
+	}
+
+	if config.Progress == nil {
+		config.Progress = noProgress{}
+	}
+
+	players, err := parsePlayerClients(config.PlayerClients)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -player-client: %v", err)
+	}
+
+	var clientTransport http.RoundTripper = &playerClientTransport{base: base}
+	if config.UserAgent != "" || len(config.Headers) > 0 || config.GeoBypassCountry != "" {
+		var geoAddress string
+		if config.GeoBypassCountry != "" {
+			if geoAddress, err = geoBypassAddress(config.GeoBypassCountry); err != nil {
+				return nil, fmt.Errorf("invalid -geo-bypass-country: %v", err)
+			}
+		}
+		clientTransport = newHeaderTransport(clientTransport, config.UserAgent, config.Headers, geoAddress)
+	}
+	pacing := newPacingTransport(clientTransport)
+	var transport http.RoundTripper = pacing
+	if config.MaxRPM != 0 || config.SleepRequests != 0 {
+		if transport, err = newRequestLimiter(pacing, config.MaxRPM, config.RPMBurst, config.SleepRequests); err != nil {
+			return nil, err
+		}
+	}
+	httpClient := &http.Client{Transport: transport}
+	d := &Downloader{
+		http:        httpClient,
+		config:      config,
+		sched:       newScheduler(config.MaxConcurrent),
+		metaGuard:   newSemaphore(config.MetadataConcurrent),
+		ffmpegGuard: newSemaphore(config.PostProcessConcurrent),
+		sizes:       newSizeTracker(defaultSizeHistoryPath()),
+		runSize:     newRunBudget(config.MaxTotalSize),
+		pacing:      pacing,
+		archive:     archive,
+		limiter:     newRateLimiter(schedule),
+		temps:       newCleanupRegistry(),
+		logger:      log.New(os.Stdout, "[YouTube Downloader] ", log.LstdFlags),
+		stopped:     make(chan struct{}),
+		players:     players,
+	}
+	if config.CacheDir != "" {
+		d.cache = &metadataCache{dir: config.CacheDir, ttl: config.CacheTTL}
+	}
+	if config.Resolver != "" {
+		d.resolveHTTP = &http.Client{Transport: base}
+		if d.resolver, err = parseResolver(config.Resolver, d.resolveHTTP); err != nil {
+			return nil, fmt.Errorf("invalid -resolver: %v", err)
+		}
+	}
+	d.setClient(&youtube.Client{HTTPClient: httpClient})
+
+	if config.SkipWatched {
+		path, err := watchedListPath()
+		if err == nil {
+			d.watched, err = loadWatched(path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read watch history: %v", err)
+		}
+		if len(d.watched) == 0 {
+			d.logger.Printf("Warning: -skip-watched given but no watch history imported (see import-history)")
+		}
+	}
+
+	// Indexing is a convenience, so a database that can't be opened does not
+	// stop downloads
+	if d.library, err = openLibrary(config.LibraryPath); err != nil {
+		d.logger.Printf("Library indexing disabled: %v", err)
+	}
+	if d.history, err = openHistory(config.HistoryPath); err != nil {
+		d.logger.Printf("Download history disabled: %v", err)
+	}
+	return d, nil
+}
+
+func (d *Downloader) addJob(url string, source batchSource, batch int) *Job {
+	d.jobsMu.Lock()
+	defer d.jobsMu.Unlock()
+	d.pruneJobs(time.Now())
+	d.lastJob++
+	job := newJob(d.lastJob, url, d.config.Progress)
+	job.source = source
+	job.batch = batch
+	d.jobs = append(d.jobs, job)
+	if d.Stopping() {
+		job.Cancel()
+	}
+	if d.paused {
+		job.Pause()
+	}
+	return job
+}
+
+// Jobs returns every job created by this downloader in submission order,
+// apart from those forgotten after -job-retention.
+func (d *Downloader) Jobs() []*Job {
+	d.jobsMu.Lock()
+	defer d.jobsMu.Unlock()
+	return append([]*Job(nil), d.jobs...)
+}
+
+// pruneJobs forgets the jobs of batches that finished more than
+// JobRetention ago, keeping their counts for the metrics. It runs at most
+// once a minute, with jobsMu held.
+func (d *Downloader) pruneJobs(now time.Time) {
+	if d.config.JobRetention <= 0 || now.Sub(d.prunedAt) < time.Minute {
+		return
+	}
+	d.prunedAt = now
+
+	// A batch is only as finished as its last job, so a bot or API request
+	// still running keeps all of its jobs
+	ended := make(map[int]time.Time)
+	running := make(map[int]bool)
+	for _, job := range d.jobs {
+		finished := job.finishedAt()
+		if finished.IsZero() {
+			running[job.batch] = true
+		} else if finished.After(ended[job.batch]) {
+			ended[job.batch] = finished
+		}
+	}
+	cutoff := now.Add(-d.config.JobRetention)
+	kept := d.jobs[:0]
+	for _, job := range d.jobs {
+		if running[job.batch] || ended[job.batch].After(cutoff) {
+			kept = append(kept, job)
+			continue
+		}
+		d.retired.add(job.Snapshot())
+	}
+	clear(d.jobs[len(kept):])
+	d.jobs = kept
 }
 
-func NewDownloader(config Config) *Downloader {
-	return &Downloader{
-		client: &youtube.Client{},
-		config: config,
-		guard:  make(chan struct{}, config.MaxConcurrent),
-		logger: log.New(os.Stdout, "[YouTube Downloader] ", log.LstdFlags),
+// jobTotals returns the totals of every job, including pruned ones.
+func (d *Downloader) jobTotals() jobTotals {
+	d.jobsMu.Lock()
+	defer d.jobsMu.Unlock()
+	totals := d.retired.clone()
+	for _, job := range d.jobs {
+		totals.add(job.Snapshot())
 	}
+	return totals
 }
 
-func (d *Downloader) downloadVideo(ctx context.Context, video *youtube.Video, wg *sync.WaitGroup) error {
-	defer wg.Done()
+func (d *Downloader) CancelAll() {
+	for _, job := range d.Jobs() {
+		job.Cancel()
+	}
+}
 
-	d.guard <- struct{}{}
-	defer func() { <-d.guard }()
+func (d *Downloader) downloadVideo(ctx context.Context, job *Job, video *youtube.Video) (err error) {
+	if slots := job.source.Slots; slots != nil {
+		if err := slots.acquire(job); err != nil {
+			return err
+		}
+		defer slots.release(job)
+	}
+	if err := d.sched.acquire(job); err != nil {
+		return err
+	}
+	defer d.sched.release(job)
+	job.setStatus(JobRunning)
+	d.categorize(job, video)
 
 	info := VideoInfo{
 		Title:       video.Title,
@@ -60,196 +408,831 @@ func (d *Downloader) downloadVideo(ctx context.Context, video *youtube.Video, wg
 		Description: video.Description,
 	}
 
-	safeTitle := strings.Map(func(r rune) rune {
-		if strings.ContainsRune(`<>:"/\|?*`, r) {
-			return '-'
-		}
-		return r
-	}, info.Title)
+	base, err := d.outputBase(job, video)
+	if err != nil {
+		return err
+	}
 
+	// Selection errors are reported after the skip and live checks, which
+	// do not need any formats
+	sel, selErr := d.selectFormats(job, video)
+	if selErr == nil && !isLive(video) {
+		sel, selErr = d.fitFilesize(job, video, sel)
+	}
 	extension := ".mp4"
-	if d.config.MP3Only {
-		extension = ".mp3"
+	if selErr == nil && !isLive(video) {
+		extension = "." + sel.container
+		if d.config.KeepSeparate && sel.video != nil && sel.audio != nil {
+			extension = ".video." + streamExtension(sel.video)
+		}
+		if d.config.AllTracks && sel.video != nil && sel.audio != nil {
+			// Only MKV holds every audio track and subtitle as is
+			sel.container = "mkv"
+			extension = ".mkv"
+		}
 	}
 
-	tempPath := filepath.Join(d.config.OutputDir, safeTitle+"_temp.mp4")
-	finalPath := filepath.Join(d.config.OutputDir, safeTitle+extension)
-
-	// For MP4: Get both video and audio formats
-	var videoFormat, audioFormat *youtube.Format
+	base = d.claimOutput(video, base, extension)
+	tempPath := filepath.Join(d.config.OutputDir, base+"_temp.mp4")
+	finalPath := filepath.Join(d.config.OutputDir, base+extension)
+	job.setOutputPath(finalPath)
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
 
-	if !d.config.MP3Only {
-		// Get best video format
-		formats := video.Formats
-		var videoFormats youtube.FormatList
-		for _, format := range formats {
-			if format.Quality == "hd720" && format.AudioChannels == 0 {
-				videoFormats = append(videoFormats, format)
+	if d.config.ExistingPolicy != ExistingOverwrite {
+		ok, reason := d.existingOutputValid(ctx, finalPath, outputLength(job, video))
+		if ok {
+			d.logger.Printf("Skipping %s: %s already exists", info.Title, finalPath)
+			if d.dedupeEnabled() {
+				d.noteDownloaded(video.ID, finalPath)
 			}
+			return errSkipped
 		}
-		if len(videoFormats) == 0 {
-			for _, format := range formats {
-				if format.Quality == "medium" && format.AudioChannels == 0 {
-					videoFormats = append(videoFormats, format)
-				}
+		// Without -preview-upgrade the preview is all there is to keep
+		if d.config.Preview && !d.config.PreviewUpgrade {
+			previewPath := d.previewPath(base)
+			if ok, _ := d.existingOutputValid(ctx, previewPath, d.previewLength(video)); ok {
+				d.logger.Printf("Skipping %s: %s already exists", info.Title, previewPath)
+				return errSkipped
 			}
 		}
-		if len(videoFormats) > 0 {
-			videoFormat = &videoFormats[0]
+		if reason != "" {
+			d.logger.Printf("Re-downloading %s: %s", info.Title, reason)
+			d.trashOutput(finalPath)
 		}
+	} else {
+		d.trashOutput(finalPath)
+	}
 
-		// Get best audio format
-		var audioFormats youtube.FormatList
-		for _, format := range formats {
-			if strings.Contains(format.MimeType, "audio/mp4") {
-				audioFormats = append(audioFormats, format)
-			}
+	if d.dedupeEnabled() {
+		first, release, claimErr := d.claimVideo(ctx, video.ID)
+		if claimErr != nil {
+			return claimErr
 		}
-		if len(audioFormats) > 0 {
-			audioFormat = &audioFormats[0]
+		if release == nil {
+			return d.reuseDownload(ctx, job, video, first, finalPath)
+		}
+		defer func() {
+			if err != nil {
+				release("")
+			} else {
+				release(finalPath)
+			}
+		}()
+	}
+
+	if err := d.sleepBeforeDownload(ctx, video); err != nil {
+		return err
+	}
+	if err := d.beforeDownload(ctx, video, finalPath); err != nil {
+		return err
+	}
+
+	if isLive(video) {
+		job.setPhase(PhaseDownloadingVideo)
+		if err := d.recordLive(ctx, video, finalPath, d.audioOnly(job)); err != nil {
+			return err
 		}
+		return d.finishOutput(ctx, job, video, finalPath)
+	}
 
-		if videoFormat == nil || audioFormat == nil {
-			return fmt.Errorf("no suitable video or audio formats found for %s", info.Title)
+	var previewPath string
+	if d.config.Preview {
+		var err error
+		job.setPhase(PhaseDownloadingVideo)
+		if previewPath, err = d.downloadPreview(ctx, job, video, base); err != nil {
+			return err
 		}
-	} else {
-		// For MP3: Get only audio format
-		formats := video.Formats.WithAudioChannels()
-		if len(formats) == 0 {
-			return fmt.Errorf("no formats with audio found for %s", info.Title)
+		d.logger.Printf("Preview saved: %s", previewPath)
+		if !d.config.PreviewUpgrade {
+			return d.finishOutput(ctx, job, video, previewPath)
 		}
-		audioFormat = &formats[0]
 	}
 
-	if !d.config.MP3Only {
-		// Download and merge video and audio
-		videoStream, _, err := d.client.GetStream(video, videoFormat)
-		if err != nil {
-			return fmt.Errorf("failed to get video stream: %v", err)
+	if selErr != nil {
+		return selErr
+	}
+	if d.config.KeepSeparate && job.clip != nil {
+		return fmt.Errorf("-keep-separate saves whole streams and can't cut %s", job.clip.label())
+	}
+	videoFormat, audioFormat := sel.video, sel.audio
+	single := !d.audioOnly(job) && (videoFormat == nil || audioFormat == nil)
+	if single && job.clip != nil {
+		return fmt.Errorf("-f %s picked a single format, which is saved whole and can't be cut to %s", d.formatSpec(job).raw, job.clip.label())
+	}
+
+	// A resumed download must keep appending to the streams it started with
+	if d.config.ExistingPolicy == ExistingContinue {
+		if st, err := loadJobState(d.config.OutputDir, video.ID); err == nil {
+			if f := st.formatFor("video", video.Formats); f != nil && videoFormat != nil && (job.maxHeight == 0 || f.Height <= job.maxHeight) {
+				videoFormat = f
+			}
+			if f := st.formatFor("audio", video.Formats); f != nil && audioFormat != nil {
+				audioFormat = f
+			}
+		}
+	}
+
+	job.adaptive = d.config.AdaptiveQuality && videoFormat != nil && d.canDowngrade(job, video, videoFormat.Height)
+	if job.adaptive && job.budgetStart.IsZero() {
+		job.budgetStart = time.Now()
+	}
+
+	job.setFormat(formatIDs(videoFormat, audioFormat))
+	stateAudio := ""
+	if d.audioOnly(job) {
+		stateAudio = d.audioFormat(job)
+	}
+	state := newJobState(d.config.OutputDir, video, job.URL, finalPath, stateAudio)
+	switch {
+	case videoFormat != nil && audioFormat != nil:
+		state.addFormat(d.config.OutputDir, "video", videoFormat, tempPath+".video")
+		state.addFormat(d.config.OutputDir, "audio", audioFormat, tempPath+".audio")
+	case videoFormat != nil:
+		state.addFormat(d.config.OutputDir, "video", videoFormat, tempPath)
+	default:
+		state.addFormat(d.config.OutputDir, "audio", audioFormat, tempPath)
+	}
+	if err := saveJobState(d.config.OutputDir, state); err != nil {
+		d.logger.Printf("Failed to save job state for %s: %v", info.Title, err)
+	}
+	defer func() {
+		// Keep the state only while there is partial data to resume
+		if len(state.refresh(d.config.OutputDir)) == 0 {
+			os.Remove(jobStatePath(d.config.OutputDir, video.ID))
 		}
-		defer videoStream.Close()
+	}()
+
+	estimated := estimateFormatSize(videoFormat, video.Duration) + estimateFormatSize(audioFormat, video.Duration)
+	if estimated > 0 {
+		d.logger.Printf("Estimated size for %s: %s", info.Title, formatBytes(d.sizes.Adjust(estimated)))
+	}
+	releaseSpace, err := d.checkSpace(filepath.Dir(finalPath), info.Title, d.sizes.Adjust(estimated))
+	defer releaseSpace()
+	if err != nil {
+		return err
+	}
+	reserved := d.sizes.Adjust(estimated)
+	if err := d.runSize.reserve(reserved); err != nil {
+		return err
+	}
+	defer func() {
+		fetched, _ := job.byteCounts()
+		d.runSize.settle(reserved, fetched)
+	}()
 
-		audioStream, _, err := d.client.GetStream(video, audioFormat)
+	if single {
+		format := videoFormat
+		if format == nil {
+			format = audioFormat
+		}
+		fetched, err := d.saveFormat(ctx, job, video, format, tempPath, finalPath)
 		if err != nil {
-			return fmt.Errorf("failed to get audio stream: %v", err)
+			return err
 		}
-		defer audioStream.Close()
+		d.sizes.Record(info.Title, estimated, fetched)
+	} else if !d.audioOnly(job) {
+		// Download and merge video and audio
+		job.addTotal(d.fetchLength(job, video, videoFormat) + d.fetchLength(job, video, audioFormat))
 
 		// Create temporary files for video and audio
 		videoTempPath := tempPath + ".video"
 		audioTempPath := tempPath + ".audio"
 
 		// Download video stream
-		if err := d.downloadStreamToFile(videoStream, videoTempPath, info.Title+" (video)"); err != nil {
+		job.setPhase(PhaseDownloadingVideo)
+		videoBytes, err := d.fetchFormat(ctx, job, video, videoFormat, videoTempPath, info.Title+" (video)")
+		if err != nil {
 			return err
 		}
 
 		// Download audio stream
-		if err := d.downloadStreamToFile(audioStream, audioTempPath, info.Title+" (audio)"); err != nil {
-			os.Remove(videoTempPath)
+		job.setPhase(PhaseDownloadingAudio)
+		audioBytes, err := d.fetchFormat(ctx, job, video, audioFormat, audioTempPath, info.Title+" (audio)")
+		if err != nil {
+			d.removePartial(videoTempPath)
+			return err
+		}
+		d.sizes.Record(info.Title, estimated, videoBytes+audioBytes)
+
+		if d.config.KeepSeparate {
+			audioPath := strings.TrimSuffix(finalPath, extension) + ".audio." + streamExtension(audioFormat)
+			if err := d.keepSeparate(ctx, job, video, videoTempPath, finalPath, audioTempPath, audioPath); err != nil {
+				return err
+			}
+		} else {
+			// Merge video and audio using ffmpeg
+			if d.config.AllTracks {
+				if sel.tracks, err = d.fetchAllTracks(ctx, job, video, audioFormat, tempPath); err != nil {
+					d.removePartial(videoTempPath)
+					d.removePartial(audioTempPath)
+					return err
+				}
+			}
+			rights, tags := d.fetchTags(ctx, job, video)
+			job.setPhase(PhaseMerging)
+			sel.clip = job.clip
+			if d.config.AutoCrop {
+				if sel.crop, err = detectCrop(ctx, ffmpegBinary(d.config.FFmpegPath, "ffmpeg"), videoTempPath, videoFormat, video.Duration); err != nil {
+					d.logger.Printf("Not cropping %s: %v", info.Title, err)
+				}
+			}
+			if err := d.mergeVideoAudio(ctx, videoTempPath, audioTempPath, finalPath, sel, tags, job.processProgress(outputLength(job, video))); err != nil {
+				d.removePartial(videoTempPath)
+				d.removePartial(audioTempPath)
+				d.discardTracks(sel.tracks)
+				return err
+			}
+
+			// Clean up temporary files
+			d.discardTemp(videoTempPath)
+			d.discardTemp(audioTempPath)
+			d.discardTracks(sel.tracks)
+			d.writeSidecar(job, video, rights, finalPath)
+		}
+	} else {
+		// Audio only download
+		job.addTotal(d.fetchLength(job, video, audioFormat))
+
+		job.setPhase(PhaseDownloadingAudio)
+		audioBytes, err := d.fetchFormat(ctx, job, video, audioFormat, tempPath, info.Title)
+		if err != nil {
 			return err
 		}
+		d.sizes.Record(info.Title, estimated, audioBytes)
 
-		// Merge video and audio using ffmpeg
-		if err := d.mergeVideoAudio(videoTempPath, audioTempPath, finalPath); err != nil {
-			os.Remove(videoTempPath)
-			os.Remove(audioTempPath)
+		rights, tags := d.fetchTags(ctx, job, video)
+		job.setPhase(PhaseConverting)
+		if err := d.extractAudio(ctx, tempPath, finalPath, audioFormat, job.clip, tags, job.processProgress(outputLength(job, video))); err != nil {
+			d.removePartial(tempPath)
 			return err
 		}
+		d.discardTemp(tempPath)
+		d.writeSidecar(job, video, rights, finalPath)
+	}
+
+	// The full download replaces the preview
+	if previewPath != "" {
+		os.Remove(previewPath)
+	}
+
+	if err := d.finishOutput(ctx, job, video, finalPath); err != nil {
+		return err
+	}
+
+	d.logger.Printf("Successfully downloaded: %s", info.Title)
+	return nil
+}
+
+// keepSeparate saves the downloaded video and audio streams as they are
+// (-keep-separate). The video is the job's main output; the audio is
+// finished alongside it.
+func (d *Downloader) keepSeparate(ctx context.Context, job *Job, video *youtube.Video, videoTemp, videoPath, audioTemp, audioPath string) error {
+	for _, f := range [][2]string{{videoTemp, videoPath}, {audioTemp, audioPath}} {
+		if err := os.Rename(f[0], f[1]); err != nil {
+			d.removePartial(videoTemp)
+			d.removePartial(audioTemp)
+			return fmt.Errorf("failed to save %s: %v", filepath.Base(f[1]), err)
+		}
+		d.temps.forget(f[0])
+	}
+	rights, _ := d.fetchTags(ctx, job, video)
+	d.writeSidecar(job, video, rights, videoPath)
+	return d.finishOutput(ctx, job, video, audioPath)
+}
+
+// saveFormat downloads the single format -f picked and keeps it as is,
+// remuxing only if the output container differs from the stream's.
+func (d *Downloader) saveFormat(ctx context.Context, job *Job, video *youtube.Video, format *youtube.Format, tempPath, finalPath string) (int64, error) {
+	job.addTotal(d.fetchLength(job, video, format))
+	job.setPhase(PhaseDownloadingVideo)
+	if !hasVideo(format) {
+		job.setPhase(PhaseDownloadingAudio)
+	}
+	fetched, err := d.fetchFormat(ctx, job, video, format, tempPath, video.Title)
+	if err != nil {
+		return 0, err
+	}
 
-		// Clean up temporary files
-		os.Remove(videoTempPath)
-		os.Remove(audioTempPath)
+	rights, _ := d.fetchTags(ctx, job, video)
+	if strings.TrimPrefix(filepath.Ext(finalPath), ".") == streamExtension(format) {
+		if err := os.Rename(tempPath, finalPath); err != nil {
+			d.removePartial(tempPath)
+			return 0, fmt.Errorf("failed to save %s: %v", filepath.Base(finalPath), err)
+		}
+		d.temps.forget(tempPath)
 	} else {
-		// MP3 only download
-		stream, _, err := d.client.GetStream(video, audioFormat)
+		job.setPhase(PhaseMerging)
+		err := d.writeOutput(ctx, finalPath, func(out mediaOutput) error {
+			out.progress = job.processProgress(video.Duration)
+			return d.media().Remux(ctx, tempPath, 0, out)
+		})
 		if err != nil {
-			return fmt.Errorf("failed to get stream: %v", err)
+			d.removePartial(tempPath)
+			return 0, fmt.Errorf("failed to remux %s: %w", filepath.Base(finalPath), err)
 		}
-		defer stream.Close()
+		d.discardTemp(tempPath)
+	}
+	d.writeSidecar(job, video, rights, finalPath)
+	return fetched, nil
+}
 
-		if err := d.downloadStreamToFile(stream, tempPath, info.Title); err != nil {
-			return err
+// outputLength is how much media a job's output holds.
+func outputLength(job *Job, video *youtube.Video) time.Duration {
+	if job.clip != nil {
+		return job.clip.length()
+	}
+	return video.Duration
+}
+
+// fetchTags looks up the license and category when the info.json or
+// embedded tags need them, and returns the ffmpeg arguments for the tags.
+func (d *Downloader) fetchTags(ctx context.Context, job *Job, video *youtube.Video) (videoRights, []string) {
+	var rights videoRights
+	if !d.config.WriteInfoJSON && !d.config.EmbedMetadata {
+		return rights, nil
+	}
+	job.setPhase(PhaseTagging)
+	rights, err := d.fetchRights(ctx, video)
+	if err != nil {
+		d.logger.Printf("Failed to look up license for %s: %v", video.Title, err)
+	}
+	if !d.config.EmbedMetadata {
+		return rights, nil
+	}
+	return rights, metadataArgs(video, rights, d.config.Dates)
+}
+
+func (d *Downloader) writeSidecar(job *Job, video *youtube.Video, rights videoRights, path string) {
+	if !d.config.WriteInfoJSON && !d.config.WriteNFO && !d.config.WriteDescription &&
+		!d.config.WriteComments && !d.config.WriteThumbnail && !d.config.WriteSubs {
+		return
+	}
+	job.setPhase(PhaseTagging)
+	if d.config.WriteNFO {
+		d.writeNFO(video, path)
+	}
+	if d.config.WriteDescription {
+		d.writeDescription(video, path)
+	}
+	if d.config.WriteComments {
+		d.writeComments(job.ctx, video, path)
+	}
+	if d.config.WriteThumbnail {
+		d.writeThumbnail(job.ctx, video, path)
+	}
+	if d.config.WriteSubs {
+		d.writeSubtitles(job.ctx, video, path)
+	}
+	if !d.config.WriteInfoJSON {
+		return
+	}
+	sidecar := newInfoJSON(video, path)
+	// upload_date keeps its fixed layout, which other tools parse
+	if !video.PublishDate.IsZero() {
+		sidecar.UploadDate = d.config.Dates.in(video.PublishDate).Format(compactDateLayout)
+	}
+	sidecar.setRights(video, rights)
+	if err := writeInfoJSON(sidecar, path); err != nil {
+		d.logger.Printf("Failed to write info.json for %s: %v", video.Title, err)
+	}
+}
+
+// sidecarPaths lists where the enabled sidecars of a download at path
+// are written.
+func (d *Downloader) sidecarPaths(video *youtube.Video, path string) []string {
+	var paths []string
+	if d.config.WriteInfoJSON {
+		paths = append(paths, infoJSONPath(path))
+	}
+	if d.config.WriteNFO {
+		paths = append(paths, nfoPath(path))
+	}
+	if d.config.WriteDescription {
+		paths = append(paths, descriptionPath(path))
+	}
+	if d.config.WriteComments {
+		paths = append(paths, commentsPath(path))
+	}
+	if d.config.WriteThumbnail {
+		if thumbnail := largestThumbnail(video.Thumbnails); thumbnail != "" {
+			paths = append(paths, thumbnailPath(path, thumbnail))
 		}
+	}
+	if d.config.WriteSubs {
+		paths = append(paths, d.subtitlePaths(video, path)...)
+	}
+	return paths
+}
 
-		if err := d.convertToMP3(tempPath, finalPath); err != nil {
-			os.Remove(tempPath)
+// finishOutput moves a completed file and its sidecars to -dest, copies
+// them to the extra destinations, and then indexes the file and runs the
+// after-download hook.
+func (d *Downloader) finishOutput(ctx context.Context, job *Job, video *youtube.Video, path string) error {
+	var sidecars []string
+	for _, sidecar := range d.sidecarPaths(video, path) {
+		// A sidecar that failed to write has already been logged
+		if fileExists(sidecar) {
+			sidecars = append(sidecars, sidecar)
+		}
+	}
+	parts, err := d.splitOutput(ctx, job, video, path)
+	if err != nil {
+		return err
+	}
+	if parts == nil {
+		return d.finishFile(ctx, job, video, path, sidecars)
+	}
+	// The sidecars describe the whole video and go with its first part
+	for i, part := range parts {
+		if i > 0 {
+			sidecars = nil
+		}
+		if err := d.finishFile(ctx, job, video, part, sidecars); err != nil {
 			return err
 		}
-		os.Remove(tempPath)
 	}
+	return nil
+}
 
-	d.logger.Printf("Successfully downloaded: %s", info.Title)
+// finishFile finishes one output file of a download, with its sidecars.
+func (d *Downloader) finishFile(ctx context.Context, job *Job, video *youtube.Video, path string, sidecars []string) error {
+	files := append([]string{path}, sidecars...)
+	// An output streamed to -dest has no local file; its size and checksum
+	// were taken on the way
+	size, sum, streamed := d.takeStreamed(path)
+	written, err := d.writeChecksum(path, sum)
+	if err != nil {
+		d.logger.Printf("Failed to record checksum of %s: %v", filepath.Base(path), err)
+	} else if d.config.Checksums == "sidecar" {
+		files = append(files, sha256Path(path))
+	}
+	if !streamed {
+		sum = written
+		// The history needs these from the local file, which -dest moves away
+		if d.history != nil {
+			if stat, err := os.Stat(path); err == nil {
+				size = stat.Size()
+			}
+			if sum == "" {
+				sum, _ = hashFile(path)
+			}
+		}
+	}
+
+	location := path
+	if d.config.Dest != nil {
+		for _, file := range files {
+			if err := d.storeLocal(ctx, job, file); err != nil {
+				return err
+			}
+		}
+		location = storageLocation(d.config.Dest, d.storageName(path))
+		job.setOutputPath(location)
+	} else {
+		for _, file := range files {
+			if err := d.fanOut(ctx, job, file); err != nil {
+				return err
+			}
+		}
+	}
+
+	d.indexOutput(ctx, video, location)
+	d.recordHistory(ctx, job, video, location, size, sum)
+	d.afterDownload(ctx, video, location)
 	return nil
 }
 
-func (d *Downloader) ProcessPlaylist(playlistURL string) error {
-	playlist, err := d.client.GetPlaylist(playlistURL)
+// ProcessPlaylist downloads every video of a playlist. A failed video
+// doesn't stop the others; the error is only for a playlist that could
+// not be read, and per-video outcomes are in the result.
+func (d *Downloader) ProcessPlaylist(playlistURL string) (*PlaylistResult, error) {
+	source, ids, positions, err := d.listPlaylist(playlistURL)
 	if err != nil {
-		return fmt.Errorf("failed to get playlist: %v", err)
+		return nil, err
 	}
+	return d.processBatch(source, ids, positions), nil
+}
 
-	var wg sync.WaitGroup
-	errors := make(chan error, len(playlist.Videos))
+// ProcessChannel downloads a channel's uploads, newest first, stopping
+// after limit of them if it is positive.
+func (d *Downloader) ProcessChannel(channel string, limit int) (*PlaylistResult, error) {
+	source, ids, err := d.channelUploads(channel)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return d.processBatch(source, ids, nil), nil
+}
 
-	for _, entry := range playlist.Videos {
-		video, err := d.client.GetVideo(entry.ID)
-		if err != nil {
-			errors <- fmt.Errorf("failed to get video %s: %v", entry.ID, err)
+// batchSource is where a batch of videos came from. URL is empty for
+// videos given directly and for a channel's uploads, which aren't
+// numbered since every new upload would shift them.
+type batchSource struct {
+	URL     string
+	Title   string // of the playlist
+	Channel string
+	Size    int // entries in the playlist, before filtering
+	// Chosen when the batch was submitted to serve, in place of -f
+	Format *formatSpec
+	// The serve API user who submitted the batch, the directory under
+	// the output directory their downloads go to and their download slots
+	User   string
+	Subdir string
+	Slots  *scheduler
+	// Which batches' downloads go first when slots are short
+	Priority jobPriority
+}
+
+// playlistIDs returns the video IDs of a playlist in playlist order.
+func (d *Downloader) playlistIDs(playlistURL string) ([]string, error) {
+	_, ids, _, err := d.listPlaylist(playlistURL)
+	return ids, err
+}
+
+// listPlaylist returns the video IDs of a playlist in playlist order with
+// their positions. Entries whose titles are filtered out are left out
+// here, before any of their metadata is fetched.
+func (d *Downloader) listPlaylist(playlistURL string) (batchSource, []string, []int, error) {
+	playlist, err := d.client.GetPlaylistContext(context.Background(), playlistURL)
+	if err != nil {
+		return batchSource{}, nil, nil, fmt.Errorf("failed to get playlist: %v", err)
+	}
+	source := batchSource{URL: playlistURL, Title: playlist.Title, Channel: playlist.Author, Size: len(playlist.Videos)}
+
+	ids := make([]string, 0, len(playlist.Videos))
+	positions := make([]int, 0, len(playlist.Videos))
+	for i, entry := range playlist.Videos {
+		if reason := d.config.Filter.rejectTitle(entry.Title); reason != "" {
+			d.logger.Printf("Skipping %s: %s", entry.Title, reason)
 			continue
 		}
+		ids = append(ids, entry.ID)
+		positions = append(positions, i+1)
+	}
+	return source, ids, positions, nil
+}
+
+// ProcessVideos fetches and downloads a list of video IDs or URLs concurrently.
+func (d *Downloader) ProcessVideos(ids []string) error {
+	return d.processBatch(batchSource{}, ids, nil).Err()
+}
 
-		wg.Add(1)
-		go func(v *youtube.Video) {
-			if err := d.downloadVideo(context.Background(), v, &wg); err != nil {
-				errors <- err
+// processBatch downloads ids concurrently and reports each one. Jobs are
+// created and started as earlier ones finish, pipelineDepth at a time, so
+// a long playlist costs no more memory than a short one. Once MaxFailures
+// downloads have failed, the queued entries are canceled. A batch from a
+// playlist is numbered by positions, or in order when positions is nil.
+func (d *Downloader) processBatch(source batchSource, ids []string, positions []int) *PlaylistResult {
+	result := &PlaylistResult{URL: source.URL}
+
+	var mu sync.Mutex
+	var jobs []*Job
+	failures := 0
+	tripBreaker := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		failures++
+		if d.config.MaxFailures <= 0 || failures < d.config.MaxFailures || result.Aborted {
+			return
+		}
+		result.Aborted = true
+		d.logger.Printf("Stopping after %d failed downloads (-max-failures)", failures)
+		for _, job := range jobs {
+			if job.Snapshot().Status == JobQueued {
+				job.Cancel()
 			}
-		}(video)
+		}
 	}
 
-	go func() {
-		wg.Wait()
-		close(errors)
-	}()
+	d.jobsMu.Lock()
+	d.batches++
+	batch := d.batches
+	d.jobsMu.Unlock()
+
+	var wg sync.WaitGroup
+	window := newSemaphore(d.pipelineDepth())
+	for i, id := range ids {
+		// Each -section of a video is downloaded as its own job
+		sections := []*clipRange{nil}
+		if len(d.config.Sections) > 0 && !isClipURL(id) {
+			sections = sections[:0]
+			for _, section := range d.config.Sections {
+				sections = append(sections, &section)
+			}
+		}
+		for _, section := range sections {
+			window.setLimit(d.pipelineDepth())
+			window.acquire()
+
+			job := d.addJob(id, source, batch)
+			job.clip = section
+			switch {
+			case positions != nil:
+				job.Index = positions[i]
+			case source.URL != "":
+				job.Index = i + 1
+			}
+			mu.Lock()
+			jobs = append(jobs, job)
+			if result.Aborted {
+				job.Cancel()
+			}
+			mu.Unlock()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer window.release()
+				defer func() {
+					// Don't leave temp files behind if a download crashes
+					if r := recover(); r != nil {
+						d.temps.removeAll()
+						panic(r)
+					}
+				}()
+				failed := d.processJob(job)
+				d.releaseStdout(job)
+				if failed {
+					tripBreaker()
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	d.writePlaylistFile(source, jobs)
+	d.refreshMediaServers(jobs)
 
-	var downloadErrors []error
-	for err := range errors {
+	for i, job := range jobs {
+		index := job.Index
+		if index == 0 {
+			index = i + 1
+		}
+		result.Entries = append(result.Entries, newEntryResult(index, job.Snapshot()))
+	}
+	return result
+}
+
+// pipelineDepth is how many jobs of a batch run at once: one for each
+// download slot and each metadata fetch, so the next videos are resolved
+// while others download.
+func (d *Downloader) pipelineDepth() int {
+	return d.sched.capacity() + d.metaGuard.capacity()
+}
+
+// processJob fetches and downloads one job's video and reports whether
+// it failed.
+func (d *Downloader) processJob(job *Job) bool {
+	if err := d.runSize.exhausted(); err != nil {
+		job.finish(err)
+		return false
+	}
+	if err := d.stdoutTaken(); err != nil {
+		job.finish(err)
+		return false
+	}
+	videoURL := job.URL
+	if isClipURL(job.URL) {
+		clip, err := d.resolveClip(job.ctx, job.URL)
 		if err != nil {
-			downloadErrors = append(downloadErrors, err)
+			job.finish(err)
+			d.notifyJob(job)
+			return job.Snapshot().Status == JobFailed
+		}
+		job.clip = clip
+		videoURL = clip.videoID
+	} else if id, err := youtube.ExtractVideoID(job.URL); err == nil && job.clip == nil {
+		if d.archive != nil && d.archive.Has(id) {
+			d.logger.Printf("Skipping %s: already in download archive", id)
+			job.finish(errSkipped)
+			return false
+		}
+		if d.watched[id] {
+			d.logger.Printf("Skipping %s: already watched", id)
+			job.finish(errSkipped)
+			return false
 		}
 	}
 
-	if len(downloadErrors) > 0 {
-		return fmt.Errorf("encountered errors during download: %v", downloadErrors)
+	// Metadata fetches are limited separately from stream downloads
+	job.setPhase(PhaseResolving)
+	d.metaGuard.acquire()
+	video, err := d.client.GetVideoContext(job.ctx, videoURL)
+	d.metaGuard.release()
+	if err != nil && d.config.WaitForLive && isUpcoming(err) {
+		video, err = d.waitForLive(job.ctx, videoURL)
 	}
+	if err != nil {
+		if job.ctx.Err() != nil {
+			// Canceled while resolving, which isn't a problem with the video
+			err = job.ctx.Err()
+		} else if cerr := classifyVideoError(err); isUnavailable(cerr) {
+			// Retrying won't help, so a playlist just moves on
+			d.logger.Printf("Skipping %s: %v", job.URL, cerr)
+			err = &skipError{err: cerr}
+		} else {
+			err = fmt.Errorf("failed to get video %s: %w", job.URL, cerr)
+		}
+		job.finish(err)
+		d.notifyJob(job)
+		return job.Snapshot().Status == JobFailed
+	}
+	job.setVideo(video)
 
-	return nil
+	if reason := d.config.Filter.reject(video); reason != "" {
+		d.logger.Printf("Skipping %s: %s", video.Title, reason)
+		job.finish(errSkipped)
+		return false
+	}
+	if job.clip != nil && job.clip.videoID == "" {
+		if err := fitSection(job.clip, video); err != nil {
+			job.finish(err)
+			d.notifyJob(job)
+			return true
+		}
+	}
+
+	err = d.downloadVideo(job.ctx, job, video)
+	for slow := (*tooSlowError)(nil); errors.As(err, &slow); {
+		d.logger.Printf("%s: %v, over the -time-budget of %s; retrying at a lower resolution", video.Title, slow, formatDuration(d.config.TimeBudget))
+		job.maxHeight = slow.height - 1
+		job.resetProgress()
+		err = d.downloadVideo(job.ctx, job, video)
+	}
+	// A clip doesn't count as having the whole video
+	if err == nil && d.archive != nil && job.clip == nil {
+		if aerr := d.archive.Add(video.ID); aerr != nil {
+			d.logger.Printf("Failed to update download archive: %v", aerr)
+		}
+	}
+	job.finish(err)
+	d.dumpJSON(job, video)
+	d.notifyJob(job)
+	return job.Snapshot().Status == JobFailed
 }
 
-func (d *Downloader) downloadStreamToFile(stream io.Reader, filepath string, label string) error {
-	out, err := os.Create(filepath)
+// downloadStreamToFile writes stream to filepath, appending when offset is
+// non-zero, and returns the resulting file size. size is the expected
+// final size, or 0 if it isn't known.
+func (d *Downloader) downloadStreamToFile(job *Job, stream io.Reader, filepath string, label string, offset, size int64) (int64, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(filepath, flags, 0644)
 	if err != nil {
-		return fmt.Errorf("failed to create file: %v", err)
+		return 0, fmt.Errorf("failed to create file: %v", err)
 	}
-	defer out.Close()
+	d.trackTemp(filepath)
+	out := d.newDiskWriter(f, offset, size)
 
 	d.logger.Printf("Downloading %s", label)
-	_, err = io.Copy(out, stream)
-	return err
+	n, err := io.Copy(out, &progressReader{job: job, r: stream, limiter: d.limiter})
+	if cerr := out.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("failed to write %s: %v", label, cerr)
+	}
+	return offset + n, err
 }
 
-func (d *Downloader) mergeVideoAudio(videoPath, audioPath, outputPath string) error {
+func (d *Downloader) mergeVideoAudio(ctx context.Context, videoPath, audioPath, outputPath string, sel formatSelection, tags []string, progress func(time.Duration)) error {
+	d.ffmpegGuard.acquire()
+	defer d.ffmpegGuard.release()
+
 	d.logger.Printf("Merging video and audio streams...")
-	cmd := exec.Command("ffmpeg",
-		"-i", videoPath,
-		"-i", audioPath,
-		"-c:v", "copy",
-		"-c:a", "aac",
-		"-strict", "experimental",
-		"-y",
-		outputPath,
-	)
-	return cmd.Run()
+	codecArgs := mergeCodecArgs(sel, d.config.RemuxTo != "")
+	if sel.crop != "" {
+		d.logger.Printf("Re-encoding to remove black bars: %s", strings.Join(codecArgs, " "))
+	} else if sel.clip != nil {
+		d.logger.Printf("Cutting %s and re-encoding: %s", sel.clip.label(), strings.Join(codecArgs, " "))
+	} else if slices.ContainsFunc(codecArgs, func(a string) bool { return strings.HasPrefix(a, "lib") || a == "aac" }) {
+		d.logger.Printf("Re-encoding to fit the %s container: %s", sel.container, strings.Join(codecArgs, " "))
+	}
+	spec := mergeSpec{videoPath: videoPath, audioPath: audioPath, sel: sel, codecArgs: codecArgs, tags: tags}
+	merge := func() error {
+		return d.writeOutput(ctx, outputPath, func(out mediaOutput) error {
+			out.progress = progress
+			return d.media().Merge(ctx, spec, out)
+		})
+	}
+	var err error
+	if hw := d.hwCodecArgs(codecArgs); hw != nil {
+		spec.codecArgs = hw
+		if err = merge(); err != nil && ctx.Err() == nil {
+			// A probe passing doesn't guarantee every input encodes
+			d.logger.Printf("Hardware encoding failed, retrying in software: %v", err)
+			spec.codecArgs = codecArgs
+			err = merge()
+		}
+	} else {
+		err = merge()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to merge video and audio: %w", err)
+	}
+	return nil
 }
 
 func (d *Downloader) downloadWithProgress(stream io.Reader, out *os.File, size int64, title string) error {
@@ -278,68 +1261,58 @@ func (d *Downloader) downloadWithProgress(stream io.Reader, out *os.File, size i
 	return nil
 }
 
-func (d *Downloader) convertToMP3(inputPath, outputPath string) error {
-	d.logger.Printf("Converting to MP3: %s", filepath.Base(outputPath))
+// extractAudio saves the audio of an audio-only download in the format
+// of outputPath's extension.
+func (d *Downloader) extractAudio(ctx context.Context, inputPath, outputPath string, source *youtube.Format, clip *clipRange, tags []string, progress func(time.Duration)) error {
+	d.ffmpegGuard.acquire()
+	defer d.ffmpegGuard.release()
 
-	cmd := exec.Command("ffmpeg", "-i", inputPath, "-vn", "-ab", "128k", "-ar", "44100", "-y", outputPath)
-	err := cmd.Run()
+	filter := d.loudnessFilter(ctx, inputPath, clip)
+	codecArgs := audioCodecArgs(source, strings.TrimPrefix(filepath.Ext(outputPath), "."), filter != "")
+	switch {
+	case codecArgs[0] == "-ab":
+		d.logger.Printf("Converting to MP3: %s", filepath.Base(outputPath))
+	case codecArgs[1] == "copy":
+		d.logger.Printf("Extracting audio without re-encoding: %s", filepath.Base(outputPath))
+	default:
+		d.logger.Printf("Re-encoding audio to %s: %s", codecArgs[1], filepath.Base(outputPath))
+	}
+	err := d.writeOutput(ctx, outputPath, func(out mediaOutput) error {
+		out.progress = progress
+		return d.media().Convert(ctx, inputPath, clip, codecArgs, filter, tags, out)
+	})
 	if err != nil {
-		return fmt.Errorf("ffmpeg conversion failed: %v", err)
+		return fmt.Errorf("ffmpeg conversion failed: %w", err)
 	}
 
 	return nil
 }
 
-func main() {
-	mp3Flag := flag.Bool("mp3", false, "Download as MP3 (audio only)")
-	outputDir := flag.String("output", "downloads", "Output directory")
-	flag.Parse()
-
-	args := flag.Args()
-	if len(args) != 1 {
-		fmt.Println("Usage: youtube-downloader [-mp3] [-output dir] <video_or_playlist_url>")
-		os.Exit(1)
-	}
-
-	if *mp3Flag {
-		if _, err := exec.LookPath("ffmpeg"); err != nil {
-			log.Fatal("ffmpeg is required for MP3 conversion but it's not installed")
+// media returns the ffmpeg binary, or the built-in muxer if there is
+// none. It is looked up on first use, so commands that never merge don't
+// run ffmpeg or warn about it.
+func (d *Downloader) media() FFmpeg {
+	d.ffmpegOnce.Do(func() {
+		if d.ffmpeg == nil {
+			d.ffmpeg = detectFFmpeg(d.logger, d.config)
 		}
-	}
-
-	config := Config{
-		OutputDir:     *outputDir,
-		MaxConcurrent: 3,
-		Quality:       "best",
-		MetadataOnly:  false,
-		MP3Only:       *mp3Flag,
-	}
+	})
+	return d.ffmpeg
+}
 
-	if err := os.MkdirAll(config.OutputDir, 0755); err != nil {
-		log.Fatalf("Failed to create output directory: %v", err)
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(exitTotalFailure)
 	}
 
-	downloader := NewDownloader(config)
-
-	url := args[0]
-	var err error
-
-	if strings.Contains(url, "playlist?list=") {
-		err = downloader.ProcessPlaylist(url)
-	} else {
-		video, err := downloader.client.GetVideo(url)
-		if err != nil {
-			log.Fatalf("Error getting video: %v", err)
-		}
-		var wg sync.WaitGroup
-		wg.Add(1)
-		err = downloader.downloadVideo(context.Background(), video, &wg)
-		wg.Wait()
+	// Without a command, arguments are passed to download as they were
+	// before the CLI had subcommands
+	cmd, args := findCommand(os.Args[1]), os.Args[2:]
+	if cmd == nil {
+		cmd, args = findCommand("download"), os.Args[1:]
 	}
-
-	if err != nil {
-		log.Fatalf("Error processing: %v", err)
+	if err := runCommand(cmd, args); err != nil {
+		exitWith(err)
 	}
-
-	fmt.Println("Download completed successfully!")
 }
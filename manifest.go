@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type ManifestEntry struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Channel  string `json:"channel"`
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"sha256,omitempty"`
+	Missing  bool   `json:"missing,omitempty"` // recorded in the history but not on disk
+}
+
+// buildManifest walks dir and returns one entry per media file, filling in
+// video metadata from info.json sidecars where they exist and from the
+// download history h, if not nil, where they don't. Downloads the history
+// records under dir whose files are gone are listed as missing, with the
+// size and checksum they were recorded with.
+func buildManifest(ctx context.Context, dir string, checksums bool, h *history) ([]ManifestEntry, error) {
+	var entries []ManifestEntry
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	// The newest download recorded at each path under dir
+	recorded := map[string]HistoryEntry{}
+	if h != nil {
+		all, err := h.Entries(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read history: %v", err)
+		}
+		for _, e := range all {
+			rel, err := filepath.Rel(absDir, e.Path)
+			if err != nil || !filepath.IsLocal(rel) {
+				continue
+			}
+			if _, ok := recorded[rel]; !ok {
+				recorded[rel] = e
+			}
+		}
+	}
+
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != dir && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isMediaFile(path) {
+			return nil
+		}
+
+		fileInfo, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			rel = path
+		}
+
+		m := ManifestEntry{
+			Title: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+			Path:  filepath.ToSlash(rel),
+			Size:  fileInfo.Size(),
+		}
+		e, inHistory := recorded[rel]
+		delete(recorded, rel)
+		if info, err := readInfoJSON(path); err == nil {
+			m.ID = info.ID
+			m.Title = info.Title
+			m.Channel = info.Channel
+		} else if inHistory {
+			m.ID = e.VideoID
+			m.Title = e.Title
+			m.Channel = e.Channel
+		}
+		if checksums {
+			if m.Checksum, err = fileSHA256(path); err != nil {
+				return fmt.Errorf("failed to checksum %s: %v", path, err)
+			}
+		} else if inHistory && e.Size == m.Size {
+			// The recorded sum still stands for a file of the same size
+			m.Checksum = e.SHA256
+		}
+
+		entries = append(entries, m)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	missing := make([]string, 0, len(recorded))
+	for rel := range recorded {
+		missing = append(missing, rel)
+	}
+	slices.Sort(missing)
+	for _, rel := range missing {
+		e := recorded[rel]
+		entries = append(entries, ManifestEntry{
+			ID:       e.VideoID,
+			Title:    e.Title,
+			Channel:  e.Channel,
+			Path:     filepath.ToSlash(rel),
+			Size:     e.Size,
+			Checksum: e.SHA256,
+			Missing:  true,
+		})
+	}
+	return entries, nil
+}
+
+func isMediaFile(path string) bool {
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, ".") || strings.Contains(name, "_temp.") {
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".mp4", ".mp3", ".m4a", ".webm", ".mkv", ".opus":
+		return true
+	}
+	return false
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func writeManifestCSV(w io.Writer, entries []ManifestEntry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "title", "channel", "path", "size", "sha256", "missing"})
+	for _, e := range entries {
+		cw.Write([]string{e.ID, e.Title, e.Channel, e.Path, strconv.FormatInt(e.Size, 10), e.Checksum, strconv.FormatBool(e.Missing)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeManifestJSON(w io.Writer, entries []ManifestEntry) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+func runManifest(args []string) error {
+	flags := flag.NewFlagSet("manifest", flag.ExitOnError)
+	format := flags.String("format", "csv", "Manifest format: csv or json")
+	output := flags.String("o", "", "Write manifest to file instead of stdout")
+	noChecksum := flags.Bool("no-checksum", false, "Skip SHA-256 computation and use the checksums recorded in the history")
+	historyPath := flags.String("history", defaultHistoryPath(), "Cross-reference this download history (empty to skip)")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: youtube-downloader manifest [-format csv|json] [-o file] [-history db] <dir>")
+	}
+
+	var h *history
+	if *historyPath != "" && fileExists(*historyPath) {
+		var err error
+		if h, err = openHistory(*historyPath); err != nil {
+			return fmt.Errorf("failed to open history: %v", err)
+		}
+		defer h.Close()
+	}
+
+	entries, err := buildManifest(context.Background(), flags.Arg(0), !*noChecksum, h)
+	if err != nil {
+		return fmt.Errorf("failed to build manifest: %v", err)
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create manifest file: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	switch *format {
+	case "csv":
+		return writeManifestCSV(out, entries)
+	case "json":
+		return writeManifestJSON(out, entries)
+	default:
+		return fmt.Errorf("unknown manifest format %q", *format)
+	}
+}
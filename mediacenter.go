@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// nfoMovie is the Kodi/Jellyfin .nfo written next to a download with
+// -write-nfo.
+type nfoMovie struct {
+	XMLName   xml.Name    `xml:"movie"`
+	Title     string      `xml:"title"`
+	Plot      string      `xml:"plot,omitempty"`
+	Studio    string      `xml:"studio,omitempty"`
+	Premiered string      `xml:"premiered,omitempty"`
+	Runtime   int         `xml:"runtime,omitempty"` // minutes
+	Thumb     string      `xml:"thumb,omitempty"`
+	UniqueID  nfoUniqueID `xml:"uniqueid"`
+}
+
+type nfoUniqueID struct {
+	Type    string `xml:"type,attr"`
+	Default bool   `xml:"default,attr"`
+	ID      string `xml:",chardata"`
+}
+
+func nfoPath(mediaPath string) string {
+	return strings.TrimSuffix(mediaPath, filepath.Ext(mediaPath)) + ".nfo"
+}
+
+func (d *Downloader) writeNFO(video *youtube.Video, mediaPath string) {
+	nfo := nfoMovie{
+		Title:    video.Title,
+		Plot:     video.Description,
+		Studio:   video.Author,
+		Runtime:  int(video.Duration.Minutes() + 0.5),
+		Thumb:    largestThumbnail(video.Thumbnails),
+		UniqueID: nfoUniqueID{Type: "youtube", Default: true, ID: video.ID},
+	}
+	// Media centers only parse ISO dates, so -date-format doesn't apply
+	if !video.PublishDate.IsZero() {
+		nfo.Premiered = d.config.Dates.in(video.PublishDate).Format(isoDateLayout)
+	}
+	data, err := xml.MarshalIndent(nfo, "", "  ")
+	if err == nil {
+		data = append([]byte(xml.Header), data...)
+		err = os.WriteFile(nfoPath(mediaPath), data, 0644)
+	}
+	if err != nil {
+		d.logger.Printf("Failed to write .nfo for %s: %v", video.Title, err)
+	}
+}
+
+// thumbnailPath is where a download's thumbnail is saved, keeping the
+// image's own extension.
+func thumbnailPath(mediaPath, thumbnailURL string) string {
+	ext := ".jpg"
+	if u, err := url.Parse(thumbnailURL); err == nil && strings.HasSuffix(u.Path, ".webp") {
+		ext = ".webp"
+	}
+	return strings.TrimSuffix(mediaPath, filepath.Ext(mediaPath)) + ext
+}
+
+func (d *Downloader) writeThumbnail(ctx context.Context, video *youtube.Video, mediaPath string) {
+	thumbnail := largestThumbnail(video.Thumbnails)
+	if thumbnail == "" {
+		d.logger.Printf("No thumbnail for %s", video.Title)
+		return
+	}
+	if err := d.fetchFile(ctx, thumbnail, thumbnailPath(mediaPath, thumbnail)); err != nil {
+		d.logger.Printf("Failed to save thumbnail for %s: %v", video.Title, err)
+	}
+}
+
+func largestThumbnail(thumbnails youtube.Thumbnails) string {
+	var best youtube.Thumbnail
+	for _, t := range thumbnails {
+		if t.Width*t.Height >= best.Width*best.Height {
+			best = t
+		}
+	}
+	return best.URL
+}
+
+// writePlaylistFile writes an .m3u8 listing a playlist's downloaded
+// files in playlist order, next to the files with -organize playlist and
+// in the output directory otherwise. Entries are relative to it, so the
+// directory can be moved as a whole.
+func (d *Downloader) writePlaylistFile(source batchSource, jobs []*Job) {
+	if source.URL == "" {
+		return
+	}
+	if d.config.Dest != nil {
+		// Outputs are remote and there is no local directory to index
+		return
+	}
+	san := d.sanitizer()
+	dir := filepath.Join(d.config.OutputDir, source.Subdir)
+	if d.config.Organize == "playlist" && source.Title != "" {
+		dir = filepath.Join(dir, san.field(source.Title))
+	}
+	name := san.path(san.field(source.Title), "playlist") + ".m3u8"
+
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	entries := 0
+	for _, job := range jobs {
+		snap := job.Snapshot()
+		if snap.OutputPath == "" || (snap.Status != JobDone && snap.Status != JobSkipped) || !fileExists(snap.OutputPath) {
+			continue
+		}
+		rel, err := filepath.Rel(dir, snap.OutputPath)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&sb, "#EXTINF:%d,%s\n%s\n", int(snap.Duration.Seconds()), snap.Title, filepath.ToSlash(rel))
+		entries++
+	}
+	if entries == 0 {
+		return
+	}
+
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		d.logger.Printf("Failed to write %s: %v", path, err)
+		return
+	}
+	if err := os.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+		d.logger.Printf("Failed to write %s: %v", path, err)
+		return
+	}
+	d.logger.Printf("Wrote playlist %s with %d entries", path, entries)
+}
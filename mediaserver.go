@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// mediaServer is a Plex or Jellyfin server told to rescan its library
+// after a batch adds files.
+type mediaServer struct {
+	name string
+	// request builds the refresh request
+	request func(ctx context.Context) (*http.Request, error)
+}
+
+// mediaServers returns the servers configured with -plex-url and
+// -jellyfin-url.
+func (d *Downloader) mediaServers() []mediaServer {
+	var servers []mediaServer
+	if base := d.config.PlexURL; base != "" {
+		servers = append(servers, mediaServer{name: "Plex", request: func(ctx context.Context) (*http.Request, error) {
+			section := d.config.PlexSection
+			if section == "" {
+				section = "all"
+			}
+			target := strings.TrimSuffix(base, "/") + "/library/sections/" + url.PathEscape(section) + "/refresh"
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("X-Plex-Token", d.config.PlexToken)
+			return req, nil
+		}})
+	}
+	if base := d.config.JellyfinURL; base != "" {
+		servers = append(servers, mediaServer{name: "Jellyfin", request: func(ctx context.Context) (*http.Request, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(base, "/")+"/Library/Refresh", nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("X-Emby-Token", d.config.JellyfinAPIKey)
+			return req, nil
+		}})
+	}
+	return servers
+}
+
+// refreshMediaServers asks the configured media servers to scan for new
+// files. Failures are logged; the downloads themselves succeeded.
+func (d *Downloader) refreshMediaServers(jobs []*Job) {
+	servers := d.mediaServers()
+	if len(servers) == 0 {
+		return
+	}
+	added := false
+	for _, job := range jobs {
+		if job.Snapshot().Status == JobDone {
+			added = true
+			break
+		}
+	}
+	if !added {
+		return
+	}
+
+	for _, server := range servers {
+		if err := refreshMediaServer(server); err != nil {
+			d.logger.Printf("%s library refresh failed: %v", server.name, err)
+		} else {
+			d.logger.Printf("Asked %s to refresh its library", server.name)
+		}
+	}
+}
+
+func refreshMediaServer(server mediaServer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	req, err := server.request(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Histogram buckets in seconds. Downloads run from seconds to hours;
+// ffmpeg remuxes are usually quick but re-encodes are not.
+var (
+	downloadBuckets = []float64{5, 15, 30, 60, 120, 300, 600, 1800, 3600, 7200}
+	ffmpegBuckets   = []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800}
+)
+
+// histogram is a Prometheus-style cumulative histogram.
+type histogram struct {
+	buckets []float64
+	counts  []uint64 // per bucket, not cumulative
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var cumulative uint64
+	for i, upper := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, strconv.FormatFloat(upper, 'f', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'f', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+func (h *histogram) clone() *histogram {
+	c := *h
+	c.counts = slices.Clone(h.counts)
+	return &c
+}
+
+// jobTotals are the job counts the metrics endpoint reports.
+type jobTotals struct {
+	counts    map[JobStatus]int
+	bytes     int64
+	durations *histogram // of successful downloads
+}
+
+func (t *jobTotals) add(snap JobSnapshot) {
+	if t.counts == nil {
+		t.counts = make(map[JobStatus]int)
+		t.durations = newHistogram(downloadBuckets)
+	}
+	t.counts[snap.Status]++
+	t.bytes += snap.Downloaded
+	if snap.Status == JobDone {
+		t.durations.observe(snap.Elapsed.Seconds())
+	}
+}
+
+func (t jobTotals) clone() jobTotals {
+	if t.counts == nil {
+		return jobTotals{counts: make(map[JobStatus]int), durations: newHistogram(downloadBuckets)}
+	}
+	return jobTotals{counts: maps.Clone(t.counts), bytes: t.bytes, durations: t.durations.clone()}
+}
+
+// ffmpegTimings records how long each ffmpeg run took. Everything else
+// the metrics endpoint reports is derived from the job list, and the
+// totals of the jobs pruned from it, when scraped.
+type ffmpegTimings struct {
+	mu   sync.Mutex
+	hist *histogram
+}
+
+func (t *ffmpegTimings) observe(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.hist == nil {
+		t.hist = newHistogram(ffmpegBuckets)
+	}
+	t.hist.observe(d.Seconds())
+}
+
+func (t *ffmpegTimings) write(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	hist := t.hist
+	if hist == nil {
+		hist = newHistogram(ffmpegBuckets)
+	}
+	hist.write(w, "ytdl_ffmpeg_duration_seconds", "Time spent in each ffmpeg merge or conversion.")
+}
+
+func writeMetric(w io.Writer, name, kind, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, kind, name, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+// handleMetrics serves the Prometheus text exposition format.
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	totals := s.d.jobTotals()
+	counts, bytes, durations := totals.counts, totals.bytes, totals.durations
+	started := 0
+	for status, n := range counts {
+		if status != JobQueued {
+			started += n
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetric(w, "ytdl_downloads_started_total", "counter", "Downloads that have started.", float64(started))
+	writeMetric(w, "ytdl_downloads_completed_total", "counter", "Downloads that finished successfully.", float64(counts[JobDone]))
+	writeMetric(w, "ytdl_downloads_failed_total", "counter", "Downloads that failed.", float64(counts[JobFailed]))
+	writeMetric(w, "ytdl_downloads_skipped_total", "counter", "Downloads skipped because the output already existed.", float64(counts[JobSkipped]))
+	writeMetric(w, "ytdl_downloads_canceled_total", "counter", "Downloads canceled before finishing.", float64(counts[JobCanceled]))
+	writeMetric(w, "ytdl_downloaded_bytes_total", "counter", "Bytes of stream data downloaded.", float64(bytes))
+	writeMetric(w, "ytdl_queue_depth", "gauge", "Downloads waiting to start.", float64(counts[JobQueued]))
+	writeMetric(w, "ytdl_downloads_active", "gauge", "Downloads in progress.", float64(counts[JobRunning]))
+	durations.write(w, "ytdl_download_duration_seconds", "Time taken by each successful download.")
+	s.d.ffmpegTimes.write(w)
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// This file merges the fragmented MP4 streams YouTube serves for DASH
+// (ftyp, moov, sidx, then moof/mdat pairs) into one fragmented MP4
+// without ffmpeg. Samples are copied untouched: the video's moov gains
+// the audio track, and the fragments of both are interleaved by time
+// with their track IDs renumbered.
+
+// mp4Box locates a box within a file or buffer.
+type mp4Box struct {
+	typ    string
+	offset int64 // of the header
+	size   int64 // including the header
+	hdr    int64
+}
+
+// readBoxes lists the boxes between start and end.
+func readBoxes(r io.ReaderAt, start, end int64) ([]mp4Box, error) {
+	var boxes []mp4Box
+	var hdr [16]byte
+	for off := start; off < end; {
+		if end-off < 8 {
+			return nil, fmt.Errorf("truncated box at offset %d", off)
+		}
+		if _, err := r.ReadAt(hdr[:8], off); err != nil {
+			return nil, err
+		}
+		box := mp4Box{typ: string(hdr[4:8]), offset: off, size: int64(binary.BigEndian.Uint32(hdr[:4])), hdr: 8}
+		switch box.size {
+		case 0:
+			box.size = end - off
+		case 1:
+			if _, err := r.ReadAt(hdr[8:16], off+8); err != nil {
+				return nil, err
+			}
+			box.size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			box.hdr = 16
+		}
+		if box.size < box.hdr || box.size > end-off {
+			return nil, fmt.Errorf("invalid %q box at offset %d", box.typ, off)
+		}
+		boxes = append(boxes, box)
+		off += box.size
+	}
+	return boxes, nil
+}
+
+// childBoxes lists the boxes inside a container box held in memory.
+func childBoxes(box []byte) ([]mp4Box, error) {
+	hdr := int64(8)
+	if binary.BigEndian.Uint32(box) == 1 {
+		hdr = 16
+	}
+	return readBoxes(bytes.NewReader(box), hdr, int64(len(box)))
+}
+
+// findBox follows path down from a container box and returns the first
+// matching box, sharing box's memory so it can be patched in place.
+func findBox(box []byte, path ...string) []byte {
+	for _, typ := range path {
+		children, err := childBoxes(box)
+		if err != nil {
+			return nil
+		}
+		var next []byte
+		for _, c := range children {
+			if c.typ == typ {
+				next = box[c.offset : c.offset+c.size]
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		box = next
+	}
+	return box
+}
+
+// findBoxes returns every child of box with type typ.
+func findBoxes(box []byte, typ string) [][]byte {
+	children, _ := childBoxes(box)
+	var found [][]byte
+	for _, c := range children {
+		if c.typ == typ {
+			found = append(found, box[c.offset:c.offset+c.size])
+		}
+	}
+	return found
+}
+
+// fullBoxPayload returns a full box's fields after its header, and its
+// version and flags.
+func fullBoxPayload(box []byte) (payload []byte, version byte, flags uint32) {
+	hdr := 8
+	if binary.BigEndian.Uint32(box) == 1 {
+		hdr = 16
+	}
+	return box[hdr+4:], box[hdr], binary.BigEndian.Uint32(box[hdr:]) & 0xffffff
+}
+
+func makeBox(typ string, children ...[]byte) []byte {
+	size := 8
+	for _, c := range children {
+		size += len(c)
+	}
+	box := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(box, uint32(size))
+	copy(box[4:], typ)
+	for _, c := range children {
+		box = append(box, c...)
+	}
+	return box
+}
+
+// fmp4Fragment is a moof and the mdat after it.
+type fmp4Fragment struct {
+	track *fmp4Input
+	start int64 // offset of the moof
+	moof  int64 // size of the moof
+	mdat  int64 // size of the mdat
+	time  float64
+}
+
+// fmp4Input is one single-track fragmented MP4 being merged.
+type fmp4Input struct {
+	f              *os.File
+	ftyp           []byte
+	moov           []byte
+	trak           []byte
+	trex           []byte
+	movieTimescale uint32
+	timescale      uint32
+	frags          []fmp4Fragment
+}
+
+func openFMP4(path string) (*fmp4Input, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	in, err := readFMP4(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("can't merge %s: %v", filepath.Base(path), err)
+	}
+	return in, nil
+}
+
+func readFMP4(f *os.File) (*fmp4Input, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	boxes, err := readBoxes(f, 0, st.Size())
+	if err != nil {
+		return nil, err
+	}
+	in := &fmp4Input{f: f}
+	readBox := func(b mp4Box) ([]byte, error) {
+		data := make([]byte, b.size)
+		_, err := f.ReadAt(data, b.offset)
+		return data, err
+	}
+	for i, b := range boxes {
+		switch b.typ {
+		case "ftyp":
+			if in.ftyp, err = readBox(b); err != nil {
+				return nil, err
+			}
+		case "moov":
+			if in.moov, err = readBox(b); err != nil {
+				return nil, err
+			}
+		case "moof":
+			if i+1 >= len(boxes) || boxes[i+1].typ != "mdat" {
+				return nil, fmt.Errorf("fragment at offset %d has no data", b.offset)
+			}
+			moof, err := readBox(b)
+			if err != nil {
+				return nil, err
+			}
+			in.frags = append(in.frags, fmp4Fragment{
+				track: in,
+				start: b.offset,
+				moof:  b.size,
+				mdat:  boxes[i+1].size,
+				time:  float64(fragmentDecodeTime(moof)),
+			})
+		}
+	}
+
+	if in.moov == nil || len(in.frags) == 0 || findBox(in.moov, "mvex") == nil {
+		return nil, fmt.Errorf("not a fragmented MP4")
+	}
+	if len(findBoxes(in.moov, "trak")) != 1 {
+		return nil, fmt.Errorf("more than one track")
+	}
+	in.trak = findBox(in.moov, "trak")
+	in.trex = findBox(in.moov, "mvex", "trex")
+	mvhd := findBox(in.moov, "mvhd")
+	mdhd := findBox(in.trak, "mdia", "mdhd")
+	if in.trex == nil || mvhd == nil || mdhd == nil {
+		return nil, fmt.Errorf("missing track headers")
+	}
+	in.movieTimescale = headerTimescale(mvhd)
+	in.timescale = headerTimescale(mdhd)
+	if in.movieTimescale == 0 || in.timescale == 0 {
+		return nil, fmt.Errorf("no timescale")
+	}
+	for i := range in.frags {
+		in.frags[i].time /= float64(in.timescale)
+	}
+	return in, nil
+}
+
+// headerTimescale reads the timescale of an mvhd or mdhd box, which
+// share their layout up to it.
+func headerTimescale(box []byte) uint32 {
+	p, v, _ := fullBoxPayload(box)
+	if v == 1 {
+		return binary.BigEndian.Uint32(p[16:])
+	}
+	return binary.BigEndian.Uint32(p[8:])
+}
+
+// fragmentDecodeTime reads the tfdt of a moof, in the track's timescale.
+func fragmentDecodeTime(moof []byte) uint64 {
+	tfdt := findBox(moof, "traf", "tfdt")
+	if tfdt == nil {
+		return 0
+	}
+	p, v, _ := fullBoxPayload(tfdt)
+	if v == 1 {
+		return binary.BigEndian.Uint64(p)
+	}
+	return uint64(binary.BigEndian.Uint32(p))
+}
+
+// setTrackID renumbers a track's trak and trex.
+func setTrackID(trak, trex []byte, id uint32) {
+	p, v, _ := fullBoxPayload(findBox(trak, "tkhd"))
+	if v == 1 {
+		binary.BigEndian.PutUint32(p[16:], id)
+	} else {
+		binary.BigEndian.PutUint32(p[8:], id)
+	}
+	p, _, _ = fullBoxPayload(trex)
+	binary.BigEndian.PutUint32(p, id)
+}
+
+// rescaleTrack converts the durations in a trak that are counted in the
+// movie timescale, which may differ between the two inputs.
+func rescaleTrack(trak []byte, from, to uint32) {
+	if from == to {
+		return
+	}
+	scale := func(n uint64) uint64 { return n * uint64(to) / uint64(from) }
+	p, v, _ := fullBoxPayload(findBox(trak, "tkhd"))
+	if v == 1 {
+		binary.BigEndian.PutUint64(p[24:], scale(binary.BigEndian.Uint64(p[24:])))
+	} else {
+		binary.BigEndian.PutUint32(p[16:], uint32(scale(uint64(binary.BigEndian.Uint32(p[16:])))))
+	}
+	elst := findBox(trak, "edts", "elst")
+	if elst == nil {
+		return
+	}
+	p, v, _ = fullBoxPayload(elst)
+	entries := p[4:]
+	for i := uint32(0); i < binary.BigEndian.Uint32(p); i++ {
+		if v == 1 {
+			binary.BigEndian.PutUint64(entries, scale(binary.BigEndian.Uint64(entries)))
+			entries = entries[20:]
+		} else {
+			binary.BigEndian.PutUint32(entries, uint32(scale(uint64(binary.BigEndian.Uint32(entries)))))
+			entries = entries[12:]
+		}
+	}
+}
+
+// mergedMoov is the video's moov with the audio track added as track 2.
+func mergedMoov(video, audio *fmp4Input) ([]byte, error) {
+	mvhd := bytes.Clone(findBox(video.moov, "mvhd"))
+	binary.BigEndian.PutUint32(mvhd[len(mvhd)-4:], 3) // next_track_ID
+
+	videoTrak, videoTrex := bytes.Clone(video.trak), bytes.Clone(video.trex)
+	audioTrak, audioTrex := bytes.Clone(audio.trak), bytes.Clone(audio.trex)
+	setTrackID(videoTrak, videoTrex, 1)
+	setTrackID(audioTrak, audioTrex, 2)
+	rescaleTrack(audioTrak, audio.movieTimescale, video.movieTimescale)
+
+	mvex := [][]byte{}
+	if mehd := findBox(video.moov, "mvex", "mehd"); mehd != nil {
+		mvex = append(mvex, mehd)
+	}
+	mvex = append(mvex, videoTrex, audioTrex)
+
+	children := [][]byte{mvhd, videoTrak, audioTrak, makeBox("mvex", mvex...)}
+	boxes, err := childBoxes(video.moov)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range boxes {
+		switch b.typ {
+		case "mvhd", "trak", "mvex":
+		default:
+			// e.g. udta
+			children = append(children, video.moov[b.offset:b.offset+b.size])
+		}
+	}
+	return makeBox("moov", children...), nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// muxFragmentedMP4 merges a video-only and an audio-only fragmented MP4
+// into w, reporting the time written so far to progress if it is set.
+func muxFragmentedMP4(ctx context.Context, videoPath, audioPath string, w io.Writer, progress func(time.Duration)) (err error) {
+	// Box fields are read without bounds checks; a box too short for its
+	// type is a broken file, not a crash
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed MP4: %v", r)
+		}
+	}()
+
+	video, err := openFMP4(videoPath)
+	if err != nil {
+		return err
+	}
+	defer video.f.Close()
+	audio, err := openFMP4(audioPath)
+	if err != nil {
+		return err
+	}
+	defer audio.f.Close()
+
+	moov, err := mergedMoov(video, audio)
+	if err != nil {
+		return err
+	}
+	ftyp := video.ftyp
+	if ftyp == nil {
+		ftyp = makeBox("ftyp", []byte("isom\x00\x00\x02\x00isomiso6mp41"))
+	}
+
+	// Interleave by decode time so players don't have to seek between
+	// the tracks
+	frags := append(append([]fmp4Fragment{}, video.frags...), audio.frags...)
+	sort.SliceStable(frags, func(i, j int) bool { return frags[i].time < frags[j].time })
+
+	cw := &countingWriter{w: w}
+	if _, err := cw.Write(ftyp); err != nil {
+		return err
+	}
+	if _, err := cw.Write(moov); err != nil {
+		return err
+	}
+	for seq, frag := range frags {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		trackID := uint32(1)
+		if frag.track == audio {
+			trackID = 2
+		}
+		moof := make([]byte, frag.moof)
+		if _, err := frag.track.f.ReadAt(moof, frag.start); err != nil {
+			return err
+		}
+		if err := patchMoof(moof, uint32(seq+1), trackID, cw.n-frag.start); err != nil {
+			return err
+		}
+		if _, err := cw.Write(moof); err != nil {
+			return err
+		}
+		data := io.NewSectionReader(frag.track.f, frag.start+frag.moof, frag.mdat)
+		if _, err := io.Copy(cw, data); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(time.Duration(frag.time * float64(time.Second)))
+		}
+	}
+	return nil
+}
+
+// patchMoof renumbers a fragment and its track, and moves any absolute
+// data offsets by shift, the distance the fragment moved.
+func patchMoof(moof []byte, seq, trackID uint32, shift int64) error {
+	mfhd := findBox(moof, "mfhd")
+	if mfhd == nil {
+		return fmt.Errorf("fragment has no header")
+	}
+	p, _, _ := fullBoxPayload(mfhd)
+	binary.BigEndian.PutUint32(p, seq)
+
+	for _, traf := range findBoxes(moof, "traf") {
+		tfhd := findBox(traf, "tfhd")
+		if tfhd == nil {
+			return fmt.Errorf("track fragment has no header")
+		}
+		p, _, flags := fullBoxPayload(tfhd)
+		binary.BigEndian.PutUint32(p, trackID)
+		if flags&0x000001 != 0 {
+			// base-data-offset-present
+			offset := binary.BigEndian.Uint64(p[4:])
+			binary.BigEndian.PutUint64(p[4:], uint64(int64(offset)+shift))
+		}
+	}
+	return nil
+}
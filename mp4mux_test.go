@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func fullBox(typ string, version byte, flags uint32, payload ...[]byte) []byte {
+	hdr := make([]byte, 4)
+	binary.BigEndian.PutUint32(hdr, flags)
+	hdr[0] = version
+	return makeBox(typ, append([][]byte{hdr}, payload...)...)
+}
+
+func be32(n uint32) []byte { return binary.BigEndian.AppendUint32(nil, n) }
+func be64(n uint64) []byte { return binary.BigEndian.AppendUint64(nil, n) }
+
+// testTrack describes a single-track fragmented MP4 to build.
+type testTrack struct {
+	movieTimescale uint32
+	timescale      uint32
+	duration       uint32   // in movieTimescale
+	times          []uint64 // decode time of each fragment, in timescale
+	name           string   // fragment i's mdat holds name and i
+	baseOffsets    bool     // set base-data-offset in tfhd
+	traks          int      // 0 for 1
+	noMvex         bool
+}
+
+func (tt testTrack) build() []byte {
+	mvhd := fullBox("mvhd", 0, 0, be32(0), be32(0), be32(tt.movieTimescale), be32(tt.duration), make([]byte, 76), be32(2))
+	tkhd := fullBox("tkhd", 0, 0, be32(0), be32(0), be32(1), be32(0), be32(tt.duration), make([]byte, 60))
+	mdhd := fullBox("mdhd", 0, 0, be32(0), be32(0), be32(tt.timescale), be32(0), be32(0))
+	trak := makeBox("trak", tkhd, makeBox("mdia", mdhd))
+	children := [][]byte{mvhd}
+	for i := 0; i < max(tt.traks, 1); i++ {
+		children = append(children, trak)
+	}
+	if !tt.noMvex {
+		children = append(children, makeBox("mvex", fullBox("trex", 0, 0, be32(1), be32(1), be32(0), be32(0), be32(0))))
+	}
+	children = append(children, makeBox("udta"))
+
+	file := append(makeBox("ftyp", []byte("iso6")), makeBox("moov", children...)...)
+	for i, t := range tt.times {
+		var tfhd []byte
+		if tt.baseOffsets {
+			tfhd = fullBox("tfhd", 0, 1, be32(1), be64(uint64(len(file))))
+		} else {
+			tfhd = fullBox("tfhd", 0, 0, be32(1))
+		}
+		moof := makeBox("moof",
+			fullBox("mfhd", 0, 0, be32(uint32(i+1))),
+			makeBox("traf", tfhd, fullBox("tfdt", 1, 0, be64(t))),
+		)
+		file = append(file, moof...)
+		file = append(file, makeBox("mdat", []byte{tt.name[0], byte('0' + i)})...)
+	}
+	return file
+}
+
+// muxedFragment is what the merged output says about one fragment.
+type muxedFragment struct {
+	seq, track uint32
+	data       string
+	baseOffset int64 // -1 when not set
+	moofOffset int64
+}
+
+func readMuxedFragments(t *testing.T, out []byte) (moov []byte, frags []muxedFragment) {
+	t.Helper()
+	boxes, err := readBoxes(bytes.NewReader(out), 0, int64(len(out)))
+	if err != nil {
+		t.Fatalf("merged output doesn't parse: %v", err)
+	}
+	for i, b := range boxes {
+		box := out[b.offset : b.offset+b.size]
+		switch b.typ {
+		case "moov":
+			moov = box
+		case "moof":
+			p, _, _ := fullBoxPayload(findBox(box, "mfhd"))
+			f := muxedFragment{seq: binary.BigEndian.Uint32(p), baseOffset: -1, moofOffset: b.offset}
+			p, _, flags := fullBoxPayload(findBox(box, "traf", "tfhd"))
+			f.track = binary.BigEndian.Uint32(p)
+			if flags&1 != 0 {
+				f.baseOffset = int64(binary.BigEndian.Uint64(p[4:]))
+			}
+			mdat := boxes[i+1]
+			f.data = string(out[mdat.offset+mdat.hdr : mdat.offset+mdat.size])
+			frags = append(frags, f)
+		}
+	}
+	return moov, frags
+}
+
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMuxFragmentedMP4(t *testing.T) {
+	video := testTrack{movieTimescale: 1000, timescale: 1000, duration: 6000, times: []uint64{0, 2000, 4000}, name: "v", baseOffsets: true}
+	audio := testTrack{movieTimescale: 600, timescale: 48000, duration: 3600, times: []uint64{0, 48000, 144000}, name: "a"}
+	videoPath := writeTestFile(t, "video.mp4", video.build())
+	audioPath := writeTestFile(t, "audio.mp4", audio.build())
+
+	var out bytes.Buffer
+	if err := muxFragmentedMP4(context.Background(), videoPath, audioPath, &out, nil); err != nil {
+		t.Fatal(err)
+	}
+	moov, frags := readMuxedFragments(t, out.Bytes())
+
+	// Interleaved by time, the video first on a tie
+	want := []struct {
+		track uint32
+		data  string
+	}{{1, "v0"}, {2, "a0"}, {2, "a1"}, {1, "v1"}, {2, "a2"}, {1, "v2"}}
+	if len(frags) != len(want) {
+		t.Fatalf("got %d fragments, want %d", len(frags), len(want))
+	}
+	for i, f := range frags {
+		if f.seq != uint32(i+1) || f.track != want[i].track || f.data != want[i].data {
+			t.Errorf("fragment %d = seq %d track %d %q, want seq %d track %d %q", i, f.seq, f.track, f.data, i+1, want[i].track, want[i].data)
+		}
+		// Absolute offsets follow the fragment to its new place
+		if f.track == 1 && f.baseOffset != f.moofOffset {
+			t.Errorf("fragment %d has base offset %d, want %d", i, f.baseOffset, f.moofOffset)
+		}
+	}
+
+	traks := findBoxes(moov, "trak")
+	if len(traks) != 2 {
+		t.Fatalf("merged moov has %d tracks, want 2", len(traks))
+	}
+	for i, trak := range traks {
+		p, _, _ := fullBoxPayload(findBox(trak, "tkhd"))
+		if id := binary.BigEndian.Uint32(p[8:]); id != uint32(i+1) {
+			t.Errorf("track %d has ID %d", i+1, id)
+		}
+		// The audio's duration is rescaled to the video's movie timescale
+		if d := binary.BigEndian.Uint32(p[16:]); d != 6000 {
+			t.Errorf("track %d lasts %d, want 6000", i+1, d)
+		}
+	}
+	mvhd := findBox(moov, "mvhd")
+	if next := binary.BigEndian.Uint32(mvhd[len(mvhd)-4:]); next != 3 {
+		t.Errorf("next track ID is %d, want 3", next)
+	}
+	if findBox(moov, "udta") == nil {
+		t.Error("the video's udta was dropped")
+	}
+}
+
+func TestMuxFragmentedMP4Errors(t *testing.T) {
+	good := testTrack{movieTimescale: 1000, timescale: 1000, times: []uint64{0}, name: "v"}.build()
+	tests := []struct {
+		name  string
+		video []byte
+	}{
+		{"not fragmented", testTrack{movieTimescale: 1000, timescale: 1000, times: []uint64{0}, name: "v", noMvex: true}.build()},
+		{"no fragments", testTrack{movieTimescale: 1000, timescale: 1000, name: "v"}.build()},
+		{"two tracks", testTrack{movieTimescale: 1000, timescale: 1000, times: []uint64{0}, name: "v", traks: 2}.build()},
+		{"no timescale", testTrack{movieTimescale: 1000, times: []uint64{0}, name: "v"}.build()},
+		{"truncated", good[:len(good)-1]},
+		{"fragment without data", good[:len(good)-len(makeBox("mdat", []byte("v0")))]},
+		{"empty", nil},
+	}
+	audioPath := writeTestFile(t, "audio.mp4", good)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			videoPath := writeTestFile(t, "video.mp4", tt.video)
+			if err := muxFragmentedMP4(context.Background(), videoPath, audioPath, &bytes.Buffer{}, nil); err == nil {
+				t.Error("merged a broken input")
+			}
+		})
+	}
+}
+
+func TestReadBoxes(t *testing.T) {
+	large := append(be32(1), []byte("mdat")...)
+	large = append(large, be64(20)...)
+	large = append(large, "data"...)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []mp4Box
+		wantErr bool
+	}{
+		{"boxes", append(makeBox("ftyp", []byte("iso6")), makeBox("free")...), []mp4Box{{"ftyp", 0, 12, 8}, {"free", 12, 8, 8}}, false},
+		{"64-bit size", large, []mp4Box{{"mdat", 0, 20, 16}}, false},
+		{"to the end", append(be32(0), "mdat1234"...), []mp4Box{{"mdat", 0, 12, 8}}, false},
+		{"truncated header", []byte{0, 0, 0}, nil, true},
+		{"size past the end", append(be32(100), "mdat"...), nil, true},
+		{"size under the header", append(be32(4), "mdat"...), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readBoxes(bytes.NewReader(tt.data), 0, int64(len(tt.data)))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("readBoxes = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("readBoxes = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("box %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const notifyTimeout = 10 * time.Second
+
+// Notification is the JSON payload POSTed to -notify-url after each download.
+type Notification struct {
+	VideoID    string    `json:"video_id"`
+	URL        string    `json:"url"`
+	Title      string    `json:"title"`
+	OutputPath string    `json:"output_path,omitempty"`
+	Duration   float64   `json:"duration"`
+	Elapsed    float64   `json:"elapsed"`
+	Status     JobStatus `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+
+	// Seconds spent in each pipeline phase
+	Phases map[JobPhase]float64 `json:"phases,omitempty"`
+}
+
+func (d *Downloader) notify(n Notification) {
+	if d.config.NotifyURL == "" {
+		return
+	}
+	if err := postJSON(d.config.NotifyURL, n); err != nil {
+		d.logger.Printf("Notification for %s failed: %v", n.Title, err)
+	}
+}
+
+func postJSON(url string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// notifyJob reports a finished job to -notify-url and -discord-webhook, and for a failure or
+// skip with a known cause, tells the user what to do about it.
+func (d *Downloader) notifyJob(job *Job) {
+	snap := job.Snapshot()
+	if hint := errorHint(snap.Err); hint != "" {
+		d.logger.Printf("%s: %s", snap.Title, hint)
+	}
+	n := Notification{
+		VideoID:    snap.VideoID,
+		URL:        snap.URL,
+		Title:      snap.Title,
+		OutputPath: snap.OutputPath,
+		Duration:   snap.Duration.Seconds(),
+		Elapsed:    snap.Elapsed.Seconds(),
+		Status:     snap.Status,
+		Timestamp:  time.Now(),
+	}
+	if snap.Err != nil {
+		n.Error = snap.Err.Error()
+	}
+	if len(snap.Phases) > 0 {
+		n.Phases = make(map[JobPhase]float64)
+		for _, t := range snap.Phases {
+			n.Phases[t.Phase] = t.Duration.Seconds()
+		}
+	}
+	d.notify(n)
+	d.notifyDiscord(snap)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	pacingAlpha     = 0.2 // weight of the newest request in the error rate
+	pacingSlowDown  = 0.2 // error rate above which requests to a host are delayed
+	pacingRecover   = 0.05
+	pacingMinDelay  = 500 * time.Millisecond
+	pacingMaxDelay  = 30 * time.Second
+	pacingDropDelay = 50 * time.Millisecond
+)
+
+// hostStats holds request metrics and the current pacing delay for one host.
+type hostStats struct {
+	Requests     int
+	Errors       int
+	StatusCodes  map[int]int
+	TotalLatency time.Duration
+	ErrorRate    float64
+	Delay        time.Duration
+}
+
+// pacingTransport records per-host response codes and latencies and
+// delays requests to hosts whose recent error rate is high, backing off
+// exponentially and recovering as requests start succeeding again.
+type pacingTransport struct {
+	base http.RoundTripper
+
+	mu    sync.Mutex
+	hosts map[string]*hostStats
+}
+
+func newPacingTransport(base http.RoundTripper) *pacingTransport {
+	return &pacingTransport{base: base, hosts: make(map[string]*hostStats)}
+}
+
+func (t *pacingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+
+	if delay := t.delay(host); delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	t.record(host, resp, err, time.Since(start))
+	return resp, err
+}
+
+func (t *pacingTransport) delay(host string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.hosts[host]; ok {
+		return s.Delay
+	}
+	return 0
+}
+
+func (t *pacingTransport) record(host string, resp *http.Response, err error, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.hosts[host]
+	if !ok {
+		s = &hostStats{StatusCodes: make(map[int]int)}
+		t.hosts[host] = s
+	}
+	s.Requests++
+	s.TotalLatency += latency
+
+	failed := err != nil
+	if resp != nil {
+		s.StatusCodes[resp.StatusCode]++
+		failed = failed || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusForbidden || resp.StatusCode >= 500
+	}
+
+	sample := 0.0
+	if failed {
+		s.Errors++
+		sample = 1
+	}
+	s.ErrorRate = pacingAlpha*sample + (1-pacingAlpha)*s.ErrorRate
+
+	switch {
+	case resp != nil && resp.StatusCode == http.StatusTooManyRequests && retryAfter(resp) > 0:
+		s.Delay = min(retryAfter(resp), pacingMaxDelay)
+	case s.ErrorRate > pacingSlowDown:
+		s.Delay = min(max(s.Delay*2, pacingMinDelay), pacingMaxDelay)
+	case s.ErrorRate < pacingRecover:
+		if s.Delay /= 2; s.Delay < pacingDropDelay {
+			s.Delay = 0
+		}
+	}
+}
+
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// Stats returns a copy of the metrics collected for every host.
+func (t *pacingTransport) Stats() map[string]hostStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	out := make(map[string]hostStats, len(t.hosts))
+	for host, s := range t.hosts {
+		c := *s
+		c.StatusCodes = make(map[int]int, len(s.StatusCodes))
+		for code, n := range s.StatusCodes {
+			c.StatusCodes[code] = n
+		}
+		out[host] = c
+	}
+	return out
+}
+
+func (t *pacingTransport) Report(logger *log.Logger) {
+	stats := t.Stats()
+	hosts := make([]string, 0, len(stats))
+	for host := range stats {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	for _, host := range hosts {
+		s := stats[host]
+		if s.Errors == 0 && s.Delay == 0 {
+			continue
+		}
+		logger.Printf("Host %s: %d requests, %d errors, avg latency %s, status codes %v",
+			host, s.Requests, s.Errors, formatDuration(s.TotalLatency/time.Duration(s.Requests)), s.StatusCodes)
+	}
+}
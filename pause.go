@@ -0,0 +1,38 @@
+package main
+
+// PauseAll pauses every unfinished job, and jobs added until ResumeAll.
+// Running downloads stop once the chunk they are reading arrives and
+// idle, keeping their connection slot; merges already under way finish.
+func (d *Downloader) PauseAll() {
+	d.jobsMu.Lock()
+	d.paused = true
+	jobs := append([]*Job(nil), d.jobs...)
+	d.jobsMu.Unlock()
+
+	for _, job := range jobs {
+		if s := job.Snapshot().Status; s == JobQueued || s == JobRunning {
+			job.Pause()
+		}
+	}
+	d.logger.Printf("Paused all downloads")
+}
+
+// ResumeAll resumes every job paused, whether by PauseAll or on its own.
+func (d *Downloader) ResumeAll() {
+	d.jobsMu.Lock()
+	d.paused = false
+	jobs := append([]*Job(nil), d.jobs...)
+	d.jobsMu.Unlock()
+
+	for _, job := range jobs {
+		job.Resume()
+	}
+	d.logger.Printf("Resumed all downloads")
+}
+
+// Paused reports whether the queue is paused.
+func (d *Downloader) Paused() bool {
+	d.jobsMu.Lock()
+	defer d.jobsMu.Unlock()
+	return d.paused
+}
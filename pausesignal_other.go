@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd)
+
+package main
+
+// handlePauseSignals does nothing where there is no SIGUSR1 or SIGUSR2.
+func (d *Downloader) handlePauseSignals() (stop func()) {
+	return func() {}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// JobPhase is the pipeline step a running job is in.
+type JobPhase string
+
+const (
+	PhaseResolving        JobPhase = "resolving"
+	PhaseDownloadingVideo JobPhase = "downloading video"
+	PhaseDownloadingAudio JobPhase = "downloading audio"
+	PhaseVerifying        JobPhase = "verifying"
+	PhaseMerging          JobPhase = "merging"
+	PhaseConverting       JobPhase = "converting"
+	PhaseTagging          JobPhase = "tagging"
+	PhaseSplitting        JobPhase = "splitting"
+	PhaseUploading        JobPhase = "uploading"
+)
+
+// phaseOrder is the order phases are listed in reports.
+var phaseOrder = []JobPhase{
+	PhaseResolving,
+	PhaseDownloadingVideo,
+	PhaseDownloadingAudio,
+	PhaseVerifying,
+	PhaseMerging,
+	PhaseConverting,
+	PhaseTagging,
+	PhaseSplitting,
+	PhaseUploading,
+}
+
+// PhaseTiming is the total time a job spent in one phase.
+type PhaseTiming struct {
+	Phase    JobPhase
+	Duration time.Duration
+}
+
+// setPhase ends the current phase, if any, and starts the next one.
+func (j *Job) setPhase(phase JobPhase) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.endPhase()
+	j.phase = phase
+	j.phaseStart = time.Now()
+	j.processed, j.processTotal = 0, 0
+}
+
+// endPhase adds the time spent in the current phase. j.mu must be held.
+func (j *Job) endPhase() {
+	if j.phase == "" {
+		return
+	}
+	if j.phaseTimes == nil {
+		j.phaseTimes = make(map[JobPhase]time.Duration)
+	}
+	j.phaseTimes[j.phase] += time.Since(j.phaseStart)
+	j.phase = ""
+}
+
+// phaseTimings returns the time per phase in pipeline order, including
+// the running phase so far. j.mu must be held.
+func (j *Job) phaseTimings() []PhaseTiming {
+	var timings []PhaseTiming
+	for _, phase := range phaseOrder {
+		d := j.phaseTimes[phase]
+		if phase == j.phase {
+			d += time.Since(j.phaseStart)
+		}
+		if d > 0 {
+			timings = append(timings, PhaseTiming{Phase: phase, Duration: d})
+		}
+	}
+	return timings
+}
+
+// reportPhases logs the total and slowest time spent in each phase across
+// all jobs, showing where the pipeline spends its time.
+func reportPhases(jobs []*Job, logger *log.Logger) {
+	totals := make(map[JobPhase]time.Duration)
+	slowest := make(map[JobPhase]JobSnapshot)
+	slowestTime := make(map[JobPhase]time.Duration)
+	for _, job := range jobs {
+		snap := job.Snapshot()
+		for _, t := range snap.Phases {
+			totals[t.Phase] += t.Duration
+			if t.Duration > slowestTime[t.Phase] {
+				slowestTime[t.Phase] = t.Duration
+				slowest[t.Phase] = snap
+			}
+		}
+	}
+
+	for _, phase := range phaseOrder {
+		if totals[phase] == 0 {
+			continue
+		}
+		logger.Printf("Phase %-17s total %8s, slowest %8s (%s)", phase,
+			formatDuration(totals[phase]), formatDuration(slowestTime[phase]), slowest[phase].Title)
+	}
+}
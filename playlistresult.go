@@ -0,0 +1,79 @@
+package main
+
+import "fmt"
+
+// EntryResult is the outcome of one video in a playlist or batch.
+type EntryResult struct {
+	Index      int // 1-based position in the playlist or batch
+	URL        string
+	VideoID    string
+	Title      string
+	Status     JobStatus
+	OutputPath string
+	Err        error
+}
+
+func newEntryResult(index int, snap JobSnapshot) EntryResult {
+	return EntryResult{
+		Index:      index,
+		URL:        snap.URL,
+		VideoID:    snap.VideoID,
+		Title:      snap.Title,
+		Status:     snap.Status,
+		OutputPath: snap.OutputPath,
+		Err:        snap.Err,
+	}
+}
+
+// PlaylistResult reports every entry of a playlist, so one broken video
+// doesn't hide how the others went.
+type PlaylistResult struct {
+	URL     string
+	Entries []EntryResult
+	// Aborted is set when -max-failures was reached and the entries that
+	// had not started were canceled.
+	Aborted bool
+}
+
+// Count returns the number of entries with status.
+func (r *PlaylistResult) Count(status JobStatus) int {
+	n := 0
+	for _, e := range r.Entries {
+		if e.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed returns the entries that failed or were canceled.
+func (r *PlaylistResult) Failed() []EntryResult {
+	var failed []EntryResult
+	for _, e := range r.Entries {
+		if e.Status == JobFailed || e.Status == JobCanceled {
+			failed = append(failed, e)
+		}
+	}
+	return failed
+}
+
+// Err summarizes the failed entries, or returns nil when there were none.
+func (r *PlaylistResult) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	var errs []error
+	canceled := 0
+	for _, e := range failed {
+		if r.Aborted && e.Status == JobCanceled {
+			canceled++
+			continue
+		}
+		errs = append(errs, e.Err)
+	}
+	if r.Aborted {
+		return fmt.Errorf("stopped after %d failures, %d videos not downloaded: %v", len(errs), canceled, errs)
+	}
+	return fmt.Errorf("encountered errors during download: %v", errs)
+}
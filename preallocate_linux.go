@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// preallocate reserves the blocks of f from offset up to size, keeping its
+// reported size, so a large download isn't scattered across the disk.
+func preallocate(f *os.File, offset, size int64) error {
+	const keepSize = 0x1 // FALLOC_FL_KEEP_SIZE
+	return syscall.Fallocate(int(f.Fd()), keepSize, offset, size-offset)
+}
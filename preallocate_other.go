@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func preallocate(f *os.File, offset, size int64) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// lowestMuxedFormat returns the smallest format carrying both audio and
+// video, which can be played without merging.
+func lowestMuxedFormat(formats youtube.FormatList) *youtube.Format {
+	muxed := formats.Select(func(f youtube.Format) bool {
+		return f.AudioChannels > 0 && strings.HasPrefix(f.MimeType, "video/")
+	})
+	if len(muxed) == 0 {
+		return nil
+	}
+	sort.SliceStable(muxed, func(i, j int) bool { return muxed[i].Bitrate < muxed[j].Bitrate })
+	return &muxed[0]
+}
+
+// previewPath is where the preview of the download named base is saved.
+func (d *Downloader) previewPath(base string) string {
+	return filepath.Join(d.config.OutputDir, base+".preview.mp4")
+}
+
+// previewLength is how much of video its preview holds.
+func (d *Downloader) previewLength(video *youtube.Video) time.Duration {
+	if limit := time.Duration(d.config.PreviewSeconds) * time.Second; limit > 0 && limit < video.Duration {
+		return limit
+	}
+	return video.Duration
+}
+
+// downloadPreview fetches a quick low-quality copy of the video. With
+// PreviewSeconds set, ffmpeg reads only the start of the stream URL.
+func (d *Downloader) downloadPreview(ctx context.Context, job *Job, video *youtube.Video, base string) (string, error) {
+	format := lowestMuxedFormat(video.Formats)
+	if format == nil {
+		return "", fmt.Errorf("%w: no muxed format to preview %s", ErrNoFormats, video.Title)
+	}
+	previewPath := d.previewPath(base)
+
+	if d.config.PreviewSeconds > 0 {
+		url, err := d.client.GetStreamURLContext(ctx, video, format)
+		if err != nil {
+			return "", fmt.Errorf("failed to get preview stream url: %v", err)
+		}
+		d.logger.Printf("Downloading first %d seconds of %s (preview)", d.config.PreviewSeconds, video.Title)
+		limit := time.Duration(d.config.PreviewSeconds) * time.Second
+		err = d.writePart(previewPath, func(out mediaOutput) error {
+			return d.media().Remux(ctx, url, limit, out)
+		})
+		if err != nil {
+			return "", fmt.Errorf("ffmpeg preview failed: %w", err)
+		}
+		return previewPath, nil
+	}
+
+	job.addTotal(format.ContentLength)
+	part := partPath(previewPath)
+	if _, err := d.fetchFormat(ctx, job, video, format, part, video.Title+" (preview)"); err != nil {
+		d.discardTemp(part)
+		return "", err
+	}
+	if err := d.commitPart(previewPath); err != nil {
+		return "", err
+	}
+	return previewPath, nil
+}
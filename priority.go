@@ -0,0 +1,33 @@
+package main
+
+import "fmt"
+
+// jobPriority orders the downloads waiting for a slot. Single videos
+// asked for through serve or the Telegram bot go ahead of playlists, and
+// those ahead of channel downloads and subscription checks.
+type jobPriority int
+
+const (
+	priorityLow jobPriority = iota - 1
+	priorityNormal
+	priorityHigh
+)
+
+var priorityNames = map[jobPriority]string{
+	priorityLow:    "low",
+	priorityNormal: "normal",
+	priorityHigh:   "high",
+}
+
+func (p jobPriority) String() string {
+	return priorityNames[p]
+}
+
+func parsePriority(s string) (jobPriority, error) {
+	for p, name := range priorityNames {
+		if s == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q (expected low, normal or high)", s)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// builtinProfiles are the profiles -profile knows without a config file.
+// A profile of the same name in the config file replaces one of these.
+var builtinProfiles = map[string]map[string]any{
+	"music": {
+		"mp3":             "true",
+		"embed-metadata":  "true",
+		"write-thumbnail": "true",
+		"output-template": "{artist|channel}/{title}",
+	},
+	"podcast": {
+		"mp3":             "true",
+		"normalize-audio": "true",
+		"embed-metadata":  "true",
+		"output-template": "{channel}/{upload_date} - {title}",
+	},
+	"archive": {
+		"f":                 "bestvideo+bestaudio/best",
+		"write-info-json":   "true",
+		"write-description": "true",
+		"write-thumbnail":   "true",
+		"write-subs":        "true",
+		"embed-metadata":    "true",
+		"output-template":   "{channel}/{upload_date} - {title}",
+	},
+}
+
+// applyProfile applies the settings of the named profile as flag defaults,
+// looking in the config file's profiles section before the built-in ones:
+//
+//	profiles:
+//	  lectures:
+//	    quality: hd720
+//	    output-template: "{channel}/{index} - {title}"
+func applyProfile(flags *flag.FlagSet, name string, section any) error {
+	if name == "" {
+		return nil
+	}
+	profiles, ok := section.(map[string]any)
+	if !ok && section != nil && section != "" {
+		return fmt.Errorf("failed to load config: profiles: expected a name for each profile")
+	}
+	settings, ok := profiles[name].(map[string]any)
+	if !ok {
+		if profiles[name] != nil {
+			return fmt.Errorf("failed to load config: profiles: %s: expected settings", name)
+		}
+		if settings, ok = builtinProfiles[name]; !ok {
+			return fmt.Errorf("unknown profile %q (have %s)", name, strings.Join(profileNames(profiles), ", "))
+		}
+	}
+	if _, ok := settings["profile"]; ok {
+		return fmt.Errorf("profile %s: a profile can't choose another profile", name)
+	}
+	if err := applyConfig(flags, settings); err != nil {
+		return fmt.Errorf("profile %s: %v", name, err)
+	}
+	return nil
+}
+
+// profileNames lists the built-in profiles and those in the config file.
+func profileNames(profiles map[string]any) []string {
+	var names []string
+	for name := range builtinProfiles {
+		names = append(names, name)
+	}
+	for name := range profiles {
+		if _, ok := builtinProfiles[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProgressReporter is told about each job as it runs, so embedders can
+// show progress in their own UI. Start is called when the job begins
+// downloading and Done once when it ends, whether or not it started.
+// Update is called as bytes arrive, at most every progressInterval, and
+// with bytes unchanged as merging or converting advances; Snapshot has
+// the phase and how far processing has got. Calls for different jobs
+// may come from different goroutines at once.
+type ProgressReporter interface {
+	Start(job *Job)
+	Update(job *Job, bytes, total int64)
+	Done(job *Job, err error)
+}
+
+// progressInterval limits how often a job calls Update.
+const progressInterval = 500 * time.Millisecond
+
+// progressReporters are the -progress choices.
+var progressReporters = []string{"none", "bar", "json"}
+
+// newProgressReporter returns the built-in reporter called name, writing
+// to w.
+func newProgressReporter(name string, w io.Writer) ProgressReporter {
+	switch name {
+	case "bar":
+		return &barProgress{w: w}
+	case "json":
+		return &jsonProgress{enc: json.NewEncoder(w)}
+	default:
+		return noProgress{}
+	}
+}
+
+type noProgress struct{}
+
+func (noProgress) Start(*Job)                {}
+func (noProgress) Update(*Job, int64, int64) {}
+func (noProgress) Done(*Job, error)          {}
+
+// barProgress redraws a single terminal line with the job that last made
+// progress, and leaves a line behind for each finished job.
+type barProgress struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (b *barProgress) Start(job *Job) {}
+
+func (b *barProgress) Update(job *Job, bytes, total int64) {
+	line := b.line(job.Snapshot())
+	b.mu.Lock()
+	fmt.Fprintf(b.w, "\r\033[K%s", line)
+	b.mu.Unlock()
+}
+
+func (b *barProgress) Done(job *Job, err error) {
+	s := job.Snapshot()
+	status := string(s.Status)
+	if s.Err != nil {
+		status += ": " + s.Err.Error()
+	}
+	b.mu.Lock()
+	fmt.Fprintf(b.w, "\r\033[K[%d] %s  %s\n", s.ID, s.Title, status)
+	b.mu.Unlock()
+}
+
+func (b *barProgress) line(s JobSnapshot) string {
+	done, total := float64(s.Downloaded), float64(s.Total)
+	detail := fmt.Sprintf("%s  %s", formatBytes(s.Downloaded), formatRate(s.Speed))
+	if s.ProcessTotal > 0 {
+		done, total = float64(s.Processed), float64(s.ProcessTotal)
+		detail = fmt.Sprintf("%s of %s", formatDuration(s.Processed), formatDuration(s.ProcessTotal))
+	}
+	fraction := 0.0
+	if total > 0 {
+		fraction = min(done/total, 1)
+	}
+	filled := int(fraction * tuiBarWidth)
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", tuiBarWidth-filled)
+	return fmt.Sprintf("[%d] %s  %s [%s] %3.0f%%  %s", s.ID, s.Title, s.Phase, bar, fraction*100, detail)
+}
+
+// jsonProgress writes one JSON object per event, for scripts driving the
+// downloader.
+type jsonProgress struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+type progressEvent struct {
+	Event        string  `json:"event"`
+	Job          int     `json:"job"`
+	URL          string  `json:"url"`
+	VideoID      string  `json:"video_id,omitempty"`
+	Title        string  `json:"title,omitempty"`
+	Phase        string  `json:"phase,omitempty"`
+	Bytes        int64   `json:"bytes"`
+	Total        int64   `json:"total"`
+	Speed        float64 `json:"speed"`
+	Processed    float64 `json:"processed,omitempty"` // seconds
+	ProcessTotal float64 `json:"process_total,omitempty"`
+	Status       string  `json:"status,omitempty"`
+	Error        string  `json:"error,omitempty"`
+}
+
+func (p *jsonProgress) Start(job *Job) { p.write("start", job) }
+
+func (p *jsonProgress) Update(job *Job, bytes, total int64) { p.write("progress", job) }
+
+func (p *jsonProgress) Done(job *Job, err error) { p.write("done", job) }
+
+func (p *jsonProgress) write(event string, job *Job) {
+	s := job.Snapshot()
+	e := progressEvent{
+		Event:        event,
+		Job:          s.ID,
+		URL:          s.URL,
+		VideoID:      s.VideoID,
+		Title:        s.Title,
+		Phase:        string(s.Phase),
+		Bytes:        s.Downloaded,
+		Total:        s.Total,
+		Speed:        s.Speed,
+		Processed:    s.Processed.Seconds(),
+		ProcessTotal: s.ProcessTotal.Seconds(),
+	}
+	if event == "done" {
+		e.Status = string(s.Status)
+		if s.Err != nil {
+			e.Error = s.Err.Error()
+		}
+	}
+	p.mu.Lock()
+	p.enc.Encode(e)
+	p.mu.Unlock()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateRule limits bandwidth to limit bytes per second, either always or
+// only between two times of day. A limit of 0 means unlimited.
+type rateRule struct {
+	limit      int64
+	start, end int // minutes after midnight
+	windowed   bool
+}
+
+func (r rateRule) matches(now time.Time) bool {
+	if !r.windowed {
+		return true
+	}
+	m := now.Hour()*60 + now.Minute()
+	if r.start <= r.end {
+		return m >= r.start && m < r.end
+	}
+	// Windows such as 22:00-06:00 wrap around midnight
+	return m >= r.start || m < r.end
+}
+
+// rateSchedule is an ordered list of rules; the first rule that matches
+// the current local time applies.
+type rateSchedule []rateRule
+
+// parseRateSchedule parses -limit-rate values such as "2M" or
+// "1M@09:00-17:00,unlimited": comma-separated RATE[@HH:MM-HH:MM] rules.
+func parseRateSchedule(spec string) (rateSchedule, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	var schedule rateSchedule
+	for _, part := range strings.Split(spec, ",") {
+		rate, window, windowed := strings.Cut(strings.TrimSpace(part), "@")
+		if strings.TrimSpace(rate) == "" {
+			return nil, fmt.Errorf("missing rate in %q", spec)
+		}
+		limit, err := parseRate(rate)
+		if err != nil {
+			return nil, err
+		}
+		rule := rateRule{limit: limit, windowed: windowed}
+		if windowed {
+			from, to, ok := strings.Cut(window, "-")
+			if !ok {
+				return nil, fmt.Errorf("invalid time window %q (want HH:MM-HH:MM)", window)
+			}
+			if rule.start, err = parseClock(from); err != nil {
+				return nil, err
+			}
+			if rule.end, err = parseClock(to); err != nil {
+				return nil, err
+			}
+		}
+		schedule = append(schedule, rule)
+	}
+	return schedule, nil
+}
+
+// parseRate parses a byte rate with an optional K, M or G suffix.
+func parseRate(s string) (int64, error) {
+	if strings.EqualFold(strings.TrimSpace(s), "unlimited") {
+		return 0, nil
+	}
+	n, err := parseSize(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q", strings.TrimSpace(s))
+	}
+	return n, nil
+}
+
+// parseSize parses a byte count with an optional K, M or G suffix.
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	switch s[len(s)-1] {
+	case 'K':
+		multiplier = 1 << 10
+	case 'M':
+		multiplier = 1 << 20
+	case 'G':
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (want HH:MM)", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (s rateSchedule) current(now time.Time) int64 {
+	for _, rule := range s {
+		if rule.matches(now) {
+			return rule.limit
+		}
+	}
+	return 0
+}
+
+// rateLimiter is a token bucket shared by all transfers. The rate is
+// looked up from the schedule on every call, so a new time window takes
+// effect on downloads that are already running.
+type rateLimiter struct {
+	schedule rateSchedule
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// maxLimiterWait bounds each sleep so schedule changes apply promptly.
+const maxLimiterWait = time.Second
+
+func newRateLimiter(schedule rateSchedule) *rateLimiter {
+	return &rateLimiter{schedule: schedule}
+}
+
+// wait blocks until n more bytes may be transferred. Callers take their
+// bytes as soon as the bucket is not in debt, so large reads are allowed
+// and paid back by later callers.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		delay := l.take(n)
+		if delay == 0 {
+			return nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// setSchedule replaces the schedule; running transfers pick it up on
+// their next read.
+func (l *rateLimiter) setSchedule(schedule rateSchedule) {
+	l.mu.Lock()
+	l.schedule = schedule
+	l.mu.Unlock()
+}
+
+func (l *rateLimiter) take(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	rate := float64(l.schedule.current(now))
+	if rate <= 0 {
+		l.tokens = 0
+		l.last = now
+		return 0
+	}
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * rate
+	}
+	l.last = now
+	// Allow at most one second of burst
+	l.tokens = min(l.tokens, rate)
+
+	if l.tokens >= 0 {
+		l.tokens -= float64(n)
+		return 0
+	}
+	return min(time.Duration(-l.tokens/rate*float64(time.Second)), maxLimiterWait)
+}
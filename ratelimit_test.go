@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateSchedule(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 5, 1, hour, minute, 0, 0, time.Local)
+	}
+	tests := []struct {
+		spec string
+		now  time.Time
+		want int64
+	}{
+		{"", at(12, 0), 0},
+		{"2M", at(12, 0), 2 << 20},
+		{"500K", at(3, 0), 500 << 10},
+		{"1.5M", at(3, 0), 3 << 19},
+		{"1M@09:00-17:00,unlimited", at(9, 0), 1 << 20},
+		{"1M@09:00-17:00,unlimited", at(16, 59), 1 << 20},
+		{"1M@09:00-17:00,unlimited", at(17, 0), 0},
+		{"1M@09:00-17:00,unlimited", at(8, 59), 0},
+		// Windows wrap around midnight
+		{"100K@22:00-06:00,4M", at(23, 30), 100 << 10},
+		{"100K@22:00-06:00,4M", at(5, 59), 100 << 10},
+		{"100K@22:00-06:00,4M", at(6, 0), 4 << 20},
+		// The first matching rule wins
+		{"1M@00:00-23:59, 2M", at(12, 0), 1 << 20},
+		// Outside every window there is no limit
+		{"1M@09:00-10:00", at(11, 0), 0},
+	}
+	for _, tt := range tests {
+		schedule, err := parseRateSchedule(tt.spec)
+		if err != nil {
+			t.Fatalf("parseRateSchedule(%q): %v", tt.spec, err)
+		}
+		if got := schedule.current(tt.now); got != tt.want {
+			t.Errorf("%q at %s = %d, want %d", tt.spec, tt.now.Format("15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestParseRateScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"fast",
+		"-1M",
+		"1M@09:00",
+		"1M@9am-5pm",
+		"1M@09:00-25:00",
+		"1M,",
+	} {
+		if _, err := parseRateSchedule(spec); err == nil {
+			t.Errorf("parseRateSchedule(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestRateLimiterTake(t *testing.T) {
+	schedule, err := parseRateSchedule("1K")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := newRateLimiter(schedule)
+
+	// The bucket starts empty but not in debt, so the first read goes
+	// through and the next waits for it to be paid back
+	if d := l.take(2048); d != 0 {
+		t.Errorf("first take waited %s", d)
+	}
+	if d := l.take(1); d < 900*time.Millisecond || d > maxLimiterWait {
+		t.Errorf("take in debt waited %s, want about a second", d)
+	}
+
+	l.setSchedule(nil)
+	if d := l.take(1 << 20); d != 0 {
+		t.Errorf("take without a limit waited %s", d)
+	}
+}
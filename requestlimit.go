@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// requestLimiter spaces out requests to YouTube's pages and API, such as
+// the playlist pages fetched one after another during a big channel
+// scrape, so as not to be answered with 429s or have the address banned.
+// Media downloads are left alone; -limit-rate is for those.
+type requestLimiter struct {
+	base  http.RoundTripper
+	rpm   int           // requests per minute, 0 for no limit
+	burst int           // requests that may go at once after a quiet spell
+	sleep time.Duration // average pause between requests
+
+	mu     sync.Mutex
+	tokens float64
+	filled time.Time // when tokens was last topped up
+	next   time.Time // earliest start of the next request after a pause
+}
+
+func newRequestLimiter(base http.RoundTripper, rpm, burst int, sleep time.Duration) (*requestLimiter, error) {
+	if rpm < 0 {
+		return nil, fmt.Errorf("-max-rpm must not be negative")
+	}
+	if rpm > 0 && burst < 1 {
+		return nil, fmt.Errorf("-rpm-burst must be at least 1")
+	}
+	if sleep < 0 {
+		return nil, fmt.Errorf("-sleep-requests must not be negative")
+	}
+	return &requestLimiter{base: base, rpm: rpm, burst: burst, sleep: sleep, tokens: float64(burst)}, nil
+}
+
+// isAPIRequest tells metadata requests from media, which comes from
+// googlevideo.com and thumbnails from ytimg.com.
+func isAPIRequest(req *http.Request) bool {
+	host := req.URL.Hostname()
+	return !strings.HasSuffix(host, "googlevideo.com") && !strings.HasSuffix(host, "ytimg.com") &&
+		!strings.HasPrefix(req.URL.Path, "/videoplayback")
+}
+
+func (l *requestLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isAPIRequest(req) {
+		return l.base.RoundTrip(req)
+	}
+	if wait := l.reserve(time.Now()); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+	return l.base.RoundTrip(req)
+}
+
+// reserve books the next request slot and returns how long to wait for
+// it. Tokens refill at rpm a minute up to burst; a request taken on
+// credit waits until its token would have arrived.
+func (l *requestLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	start := now
+	if l.next.After(start) {
+		start = l.next
+	}
+	if l.rpm > 0 {
+		perSecond := float64(l.rpm) / 60
+		if !l.filled.IsZero() {
+			l.tokens = min(float64(l.burst), l.tokens+now.Sub(l.filled).Seconds()*perSecond)
+		}
+		l.filled = now
+		l.tokens--
+		if l.tokens < 0 {
+			if at := now.Add(time.Duration(-l.tokens / perSecond * float64(time.Second))); at.After(start) {
+				start = at
+			}
+		}
+	}
+	if l.sleep > 0 {
+		// Anywhere from half to one and a half times the pause, so the
+		// requests don't come at a machine-like beat
+		l.next = start.Add(l.sleep/2 + time.Duration(rand.Int63n(int64(l.sleep))))
+	}
+	return start.Sub(now)
+}
+
+// sleepBeforeDownload waits out -sleep-interval since the previous
+// download started. Each download books its start time in turn, so
+// concurrent ones are spaced out too.
+func (d *Downloader) sleepBeforeDownload(ctx context.Context, video *youtube.Video) error {
+	if d.config.SleepInterval <= 0 {
+		return nil
+	}
+	d.sleepMu.Lock()
+	now := time.Now()
+	start := now
+	if d.nextStart.After(start) {
+		start = d.nextStart
+	}
+	gap := d.config.SleepInterval
+	if spread := d.config.MaxSleepInterval - gap; spread > 0 {
+		gap += time.Duration(rand.Int63n(int64(spread) + 1))
+	}
+	d.nextStart = start.Add(gap)
+	d.sleepMu.Unlock()
+
+	wait := start.Sub(now)
+	if wait <= 0 {
+		return nil
+	}
+	d.logger.Printf("Sleeping %s before downloading %s", formatDuration(wait), video.Title)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
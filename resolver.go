@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// externalResolver looks up a video's metadata and stream URLs outside
+// the built-in extractor, for videos it can't handle such as age or
+// region restricted ones.
+type externalResolver interface {
+	resolve(ctx context.Context, id string) (*youtube.Video, error)
+	String() string
+}
+
+// parseResolver parses a -resolver spec:
+//
+//	invidious:https://instance    an Invidious instance's /api/v1/videos
+//	piped:https://api-instance    a Piped API instance's /streams
+//	https://host/path?id={}       a service returning resolverVideo JSON
+//	command {}                    a shell command printing resolverVideo JSON
+//
+// {} is replaced by the video ID.
+func parseResolver(spec string, client *http.Client) (externalResolver, error) {
+	if base, ok := strings.CutPrefix(spec, "invidious:"); ok {
+		if _, err := url.ParseRequestURI(base); err != nil {
+			return nil, fmt.Errorf("invalid invidious URL: %v", err)
+		}
+		return &invidiousResolver{base: strings.TrimSuffix(base, "/"), client: client}, nil
+	}
+	if base, ok := strings.CutPrefix(spec, "piped:"); ok {
+		if _, err := url.ParseRequestURI(base); err != nil {
+			return nil, fmt.Errorf("invalid piped URL: %v", err)
+		}
+		return &pipedResolver{base: strings.TrimSuffix(base, "/"), client: client}, nil
+	}
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		if !strings.Contains(spec, "{}") {
+			return nil, fmt.Errorf("resolver URL must contain {} for the video ID")
+		}
+		return &httpResolver{template: spec, client: client}, nil
+	}
+	if strings.TrimSpace(spec) == "" {
+		return nil, fmt.Errorf("empty resolver")
+	}
+	return &commandResolver{command: spec}, nil
+}
+
+// resolverVideo is the JSON a resolver command or service returns.
+// duration is in seconds.
+type resolverVideo struct {
+	Title       string           `json:"title"`
+	Author      string           `json:"author"`
+	ChannelID   string           `json:"channel_id"`
+	Description string           `json:"description"`
+	Duration    float64          `json:"duration"`
+	Views       int              `json:"views"`
+	Formats     []resolverFormat `json:"formats"`
+}
+
+type resolverFormat struct {
+	URL           string `json:"url"`
+	Itag          int    `json:"itag"`
+	MimeType      string `json:"mime_type"` // e.g. video/mp4; codecs="avc1.640028"
+	Bitrate       int    `json:"bitrate"`
+	Width         int    `json:"width"`
+	Height        int    `json:"height"`
+	FPS           int    `json:"fps"`
+	ContentLength int64  `json:"content_length"`
+	AudioChannels int    `json:"audio_channels"`
+}
+
+func (rv *resolverVideo) video(id string) (*youtube.Video, error) {
+	video := &youtube.Video{
+		ID:          id,
+		Title:       rv.Title,
+		Author:      rv.Author,
+		ChannelID:   rv.ChannelID,
+		Description: rv.Description,
+		Duration:    time.Duration(rv.Duration * float64(time.Second)),
+		Views:       rv.Views,
+	}
+	for _, f := range rv.Formats {
+		if f.URL == "" {
+			continue
+		}
+		video.Formats = append(video.Formats, youtube.Format{
+			ItagNo:        f.Itag,
+			URL:           f.URL,
+			MimeType:      f.MimeType,
+			Quality:       qualityForHeight(f.Height),
+			QualityLabel:  qualityLabel(f.Height, f.FPS),
+			Bitrate:       f.Bitrate,
+			FPS:           f.FPS,
+			Width:         f.Width,
+			Height:        f.Height,
+			ContentLength: f.ContentLength,
+			AudioChannels: f.AudioChannels,
+		})
+	}
+	if len(video.Formats) == 0 {
+		return nil, fmt.Errorf("resolver returned no formats")
+	}
+	return video, nil
+}
+
+// qualityForHeight maps a video height to YouTube's quality names, which
+// format selection matches -quality against.
+func qualityForHeight(height int) string {
+	switch {
+	case height == 0:
+		return "tiny"
+	case height >= 2160:
+		return "hd2160"
+	case height >= 1440:
+		return "hd1440"
+	case height >= 1080:
+		return "hd1080"
+	case height >= 720:
+		return "hd720"
+	case height >= 480:
+		return "large"
+	case height >= 360:
+		return "medium"
+	case height >= 240:
+		return "small"
+	}
+	return "tiny"
+}
+
+func qualityLabel(height, fps int) string {
+	if height == 0 {
+		return ""
+	}
+	if fps > 30 {
+		return fmt.Sprintf("%dp%d", height, fps)
+	}
+	return fmt.Sprintf("%dp", height)
+}
+
+// getResolverJSON fetches url and decodes the JSON response into out.
+func getResolverJSON(ctx context.Context, client *http.Client, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type httpResolver struct {
+	template string
+	client   *http.Client
+}
+
+func (r *httpResolver) String() string { return r.template }
+
+func (r *httpResolver) resolve(ctx context.Context, id string) (*youtube.Video, error) {
+	var rv resolverVideo
+	if err := getResolverJSON(ctx, r.client, strings.ReplaceAll(r.template, "{}", url.QueryEscape(id)), &rv); err != nil {
+		return nil, err
+	}
+	return rv.video(id)
+}
+
+type commandResolver struct {
+	command string
+}
+
+func (r *commandResolver) String() string { return fmt.Sprintf("%q", r.command) }
+
+func (r *commandResolver) resolve(ctx context.Context, id string) (*youtube.Video, error) {
+	line := strings.ReplaceAll(r.command, "{}", shellQuote(id))
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", line)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", line)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "YTDL_VIDEO_ID="+id)
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%q failed: %v", line, err)
+	}
+
+	var rv resolverVideo
+	if err := json.Unmarshal(stdout.Bytes(), &rv); err != nil {
+		return nil, fmt.Errorf("invalid resolver output: %v", err)
+	}
+	return rv.video(id)
+}
+
+type invidiousResolver struct {
+	base   string
+	client *http.Client
+}
+
+type invidiousVideo struct {
+	Title           string            `json:"title"`
+	Author          string            `json:"author"`
+	AuthorID        string            `json:"authorId"`
+	Description     string            `json:"description"`
+	LengthSeconds   int               `json:"lengthSeconds"`
+	ViewCount       int               `json:"viewCount"`
+	AdaptiveFormats []invidiousFormat `json:"adaptiveFormats"`
+	FormatStreams   []invidiousFormat `json:"formatStreams"`
+}
+
+// invidiousFormat mirrors Invidious, which sends most numbers as strings.
+type invidiousFormat struct {
+	URL           string `json:"url"`
+	Itag          string `json:"itag"`
+	Type          string `json:"type"`
+	Bitrate       string `json:"bitrate"`
+	Clen          string `json:"clen"`
+	Size          string `json:"size"` // "1280x720"
+	FPS           int    `json:"fps"`
+	AudioChannels int    `json:"audioChannels"`
+}
+
+func (r *invidiousResolver) String() string { return "invidious " + r.base }
+
+func (r *invidiousResolver) resolve(ctx context.Context, id string) (*youtube.Video, error) {
+	// local=true proxies the streams through the instance, which is what
+	// gets around region blocks
+	var iv invidiousVideo
+	if err := getResolverJSON(ctx, r.client, r.base+"/api/v1/videos/"+url.PathEscape(id)+"?local=true", &iv); err != nil {
+		return nil, err
+	}
+
+	rv := resolverVideo{
+		Title:       iv.Title,
+		Author:      iv.Author,
+		ChannelID:   iv.AuthorID,
+		Description: iv.Description,
+		Duration:    float64(iv.LengthSeconds),
+		Views:       iv.ViewCount,
+	}
+	add := func(f invidiousFormat, muxed bool) {
+		format := resolverFormat{URL: r.absolute(f.URL), MimeType: f.Type, FPS: f.FPS, AudioChannels: f.AudioChannels}
+		format.Itag, _ = strconv.Atoi(f.Itag)
+		format.Bitrate, _ = strconv.Atoi(f.Bitrate)
+		format.ContentLength, _ = strconv.ParseInt(f.Clen, 10, 64)
+		fmt.Sscanf(f.Size, "%dx%d", &format.Width, &format.Height)
+		if muxed && format.AudioChannels == 0 {
+			format.AudioChannels = 2
+		}
+		rv.Formats = append(rv.Formats, format)
+	}
+	for _, f := range iv.AdaptiveFormats {
+		add(f, false)
+	}
+	for _, f := range iv.FormatStreams {
+		add(f, true)
+	}
+	return rv.video(id)
+}
+
+// absolute resolves the instance-relative URLs returned with local=true.
+func (r *invidiousResolver) absolute(u string) string {
+	if strings.HasPrefix(u, "/") {
+		return r.base + u
+	}
+	return u
+}
+
+type pipedResolver struct {
+	base   string
+	client *http.Client
+}
+
+type pipedStreams struct {
+	Title        string        `json:"title"`
+	Description  string        `json:"description"`
+	Uploader     string        `json:"uploader"`
+	UploaderURL  string        `json:"uploaderUrl"`
+	Duration     int           `json:"duration"`
+	Views        int           `json:"views"`
+	VideoStreams []pipedStream `json:"videoStreams"`
+	AudioStreams []pipedStream `json:"audioStreams"`
+}
+
+type pipedStream struct {
+	URL           string `json:"url"`
+	MimeType      string `json:"mimeType"`
+	Codec         string `json:"codec"`
+	Bitrate       int    `json:"bitrate"`
+	Width         int    `json:"width"`
+	Height        int    `json:"height"`
+	FPS           int    `json:"fps"`
+	ContentLength int64  `json:"contentLength"`
+	VideoOnly     bool   `json:"videoOnly"`
+	Itag          int    `json:"itag"`
+}
+
+func (r *pipedResolver) String() string { return "piped " + r.base }
+
+func (r *pipedResolver) resolve(ctx context.Context, id string) (*youtube.Video, error) {
+	var ps pipedStreams
+	if err := getResolverJSON(ctx, r.client, r.base+"/streams/"+url.PathEscape(id), &ps); err != nil {
+		return nil, err
+	}
+
+	rv := resolverVideo{
+		Title:       ps.Title,
+		Author:      ps.Uploader,
+		ChannelID:   strings.TrimPrefix(ps.UploaderURL, "/channel/"),
+		Description: ps.Description,
+		Duration:    float64(ps.Duration),
+		Views:       ps.Views,
+	}
+	add := func(s pipedStream, channels int) {
+		// Piped sends the codec separately from the MIME type
+		mime := s.MimeType
+		if s.Codec != "" {
+			mime += fmt.Sprintf("; codecs=%q", s.Codec)
+		}
+		rv.Formats = append(rv.Formats, resolverFormat{
+			URL:           s.URL,
+			Itag:          s.Itag,
+			MimeType:      mime,
+			Bitrate:       s.Bitrate,
+			Width:         s.Width,
+			Height:        s.Height,
+			FPS:           s.FPS,
+			ContentLength: s.ContentLength,
+			AudioChannels: channels,
+		})
+	}
+	for _, s := range ps.VideoStreams {
+		if s.VideoOnly {
+			add(s, 0)
+		} else {
+			add(s, 2)
+		}
+	}
+	for _, s := range ps.AudioStreams {
+		add(s, 2)
+	}
+	return rv.video(id)
+}
+
+// resolvingProvider falls back to an external resolver when the wrapped
+// provider can't extract a video. Streams of resolved videos are fetched
+// straight from the URLs the resolver returned; everything after that is
+// the normal download pipeline.
+type resolvingProvider struct {
+	VideoProvider
+	resolver externalResolver
+	client   *http.Client
+	logger   *log.Logger
+
+	mu       sync.Mutex
+	resolved map[string]bool
+}
+
+func (p *resolvingProvider) GetVideoContext(ctx context.Context, videoURL string) (*youtube.Video, error) {
+	video, err := p.VideoProvider.GetVideoContext(ctx, videoURL)
+	if err == nil || ctx.Err() != nil || isUpcoming(err) {
+		return video, err
+	}
+	id, idErr := youtube.ExtractVideoID(videoURL)
+	if idErr != nil {
+		return nil, err
+	}
+
+	p.logger.Printf("Extraction failed for %s (%v), trying resolver %s", id, err, p.resolver)
+	resolved, rerr := p.resolver.resolve(ctx, id)
+	if rerr != nil {
+		p.logger.Printf("Resolver failed for %s: %v", id, rerr)
+		return nil, err
+	}
+	p.markResolved(id)
+	return resolved, nil
+}
+
+func (p *resolvingProvider) isResolved(video *youtube.Video) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.resolved[video.ID]
+}
+
+func (p *resolvingProvider) markResolved(id string) {
+	p.mu.Lock()
+	p.resolved[id] = true
+	p.mu.Unlock()
+}
+
+func (p *resolvingProvider) GetStreamContext(ctx context.Context, video *youtube.Video, format *youtube.Format) (io.ReadCloser, int64, error) {
+	if !p.isResolved(video) {
+		return p.VideoProvider.GetStreamContext(ctx, video, format)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, format.URL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, 0, youtube.ErrUnexpectedStatusCode(resp.StatusCode)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+func (p *resolvingProvider) GetStreamURLContext(ctx context.Context, video *youtube.Video, format *youtube.Format) (string, error) {
+	if !p.isResolved(video) {
+		return p.VideoProvider.GetStreamURLContext(ctx, video, format)
+	}
+	return format.URL, nil
+}
+
+// setClient installs the video provider, wrapped with the -player-client
+// fallback, the -resolver fallback when one is configured, and the
+// metadata cache.
+func (d *Downloader) setClient(client VideoProvider) {
+	var fallback *clientFallbackProvider
+	if len(d.players) > 0 {
+		fallback = newClientFallbackProvider(client, d.players, d.logger)
+		client = fallback
+	}
+	var resolving *resolvingProvider
+	if d.resolver != nil {
+		resolving = &resolvingProvider{
+			VideoProvider: client,
+			resolver:      d.resolver,
+			client:        d.resolveHTTP,
+			logger:        d.logger,
+			resolved:      make(map[string]bool),
+		}
+		client = resolving
+	}
+	if d.cache != nil {
+		client = &cachingProvider{VideoProvider: client, cache: d.cache, fallback: fallback, resolving: resolving}
+	}
+	d.client = client
+}
+
+// streamHTTP returns the client to fetch video's stream URLs with: for a
+// video a -resolver returned, the bare client, so that headers and
+// credentials meant for YouTube don't reach the resolver's stream hosts.
+func (d *Downloader) streamHTTP(video *youtube.Video) *http.Client {
+	if d.resolvedVideo(video) {
+		return d.resolveHTTP
+	}
+	return d.httpClient()
+}
+
+// resolvedVideo reports whether video came from a -resolver.
+func (d *Downloader) resolvedVideo(video *youtube.Video) bool {
+	client := d.client
+	if p, ok := client.(*cachingProvider); ok {
+		client = p.VideoProvider
+	}
+	p, ok := client.(*resolvingProvider)
+	return ok && p.isResolved(video)
+}
+
+// baseClient returns the provider under the cache and the client and
+// resolver fallbacks, for the features that need the real YouTube client.
+func (d *Downloader) baseClient() VideoProvider {
+	client := d.client
+	if p, ok := client.(*cachingProvider); ok {
+		client = p.VideoProvider
+	}
+	if p, ok := client.(*resolvingProvider); ok {
+		client = p.VideoProvider
+	}
+	if p, ok := client.(*clientFallbackProvider); ok {
+		client = p.VideoProvider
+	}
+	return client
+}
+
+// baseClientFallback returns the -player-client fallback, if installed.
+func (d *Downloader) baseClientFallback() (*clientFallbackProvider, bool) {
+	client := d.client
+	if p, ok := client.(*cachingProvider); ok {
+		client = p.VideoProvider
+	}
+	if p, ok := client.(*resolvingProvider); ok {
+		client = p.VideoProvider
+	}
+	p, ok := client.(*clientFallbackProvider)
+	return p, ok
+}
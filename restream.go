@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// streamChunk is how much of a stream is asked of YouTube at once. Large
+// ranges are served slowly, so players' requests are fetched in pieces.
+const streamChunk = 10 << 20
+
+// restreamer serves one video over HTTP for players on the network: a
+// format holding both video and audio as is, with Range support so they
+// can seek, or with -remux, the best video and audio merged by ffmpeg as
+// they play. ffmpeg reads the streams back from the server's /format/
+// routes, which go through the same client as downloads.
+type restreamer struct {
+	d     *Downloader
+	video *youtube.Video
+	muxed *youtube.Format // nil with -remux
+	sel   formatSelection // with -remux
+	base  string          // this server's URL as ffmpeg reaches it
+
+	mu   sync.Mutex
+	urls map[int]string // itag -> stream URL
+}
+
+func (s *restreamer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /format/{itag}", s.handleFormat)
+	mux.HandleFunc("GET /", s.handleVideo)
+	return mux
+}
+
+func (s *restreamer) handleVideo(w http.ResponseWriter, r *http.Request) {
+	if s.muxed != nil {
+		s.serveFormat(w, r, s.muxed)
+		return
+	}
+
+	// A merge has no length up front, so players can't seek in it
+	w.Header().Set("Content-Type", containerMIMETypes[s.sel.container])
+	if r.Method == http.MethodHead {
+		return
+	}
+	spec := mergeSpec{
+		videoPath: s.base + "/format/" + strconv.Itoa(s.sel.video.ItagNo),
+		audioPath: s.base + "/format/" + strconv.Itoa(s.sel.audio.ItagNo),
+		sel:       s.sel,
+		codecArgs: mergeCodecArgs(s.sel, true),
+	}
+	s.d.logger.Printf("%s started playing", r.RemoteAddr)
+	err := s.d.media().Merge(r.Context(), spec, mediaOutput{w: w, format: ffmpegMuxers["."+s.sel.container]})
+	if err != nil && r.Context().Err() == nil {
+		s.d.logger.Printf("Failed to remux for %s: %v", r.RemoteAddr, err)
+	}
+}
+
+func (s *restreamer) handleFormat(w http.ResponseWriter, r *http.Request) {
+	itag, _ := strconv.Atoi(r.PathValue("itag"))
+	i := slices.IndexFunc(s.video.Formats, func(f youtube.Format) bool { return f.ItagNo == itag })
+	if i < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	s.serveFormat(w, r, &s.video.Formats[i])
+}
+
+// serveFormat relays format, answering Range requests itself from
+// ranges fetched from YouTube.
+func (s *restreamer) serveFormat(w http.ResponseWriter, r *http.Request, format *youtube.Format) {
+	w.Header().Set("Content-Type", strings.TrimSpace(strings.Split(format.MimeType, ";")[0]))
+	if format.ContentLength > 0 {
+		stream := &remoteStream{ctx: r.Context(), s: s, format: format}
+		defer stream.close()
+		http.ServeContent(w, r, "", time.Time{}, stream)
+		return
+	}
+
+	// Without a length there is nothing to seek in, only to pass on
+	if r.Method == http.MethodHead {
+		return
+	}
+	stream, _, err := s.d.client.GetStreamContext(r.Context(), s.video, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer stream.Close()
+	io.Copy(w, stream)
+}
+
+// openRange fetches bytes start to end of format, resolving its URL again
+// if the one it had has expired.
+func (s *restreamer) openRange(ctx context.Context, format *youtube.Format, start, end int64) (io.ReadCloser, error) {
+	for attempt := 0; ; attempt++ {
+		streamURL, err := s.streamURL(ctx, format, attempt > 0)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, streamURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+		resp, err := s.d.streamHTTP(s.video).Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusPartialContent {
+			return resp.Body, nil
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden || attempt > 0 {
+			return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		}
+	}
+}
+
+func (s *restreamer) streamURL(ctx context.Context, format *youtube.Format, refresh bool) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.urls[format.ItagNo]; ok && !refresh {
+		return u, nil
+	}
+	u, err := s.d.client.GetStreamURLContext(ctx, s.video, format)
+	if err != nil {
+		return "", err
+	}
+	s.urls[format.ItagNo] = u
+	return u, nil
+}
+
+// remoteStream is a format of known length read through ranged requests,
+// which http.ServeContent can seek in.
+type remoteStream struct {
+	ctx    context.Context
+	s      *restreamer
+	format *youtube.Format
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *remoteStream) Read(p []byte) (int, error) {
+	for {
+		if r.offset >= r.format.ContentLength {
+			return 0, io.EOF
+		}
+		opened := false
+		if r.body == nil {
+			end := min(r.offset+streamChunk, r.format.ContentLength) - 1
+			body, err := r.s.openRange(r.ctx, r.format, r.offset, end)
+			if err != nil {
+				return 0, err
+			}
+			r.body, opened = body, true
+		}
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		if err == io.EOF {
+			// On to the next chunk
+			r.close()
+			if n == 0 && opened {
+				return 0, io.ErrUnexpectedEOF
+			}
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *remoteStream) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.format.ContentLength
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the stream")
+	}
+	if offset != r.offset {
+		r.close()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+func (r *remoteStream) close() {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+}
+
+// containerMIMETypes are the Content-Types of -remux output.
+var containerMIMETypes = map[string]string{
+	"mp4":  "video/mp4",
+	"webm": "video/webm",
+	"mkv":  "video/x-matroska",
+}
+
+// muxedFormat returns the best format with both video and audio, which
+// plays without merging, or nil if there is none.
+func muxedFormat(video *youtube.Video) *youtube.Format {
+	var best *youtube.Format
+	for i := range video.Formats {
+		f := &video.Formats[i]
+		if !hasVideo(f) || f.AudioChannels == 0 {
+			continue
+		}
+		if best == nil || slices.Compare(formatRank(f), formatRank(best)) > 0 {
+			best = f
+		}
+	}
+	return best
+}
+
+// lanAddress is an address of this machine that other devices on the
+// network can likely reach, or "" if there is none.
+func lanAddress() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	for _, a := range addrs {
+		if ip, ok := a.(*net.IPNet); ok && ip.IP.IsPrivate() && ip.IP.To4() != nil {
+			return ip.IP.String()
+		}
+	}
+	return ""
+}
+
+func setupStream(flags *flag.FlagSet) func([]string) error {
+	opts := registerClientFlags(flags)
+	listen := flags.String("listen", ":8090", "Address to serve the video on; the default is reachable from the network")
+	remux := flags.Bool("remux", false, "Merge the best video and audio with ffmpeg as they play instead of serving a format that has both, which tops out at 360p; players can't seek then")
+
+	return func(args []string) error {
+		if len(args) != 1 {
+			flags.Usage()
+			return &exitError{exitTotalFailure, fmt.Errorf("expected one video")}
+		}
+		if *remux {
+			if _, err := exec.LookPath(ffmpegBinary("", "ffmpeg")); err != nil {
+				return fmt.Errorf("%w; -remux needs it to merge streams as they play", ErrFFmpegMissing)
+			}
+		}
+		// What TVs and Chromecasts play, where there is a choice
+		config := Config{MaxConcurrent: 1, MetadataConcurrent: 1, PostProcessConcurrent: 1, VideoCodec: "h264", AudioCodec: "aac"}
+		downloader, closeFn, err := opts.newDownloader(config)
+		if err != nil {
+			return err
+		}
+		defer closeFn()
+
+		video, err := downloader.client.GetVideoContext(context.Background(), args[0])
+		if err != nil {
+			return fmt.Errorf("failed to get video %s: %w", args[0], classifyVideoError(err))
+		}
+		if isLive(video) {
+			return fmt.Errorf("%s is live; only finished videos can be streamed", video.Title)
+		}
+		s := &restreamer{d: downloader, video: video, urls: make(map[int]string)}
+		var ext string
+		if *remux {
+			job := newJob(0, args[0], noProgress{})
+			if s.sel, err = downloader.selectFormats(job, video); err != nil {
+				return err
+			}
+			ext = s.sel.container
+		} else {
+			if s.muxed = muxedFormat(video); s.muxed == nil {
+				return fmt.Errorf("%s has no format with both video and audio; try -remux", video.Title)
+			}
+			ext = streamExtension(s.muxed)
+		}
+
+		lis, err := net.Listen("tcp", *listen)
+		if err != nil {
+			return err
+		}
+		addr := lis.Addr().(*net.TCPAddr)
+		host := addr.IP.String()
+		if addr.IP.IsUnspecified() {
+			host = "127.0.0.1"
+		}
+		s.base = "http://" + net.JoinHostPort(host, strconv.Itoa(addr.Port))
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		// Players hold connections open, so they end with the server
+		baseCtx, cancelStreams := context.WithCancel(context.Background())
+		defer cancelStreams()
+		httpServer := &http.Server{Handler: s.routes(), BaseContext: func(net.Listener) context.Context { return baseCtx }}
+		httpServer.RegisterOnShutdown(cancelStreams)
+		errc := make(chan error, 1)
+		go func() { errc <- httpServer.Serve(lis) }()
+
+		if s.muxed != nil {
+			downloader.logger.Printf("Streaming %s at %dp", video.Title, s.muxed.Height)
+		} else {
+			downloader.logger.Printf("Streaming %s at %dp, remuxed to %s", video.Title, s.sel.video.Height, s.sel.container)
+		}
+		downloader.logger.Printf("Play http://%s/video.%s", net.JoinHostPort(host, strconv.Itoa(addr.Port)), ext)
+		if lan := lanAddress(); addr.IP.IsUnspecified() && lan != "" {
+			downloader.logger.Printf("On other devices: http://%s/video.%s", net.JoinHostPort(lan, strconv.Itoa(addr.Port)), ext)
+		}
+
+		select {
+		case err := <-errc:
+			return err
+		case <-ctx.Done():
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}
+}
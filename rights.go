@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+const (
+	nextEndpoint   = "https://www.youtube.com/youtubei/v1/next?prettyPrint=false"
+	playerEndpoint = "https://www.youtube.com/youtubei/v1/player?prettyPrint=false"
+
+	standardLicense = "Standard YouTube License"
+)
+
+// videoRights is the licensing and credit information YouTube shows on a
+// watch page, which the player response the client library parses lacks.
+type videoRights struct {
+	License         string
+	CreativeCommons bool
+	Category        string
+}
+
+// attribution is a ready-made credit line for reuse.
+func attribution(video *youtube.Video, rights videoRights) string {
+	credit := fmt.Sprintf("%q by %s (https://www.youtube.com/watch?v=%s)", video.Title, video.Author, video.ID)
+	if rights.CreativeCommons {
+		credit += ", licensed under " + rights.License
+	}
+	return credit
+}
+
+// fetchRights looks up a video's license and category. A field stays
+// empty when its lookup fails.
+func (d *Downloader) fetchRights(ctx context.Context, video *youtube.Video) (videoRights, error) {
+	var rights videoRights
+	// The innertube endpoints are only reachable with the real client
+	if _, ok := d.baseClient().(*youtube.Client); !ok {
+		return rights, nil
+	}
+
+	body := map[string]any{"context": innertubeContext(), "videoId": video.ID}
+	var errs []string
+	if resp, err := d.postInnertube(ctx, playerEndpoint, body); err == nil {
+		walkJSON(resp, func(key string, value map[string]any) {
+			if key == "playerMicroformatRenderer" {
+				rights.Category, _ = value["category"].(string)
+			}
+		})
+	} else {
+		errs = append(errs, fmt.Sprintf("category: %v", err))
+	}
+
+	if resp, err := d.postInnertube(ctx, nextEndpoint, body); err == nil {
+		rights.License = standardLicense
+		walkJSON(resp, func(key string, value map[string]any) {
+			if key != "metadataRowRenderer" {
+				return
+			}
+			contents, _ := value["contents"].([]any)
+			if len(contents) == 0 {
+				return
+			}
+			switch title := strings.ToLower(jsonText(value["title"])); title {
+			case "license", "licence":
+				rights.License = jsonText(contents[0])
+			case "category":
+				if rights.Category == "" {
+					rights.Category = jsonText(contents[0])
+				}
+			}
+		})
+		rights.CreativeCommons = strings.Contains(strings.ToLower(rights.License), "creative commons")
+	} else {
+		errs = append(errs, fmt.Sprintf("license: %v", err))
+	}
+
+	if len(errs) > 0 {
+		return rights, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return rights, nil
+}
+
+// metadataArgs returns the ffmpeg arguments that tag the output with
+// title, credit and licensing metadata (-embed-metadata).
+func metadataArgs(video *youtube.Video, rights videoRights, dates dateFormat) []string {
+	tags := [][2]string{
+		{"title", video.Title},
+		{"artist", video.Author},
+		{"comment", "https://www.youtube.com/watch?v=" + video.ID},
+		{"description", video.Description},
+		{"genre", rights.Category},
+		{"copyright", rights.License},
+		{"date", dates.format(video.PublishDate, isoDateLayout)},
+	}
+
+	var args []string
+	for _, tag := range tags {
+		if tag[1] != "" {
+			args = append(args, "-metadata", tag[0]+"="+tag[1])
+		}
+	}
+	return args
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// defaultMaxFilenameLength leaves room under the usual 255-byte limit for
+// the suffixes added to temp and sidecar files.
+const defaultMaxFilenameLength = 200
+
+// minFilenameLength keeps room for a readable title next to the suffixes
+// that are never cut off, such as a video ID.
+const minFilenameLength = 32
+
+// Names Windows reserves for devices, with or without an extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizer makes template values and paths safe to use as filenames on
+// Linux, macOS and Windows alike.
+type sanitizer struct {
+	restrict bool // ASCII letters, digits, "-", "_" and "." only
+	maxLen   int  // bytes per path component, excluding the extension
+}
+
+// field cleans one template value. Path separators are replaced, so a
+// "/" in a title never creates a directory.
+func (s sanitizer) field(value string) string {
+	var sb strings.Builder
+	for _, r := range value {
+		switch {
+		case unicode.IsControl(r):
+		case strings.ContainsRune(`<>:"/\|?*`, r):
+			sb.WriteRune('-')
+		case s.restrict && r == ' ':
+			sb.WriteRune('_')
+		case s.restrict && (r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_.,()[]", r))):
+			sb.WriteRune('_')
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	out := sb.String()
+	if s.restrict {
+		for strings.Contains(out, "__") {
+			out = strings.ReplaceAll(out, "__", "_")
+		}
+	}
+	return out
+}
+
+// path fixes each component of a rendered template: names Windows cannot
+// create are adjusted and overlong names are truncated. Components left
+// without any usable characters, such as emoji-only titles under
+// -restrict-filenames, become fallback.
+func (s sanitizer) path(p, fallback string) string {
+	parts := strings.Split(filepath.ToSlash(p), "/")
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		// Windows strips trailing dots and spaces, which breaks lookups
+		part = strings.TrimRight(part, ". ")
+		if strings.Trim(part, "-_. ") == "" {
+			part = fallback
+		}
+		stem, _, _ := strings.Cut(part, ".")
+		if windowsReserved[strings.ToUpper(stem)] {
+			part = "_" + part
+		}
+		parts[i] = s.truncate(part, 0)
+	}
+	return filepath.FromSlash(strings.Join(parts, "/"))
+}
+
+// truncate shortens name so that name plus reserve more bytes fit within
+// maxLen, cutting on a character boundary.
+func (s sanitizer) truncate(name string, reserve int) string {
+	limit := s.maxLen - reserve
+	if s.maxLen <= 0 || len(name) <= limit {
+		return name
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	if limit >= len(name) {
+		return name
+	}
+	for limit > 0 && !utf8.RuneStart(name[limit]) {
+		limit--
+	}
+	return strings.TrimRight(name[:limit], ". ")
+}
+
+// withSuffix appends suffix to the last component of base, truncating it
+// first if needed so the suffix is never cut off.
+func (s sanitizer) withSuffix(base, suffix string) string {
+	dir, name := filepath.Split(base)
+	return dir + s.truncate(name, len(suffix)) + suffix
+}
+
+func (d *Downloader) sanitizer() sanitizer {
+	return sanitizer{restrict: d.config.RestrictFilenames, maxLen: d.config.MaxFilenameLength}
+}
+
+// claimOutput reserves base+ext for video. When another video in this run
+// already claimed the same name, or an existing file's info.json belongs
+// to a different video, " [videoID]" is appended to keep them apart.
+func (d *Downloader) claimOutput(video *youtube.Video, base, ext string) string {
+	d.claimsMu.Lock()
+	defer d.claimsMu.Unlock()
+
+	if d.claims == nil {
+		d.claims = make(map[string]string)
+	}
+	// Compare case-insensitively for macOS and Windows filesystems
+	key := strings.ToLower(base + ext)
+	owner, claimed := d.claims[key]
+	conflict := claimed && owner != video.ID
+	if !claimed {
+		info, err := readInfoJSON(filepath.Join(d.config.OutputDir, base+ext))
+		conflict = err == nil && info.ID != "" && info.ID != video.ID
+	}
+	if conflict {
+		base = d.sanitizer().withSuffix(base, " ["+video.ID+"]")
+		key = strings.ToLower(base + ext)
+	}
+	d.claims[key] = video.ID
+	return base
+}
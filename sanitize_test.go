@@ -0,0 +1,87 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSanitizerField(t *testing.T) {
+	tests := []struct {
+		value    string
+		restrict bool
+		want     string
+	}{
+		{"AC/DC: Live?", false, "AC-DC- Live-"},
+		{`a<b>c"d\e|f*g`, false, "a-b-c-d-e-f-g"},
+		{"tab\there\x00", false, "tabhere"},
+		{"Café 🎵 mix", false, "Café 🎵 mix"},
+		{"Café 🎵 mix", true, "Caf_mix"},
+		{"Rock & Roll (Live)", true, "Rock_Roll_(Live)"},
+	}
+	for _, tt := range tests {
+		if got := (sanitizer{restrict: tt.restrict}).field(tt.value); got != tt.want {
+			t.Errorf("field(%q) with restrict=%v = %q, want %q", tt.value, tt.restrict, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizerPath(t *testing.T) {
+	tests := []struct {
+		path   string
+		maxLen int
+		want   string
+	}{
+		{"Channel/Title", 0, filepath.Join("Channel", "Title")},
+		{"dots.../trailing. ", 0, filepath.Join("dots", "trailing")},
+		{"CON", 0, "_CON"},
+		{"nul.txt", 0, "_nul.txt"},
+		{"Channel/___", 0, filepath.Join("Channel", "fallback")},
+		{"abcdefghij/klmnopqrst", 4, filepath.Join("abcd", "klmn")},
+	}
+	for _, tt := range tests {
+		if got := (sanitizer{maxLen: tt.maxLen}).path(tt.path, "fallback"); got != tt.want {
+			t.Errorf("path(%q) with maxLen=%d = %q, want %q", tt.path, tt.maxLen, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizerTruncate(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxLen  int
+		reserve int
+		want    string
+	}{
+		{"short", 10, 0, "short"},
+		{"unlimited name", 0, 5, "unlimited name"},
+		{"abcdefghij", 5, 0, "abcde"},
+		{"abcdefghij", 8, 3, "abcde"},
+		// Never cut inside a character: é is two bytes
+		{"caféteria", 4, 0, "caf"},
+		{"end. here", 5, 0, "end"},
+		// A reserve past maxLen still keeps a byte of the name
+		{"abcdef", 4, 10, "a"},
+		{"x", 4, 10, "x"},
+		{"", 4, 10, ""},
+	}
+	for _, tt := range tests {
+		if got := (sanitizer{maxLen: tt.maxLen}).truncate(tt.name, tt.reserve); got != tt.want {
+			t.Errorf("truncate(%q, %d) with maxLen=%d = %q, want %q", tt.name, tt.reserve, tt.maxLen, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizerWithSuffix(t *testing.T) {
+	s := sanitizer{maxLen: 20}
+	tests := []struct {
+		base, suffix, want string
+	}{
+		{"Title", " [abc]", "Title [abc]"},
+		{filepath.Join("dir", "A very long video title"), " [dQw4w9WgXcQ]", filepath.Join("dir", "A very [dQw4w9WgXcQ]")},
+	}
+	for _, tt := range tests {
+		if got := s.withSuffix(tt.base, tt.suffix); got != tt.want {
+			t.Errorf("withSuffix(%q, %q) = %q, want %q", tt.base, tt.suffix, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	searchEndpoint = "https://www.youtube.com/youtubei/v1/search?prettyPrint=false"
+	// Restricts search results to videos only
+	searchVideosParam = "EgIQAQ=="
+)
+
+type SearchResult struct {
+	ID       string
+	Title    string
+	Author   string
+	Duration string
+}
+
+// parseSearchURL recognises yt-dlp style "ytsearchN:query" pseudo-URLs.
+func parseSearchURL(s string) (query string, limit int, ok bool) {
+	if !strings.HasPrefix(s, "ytsearch") {
+		return "", 0, false
+	}
+	rest := strings.TrimPrefix(s, "ytsearch")
+	colon := strings.Index(rest, ":")
+	if colon < 0 {
+		return "", 0, false
+	}
+
+	limit = 1
+	switch n := rest[:colon]; n {
+	case "":
+	case "all":
+		limit = 100
+	default:
+		var err error
+		if limit, err = strconv.Atoi(n); err != nil || limit < 1 {
+			return "", 0, false
+		}
+	}
+	return strings.TrimSpace(rest[colon+1:]), limit, true
+}
+
+func (d *Downloader) httpClient() *http.Client {
+	if d.http != nil {
+		return d.http
+	}
+	return http.DefaultClient
+}
+
+// Search queries the innertube search endpoint, following continuations
+// until limit results have been collected or the results run out.
+func (d *Downloader) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	body := map[string]any{
+		"context": innertubeContext(),
+		"query":   query,
+		"params":  searchVideosParam,
+	}
+
+	var results []SearchResult
+	seen := make(map[string]bool)
+	followed := make(map[string]bool) // continuation tokens
+
+	for len(results) < limit {
+		found := len(results)
+		resp, err := d.postInnertube(ctx, searchEndpoint, body)
+		if err != nil {
+			return nil, fmt.Errorf("search failed: %v", err)
+		}
+
+		var continuation string
+		walkJSON(resp, func(key string, value map[string]any) {
+			switch key {
+			case "itemSectionRenderer":
+				// Only the section's own items: shelves and recommendations
+				// nested in it aren't results for the query
+				items, _ := value["contents"].([]any)
+				for _, item := range items {
+					obj, _ := item.(map[string]any)
+					renderer, ok := obj["videoRenderer"].(map[string]any)
+					if !ok {
+						continue
+					}
+					r := searchResultFromRenderer(renderer)
+					if r.ID != "" && !seen[r.ID] {
+						seen[r.ID] = true
+						results = append(results, r)
+					}
+				}
+			case "continuationCommand":
+				if token, ok := value["token"].(string); ok {
+					continuation = token
+				}
+			}
+		})
+
+		// A page of nothing new, or a token that was already followed,
+		// means the results have run out even if there is a continuation
+		if continuation == "" || len(results) == found || followed[continuation] {
+			break
+		}
+		followed[continuation] = true
+		delete(body, "query")
+		delete(body, "params")
+		body["continuation"] = continuation
+	}
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func innertubeContext() map[string]any {
+	return map[string]any{
+		"client": map[string]any{
+			"clientName":    "WEB",
+			"clientVersion": "2.20240101.00.00",
+			"hl":            "en",
+			"gl":            "US",
+		},
+	}
+}
+
+func (d *Downloader) postInnertube(ctx context.Context, url string, body any) (any, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var out any
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// walkJSON calls fn for every object-valued key in a decoded JSON tree.
+func walkJSON(node any, fn func(key string, value map[string]any)) {
+	switch v := node.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if obj, ok := child.(map[string]any); ok {
+				fn(key, obj)
+			}
+			walkJSON(child, fn)
+		}
+	case []any:
+		for _, child := range v {
+			walkJSON(child, fn)
+		}
+	}
+}
+
+// jsonText extracts the display text of an innertube text object, which is
+// either {"simpleText": "..."} or {"runs": [{"text": "..."}, ...]}.
+func jsonText(node any) string {
+	obj, ok := node.(map[string]any)
+	if !ok {
+		return ""
+	}
+	if s, ok := obj["simpleText"].(string); ok {
+		return s
+	}
+	runs, _ := obj["runs"].([]any)
+	var sb strings.Builder
+	for _, run := range runs {
+		if r, ok := run.(map[string]any); ok {
+			if s, ok := r["text"].(string); ok {
+				sb.WriteString(s)
+			}
+		}
+	}
+	return sb.String()
+}
+
+func searchResultFromRenderer(r map[string]any) SearchResult {
+	id, _ := r["videoId"].(string)
+	return SearchResult{
+		ID:       id,
+		Title:    jsonText(r["title"]),
+		Author:   jsonText(r["ownerText"]),
+		Duration: jsonText(r["lengthText"]),
+	}
+}
+
+// pickSearchResults prints the results and asks which ones to download.
+// Accepts a comma separated list of numbers, "all", or an empty line for none.
+func pickSearchResults(results []SearchResult, in io.Reader, out io.Writer) []SearchResult {
+	for i, r := range results {
+		fmt.Fprintf(out, "%2d. %s [%s] (%s)\n", i+1, r.Title, r.Author, r.Duration)
+	}
+	fmt.Fprint(out, "Select videos to download (e.g. 1,3 or all): ")
+
+	line, _ := bufio.NewReader(in).ReadString('\n')
+	line = strings.TrimSpace(line)
+	if line == "all" {
+		return results
+	}
+
+	var picked []SearchResult
+	for _, field := range strings.Split(line, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 1 || n > len(results) {
+			continue
+		}
+		picked = append(picked, results[n-1])
+	}
+	return picked
+}
+
+func (d *Downloader) processSearch(query string, limit int, interactive bool) error {
+	// Searching runs before there are jobs for an interrupt to cancel
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	results, err := d.Search(ctx, query, limit)
+	stop()
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		return fmt.Errorf("no results found for %q", query)
+	}
+
+	if interactive {
+		if results = pickSearchResults(results, os.Stdin, os.Stdout); len(results) == 0 {
+			return fmt.Errorf("no search results picked")
+		}
+	}
+
+	ids := make([]string, 0, len(results))
+	for _, r := range results {
+		d.logger.Printf("Queued search result: %s [%s]", r.Title, r.ID)
+		ids = append(ids, r.ID)
+	}
+	return d.ProcessVideos(ids)
+}
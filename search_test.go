@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// roundTripFunc serves requests without a network.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// searchPage is an innertube search response with a video for each ID
+// and, unless it is empty, a continuation token.
+func searchPage(token string, ids ...string) []byte {
+	var items []any
+	for _, id := range ids {
+		items = append(items, map[string]any{"videoRenderer": map[string]any{
+			"videoId": id,
+			"title":   map[string]any{"runs": []any{map[string]any{"text": "Video " + id}}},
+		}})
+	}
+	contents := []any{map[string]any{"itemSectionRenderer": map[string]any{"contents": items}}}
+	if token != "" {
+		contents = append(contents, map[string]any{"continuationItemRenderer": map[string]any{
+			"continuationEndpoint": map[string]any{"continuationCommand": map[string]any{"token": token}},
+		}})
+	}
+	data, _ := json.Marshal(map[string]any{"contents": contents})
+	return data
+}
+
+func TestSearchContinuations(t *testing.T) {
+	tests := []struct {
+		name      string
+		pages     map[string][]byte // by the continuation requested, "" for the first
+		limit     int
+		want      int
+		wantPages int
+	}{
+		{
+			name:      "stops at the limit",
+			pages:     map[string][]byte{"": searchPage("a", "v1", "v2"), "a": searchPage("b", "v3", "v4")},
+			limit:     3,
+			want:      3,
+			wantPages: 2,
+		},
+		{
+			name:      "stops without a continuation",
+			pages:     map[string][]byte{"": searchPage("a", "v1"), "a": searchPage("", "v2")},
+			limit:     10,
+			want:      2,
+			wantPages: 2,
+		},
+		{
+			name:      "stops on a page of repeats",
+			pages:     map[string][]byte{"": searchPage("a", "v1"), "a": searchPage("b", "v1"), "b": searchPage("c", "v2")},
+			limit:     10,
+			want:      1,
+			wantPages: 2,
+		},
+		{
+			name:      "stops on a repeated token",
+			pages:     map[string][]byte{"": searchPage("a", "v1"), "a": searchPage("a", "v2")},
+			limit:     10,
+			want:      2,
+			wantPages: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			d := &Downloader{http: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				requests++
+				var body struct {
+					Continuation string `json:"continuation"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					return nil, err
+				}
+				page, ok := tt.pages[body.Continuation]
+				if !ok {
+					return nil, fmt.Errorf("unexpected continuation %q", body.Continuation)
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(page))}, nil
+			})}}
+			results, err := d.Search(context.Background(), "query", tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != tt.want || requests != tt.wantPages {
+				t.Errorf("got %d results from %d pages, want %d from %d", len(results), requests, tt.want, tt.wantPages)
+			}
+		})
+	}
+}
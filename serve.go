@@ -0,0 +1,744 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// jobView is the JSON form of a job in the serve API.
+type jobView struct {
+	ID         int                  `json:"id"`
+	URL        string               `json:"url"`
+	VideoID    string               `json:"video_id,omitempty"`
+	Title      string               `json:"title"`
+	OutputPath string               `json:"output_path,omitempty"`
+	Status     JobStatus            `json:"status"`
+	Phase      JobPhase             `json:"phase,omitempty"`
+	Phases     map[JobPhase]float64 `json:"phases,omitempty"`
+	Priority   string               `json:"priority"`
+	Paused     bool                 `json:"paused"`
+	Downloaded int64                `json:"downloaded"`
+	Total      int64                `json:"total"`
+	Speed      float64              `json:"speed"`
+	Elapsed    float64              `json:"elapsed"`
+	// Seconds of media merged or converted so far, and in all
+	Processed    float64 `json:"processed,omitempty"`
+	ProcessTotal float64 `json:"process_total,omitempty"`
+	Error        string  `json:"error,omitempty"`
+
+	// Human-readable forms, omitted with -raw-units
+	DownloadedText string `json:"downloaded_text,omitempty"`
+	TotalText      string `json:"total_text,omitempty"`
+	SpeedText      string `json:"speed_text,omitempty"`
+	ElapsedText    string `json:"elapsed_text,omitempty"`
+}
+
+func newJobView(snap JobSnapshot) jobView {
+	v := jobView{
+		ID:           snap.ID,
+		URL:          snap.URL,
+		VideoID:      snap.VideoID,
+		Title:        snap.Title,
+		OutputPath:   snap.OutputPath,
+		Status:       snap.Status,
+		Phase:        snap.Phase,
+		Priority:     snap.Priority.String(),
+		Paused:       snap.Paused,
+		Downloaded:   snap.Downloaded,
+		Total:        snap.Total,
+		Speed:        snap.Speed,
+		Elapsed:      snap.Elapsed.Seconds(),
+		Processed:    snap.Processed.Seconds(),
+		ProcessTotal: snap.ProcessTotal.Seconds(),
+	}
+	if len(snap.Phases) > 0 {
+		v.Phases = make(map[JobPhase]float64)
+		for _, t := range snap.Phases {
+			v.Phases[t.Phase] = t.Duration.Seconds()
+		}
+	}
+	if snap.Err != nil {
+		v.Error = snap.Err.Error()
+	}
+	if !rawUnits {
+		v.DownloadedText = formatBytes(snap.Downloaded)
+		v.TotalText = formatBytes(snap.Total)
+		v.SpeedText = formatRate(snap.Speed)
+		v.ElapsedText = formatDuration(snap.Elapsed)
+	}
+	return v
+}
+
+// server exposes a downloader over HTTP. Submitted URLs are processed in
+// the background; their jobs can then be listed, paused and canceled.
+type server struct {
+	d       *Downloader
+	args    []string
+	users   []*apiUser // from -api-keys, nil for an open server
+	subs    *subscriptions
+	batches sync.WaitGroup
+}
+
+// routes serves the web UI and health check to anyone, since they give
+// nothing away, and the API to whoever has a token.
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", sameOrigin(s.authenticate(s.apiRoutes())))
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	mux.Handle("GET /{$}", webHandler())
+	mux.Handle("GET /static/", webHandler())
+	return mux
+}
+
+// sameOrigin refuses requests that change anything when a browser sent
+// them from another site, so a page the user happens to visit can't use
+// their token cookie, or an open server, to queue downloads. Clients
+// other than browsers send no Origin and are let through.
+func sameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if r.Header.Get("Sec-Fetch-Site") == "cross-site" || !originMatches(r) {
+				writeError(w, http.StatusForbidden, "cross-origin request refused")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// originMatches reports whether r has no Origin or one naming this server.
+func originMatches(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// requireJSON refuses a request body that isn't sent as JSON, since an
+// HTML form can post any other type to another site without asking it
+// first.
+func requireJSON(w http.ResponseWriter, r *http.Request) bool {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, "expected Content-Type: application/json")
+		return false
+	}
+	return true
+}
+
+func (s *server) apiRoutes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", s.handleSubmit)
+	mux.HandleFunc("GET /jobs", s.handleList)
+	mux.HandleFunc("GET /jobs/{id}", s.withJob(func(w http.ResponseWriter, r *http.Request, job *Job) {
+		writeJSON(w, http.StatusOK, newJobView(job.Snapshot()))
+	}))
+	mux.HandleFunc("GET /jobs/{id}/events", s.withJob(func(w http.ResponseWriter, r *http.Request, job *Job) {
+		s.streamEvents(w, r, map[int]bool{job.ID: true})
+	}))
+	mux.HandleFunc("GET /jobs/{id}/file", s.withJob(s.handleFile))
+	mux.HandleFunc("GET /events", func(w http.ResponseWriter, r *http.Request) {
+		s.streamEvents(w, r, nil)
+	})
+	mux.HandleFunc("GET /files/", func(w http.ResponseWriter, r *http.Request) {
+		root := s.d.config.OutputDir
+		if u := apiUserFrom(r.Context()); u != nil {
+			root = filepath.Join(root, u.dir)
+		}
+		http.StripPrefix("/files/", http.FileServer(http.Dir(root))).ServeHTTP(w, r)
+	})
+	mux.HandleFunc("DELETE /jobs/{id}", s.withJob(func(w http.ResponseWriter, r *http.Request, job *Job) {
+		job.Cancel()
+		writeJSON(w, http.StatusOK, newJobView(job.Snapshot()))
+	}))
+	mux.HandleFunc("POST /jobs/{id}/pause", s.withJob(func(w http.ResponseWriter, r *http.Request, job *Job) {
+		job.Pause()
+		writeJSON(w, http.StatusOK, newJobView(job.Snapshot()))
+	}))
+	mux.HandleFunc("POST /pause", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		s.d.PauseAll()
+		writeJSON(w, http.StatusOK, map[string]string{"status": "paused"})
+	}))
+	mux.HandleFunc("POST /resume", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		s.d.ResumeAll()
+		writeJSON(w, http.StatusOK, map[string]string{"status": "running"})
+	}))
+	mux.HandleFunc("GET /metrics", adminOnly(s.handleMetrics))
+	mux.HandleFunc("POST /stop", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		s.d.StopAfterCurrent()
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "stopping"})
+	}))
+	mux.HandleFunc("POST /config/reload", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		settings, err := s.reload()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, settings)
+	}))
+	mux.HandleFunc("GET /concurrency", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.concurrency())
+	}))
+	mux.HandleFunc("PUT /concurrency", adminOnly(s.handleSetConcurrency))
+	mux.HandleFunc("GET /subscriptions", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		views := []subscriptionView{}
+		for _, sub := range s.subs.list() {
+			views = append(views, newSubscriptionView(sub))
+		}
+		writeJSON(w, http.StatusOK, views)
+	}))
+	mux.HandleFunc("POST /subscriptions", adminOnly(s.handleSubscribe))
+	mux.HandleFunc("DELETE /subscriptions/{name}", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		switch err := s.subs.remove(r.PathValue("name")); {
+		case errors.Is(err, errNoSubscription):
+			writeError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, errConfigSubscription):
+			writeError(w, http.StatusConflict, err.Error())
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, err.Error())
+		default:
+			writeJSON(w, http.StatusOK, map[string]string{"status": "removed"})
+		}
+	}))
+	mux.HandleFunc("POST /jobs/{id}/resume", s.withJob(func(w http.ResponseWriter, r *http.Request, job *Job) {
+		job.Resume()
+		writeJSON(w, http.StatusOK, newJobView(job.Snapshot()))
+	}))
+	return mux
+}
+
+// handleHealth answers container health checks: 200 while the server
+// takes downloads, 503 once it is stopping or can't reach its output
+// directory.
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if s.d.Stopping() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "stopping"})
+		return
+	}
+	if s.d.config.Dest == nil {
+		if _, err := os.Stat(s.d.config.OutputDir); err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unhealthy", "error": err.Error()})
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+type submitRequest struct {
+	URL    string `json:"url"`
+	Kind   string `json:"kind"`   // video (default), playlist or channel
+	Format string `json:"format"` // -f selector in place of the configured one
+	// low, normal or high; by default high for a video, normal for a
+	// playlist and low for a channel
+	Priority string `json:"priority"`
+}
+
+func (s *server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+	var req submitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" {
+		writeError(w, http.StatusBadRequest, "expected {\"url\": ..., \"kind\": video|playlist|channel, \"format\": ..., \"priority\": low|normal|high}")
+		return
+	}
+	switch err := s.enqueue(apiUserFrom(r.Context()), req); {
+	case errors.Is(err, errStopping):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	case errors.Is(err, errQuota):
+		writeError(w, http.StatusTooManyRequests, err.Error())
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "accepted"})
+	}
+}
+
+var (
+	errStopping = errors.New("stopping after the current downloads")
+	errQuota    = errors.New("hourly submission limit reached")
+)
+
+// enqueue starts processing a URL submitted by user in the background.
+func (s *server) enqueue(user *apiUser, req submitRequest) error {
+	if s.d.Stopping() {
+		return errStopping
+	}
+	var spec *formatSpec
+	if req.Format != "" {
+		var err error
+		if spec, err = parseFormatSpec(req.Format); err != nil {
+			return fmt.Errorf("invalid format: %v", err)
+		}
+	}
+	priority := map[string]jobPriority{"": priorityHigh, "video": priorityHigh, "channel": priorityLow}[req.Kind]
+	if req.Priority != "" {
+		var err error
+		if priority, err = parsePriority(req.Priority); err != nil {
+			return err
+		}
+	}
+	if user != nil {
+		if wait := user.allowSubmit(time.Now()); wait > 0 {
+			return fmt.Errorf("%w, try again in %s", errQuota, formatDuration(wait))
+		}
+	}
+
+	var process func() error
+	switch req.Kind {
+	case "", "video":
+		process = func() error {
+			return s.d.processBatch(user.source(batchSource{Format: spec, Priority: priority}), []string{req.URL}, nil).Err()
+		}
+	case "playlist":
+		process = func() error {
+			source, ids, positions, err := s.d.listPlaylist(playlistURL(req.URL))
+			if err != nil {
+				return err
+			}
+			source.Format, source.Priority = spec, priority
+			return s.d.processBatch(user.source(source), ids, positions).Err()
+		}
+	case "channel":
+		process = func() error {
+			source, ids, err := s.d.channelUploads(req.URL)
+			if err != nil {
+				return err
+			}
+			source.Format, source.Priority = spec, priority
+			return s.d.processBatch(user.source(source), ids, nil).Err()
+		}
+	default:
+		return fmt.Errorf("unknown kind %q", req.Kind)
+	}
+
+	s.batches.Add(1)
+	go func() {
+		defer s.batches.Done()
+		if err := process(); err != nil {
+			s.d.logger.Printf("Request for %s finished with errors: %v", req.URL, err)
+		}
+	}()
+	return nil
+}
+
+// subscriptionView is the JSON form of a subscription in the serve API.
+type subscriptionView struct {
+	Name     string    `json:"name"`
+	URL      string    `json:"url"`
+	Schedule string    `json:"schedule"`
+	Config   bool      `json:"config"`
+	LastRun  time.Time `json:"last_run"`
+	NextRun  time.Time `json:"next_run"`
+}
+
+func newSubscriptionView(sub subscription) subscriptionView {
+	return subscriptionView{
+		Name:     sub.Name,
+		URL:      sub.URL,
+		Schedule: sub.Schedule,
+		Config:   sub.Config,
+		LastRun:  sub.LastRun,
+		NextRun:  sub.nextRun(),
+	}
+}
+
+// handleSubscribe adds or replaces a subscription, which first runs at
+// its next scheduled time.
+func (s *server) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name     string `json:"name"`
+		URL      string `json:"url"`
+		Schedule string `json:"schedule"`
+	}
+	if !requireJSON(w, r) {
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "expected {\"name\": ..., \"url\": ..., \"schedule\": \"0 3 * * *\"}")
+		return
+	}
+	if s.d.archive == nil {
+		writeError(w, http.StatusBadRequest, "subscriptions need -download-archive to tell new uploads from old ones")
+		return
+	}
+	sub, err := s.subs.add(req.Name, req.URL, req.Schedule)
+	switch {
+	case errors.Is(err, errConfigSubscription):
+		writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeJSON(w, http.StatusCreated, newSubscriptionView(sub))
+	}
+}
+
+// runSubscriptions checks subscriptions as they fall due until ctx is done
+// or the downloader stops.
+func (s *server) runSubscriptions(ctx context.Context) {
+	for {
+		wait := 24 * time.Hour
+		if next := s.d.runDueSubscriptions(s.subs, false, 0); !next.IsZero() {
+			wait = time.Until(next)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-s.subs.changed:
+			timer.Stop()
+		case <-s.d.stopped:
+			timer.Stop()
+			return
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (s *server) handleList(w http.ResponseWriter, r *http.Request) {
+	views := []jobView{}
+	for _, job := range s.jobs(apiUserFrom(r.Context())) {
+		views = append(views, newJobView(job.Snapshot()))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// jobs returns the jobs user can see, in submission order.
+func (s *server) jobs(user *apiUser) []*Job {
+	var jobs []*Job
+	for _, job := range s.d.Jobs() {
+		if user.sees(job) {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+func (s *server) withJob(fn func(http.ResponseWriter, *http.Request, *Job)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid job id")
+			return
+		}
+		// Other users' jobs are as good as missing
+		for _, job := range s.jobs(apiUserFrom(r.Context())) {
+			if job.ID == id {
+				fn(w, r, job)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "no such job")
+	}
+}
+
+// handleFile sends a finished job's file as a download.
+func (s *server) handleFile(w http.ResponseWriter, r *http.Request, job *Job) {
+	snap := job.Snapshot()
+	if snap.Status != JobDone && snap.Status != JobSkipped || !fileExists(snap.OutputPath) {
+		writeError(w, http.StatusNotFound, "no file for this job")
+		return
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(snap.OutputPath)}))
+	http.ServeFile(w, r, snap.OutputPath)
+}
+
+// streamEvents streams jobs as server-sent events, one "job" event with a
+// jobView each time one changes, for as long as s.follow does.
+func (s *server) streamEvents(w http.ResponseWriter, r *http.Request, want map[int]bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	last := make(map[int][]byte)
+	s.follow(r.Context(), apiUserFrom(r.Context()), want, func(snap JobSnapshot) error {
+		data, err := json.Marshal(newJobView(snap))
+		if err != nil || bytes.Equal(data, last[snap.ID]) {
+			return err
+		}
+		last[snap.ID] = data
+		if _, err := fmt.Fprintf(w, "event: job\ndata: %s\n\n", data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+}
+
+// followPollInterval is how often followers of jobs look for changes.
+const followPollInterval = 500 * time.Millisecond
+
+// follow calls send with a snapshot of each of user's jobs in want, or of
+// all of them if want is nil, every followPollInterval. With want it
+// returns once those jobs have finished; otherwise it runs until ctx is
+// done.
+func (s *server) follow(ctx context.Context, user *apiUser, want map[int]bool, send func(JobSnapshot) error) error {
+	ticker := time.NewTicker(followPollInterval)
+	defer ticker.Stop()
+	for {
+		pending := 0
+		for _, job := range s.jobs(user) {
+			if want != nil && !want[job.ID] {
+				continue
+			}
+			snap := job.Snapshot()
+			if snap.Status == JobQueued || snap.Status == JobRunning {
+				pending++
+			}
+			if err := send(snap); err != nil {
+				return err
+			}
+		}
+		if want != nil && pending == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// reloadableSettings are the settings a running daemon can change.
+type reloadableSettings struct {
+	Concurrency         int    `json:"concurrency"`
+	MetadataConcurrency int    `json:"metadata_concurrency"`
+	FFmpegConcurrency   int    `json:"ffmpeg_concurrency"`
+	LimitRate           string `json:"limit_rate"`
+	Subscriptions       int    `json:"subscriptions"`
+}
+
+// reload re-reads the config file, with the original command line still
+// taking precedence, and applies the concurrency limits, bandwidth
+// schedule and subscriptions. In-flight downloads keep running; new
+// limits apply as slots free up and rate limits apply from the next read.
+func (s *server) reload() (reloadableSettings, error) {
+	flags, _, err := parseCommandFlags(findCommand("serve"), s.args, flag.ContinueOnError)
+	if err != nil {
+		return reloadableSettings{}, err
+	}
+	get := func(name string) any {
+		return flags.Lookup(name).Value.(flag.Getter).Get()
+	}
+	settings := reloadableSettings{
+		Concurrency:         get("concurrency").(int),
+		MetadataConcurrency: get("metadata-concurrency").(int),
+		FFmpegConcurrency:   get("ffmpeg-concurrency").(int),
+		LimitRate:           get("limit-rate").(string),
+	}
+
+	if settings.Concurrency < 1 || settings.MetadataConcurrency < 1 || settings.FFmpegConcurrency < 1 {
+		return reloadableSettings{}, fmt.Errorf("concurrency values must be at least 1")
+	}
+	schedule, err := parseRateSchedule(settings.LimitRate)
+	if err != nil {
+		return reloadableSettings{}, fmt.Errorf("invalid -limit-rate: %v", err)
+	}
+	subs, err := loadSubscriptions(commandConfigPath(flags), filepath.Dir(s.subs.path))
+	if err != nil {
+		return reloadableSettings{}, fmt.Errorf("failed to load subscriptions: %v", err)
+	}
+	settings.Subscriptions = len(subs.list())
+	if settings.Subscriptions > 0 && s.d.archive == nil {
+		return reloadableSettings{}, fmt.Errorf("subscriptions need -download-archive to tell new uploads from old ones")
+	}
+
+	if err := s.subs.replaceConfig(subs); err != nil {
+		return reloadableSettings{}, err
+	}
+	s.d.sched.setLimit(settings.Concurrency)
+	s.d.metaGuard.setLimit(settings.MetadataConcurrency)
+	s.d.ffmpegGuard.setLimit(settings.FFmpegConcurrency)
+	s.d.limiter.setSchedule(schedule)
+	s.d.logger.Printf("Reloaded config: concurrency %d, metadata concurrency %d, ffmpeg concurrency %d, limit rate %q, %d subscriptions",
+		settings.Concurrency, settings.MetadataConcurrency, settings.FFmpegConcurrency, settings.LimitRate, settings.Subscriptions)
+	return settings, nil
+}
+
+// concurrencyLimits are the limits GET and PUT /concurrency read and
+// change while serving, until the next config reload.
+type concurrencyLimits struct {
+	Concurrency         int `json:"concurrency"`
+	MetadataConcurrency int `json:"metadata_concurrency"`
+	FFmpegConcurrency   int `json:"ffmpeg_concurrency"`
+}
+
+func (s *server) concurrency() concurrencyLimits {
+	return concurrencyLimits{
+		Concurrency:         s.d.sched.capacity(),
+		MetadataConcurrency: s.d.metaGuard.capacity(),
+		FFmpegConcurrency:   s.d.ffmpegGuard.capacity(),
+	}
+}
+
+// handleSetConcurrency changes the limits given, keeping the others.
+// Running work is unaffected; lower limits take effect as it finishes.
+func (s *server) handleSetConcurrency(w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+	limits := s.concurrency()
+	if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+		writeError(w, http.StatusBadRequest, "expected {\"concurrency\": ..., \"metadata_concurrency\": ..., \"ffmpeg_concurrency\": ...}")
+		return
+	}
+	if limits.Concurrency < 1 || limits.MetadataConcurrency < 1 || limits.FFmpegConcurrency < 1 {
+		writeError(w, http.StatusBadRequest, "concurrency values must be at least 1")
+		return
+	}
+	s.d.sched.setLimit(limits.Concurrency)
+	s.d.metaGuard.setLimit(limits.MetadataConcurrency)
+	s.d.ffmpegGuard.setLimit(limits.FFmpegConcurrency)
+	s.d.logger.Printf("Set concurrency %d, metadata concurrency %d, ffmpeg concurrency %d",
+		limits.Concurrency, limits.MetadataConcurrency, limits.FFmpegConcurrency)
+	writeJSON(w, http.StatusOK, limits)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func setupServe(flags *flag.FlagSet) func([]string) error {
+	opts := registerDownloadFlags(flags)
+	opts.registerJobRetention(flags)
+	listen := flags.String("listen", "127.0.0.1:8080", "Address for the HTTP API")
+	grpcListen := flags.String("grpc-listen", "", "Address for the gRPC API (disabled if empty)")
+	apiKeys := flags.String("api-keys", "", "File of API users with their tokens, output directories and quotas; without it the API is open to anyone who can reach it")
+
+	return func(args []string) error {
+		subs, err := loadSubscriptions(commandConfigPath(flags), *opts.outputDir)
+		if err != nil {
+			return fmt.Errorf("failed to load subscriptions: %v", err)
+		}
+		// Like watch, subscriptions need an archive to tell new uploads
+		// from ones already fetched
+		if len(subs.list()) > 0 && *opts.archivePath == "" {
+			*opts.archivePath = filepath.Join(*opts.outputDir, watchArchiveName)
+		}
+		config, err := opts.Config()
+		if err != nil {
+			return err
+		}
+		var users []*apiUser
+		if *apiKeys != "" {
+			if users, err = loadAPIKeys(*apiKeys); err != nil {
+				return fmt.Errorf("failed to load API keys: %v", err)
+			}
+		}
+		downloader, closeFn, err := opts.newDownloader(config)
+		if err != nil {
+			return err
+		}
+		defer closeFn()
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		// serve is only reachable as an explicit command, so its own
+		// arguments follow it directly and can be parsed again on reload
+		s := &server{d: downloader, args: os.Args[2:], users: users, subs: subs}
+		// Event streams end with the server rather than holding up its
+		// shutdown
+		baseCtx, cancelStreams := context.WithCancel(context.Background())
+		defer cancelStreams()
+		httpServer := &http.Server{Addr: *listen, Handler: s.routes(), BaseContext: func(net.Listener) context.Context { return baseCtx }}
+		httpServer.RegisterOnShutdown(cancelStreams)
+
+		errc := make(chan error, 2)
+		go func() { errc <- httpServer.ListenAndServe() }()
+		downloader.logger.Printf("Serving API and web UI on http://%s", *listen)
+
+		if *grpcListen != "" {
+			lis, err := net.Listen("tcp", *grpcListen)
+			if err != nil {
+				httpServer.Close()
+				return fmt.Errorf("failed to listen for gRPC: %v", err)
+			}
+			grpcServer := newGRPCServer(s)
+			go func() { errc <- grpcServer.Serve(lis) }()
+			// Progress streams would otherwise hold up the shutdown
+			defer grpcServer.Stop()
+			downloader.logger.Printf("Serving gRPC API on %s", lis.Addr())
+		}
+
+		s.batches.Add(1)
+		go func() {
+			defer s.batches.Done()
+			s.runSubscriptions(ctx)
+		}()
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGQUIT)
+		defer signal.Stop(quit)
+		defer downloader.handlePauseSignals()()
+
+	wait:
+		for {
+			select {
+			case err := <-errc:
+				return err
+			case <-hup:
+				if _, err := s.reload(); err != nil {
+					downloader.logger.Printf("Config reload failed, keeping current settings: %v", err)
+				}
+			case <-quit:
+				downloader.StopAfterCurrent()
+			case <-downloader.stopped:
+				// Let the running downloads finish before shutting down
+				s.batches.Wait()
+				break wait
+			case <-ctx.Done():
+				break wait
+			}
+		}
+
+		downloader.logger.Printf("Shutting down, canceling running downloads")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err = httpServer.Shutdown(shutdownCtx)
+		downloader.CancelAll()
+		s.batches.Wait()
+		downloader.temps.removeAll()
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+		return err
+	}
+}
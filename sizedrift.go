@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// Drifts larger than this fraction of the estimate are reported in the summary
+const driftThreshold = 0.10
+
+type sizeRecord struct {
+	Title     string
+	Estimated int64
+	Actual    int64
+}
+
+func (r sizeRecord) drift() float64 {
+	if r.Estimated <= 0 {
+		return 0
+	}
+	return float64(r.Actual-r.Estimated) / float64(r.Estimated)
+}
+
+// sizeHistory is persisted between runs so estimates can be corrected
+// by how far off they have been in the past.
+type sizeHistory struct {
+	Samples int     `json:"samples"`
+	Ratio   float64 `json:"ratio"`
+}
+
+type sizeTracker struct {
+	mu      sync.Mutex
+	path    string
+	history sizeHistory
+	records []sizeRecord
+}
+
+func newSizeTracker(path string) *sizeTracker {
+	t := &sizeTracker{path: path, history: sizeHistory{Ratio: 1}}
+	if path == "" {
+		return t
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		var h sizeHistory
+		if json.Unmarshal(data, &h) == nil && h.Ratio > 0 {
+			t.history = h
+		}
+	}
+	return t
+}
+
+func defaultSizeHistoryPath() string {
+	dir, err := ytdlConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "size_history.json")
+}
+
+// Adjust scales a raw estimate by the historical actual/estimated ratio.
+func (t *sizeTracker) Adjust(estimated int64) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return int64(math.Round(float64(estimated) * t.history.Ratio))
+}
+
+func (t *sizeTracker) Record(title string, estimated, actual int64) {
+	if estimated <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.records = append(t.records, sizeRecord{Title: title, Estimated: estimated, Actual: actual})
+
+	// Running mean of the ratio, capped so old runs slowly age out
+	ratio := float64(actual) / float64(estimated)
+	n := float64(t.history.Samples)
+	if n > 50 {
+		n = 50
+	}
+	t.history.Ratio = (t.history.Ratio*n + ratio) / (n + 1)
+	t.history.Samples++
+}
+
+// Report logs every download whose size drifted beyond driftThreshold and
+// saves the updated history for future runs.
+func (t *sizeTracker) Report(logger *log.Logger) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.records) == 0 {
+		return
+	}
+
+	var totalEstimated, totalActual int64
+	for _, r := range t.records {
+		totalEstimated += r.Estimated
+		totalActual += r.Actual
+		if d := r.drift(); math.Abs(d) > driftThreshold {
+			logger.Printf("Size drift for %s: estimated %s, downloaded %s (%+.1f%%)", r.Title, formatBytes(r.Estimated), formatBytes(r.Actual), d*100)
+		}
+	}
+	logger.Printf("Estimated %s, downloaded %s across %d files", formatBytes(totalEstimated), formatBytes(totalActual), len(t.records))
+
+	if t.path == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+		logger.Printf("Failed to save size history: %v", err)
+		return
+	}
+	data, _ := json.MarshalIndent(t.history, "", "  ")
+	if err := os.WriteFile(t.path, data, 0644); err != nil {
+		logger.Printf("Failed to save size history: %v", err)
+	}
+}
+
+// estimateFormatSize uses the advertised content length, falling back to
+// bitrate times duration when YouTube omits it.
+func estimateFormatSize(format *youtube.Format, duration time.Duration) int64 {
+	if format == nil {
+		return 0
+	}
+	if format.ContentLength > 0 {
+		return format.ContentLength
+	}
+	bitrate := format.AverageBitrate
+	if bitrate == 0 {
+		bitrate = format.Bitrate
+	}
+	return int64(float64(bitrate) / 8 * duration.Seconds())
+}
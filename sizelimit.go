@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// estimatedSize is how big downloading sel of video is expected to be,
+// corrected by how far off past estimates were.
+func (d *Downloader) estimatedSize(video *youtube.Video, sel formatSelection) int64 {
+	return d.sizes.Adjust(estimateFormatSize(sel.video, video.Duration) + estimateFormatSize(sel.audio, video.Duration))
+}
+
+// fitFilesize lowers the resolution of sel until it is estimated to fit
+// -max-filesize, and skips the video if none does.
+func (d *Downloader) fitFilesize(job *Job, video *youtube.Video, sel formatSelection) (formatSelection, error) {
+	if d.config.MaxFilesize <= 0 {
+		return sel, nil
+	}
+	for {
+		size := d.estimatedSize(video, sel)
+		if size <= d.config.MaxFilesize {
+			return sel, nil
+		}
+		if sel.video == nil || sel.video.Height <= 0 || !d.canDowngrade(job, video, sel.video.Height) {
+			return sel, &skipError{err: fmt.Errorf("estimated at %s, over -max-filesize %s", formatBytes(size), formatBytes(d.config.MaxFilesize))}
+		}
+		job.maxHeight = sel.video.Height - 1
+		next, err := d.selectFormats(job, video)
+		if err != nil {
+			return sel, err
+		}
+		d.logger.Printf("%s: %dp is estimated at %s, over -max-filesize; trying %dp", video.Title, sel.video.Height, formatBytes(size), next.video.Height)
+		sel = next
+	}
+}
+
+// runBudget holds a run to -max-total-size. Each download reserves its
+// estimated size before starting and settles it at what it fetched. Once
+// a download doesn't fit, the budget counts as spent and the rest of the
+// run is skipped.
+type runBudget struct {
+	mu      sync.Mutex
+	limit   int64
+	used    int64
+	reached bool
+}
+
+func newRunBudget(limit int64) *runBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &runBudget{limit: limit}
+}
+
+func (b *runBudget) reserve(size int64) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.reached && b.used+size > b.limit {
+		b.reached = true
+	}
+	if b.reached {
+		return &skipError{err: fmt.Errorf("-max-total-size %s reached", formatBytes(b.limit))}
+	}
+	b.used += size
+	return nil
+}
+
+func (b *runBudget) settle(reserved, fetched int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.used += fetched - reserved
+	b.mu.Unlock()
+}
+
+// exhausted reports whether no more downloads fit, so a batch can skip
+// its remaining entries without fetching anything for them.
+func (b *runBudget) exhausted() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.reached {
+		return nil
+	}
+	return &skipError{err: fmt.Errorf("-max-total-size %s reached", formatBytes(b.limit))}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+const (
+	// silenceThreshold is how quiet -split-silence takes to be silence
+	silenceThreshold = "-35dB"
+	// minSplitPart keeps splits from leaving slivers, such as a cut just
+	// before the end or two silences close together
+	minSplitPart = 30 * time.Second
+)
+
+// silence is a quiet stretch of a recording found by silencedetect.
+type silence struct {
+	start, end time.Duration
+}
+
+var silencePattern = regexp.MustCompile(`silence_(start|end): (-?[0-9.]+)`)
+
+func (f *execFFmpeg) DetectSilence(ctx context.Context, inputPath string, minLength time.Duration) ([]silence, error) {
+	args := []string{"-hide_banner", "-nostdin", "-nostats", "-i", inputPath, "-vn",
+		"-af", fmt.Sprintf("silencedetect=noise=%s:d=%s", silenceThreshold, strconv.FormatFloat(minLength.Seconds(), 'f', -1, 64)),
+		"-f", "null", "-"}
+	cmd := exec.CommandContext(ctx, f.path, args...)
+	var stderr bytes.Buffer
+	log := &ffmpegLog{debug: f.debug}
+	cmd.Stderr = io.MultiWriter(&stderr, log)
+	if err := cmd.Run(); err != nil {
+		if tail := log.tail(); tail != "" {
+			return nil, fmt.Errorf("%v: %s", err, tail)
+		}
+		return nil, err
+	}
+
+	var silences []silence
+	for _, m := range silencePattern.FindAllStringSubmatch(stderr.String(), -1) {
+		secs, err := strconv.ParseFloat(m[2], 64)
+		if err != nil {
+			continue
+		}
+		at := max(time.Duration(secs*float64(time.Second)), 0)
+		if m[1] == "start" {
+			silences = append(silences, silence{start: at, end: -1})
+		} else if n := len(silences); n > 0 && silences[n-1].end < 0 {
+			silences[n-1].end = at
+		}
+	}
+	// A recording can end in silence
+	if n := len(silences); n > 0 && silences[n-1].end < 0 {
+		silences = silences[:n-1]
+	}
+	return silences, nil
+}
+
+func (f *execFFmpeg) Extract(ctx context.Context, inputPath string, clip *clipRange, tags []string, out mediaOutput) error {
+	args := append(clip.inputArgs(), "-i", inputPath, "-map", "0", "-c", "copy", "-map_metadata", "0")
+	args = append(args, tags...)
+	return f.run(ctx, args, out)
+}
+
+// splitPoints returns where to cut a recording of length: every so often,
+// at silences, or with both, at the silence nearest each interval.
+func splitPoints(length, every time.Duration, silences []silence) []time.Duration {
+	var cuts []time.Duration
+	prev := time.Duration(0)
+	for {
+		var cut time.Duration
+		if every > 0 {
+			target := prev + every
+			if target > length-minSplitPart {
+				break
+			}
+			cut = target
+			// Within half an interval, a silence makes a better cut
+			best := every / 2
+			for _, s := range silences {
+				mid := (s.start + s.end) / 2
+				diff := mid - target
+				if diff < 0 {
+					diff = -diff
+				}
+				if mid >= prev+minSplitPart && mid <= length-minSplitPart && diff < best {
+					cut, best = mid, diff
+				}
+			}
+		} else {
+			cut = -1
+			for _, s := range silences {
+				if mid := (s.start + s.end) / 2; mid >= prev+minSplitPart {
+					cut = mid
+					break
+				}
+			}
+			if cut < 0 || cut > length-minSplitPart {
+				break
+			}
+		}
+		cuts = append(cuts, cut)
+		prev = cut
+	}
+	return cuts
+}
+
+// splitOutput cuts a finished download into numbered parts with
+// -split-by and -split-silence, and returns their paths in place of path,
+// or nil if it wasn't split. Each part keeps the tags of the whole.
+func (d *Downloader) splitOutput(ctx context.Context, job *Job, video *youtube.Video, path string) ([]string, error) {
+	if d.config.SplitBy <= 0 && !d.config.SplitSilence {
+		return nil, nil
+	}
+	length := outputLength(job, video)
+	if length <= 0 {
+		d.logger.Printf("Not splitting %s: its length is unknown", filepath.Base(path))
+		return nil, nil
+	}
+
+	d.ffmpegGuard.acquire()
+	defer d.ffmpegGuard.release()
+	job.setPhase(PhaseSplitting)
+
+	var silences []silence
+	if d.config.SplitSilence {
+		var err error
+		if silences, err = d.media().DetectSilence(ctx, path, d.config.SplitSilenceMin); err != nil {
+			return nil, fmt.Errorf("failed to detect silence in %s: %w", filepath.Base(path), err)
+		}
+	}
+	cuts := splitPoints(length, d.config.SplitBy, silences)
+	if len(cuts) == 0 {
+		return nil, nil
+	}
+
+	n := len(cuts) + 1
+	width := max(len(strconv.Itoa(n)), 2)
+	ext := filepath.Ext(path)
+	var parts []string
+	for i := 0; i < n; i++ {
+		clip := &clipRange{end: length}
+		if i > 0 {
+			clip.start = cuts[i-1]
+		}
+		if i < len(cuts) {
+			clip.end = cuts[i]
+		}
+		part := fmt.Sprintf("%s - part %0*d%s", strings.TrimSuffix(path, ext), width, i+1, ext)
+		tags := []string{
+			"-metadata", fmt.Sprintf("title=%s (part %d of %d)", video.Title, i+1, n),
+			"-metadata", fmt.Sprintf("track=%d/%d", i+1, n),
+		}
+		err := d.writePart(part, func(out mediaOutput) error {
+			return d.media().Extract(ctx, path, clip, tags, out)
+		})
+		if err != nil {
+			for _, p := range parts {
+				os.Remove(p)
+			}
+			return nil, fmt.Errorf("failed to split %s: %w", filepath.Base(path), err)
+		}
+		parts = append(parts, part)
+	}
+	if err := os.Remove(path); err != nil {
+		d.logger.Printf("Failed to remove %s after splitting it: %v", filepath.Base(path), err)
+	}
+	job.setOutputPath(parts[0])
+	d.logger.Printf("Split %s into %d parts", filepath.Base(path), n)
+	return parts, nil
+}
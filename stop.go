@@ -0,0 +1,27 @@
+package main
+
+// StopAfterCurrent lets the downloads already running finish and cancels
+// the rest, including jobs added later, so a long run can be ended
+// without throwing away work in progress. The canceled jobs end up in
+// the failed report for -retry-failed.
+func (d *Downloader) StopAfterCurrent() {
+	d.stopOnce.Do(func() {
+		close(d.stopped)
+		d.logger.Printf("Stopping once the current downloads finish")
+		for _, job := range d.Jobs() {
+			if job.Snapshot().Status == JobQueued {
+				job.Cancel()
+			}
+		}
+	})
+}
+
+// Stopping reports whether StopAfterCurrent has been called.
+func (d *Downloader) Stopping() bool {
+	select {
+	case <-d.stopped:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage is a place finished files are written to: the main output with
+// -dest, or an extra copy with -copy-to. Put reads the file from r and
+// stores it under name, a slash-separated path relative to the output
+// directory. size is -1 when the file is streamed from ffmpeg and its
+// length is not known yet. Stat returns the size of the file stored under
+// name, or an error matching fs.ErrNotExist when there is none.
+type Storage interface {
+	String() string
+	Put(ctx context.Context, name string, r io.Reader, size int64) error
+	Stat(ctx context.Context, name string) (int64, error)
+}
+
+// httpStorage is a Storage reached over HTTP, which the downloader hands
+// its own client so that -proxy and the connection settings apply.
+type httpStorage interface {
+	setHTTPClient(client *http.Client)
+}
+
+// parseStorage accepts a local directory, s3://bucket/prefix,
+// gs://bucket/prefix, sftp://user@host[:port]/path (also ssh://) or
+// webdav[s]://[user:pass@]host/path.
+func parseStorage(spec string) (Storage, error) {
+	u, err := url.Parse(spec)
+	if err != nil || u.Scheme == "" || len(u.Scheme) == 1 {
+		// Plain paths, including Windows drive letters
+		return localStorage{dir: spec}, nil
+	}
+
+	switch u.Scheme {
+	case "file":
+		return localStorage{dir: u.Path}, nil
+	case "s3":
+		if u.Host == "" {
+			return nil, fmt.Errorf("%s: missing bucket", spec)
+		}
+		return newS3Storage(u.Host, strings.Trim(u.Path, "/"))
+	case "gs":
+		if u.Host == "" {
+			return nil, fmt.Errorf("%s: missing bucket", spec)
+		}
+		return newGCSStorage(u.Host, strings.Trim(u.Path, "/"))
+	case "sftp", "ssh":
+		if u.Host == "" {
+			return nil, fmt.Errorf("%s: missing host", spec)
+		}
+		return sshStorage{url: u}, nil
+	case "webdav", "webdavs":
+		if u.Host == "" {
+			return nil, fmt.Errorf("%s: missing host", spec)
+		}
+		return newWebDAVStorage(u), nil
+	default:
+		return nil, fmt.Errorf("unsupported storage scheme %q", u.Scheme)
+	}
+}
+
+// storageLocation is how a file stored under name is shown to the user,
+// hooks and the library.
+func storageLocation(s Storage, name string) string {
+	switch s := s.(type) {
+	case localStorage:
+		return filepath.Join(s.dir, filepath.FromSlash(name))
+	case *stdoutStorage:
+		return "-"
+	}
+	return strings.TrimSuffix(s.String(), "/") + "/" + name
+}
+
+type localStorage struct {
+	dir string
+}
+
+func (l localStorage) String() string { return l.dir }
+
+func (l localStorage) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	target := filepath.Join(l.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(target)
+		return err
+	}
+	return f.Close()
+}
+
+func (l localStorage) Stat(ctx context.Context, name string) (int64, error) {
+	info, err := os.Stat(filepath.Join(l.dir, filepath.FromSlash(name)))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// stdoutStorage writes the download of -o - to stdout, to be piped into
+// a player. Only one file fits in the stream: the first download to
+// produce output claims it, and the others are skipped.
+type stdoutStorage struct {
+	w     io.Writer
+	mu    sync.Mutex
+	owner string // name of the file claiming stdout
+	used  bool   // whether anything has been written
+}
+
+func (s *stdoutStorage) String() string { return "stdout" }
+
+func (s *stdoutStorage) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	if err := s.claim(name); err != nil {
+		return err
+	}
+	_, err := io.Copy(s, r)
+	return err
+}
+
+// Stat finds nothing: what went to stdout is gone.
+func (s *stdoutStorage) Stat(ctx context.Context, name string) (int64, error) {
+	return 0, fs.ErrNotExist
+}
+
+func (s *stdoutStorage) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	s.used = true
+	s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// claim gives stdout to the file stored as name, unless another file has
+// it.
+func (s *stdoutStorage) claim(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.owner == "":
+		s.owner = name
+	case s.owner != name:
+		return &skipError{err: fmt.Errorf("-o - writes only one download to stdout")}
+	case s.used:
+		// A retry would follow the broken start of the file
+		return fmt.Errorf("part of %s was already written to stdout", name)
+	}
+	return nil
+}
+
+// release frees stdout for the next download if the file stored as name
+// had claimed it but never wrote to it.
+func (s *stdoutStorage) release(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owner == name && !s.used {
+		s.owner = ""
+	}
+}
+
+// stdoutTaken skips a download once another one has written to stdout.
+func (d *Downloader) stdoutTaken() error {
+	s, ok := d.config.Dest.(*stdoutStorage)
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.used {
+		return &skipError{err: fmt.Errorf("-o - writes only one download to stdout")}
+	}
+	return nil
+}
+
+// releaseStdout gives stdout back after job was skipped or failed
+// without writing to it.
+func (d *Downloader) releaseStdout(job *Job) {
+	s, ok := d.config.Dest.(*stdoutStorage)
+	if !ok {
+		return
+	}
+	if snap := job.Snapshot(); snap.Status != JobDone && snap.OutputPath != "" {
+		s.release(d.storageName(snap.OutputPath))
+	}
+}
+
+// toStdout reports whether downloads are written to stdout with -o -.
+func (c Config) toStdout() bool {
+	_, ok := c.Dest.(*stdoutStorage)
+	return ok
+}
+
+// newGCSStorage uses Cloud Storage's S3-compatible XML API, which takes
+// HMAC keys from GCS_HMAC_ACCESS_KEY_ID and GCS_HMAC_SECRET (falling back
+// to the AWS_* variables).
+func newGCSStorage(bucket, prefix string) (*s3Storage, error) {
+	s := &s3Storage{
+		scheme:    "gs",
+		bucket:    bucket,
+		prefix:    prefix,
+		region:    "auto",
+		pathStyle: true,
+		client:    http.DefaultClient,
+		accessKey: os.Getenv("GCS_HMAC_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("GCS_HMAC_SECRET"),
+	}
+	if s.accessKey == "" {
+		s.accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		s.secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("gs://%s: GCS_HMAC_ACCESS_KEY_ID and GCS_HMAC_SECRET must be set", bucket)
+	}
+	s.endpoint = &url.URL{Scheme: "https", Host: "storage.googleapis.com"}
+	return s, nil
+}
+
+// webdavStorage uploads with PUT, creating parent collections with MKCOL.
+// Credentials, if any, come from the URL.
+type webdavStorage struct {
+	base   *url.URL
+	client *http.Client
+}
+
+func newWebDAVStorage(u *url.URL) *webdavStorage {
+	base := *u
+	base.Scheme = "http"
+	if u.Scheme == "webdavs" {
+		base.Scheme = "https"
+	}
+	return &webdavStorage{base: &base, client: http.DefaultClient}
+}
+
+func (w *webdavStorage) setHTTPClient(client *http.Client) { w.client = client }
+
+func (w *webdavStorage) String() string { return w.base.Redacted() }
+
+func (w *webdavStorage) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	target := w.url(path.Join(w.base.Path, name))
+	if err := w.mkcol(ctx, path.Dir(target.Path)); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target.String(), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	_, err = w.do(req)
+	return err
+}
+
+func (w *webdavStorage) Stat(ctx context.Context, name string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, w.url(path.Join(w.base.Path, name)).String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	status, length, err := w.head(req)
+	if status == http.StatusNotFound {
+		return 0, fs.ErrNotExist
+	}
+	return length, err
+}
+
+// mkcol creates dir and its parents, ignoring ones that already exist.
+func (w *webdavStorage) mkcol(ctx context.Context, dir string) error {
+	if dir == "/" || dir == "." || dir == "" {
+		return nil
+	}
+	if err := w.mkcol(ctx, path.Dir(dir)); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "MKCOL", w.url(dir+"/").String(), nil)
+	if err != nil {
+		return err
+	}
+	// 405 means the collection is already there
+	if status, err := w.do(req); err != nil && status != http.StatusMethodNotAllowed {
+		return fmt.Errorf("MKCOL %s: %v", dir, err)
+	}
+	return nil
+}
+
+func (w *webdavStorage) url(p string) *url.URL {
+	u := *w.base
+	u.User = nil
+	u.Path = p
+	return &u
+}
+
+// do sends req with the URL's credentials and returns the status code.
+func (w *webdavStorage) do(req *http.Request) (int, error) {
+	status, _, err := w.head(req)
+	return status, err
+}
+
+// head is do, also returning the response's content length.
+func (w *webdavStorage) head(req *http.Request) (int, int64, error) {
+	if w.base.User != nil {
+		password, _ := w.base.User.Password()
+		req.SetBasicAuth(w.base.User.Username(), password)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, 0, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return resp.StatusCode, resp.ContentLength, nil
+}
+
+// storageName is the name a file under the output directory is stored as.
+func (d *Downloader) storageName(file string) string {
+	rel, err := filepath.Rel(d.config.OutputDir, file)
+	if err != nil {
+		rel = filepath.Base(file)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// writeOutput runs an FFmpeg operation to produce outputPath. With -dest
+// the output is streamed straight to storage and never written to local
+// disk; with -o - it goes to stdout as ffmpeg writes it.
+func (d *Downloader) writeOutput(ctx context.Context, outputPath string, run func(mediaOutput) error) error {
+	if d.config.Dest == nil {
+		return d.writePart(outputPath, run)
+	}
+
+	ext := strings.ToLower(filepath.Ext(outputPath))
+	muxer, ok := ffmpegMuxers[ext]
+	if !ok {
+		return fmt.Errorf("cannot stream %s output to %s", ext, d.config.Dest)
+	}
+
+	start := time.Now()
+	defer func() { d.ffmpegTimes.observe(time.Since(start)) }()
+	if s, ok := d.config.Dest.(*stdoutStorage); ok {
+		name := d.storageName(outputPath)
+		if err := s.claim(name); err != nil {
+			return err
+		}
+		if err := run(mediaOutput{w: s, format: muxer}); err != nil {
+			return fmt.Errorf("failed while streaming to %s: %w", s, err)
+		}
+		d.logger.Printf("Streamed %s to %s", name, s)
+		return nil
+	}
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := run(mediaOutput{w: pw, format: muxer})
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	name := d.storageName(outputPath)
+	// There is no local file to hash and measure afterwards
+	hash := sha256.New()
+	counter := &countingWriter{w: hash}
+	putErr := d.config.Dest.Put(ctx, name, io.TeeReader(pr, counter), -1)
+	// Drain whatever Put left so the writer can finish
+	io.Copy(io.Discard, pr)
+	if err := <-done; err != nil {
+		return fmt.Errorf("failed while streaming to %s: %w", d.config.Dest, err)
+	}
+	if putErr != nil {
+		return fmt.Errorf("failed to store %s in %s: %v", name, d.config.Dest, putErr)
+	}
+	d.streamedMu.Lock()
+	if d.streamed == nil {
+		d.streamed = make(map[string]streamedOutput)
+	}
+	d.streamed[outputPath] = streamedOutput{size: counter.n, sum: hex.EncodeToString(hash.Sum(nil))}
+	d.streamedMu.Unlock()
+	d.logger.Printf("Streamed %s to %s", name, d.config.Dest)
+	return nil
+}
+
+// streamedOutput is the size and SHA-256 of an output streamed to -dest.
+type streamedOutput struct {
+	size int64
+	sum  string
+}
+
+// takeStreamed returns what writeOutput streamed to -dest as outputPath,
+// and false if it streamed nothing.
+func (d *Downloader) takeStreamed(outputPath string) (int64, string, bool) {
+	d.streamedMu.Lock()
+	defer d.streamedMu.Unlock()
+	out, ok := d.streamed[outputPath]
+	delete(d.streamed, outputPath)
+	return out.size, out.sum, ok
+}
+
+// storeLocal moves a file that was written locally, such as a live
+// recording or an info.json, to -dest. Files already streamed there are
+// skipped.
+func (d *Downloader) storeLocal(ctx context.Context, job *Job, file string) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	job.setPhase(PhaseUploading)
+	name := d.storageName(file)
+	if err := d.config.Dest.Put(ctx, name, f, info.Size()); err != nil {
+		return fmt.Errorf("failed to store %s in %s: %v", name, d.config.Dest, err)
+	}
+	f.Close()
+	return os.Remove(file)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// subscriptionsFileName keeps the subscriptions added through the serve
+// API and when each subscription last ran, next to the downloads they
+// belong to.
+const subscriptionsFileName = ".subscriptions.json"
+
+// subscription is a channel, playlist or feed checked for new uploads on
+// a cron schedule.
+type subscription struct {
+	Name     string    `json:"name"`
+	URL      string    `json:"url"`
+	Schedule string    `json:"schedule"`
+	LastRun  time.Time `json:"last_run"` // or when it was added, before its first run
+	Config   bool      `json:"config"`   // from the config file, not the API
+
+	sched *cronSchedule
+	src   *watchSource
+}
+
+func newSubscription(name, url, schedule string) (*subscription, error) {
+	if name == "" || url == "" || schedule == "" {
+		return nil, fmt.Errorf("a subscription needs a name, url and schedule")
+	}
+	sched, err := parseCron(schedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %v", schedule, err)
+	}
+	if sched.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("schedule %q never runs", schedule)
+	}
+	return &subscription{Name: name, URL: url, Schedule: schedule, sched: sched, src: &watchSource{spec: url}}, nil
+}
+
+// nextRun is when s is next due. A run missed while nothing was running
+// is due straight away, however many were missed.
+func (s *subscription) nextRun() time.Time {
+	return s.sched.next(s.LastRun)
+}
+
+// subscriptions are the subscriptions of the config file and those added
+// through the API, with their last runs kept in subscriptionsFileName.
+type subscriptions struct {
+	path    string
+	changed chan struct{} // signaled when a subscription is added or removed
+
+	mu   sync.Mutex
+	subs map[string]*subscription
+}
+
+var (
+	errNoSubscription     = errors.New("no such subscription")
+	errConfigSubscription = errors.New("subscription is set in the config file")
+)
+
+// loadSubscriptions reads the subscriptions in the config file at
+// configPath and those saved in outputDir.
+func loadSubscriptions(configPath, outputDir string) (*subscriptions, error) {
+	s := &subscriptions{
+		path:    filepath.Join(outputDir, subscriptionsFileName),
+		changed: make(chan struct{}, 1),
+		subs:    make(map[string]*subscription),
+	}
+
+	var saved []*subscription
+	data, err := os.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return nil, fmt.Errorf("%s: %v", s.path, err)
+		}
+	}
+	lastRuns := make(map[string]time.Time)
+	for _, sv := range saved {
+		lastRuns[sv.Name] = sv.LastRun
+		if sv.Config {
+			continue
+		}
+		sub, err := newSubscription(sv.Name, sv.URL, sv.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", s.path, sv.Name, err)
+		}
+		sub.LastRun = sv.LastRun
+		s.subs[sub.Name] = sub
+	}
+
+	path, values, err := readConfigFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	section, ok := values["subscriptions"].(map[string]any)
+	if !ok && values["subscriptions"] != nil && values["subscriptions"] != "" {
+		return nil, fmt.Errorf("%s: subscriptions: expected a name for each subscription", path)
+	}
+	now := time.Now()
+	dirty := false
+	for name, value := range section {
+		settings, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: subscriptions: %s: expected url and schedule", path, name)
+		}
+		var url, schedule string
+		for key, v := range settings {
+			text, _ := v.(string)
+			switch key {
+			case "url":
+				url = text
+			case "schedule":
+				schedule = text
+			default:
+				return nil, fmt.Errorf("%s: subscriptions: %s: unknown setting %q", path, name, key)
+			}
+		}
+		sub, err := newSubscription(name, url, schedule)
+		if err != nil {
+			return nil, fmt.Errorf("%s: subscriptions: %s: %v", path, name, err)
+		}
+		sub.Config = true
+		// A new subscription waits for its first scheduled time, which
+		// has to be remembered for it to be caught up on
+		last, ok := lastRuns[name]
+		if !ok {
+			last, dirty = now, true
+		}
+		sub.LastRun = last
+		s.subs[name] = sub
+	}
+	for _, sv := range saved {
+		if sv.Config && s.subs[sv.Name] == nil {
+			dirty = true
+		}
+	}
+	if dirty {
+		if err := s.saveLocked(); err != nil {
+			return nil, fmt.Errorf("failed to save subscriptions: %v", err)
+		}
+	}
+	return s, nil
+}
+
+// replaceConfig replaces the subscriptions of the config file with those
+// of fresh, as read again by loadSubscriptions. Subscriptions added through
+// the API are kept.
+func (s *subscriptions) replaceConfig(fresh *subscriptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, sub := range s.subs {
+		if sub.Config {
+			delete(s.subs, name)
+		}
+	}
+	for name, sub := range fresh.subs {
+		// As on startup, the config file wins over the API
+		if sub.Config {
+			s.subs[name] = sub
+		}
+	}
+	if err := s.saveLocked(); err != nil {
+		return fmt.Errorf("failed to save subscriptions: %v", err)
+	}
+	s.signal()
+	return nil
+}
+
+// list returns copies of the subscriptions, by name.
+func (s *subscriptions) list() []subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]subscription, 0, len(s.subs))
+	for _, sub := range s.subs {
+		list = append(list, *sub)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (s *subscriptions) add(name, url, schedule string) (subscription, error) {
+	sub, err := newSubscription(name, url, schedule)
+	if err != nil {
+		return subscription{}, err
+	}
+	sub.LastRun = time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.subs[name]; ok && old.Config {
+		return subscription{}, errConfigSubscription
+	}
+	s.subs[name] = sub
+	if err := s.saveLocked(); err != nil {
+		return subscription{}, err
+	}
+	s.signal()
+	return *sub, nil
+}
+
+func (s *subscriptions) remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subs[name]
+	switch {
+	case !ok:
+		return errNoSubscription
+	case sub.Config:
+		return errConfigSubscription
+	}
+	delete(s.subs, name)
+	if err := s.saveLocked(); err != nil {
+		return err
+	}
+	s.signal()
+	return nil
+}
+
+func (s *subscriptions) signal() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// due returns the subscriptions due to run at now, and when the next one
+// after them is due, or the zero time if none are.
+func (s *subscriptions) due(now time.Time) ([]*subscription, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []*subscription
+	var next time.Time
+	for _, sub := range s.subs {
+		at := sub.nextRun()
+		if !at.After(now) {
+			due = append(due, sub)
+			continue
+		}
+		if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].Name < due[j].Name })
+	return due, next
+}
+
+// ran records that sub ran at t.
+func (s *subscriptions) ran(sub *subscription, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub.LastRun = t
+	// A reload may have replaced sub while it ran
+	if cur := s.subs[sub.Name]; cur != nil && cur != sub && cur.URL == sub.URL {
+		cur.LastRun = t
+	}
+	return s.saveLocked()
+}
+
+func (s *subscriptions) saveLocked() error {
+	list := make([]*subscription, 0, len(s.subs))
+	for _, sub := range s.subs {
+		list = append(list, sub)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// runDueSubscriptions checks the subscriptions that are due and returns
+// when the next one is. A subscription that fails is retried at its next
+// scheduled time.
+func (d *Downloader) runDueSubscriptions(subs *subscriptions, markSeen bool, maxPerCheck int) time.Time {
+	now := time.Now()
+	due, next := subs.due(now)
+	for _, sub := range due {
+		if d.Stopping() {
+			break
+		}
+		if scheduled := sub.sched.next(sub.LastRun); now.Sub(scheduled) > time.Minute {
+			d.logger.Printf("Subscription %s: catching up on the check due at %s", sub.Name, scheduled.Format("2006-01-02 15:04"))
+		}
+		if err := d.checkSource(sub.src, markSeen, maxPerCheck); err != nil {
+			d.logger.Printf("Subscription %s: %v", sub.Name, err)
+		}
+		if err := subs.ran(sub, now); err != nil {
+			d.logger.Printf("Failed to save subscriptions: %v", err)
+		}
+		if at := sub.nextRun(); next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	return next
+}
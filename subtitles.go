@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// subtitleTracks picks the caption tracks to save for langs, which may
+// include "all". A language's uploaded track is preferred over YouTube's
+// automatic one.
+func subtitleTracks(tracks []youtube.CaptionTrack, langs []string) []youtube.CaptionTrack {
+	all := slices.Contains(langs, "all")
+	chosen := make(map[string]youtube.CaptionTrack)
+	var order []string
+	for _, t := range tracks {
+		if !all && !slices.Contains(langs, t.LanguageCode) {
+			continue
+		}
+		prev, seen := chosen[t.LanguageCode]
+		if !seen {
+			order = append(order, t.LanguageCode)
+		}
+		if !seen || (prev.Kind == "asr" && t.Kind != "asr") {
+			chosen[t.LanguageCode] = t
+		}
+	}
+	picked := make([]youtube.CaptionTrack, len(order))
+	for i, lang := range order {
+		picked[i] = chosen[lang]
+	}
+	return picked
+}
+
+func subtitlePath(mediaPath, lang string) string {
+	return strings.TrimSuffix(mediaPath, filepath.Ext(mediaPath)) + "." + lang + ".vtt"
+}
+
+// subtitlePaths returns where video's subtitles are written for mediaPath.
+func (d *Downloader) subtitlePaths(video *youtube.Video, mediaPath string) []string {
+	var paths []string
+	for _, t := range subtitleTracks(video.CaptionTracks, d.config.SubLangs) {
+		paths = append(paths, subtitlePath(mediaPath, t.LanguageCode))
+	}
+	return paths
+}
+
+// writeSubtitles saves the chosen caption tracks as WebVTT next to the
+// download.
+func (d *Downloader) writeSubtitles(ctx context.Context, video *youtube.Video, mediaPath string) {
+	tracks := subtitleTracks(video.CaptionTracks, d.config.SubLangs)
+	if len(tracks) == 0 {
+		d.logger.Printf("No subtitles in %s for %s", strings.Join(d.config.SubLangs, ", "), video.Title)
+		return
+	}
+	for _, t := range tracks {
+		if err := d.fetchSubtitle(ctx, t, subtitlePath(mediaPath, t.LanguageCode)); err != nil {
+			d.logger.Printf("Failed to save %s subtitles for %s: %v", t.LanguageCode, video.Title, err)
+		}
+	}
+}
+
+func (d *Downloader) fetchSubtitle(ctx context.Context, track youtube.CaptionTrack, path string) error {
+	u, err := url.Parse(track.BaseURL)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("fmt", "vtt")
+	u.RawQuery = q.Encode()
+	return d.fetchFile(ctx, u.String(), path)
+}
+
+// fetchFile saves a small resource such as a thumbnail or subtitle file.
+func (d *Downloader) fetchFile(ctx context.Context, rawURL, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// FileSummary is one job's line in the end-of-run summary.
+type FileSummary struct {
+	VideoID   string    `json:"video_id,omitempty"`
+	URL       string    `json:"url"`
+	Title     string    `json:"title"`
+	Path      string    `json:"path,omitempty"`
+	Status    JobStatus `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Elapsed   float64   `json:"elapsed"`
+	AvgSpeed  float64   `json:"avg_speed"`
+	PeakSpeed float64   `json:"peak_speed"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// RunSummary totals a run. Sizes are in bytes, times in seconds and
+// speeds in bytes per second.
+type RunSummary struct {
+	Started    time.Time     `json:"started"`
+	Finished   time.Time     `json:"finished"`
+	Elapsed    float64       `json:"elapsed"`
+	Succeeded  int           `json:"succeeded"`
+	Skipped    int           `json:"skipped"`
+	Failed     int           `json:"failed"`
+	Canceled   int           `json:"canceled"`
+	TotalBytes int64         `json:"total_bytes"`
+	Files      []FileSummary `json:"files"`
+}
+
+func buildSummary(jobs []*Job, started time.Time) RunSummary {
+	s := RunSummary{Started: started, Finished: time.Now()}
+	s.Elapsed = s.Finished.Sub(started).Seconds()
+	for _, job := range jobs {
+		snap := job.Snapshot()
+		f := FileSummary{
+			VideoID:   snap.VideoID,
+			URL:       snap.URL,
+			Title:     snap.Title,
+			Path:      snap.OutputPath,
+			Status:    snap.Status,
+			Bytes:     snap.Downloaded,
+			Elapsed:   snap.Elapsed.Seconds(),
+			PeakSpeed: snap.PeakSpeed,
+		}
+		if snap.Err != nil {
+			f.Error = snap.Err.Error()
+		}
+		// Average over the time spent transferring, not resolving or merging
+		var transfer time.Duration
+		for _, t := range snap.Phases {
+			if t.Phase == PhaseDownloadingVideo || t.Phase == PhaseDownloadingAudio {
+				transfer += t.Duration
+			}
+		}
+		if transfer > 0 {
+			f.AvgSpeed = float64(snap.Downloaded) / transfer.Seconds()
+		}
+		// Files that finished within one speed sample never got a peak
+		f.PeakSpeed = max(f.PeakSpeed, f.AvgSpeed)
+
+		switch snap.Status {
+		case JobDone:
+			s.Succeeded++
+		case JobSkipped:
+			s.Skipped++
+		case JobFailed:
+			s.Failed++
+		case JobCanceled:
+			s.Canceled++
+		}
+		s.TotalBytes += snap.Downloaded
+		s.Files = append(s.Files, f)
+	}
+	return s
+}
+
+func (s RunSummary) Print(w io.Writer) {
+	if len(s.Files) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tSIZE\tTIME\tAVG\tPEAK\tTITLE")
+	for _, f := range s.Files {
+		title := f.Title
+		if f.Status == JobSkipped && f.Error != "" {
+			// Why YouTube wouldn't serve it, e.g. a private video
+			title += " (" + f.Error + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", f.Status, formatBytes(f.Bytes),
+			formatDuration(time.Duration(f.Elapsed*float64(time.Second))),
+			formatRate(f.AvgSpeed), formatRate(f.PeakSpeed), title)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "%d succeeded, %d skipped, %d failed", s.Succeeded, s.Skipped, s.Failed)
+	if s.Canceled > 0 {
+		fmt.Fprintf(w, ", %d canceled", s.Canceled)
+	}
+	fmt.Fprintf(w, "; %s in %s\n", formatBytes(s.TotalBytes), formatDuration(time.Duration(s.Elapsed*float64(time.Second))))
+}
+
+func (s RunSummary) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+const (
+	// telegramPollTimeout is how long, in seconds, each getUpdates call
+	// waits for a message.
+	telegramPollTimeout = 50
+	// telegramCallTimeout bounds API calls other than uploads.
+	telegramCallTimeout = (telegramPollTimeout + 10) * time.Second
+	// botProgressInterval is how often a download's status message is
+	// edited; Telegram limits how often a bot may edit messages.
+	botProgressInterval = 3 * time.Second
+)
+
+// formatPreset is a quality choice offered by the bot, as a -f selector.
+type formatPreset struct {
+	key, label, spec string
+}
+
+var formatPresets = []formatPreset{
+	{"default", "Default", ""},
+	{"1080p", "1080p", "bestvideo[height<=1080]+bestaudio/best[height<=1080]"},
+	{"720p", "720p", "bestvideo[height<=720]+bestaudio/best[height<=720]"},
+	{"480p", "480p", "bestvideo[height<=480]+bestaudio/best[height<=480]"},
+	{"audio", "Audio only", "bestaudio"},
+}
+
+// telegramAPI calls the Telegram Bot API.
+type telegramAPI struct {
+	base   string // e.g. https://api.telegram.org/bot<token>
+	client *http.Client
+}
+
+type tgUpdate struct {
+	UpdateID      int64            `json:"update_id"`
+	Message       *tgMessage       `json:"message"`
+	CallbackQuery *tgCallbackQuery `json:"callback_query"`
+}
+
+type tgMessage struct {
+	MessageID int64   `json:"message_id"`
+	From      *tgUser `json:"from"`
+	Chat      struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+	Text string `json:"text"`
+}
+
+type tgUser struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+type tgCallbackQuery struct {
+	ID      string     `json:"id"`
+	From    tgUser     `json:"from"`
+	Message *tgMessage `json:"message"`
+	Data    string     `json:"data"`
+}
+
+type tgButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+type tgKeyboard struct {
+	InlineKeyboard [][]tgButton `json:"inline_keyboard"`
+}
+
+// call makes an API call with params sent as JSON and decodes its result
+// into result, if not nil.
+func (t *telegramAPI) call(ctx context.Context, method string, params, result any) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, telegramCallTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.base+"/"+method, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return t.do(req, method, result)
+}
+
+func (t *telegramAPI) do(req *http.Request, method string, result any) error {
+	resp, err := t.client.Do(req)
+	if err != nil {
+		// The URL holds the token, which mustn't end up in logs
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return fmt.Errorf("%s: %v", method, err)
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return fmt.Errorf("%s: unexpected response (status %d)", method, resp.StatusCode)
+	}
+	if !reply.OK {
+		return fmt.Errorf("%s: %s", method, reply.Description)
+	}
+	if result != nil {
+		return json.Unmarshal(reply.Result, result)
+	}
+	return nil
+}
+
+// sendDocument uploads a file to a chat. The file is streamed rather
+// than read into memory, with its length given up front.
+func (t *telegramAPI) sendDocument(ctx context.Context, chatID int64, path, caption string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var head bytes.Buffer
+	form := multipart.NewWriter(&head)
+	form.WriteField("chat_id", strconv.FormatInt(chatID, 10))
+	form.WriteField("caption", caption)
+	if _, err := form.CreateFormFile("document", filepath.Base(path)); err != nil {
+		return err
+	}
+	tail := "\r\n--" + form.Boundary() + "--\r\n"
+
+	body := io.MultiReader(&head, f, strings.NewReader(tail))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.base+"/sendDocument", body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(head.Len()) + info.Size() + int64(len(tail))
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return t.do(req, "sendDocument", nil)
+}
+
+// telegramBot downloads the YouTube links it is sent and sends back the
+// files, with a status message that follows the download.
+type telegramBot struct {
+	api         *telegramAPI
+	d           *Downloader
+	allowed     map[string]bool // user IDs and @usernames, nil for anyone
+	publicURL   string          // where the output directory is served, for files too large to send
+	uploadLimit int64
+
+	mu      sync.Mutex
+	pending map[int64]string // links awaiting a quality choice, by the asking message
+	nextID  int
+	work    sync.WaitGroup
+}
+
+func (b *telegramBot) run(ctx context.Context) error {
+	var me tgUser
+	if err := b.api.call(ctx, "getMe", struct{}{}, &me); err != nil {
+		return fmt.Errorf("failed to reach Telegram: %v", err)
+	}
+	b.d.logger.Printf("Telegram bot @%s is running", me.Username)
+
+	var offset int64
+	for ctx.Err() == nil {
+		var updates []tgUpdate
+		params := map[string]any{"offset": offset, "timeout": telegramPollTimeout, "allowed_updates": []string{"message", "callback_query"}}
+		if err := b.api.call(ctx, "getUpdates", params, &updates); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			b.d.logger.Printf("Failed to get Telegram updates: %v", err)
+			sleepContext(ctx, 5*time.Second)
+			continue
+		}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			switch {
+			case u.Message != nil:
+				b.handleMessage(ctx, u.Message)
+			case u.CallbackQuery != nil:
+				b.handleCallback(ctx, u.CallbackQuery)
+			}
+		}
+	}
+	return nil
+}
+
+func (b *telegramBot) isAllowed(u *tgUser) bool {
+	if b.allowed == nil {
+		return true
+	}
+	return u != nil && (b.allowed[strconv.FormatInt(u.ID, 10)] || u.Username != "" && b.allowed["@"+strings.ToLower(u.Username)])
+}
+
+func (b *telegramBot) send(ctx context.Context, chatID int64, text string, keyboard *tgKeyboard) (*tgMessage, error) {
+	params := map[string]any{"chat_id": chatID, "text": text}
+	if keyboard != nil {
+		params["reply_markup"] = keyboard
+	}
+	var m tgMessage
+	return &m, b.api.call(ctx, "sendMessage", params, &m)
+}
+
+func (b *telegramBot) edit(ctx context.Context, m *tgMessage, text string) error {
+	return b.api.call(ctx, "editMessageText", map[string]any{"chat_id": m.Chat.ID, "message_id": m.MessageID, "text": text}, nil)
+}
+
+// handleMessage answers a link with the quality choices.
+func (b *telegramBot) handleMessage(ctx context.Context, m *tgMessage) {
+	if !b.isAllowed(m.From) {
+		b.send(ctx, m.Chat.ID, "Sorry, this bot is private.", nil)
+		return
+	}
+	link := findYouTubeLink(m.Text)
+	if link == "" {
+		b.send(ctx, m.Chat.ID, "Send me a YouTube video or playlist link and I'll download it.", nil)
+		return
+	}
+
+	var row []tgButton
+	for _, p := range formatPresets {
+		row = append(row, tgButton{Text: p.label, CallbackData: p.key})
+	}
+	ask, err := b.send(ctx, m.Chat.ID, "Which quality?\n"+link, &tgKeyboard{InlineKeyboard: [][]tgButton{row}})
+	if err != nil {
+		b.d.logger.Printf("Failed to answer Telegram message: %v", err)
+		return
+	}
+	b.mu.Lock()
+	b.pending[ask.MessageID] = link
+	b.mu.Unlock()
+}
+
+// handleCallback starts a download once its quality is chosen.
+func (b *telegramBot) handleCallback(ctx context.Context, q *tgCallbackQuery) {
+	b.api.call(ctx, "answerCallbackQuery", map[string]any{"callback_query_id": q.ID}, nil)
+	if q.Message == nil || !b.isAllowed(&q.From) {
+		return
+	}
+	b.mu.Lock()
+	link, ok := b.pending[q.Message.MessageID]
+	delete(b.pending, q.Message.MessageID)
+	b.nextID++
+	id := b.nextID
+	b.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	var spec *formatSpec
+	for _, p := range formatPresets {
+		if p.key == q.Data && p.spec != "" {
+			spec, _ = parseFormatSpec(p.spec)
+		}
+	}
+	// The question becomes the status message, without its buttons
+	status := q.Message
+	b.edit(ctx, status, "Queued\n"+link)
+
+	b.work.Add(1)
+	go func() {
+		defer b.work.Done()
+		b.download(ctx, status, link, batchSource{Format: spec, User: fmt.Sprintf("telegram:%d", id)})
+	}()
+}
+
+// download processes link, keeping status up to date, and sends the
+// files when it's done.
+func (b *telegramBot) download(ctx context.Context, status *tgMessage, link string, source batchSource) {
+	done := make(chan error, 1)
+	go func() {
+		if _, err := youtube.ExtractVideoID(link); err != nil && strings.Contains(link, "list=") {
+			playlist, ids, positions, err := b.d.listPlaylist(link)
+			if err != nil {
+				done <- err
+				return
+			}
+			playlist.Format, playlist.User = source.Format, source.User
+			b.d.processBatch(playlist, ids, positions)
+		} else {
+			source.Priority = priorityHigh
+			b.d.processBatch(source, []string{link}, nil)
+		}
+		done <- nil
+	}()
+
+	mine := func() []*Job {
+		var jobs []*Job
+		for _, job := range b.d.Jobs() {
+			if job.source.User == source.User {
+				jobs = append(jobs, job)
+			}
+		}
+		return jobs
+	}
+
+	ticker := time.NewTicker(botProgressInterval)
+	defer ticker.Stop()
+	last := ""
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				b.edit(ctx, status, "Failed: "+err.Error())
+				return
+			}
+			jobs := mine()
+			b.edit(ctx, status, botStatus(jobs))
+			for _, job := range jobs {
+				b.deliver(ctx, status.Chat.ID, job.Snapshot())
+			}
+			return
+		case <-ticker.C:
+			if text := botStatus(mine()); text != last {
+				b.edit(ctx, status, text)
+				last = text
+			}
+		}
+	}
+}
+
+// botStatus describes jobs in a status message.
+func botStatus(jobs []*Job) string {
+	if len(jobs) == 0 {
+		return "Looking up…"
+	}
+	var sb strings.Builder
+	for i, job := range jobs {
+		if i == 10 {
+			fmt.Fprintf(&sb, "…and %d more\n", len(jobs)-i)
+			break
+		}
+		snap := job.Snapshot()
+		fmt.Fprintf(&sb, "%s: ", snap.Title)
+		switch {
+		case snap.Status == JobRunning && snap.Total > 0:
+			fmt.Fprintf(&sb, "%s %d%% of %s", snap.Phase, snap.Downloaded*100/snap.Total, formatBytes(snap.Total))
+		case snap.Status == JobRunning:
+			sb.WriteString(string(snap.Phase))
+		case snap.Err != nil && snap.Status != JobSkipped:
+			fmt.Fprintf(&sb, "%s (%v)", snap.Status, snap.Err)
+		default:
+			sb.WriteString(string(snap.Status))
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// deliver sends a finished job's file, or a link to it when it is too
+// large for Telegram.
+func (b *telegramBot) deliver(ctx context.Context, chatID int64, snap JobSnapshot) {
+	if snap.Status != JobDone && snap.Status != JobSkipped {
+		return
+	}
+	info, err := os.Stat(snap.OutputPath)
+	if err != nil {
+		return
+	}
+	if info.Size() <= b.uploadLimit {
+		if err := b.api.sendDocument(ctx, chatID, snap.OutputPath, snap.Title); err != nil {
+			b.d.logger.Printf("Failed to send %s to Telegram: %v", snap.Title, err)
+			b.send(ctx, chatID, fmt.Sprintf("Couldn't send %s: %v", snap.Title, err), nil)
+		}
+		return
+	}
+	text := fmt.Sprintf("%s is too large to send (%s)", snap.Title, formatBytes(info.Size()))
+	if link := b.fileURL(snap.OutputPath); link != "" {
+		text += ": " + link
+	}
+	b.send(ctx, chatID, text, nil)
+}
+
+// fileURL returns where path can be downloaded under -public-url.
+func (b *telegramBot) fileURL(path string) string {
+	if b.publicURL == "" {
+		return ""
+	}
+	rel, err := filepath.Rel(b.d.config.OutputDir, path)
+	if err != nil || !filepath.IsLocal(rel) {
+		return ""
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.TrimRight(b.publicURL, "/") + "/" + strings.Join(parts, "/")
+}
+
+// findYouTubeLink returns the first YouTube link in text.
+func findYouTubeLink(text string) string {
+	for _, field := range strings.Fields(text) {
+		u, err := url.Parse(field)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		host := strings.TrimPrefix(u.Hostname(), "www.")
+		if host == "youtu.be" || host == "youtube.com" || strings.HasSuffix(host, ".youtube.com") {
+			return field
+		}
+	}
+	return ""
+}
+
+func sleepContext(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+func setupBot(flags *flag.FlagSet) func([]string) error {
+	opts := registerDownloadFlags(flags)
+	opts.registerJobRetention(flags)
+	token := flags.String("telegram-token", "", "Telegram bot token from @BotFather (or set YTDL_TELEGRAM_TOKEN)")
+	users := flags.String("telegram-users", "", "Comma-separated Telegram user IDs or @usernames allowed to use the bot (default anyone)")
+	apiURL := flags.String("telegram-api", "https://api.telegram.org", "Telegram Bot API server, e.g. a local one that accepts larger uploads")
+	uploadLimit := flags.String("telegram-upload-limit", "50M", "Largest file to send through Telegram; larger ones are linked with -public-url")
+	publicURL := flags.String("public-url", "", "URL the output directory is served at, e.g. a serve daemon's http://host:8080/files, for files too large to send")
+
+	return func(args []string) error {
+		if *token == "" {
+			return fmt.Errorf("-telegram-token is required")
+		}
+		limit, err := parseRate(*uploadLimit)
+		if err != nil {
+			return fmt.Errorf("invalid -telegram-upload-limit: %v", err)
+		}
+		config, err := opts.Config()
+		if err != nil {
+			return err
+		}
+		downloader, closeFn, err := opts.newDownloader(config)
+		if err != nil {
+			return err
+		}
+		defer closeFn()
+
+		bot := &telegramBot{
+			api: &telegramAPI{
+				base:   strings.TrimRight(*apiURL, "/") + "/bot" + *token,
+				client: &http.Client{},
+			},
+			d:           downloader,
+			publicURL:   *publicURL,
+			uploadLimit: limit,
+			pending:     make(map[int64]string),
+		}
+		if items := splitList(*users); len(items) > 0 {
+			bot.allowed = make(map[string]bool)
+			for _, item := range items {
+				bot.allowed[strings.ToLower(item)] = true
+			}
+		} else {
+			downloader.logger.Printf("Warning: the bot will download for anyone who finds it; use -telegram-users to restrict it")
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		err = bot.run(ctx)
+		downloader.CancelAll()
+		bot.work.Wait()
+		downloader.temps.removeAll()
+		return err
+	}
+}
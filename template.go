@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// defaultOutputTemplate names files after the video title.
+const defaultOutputTemplate = "{title}"
+
+// templateFields returns the template values for video. index is its
+// position in the playlist, or 0 outside one.
+func templateFields(video *youtube.Video, index int, dates dateFormat) map[string]string {
+	var indexValue string
+	if index > 0 {
+		indexValue = strconv.Itoa(index)
+	}
+	// Auto-generated music channels are named "<artist> - Topic"
+	artist, _ := strings.CutSuffix(video.Author, " - Topic")
+	if artist == video.Author {
+		artist = ""
+	}
+	return map[string]string{
+		"id":          video.ID,
+		"title":       video.Title,
+		"channel":     video.Author,
+		"author":      video.Author,
+		"artist":      artist,
+		"channel_id":  video.ChannelID,
+		"upload_date": dates.format(video.PublishDate, compactDateLayout),
+		"duration":    strconv.Itoa(int(video.Duration.Seconds())),
+		"index":       indexValue,
+	}
+}
+
+// templateField is one {...} placeholder:
+//
+//	{a|b}           the first of fields a and b that isn't empty
+//	{a:f1:f2(arg)}  the field passed through functions, left to right
+//	{a?format}      nothing if a is empty, else a formatted with format,
+//	                e.g. {index?%02d - }; a format without a verb is
+//	                written as is
+type templateField struct {
+	names  []string
+	funcs  []templateFunc
+	format string
+	cond   bool
+}
+
+type templateFunc struct {
+	name string
+	arg  string
+}
+
+// templateFuncs are the functions a placeholder can apply. An error means
+// the argument is invalid, and is reported by validateTemplate.
+var templateFuncs = map[string]func(value, arg string) (string, error){
+	"upper": func(v, _ string) (string, error) { return strings.ToUpper(v), nil },
+	"lower": func(v, _ string) (string, error) { return strings.ToLower(v), nil },
+	"slugify": func(v, _ string) (string, error) {
+		return slugify(v), nil
+	},
+	"truncate": func(v, arg string) (string, error) {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			return "", fmt.Errorf("truncate needs a positive length, e.g. truncate(40)")
+		}
+		if r := []rune(v); len(r) > n {
+			v = strings.TrimSpace(string(r[:n]))
+		}
+		return v, nil
+	},
+	"date": func(v, arg string) (string, error) {
+		if arg == "" {
+			return "", fmt.Errorf("date needs a Go time layout, e.g. date(2006-01-02)")
+		}
+		if v == "" {
+			return "", nil
+		}
+		for _, layout := range []string{"20060102", "2006-01-02", time.RFC3339} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t.Format(arg), nil
+			}
+		}
+		// Not a date, e.g. while validating
+		return v, nil
+	},
+}
+
+// slugify lowercases s and replaces everything but ASCII letters and
+// digits with single hyphens.
+func slugify(s string) string {
+	var sb strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(s) {
+		if r <= unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			sb.WriteRune(r)
+			hyphen = false
+		} else if !hyphen && sb.Len() > 0 {
+			sb.WriteByte('-')
+			hyphen = true
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "-")
+}
+
+// splitOutside splits s at sep, ignoring separators inside parentheses so
+// function arguments may contain them.
+func splitOutside(s string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth = max(depth-1, 0)
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseTemplateField parses the text between braces.
+func parseTemplateField(spec string, known map[string]string) (templateField, error) {
+	var field templateField
+	if parts := splitOutside(spec, '?'); len(parts) > 1 {
+		spec = parts[0]
+		field.format = strings.Join(parts[1:], "?")
+		field.cond = true
+	}
+	parts := splitOutside(spec, ':')
+	for _, name := range strings.Split(parts[0], "|") {
+		if _, ok := known[name]; !ok {
+			return field, fmt.Errorf("unknown template field {%s}", name)
+		}
+		field.names = append(field.names, name)
+	}
+	for _, call := range parts[1:] {
+		name, arg, _ := strings.Cut(call, "(")
+		if arg != "" {
+			var ok bool
+			if arg, ok = strings.CutSuffix(arg, ")"); !ok {
+				return field, fmt.Errorf("unclosed argument in %q", call)
+			}
+		}
+		fn, ok := templateFuncs[name]
+		if !ok {
+			return field, fmt.Errorf("unknown template function %q", name)
+		}
+		if _, err := fn("", arg); err != nil {
+			return field, err
+		}
+		field.funcs = append(field.funcs, templateFunc{name, arg})
+	}
+	return field, nil
+}
+
+func (f templateField) render(fields map[string]string, san sanitizer) (string, error) {
+	var value string
+	for _, name := range f.names {
+		if value = fields[name]; value != "" {
+			break
+		}
+	}
+	for _, fn := range f.funcs {
+		var err error
+		if value, err = templateFuncs[fn.name](value, fn.arg); err != nil {
+			return "", err
+		}
+	}
+	value = san.field(value)
+	if !f.cond {
+		return value, nil
+	}
+	if value == "" {
+		return "", nil
+	}
+	switch formatVerb(f.format) {
+	case 0:
+		return f.format, nil
+	case 'd', 'x', 'X', 'o', 'b':
+		// Integer verbs such as %02d need a number
+		if n, err := strconv.Atoi(value); err == nil {
+			return fmt.Sprintf(f.format, n), nil
+		}
+	}
+	return fmt.Sprintf(f.format, value), nil
+}
+
+// formatVerb returns the verb of the first directive in a fmt format, or
+// 0 if there is none.
+func formatVerb(format string) byte {
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		for i < len(format) && strings.IndexByte("+-# 0123456789.", format[i]) >= 0 {
+			i++
+		}
+		if i < len(format) && format[i] != '%' {
+			return format[i]
+		}
+	}
+	return 0
+}
+
+// renderTemplate expands {field} placeholders in an output template.
+// Field values are sanitized individually, so a "/" in the template
+// itself creates subdirectories while one in a title does not. The
+// returned path has no extension.
+func renderTemplate(tmpl string, fields map[string]string, san sanitizer) (string, error) {
+	var sb strings.Builder
+	for {
+		start := strings.IndexByte(tmpl, '{')
+		if start < 0 {
+			sb.WriteString(tmpl)
+			break
+		}
+		end := strings.IndexByte(tmpl[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated field in template %q", tmpl)
+		}
+		field, err := parseTemplateField(tmpl[start+1:start+end], fields)
+		if err != nil {
+			return "", err
+		}
+		value, err := field.render(fields, san)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(tmpl[:start])
+		sb.WriteString(value)
+		tmpl = tmpl[start+end+1:]
+	}
+
+	path := filepath.Clean(sb.String())
+	if path == "." || filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("template produced invalid path %q", path)
+	}
+	return path, nil
+}
+
+// validateTemplate checks tmpl for syntax errors and unknown fields and
+// functions.
+func validateTemplate(tmpl string) error {
+	fields := templateFields(&youtube.Video{}, 1, dateFormat{})
+	for name := range fields {
+		fields[name] = name
+	}
+	_, err := renderTemplate(tmpl, fields, sanitizer{})
+	return err
+}
+
+// outputBase returns the templated output path for video, relative to the
+// output directory and without an extension.
+func (d *Downloader) outputBase(job *Job, video *youtube.Video) (string, error) {
+	tmpl := d.config.OutputTemplate
+	if tmpl == "" {
+		tmpl = defaultOutputTemplate
+	}
+	san := d.sanitizer()
+	base, err := renderTemplate(tmpl, templateFields(video, job.Index, d.config.Dates), san)
+	if err != nil {
+		return "", err
+	}
+	if job.clip != nil {
+		base += " (" + job.clip.label() + ")"
+	}
+	base = san.path(d.organize(job, video, base, tmpl, san), video.ID)
+	if job.category != nil && job.category.dir != "" {
+		base = filepath.Join(job.category.dir, base)
+	}
+	if job.source.Subdir != "" {
+		base = filepath.Join(job.source.Subdir, base)
+	}
+	return base, nil
+}
+
+// organizeModes are the -organize choices.
+var organizeModes = []string{"flat", "playlist", "channel"}
+
+// organize puts base in a directory named after the playlist or channel
+// a job came from, numbered by playlist position unless the template
+// already uses {index}. Videos given directly stay where they are.
+func (d *Downloader) organize(job *Job, video *youtube.Video, base, tmpl string, san sanitizer) string {
+	var dir string
+	switch d.config.Organize {
+	case "playlist":
+		dir = job.source.Title
+	case "channel":
+		dir = job.source.Channel
+		if dir == "" && job.source.URL != "" {
+			dir = video.Author
+		}
+	}
+	if dir == "" {
+		return base
+	}
+	if job.Index > 0 && !strings.Contains(tmpl, "{index") {
+		width := max(2, len(strconv.Itoa(job.source.Size)))
+		base = fmt.Sprintf("%0*d - %s", width, job.Index, base)
+	}
+	return san.field(dir) + "/" + base
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	video := &youtube.Video{
+		ID:          "dQw4w9WgXcQ",
+		Title:       "Never Gonna: Give/You Up?",
+		Author:      "Rick Astley - Topic",
+		ChannelID:   "UCuAXFkgsw1L7xaCfnd5JJOw",
+		Duration:    212 * time.Second,
+		PublishDate: time.Date(2009, 10, 25, 0, 0, 0, 0, time.UTC),
+	}
+	withIndex := templateFields(video, 7, dateFormat{})
+	noIndex := templateFields(video, 0, dateFormat{})
+
+	tests := []struct {
+		name    string
+		tmpl    string
+		fields  map[string]string
+		san     sanitizer
+		want    string
+		wantErr bool
+	}{
+		{name: "title", tmpl: "{title}", fields: withIndex, want: "Never Gonna- Give-You Up-"},
+		{name: "literal text", tmpl: "{id} [{duration}s]", fields: withIndex, want: "dQw4w9WgXcQ [212s]"},
+		{name: "directories", tmpl: "{channel}/{upload_date}", fields: withIndex, want: filepath.Join("Rick Astley - Topic", "20091025")},
+		{name: "topic artist", tmpl: "{artist}", fields: withIndex, want: "Rick Astley"},
+		{name: "first non-empty", tmpl: "{index|id}", fields: noIndex, want: "dQw4w9WgXcQ"},
+		{name: "conditional", tmpl: "{index?%02d - }{id}", fields: withIndex, want: "07 - dQw4w9WgXcQ"},
+		{name: "conditional empty", tmpl: "{index?%02d - }{id}", fields: noIndex, want: "dQw4w9WgXcQ"},
+		{name: "conditional without verb", tmpl: "{index?part-}{id}", fields: withIndex, want: "part-dQw4w9WgXcQ"},
+		{name: "functions", tmpl: "{title:slugify:upper}", fields: withIndex, want: "NEVER-GONNA-GIVE-YOU-UP"},
+		{name: "truncate", tmpl: "{title:truncate(5)}", fields: withIndex, want: "Never"},
+		{name: "date", tmpl: "{upload_date:date(2006/01)}", fields: withIndex, want: "2009-10"},
+		{name: "restricted", tmpl: "{title}", fields: withIndex, san: sanitizer{restrict: true}, want: "Never_Gonna-_Give-You_Up-"},
+		{name: "unknown field", tmpl: "{views}", fields: withIndex, wantErr: true},
+		{name: "unknown function", tmpl: "{title:reverse}", fields: withIndex, wantErr: true},
+		{name: "bad truncate", tmpl: "{title:truncate(0)}", fields: withIndex, wantErr: true},
+		{name: "unclosed argument", tmpl: "{title:truncate(5}", fields: withIndex, wantErr: true},
+		{name: "unterminated", tmpl: "{title", fields: withIndex, wantErr: true},
+		{name: "escapes", tmpl: "../{title}", fields: withIndex, wantErr: true},
+		{name: "absolute", tmpl: "/{title}", fields: withIndex, wantErr: true},
+		{name: "empty", tmpl: "{index}", fields: noIndex, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderTemplate(tt.tmpl, tt.fields, tt.san)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("renderTemplate(%q) = %q, want an error", tt.tmpl, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderTemplate(%q): %v", tt.tmpl, err)
+			}
+			if got != tt.want {
+				t.Errorf("renderTemplate(%q) = %q, want %q", tt.tmpl, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// YouTube throttles stream URLs whose n parameter wasn't deciphered to a
+// few dozen KiB/s, and a fresh URL, often from another client, is
+// usually served at full speed again.
+const (
+	throttleWindow  = 10 * time.Second
+	throttleRetries = 3
+)
+
+// throttledError is a stream that has been delivering below
+// -throttled-rate.
+type throttledError struct {
+	rate float64 // bytes/s
+}
+
+func (e *throttledError) Error() string {
+	return fmt.Sprintf("stream throttled to %s", formatRate(e.rate))
+}
+
+// throttleReader fails with a throttledError once the stream has
+// averaged below rate over a throttleWindow. Only time spent waiting on
+// the stream counts, so pauses, -limit-rate and slow disks don't look
+// like throttling.
+type throttleReader struct {
+	r     io.Reader
+	rate  int64
+	bytes int64
+	busy  time.Duration
+}
+
+func (t *throttleReader) Read(b []byte) (int, error) {
+	start := time.Now()
+	n, err := t.r.Read(b)
+	t.busy += time.Since(start)
+	t.bytes += int64(n)
+	if t.busy >= throttleWindow {
+		rate := float64(t.bytes) / t.busy.Seconds()
+		if err == nil && rate < float64(t.rate) {
+			return n, &throttledError{rate: rate}
+		}
+		t.bytes, t.busy = 0, 0
+	}
+	return n, err
+}
+
+// watchThrottle wraps stream with throttle detection, unless it is off.
+func (d *Downloader) watchThrottle(stream io.Reader) io.Reader {
+	if d.config.ThrottledRate <= 0 {
+		return stream
+	}
+	return &throttleReader{r: stream, rate: d.config.ThrottledRate}
+}
+
+// resumeThrottled re-resolves a throttled stream's URL and continues the
+// download from where it stopped, up to throttleRetries times. n is what
+// has been written to path so far and err is why fetching stopped.
+func (d *Downloader) resumeThrottled(ctx context.Context, job *Job, video *youtube.Video, format *youtube.Format, path, label string, n, limit int64, err error) (int64, error) {
+	var throttled *throttledError
+	for attempt := 0; attempt < throttleRetries && errors.As(err, &throttled); attempt++ {
+		d.logger.Printf("%s is %v at %s, re-resolving its URL", label, throttled, formatBytes(n))
+		var fresh *youtube.Format
+		if video, fresh, err = d.reresolveFormat(ctx, video, format); err != nil {
+			return n, fmt.Errorf("failed to re-resolve throttled %s: %v", label, err)
+		}
+		format = fresh
+
+		var stream io.ReadCloser
+		if stream, err = d.openStreamAt(ctx, video, format, n); err != nil {
+			return n, fmt.Errorf("failed to resume throttled %s: %v", label, err)
+		}
+		var r io.Reader = stream
+		if limit > 0 {
+			r = io.LimitReader(stream, limit-n)
+		}
+		n, err = d.downloadStreamToFile(job, d.watchBudget(job, format, d.watchThrottle(r)), path, label, n, limit)
+		stream.Close()
+		err = checkStreamLength(label, n, limit, err)
+	}
+	return n, err
+}
+
+// reresolveFormat fetches video's metadata again, past the cache, for a
+// fresh URL for format. The client that extracted it is passed over,
+// since its URLs are the ones being throttled.
+func (d *Downloader) reresolveFormat(ctx context.Context, video *youtube.Video, format *youtube.Format) (*youtube.Video, *youtube.Format, error) {
+	if p, ok := d.baseClientFallback(); ok {
+		p.avoid(video.ID)
+	}
+	fresh, err := d.client.GetVideoContext(withFreshMetadata(ctx), video.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range fresh.Formats {
+		if fresh.Formats[i].ItagNo == format.ItagNo {
+			return fresh, &fresh.Formats[i], nil
+		}
+	}
+	return nil, nil, fmt.Errorf("format %d is no longer offered", format.ItagNo)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// newTransport builds the transport every request goes out through, with
+// -proxy, the dialer options and the connection pool settings applied.
+func newTransport(config Config) (http.RoundTripper, error) {
+	dial, err := newDialer(config)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.Proxy != "" {
+		proxyURL, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if dial != nil {
+		transport.DialContext = dial
+	}
+	if config.MaxIdleConns > 0 {
+		transport.MaxIdleConns = config.MaxIdleConns
+	}
+	if config.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	}
+	transport.MaxConnsPerHost = config.MaxConnsPerHost
+	if config.DisableHTTP2 {
+		// A non-nil empty map is what turns HTTP/2 off
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	if len(config.HostConns) == 0 {
+		return transport, nil
+	}
+
+	hosts := &hostTransport{base: transport, domains: make(map[string]*http.Transport)}
+	for domain, n := range config.HostConns {
+		t := transport.Clone()
+		t.MaxConnsPerHost = n
+		t.MaxIdleConnsPerHost = max(t.MaxIdleConnsPerHost, n)
+		hosts.domains[domain] = t
+	}
+	return hosts, nil
+}
+
+// hostTransport sends the requests to each domain given its own
+// connection limit by -max-conns-per-host through a pool of its own.
+type hostTransport struct {
+	base    *http.Transport
+	domains map[string]*http.Transport
+}
+
+func (h *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := strings.ToLower(req.URL.Hostname())
+	for {
+		if t, ok := h.domains[host]; ok {
+			return t.RoundTrip(req)
+		}
+		_, parent, found := strings.Cut(host, ".")
+		if !found {
+			return h.base.RoundTrip(req)
+		}
+		host = parent
+	}
+}
+
+// parseHostConns parses -max-conns-per-host: a limit for every host, a
+// list of domain=limit for the hosts of those domains, or both, e.g.
+// "8,googlevideo.com=32". 0 is no limit.
+func parseHostConns(s string) (int, map[string]int, error) {
+	var limit int
+	var domains map[string]int
+	for _, part := range splitList(s) {
+		domain, value, found := strings.Cut(part, "=")
+		if !found {
+			value, domain = domain, ""
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n < 0 {
+			return 0, nil, fmt.Errorf("invalid limit %q", part)
+		}
+		if domain == "" {
+			limit = n
+			continue
+		}
+		if domains == nil {
+			domains = make(map[string]int)
+		}
+		domains[strings.ToLower(strings.Trim(strings.TrimSpace(domain), "."))] = n
+	}
+	return limit, domains, nil
+}
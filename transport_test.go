@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHostConns(t *testing.T) {
+	tests := []struct {
+		spec      string
+		wantLimit int
+		want      map[string]int
+		wantErr   bool
+	}{
+		{spec: ""},
+		{spec: "8", wantLimit: 8},
+		{spec: "0", wantLimit: 0},
+		{spec: "googlevideo.com=32", want: map[string]int{"googlevideo.com": 32}},
+		{spec: "8,googlevideo.com=32", wantLimit: 8, want: map[string]int{"googlevideo.com": 32}},
+		{spec: " 4 , .GoogleVideo.com. = 16 ,youtube.com=2", wantLimit: 4, want: map[string]int{"googlevideo.com": 16, "youtube.com": 2}},
+		{spec: "youtube.com=0", want: map[string]int{"youtube.com": 0}},
+		// The last limit for every host wins
+		{spec: "2,6", wantLimit: 6},
+		{spec: "many", wantErr: true},
+		{spec: "-1", wantErr: true},
+		{spec: "youtube.com=", wantErr: true},
+		{spec: "youtube.com=lots", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			limit, domains, err := parseHostConns(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseHostConns(%q) = %d, %v, want an error", tt.spec, limit, domains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseHostConns(%q): %v", tt.spec, err)
+			}
+			if limit != tt.wantLimit || !reflect.DeepEqual(domains, tt.want) {
+				t.Errorf("parseHostConns(%q) = %d, %v, want %d, %v", tt.spec, limit, domains, tt.wantLimit, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	trashDirName     = ".trash"
+	trashIndexName   = "index.json"
+	defaultRetention = "30d"
+)
+
+type TrashEntry struct {
+	ID           string    `json:"id"`
+	Group        string    `json:"group"` // shared by a file and its sidecars
+	OriginalPath string    `json:"original_path"`
+	TrashPath    string    `json:"trash_path"`
+	DeletedAt    time.Time `json:"deleted_at"`
+}
+
+// Trash is a soft-delete area inside an output directory. Anything that
+// prunes downloads moves them here instead of removing them outright.
+type Trash struct {
+	dir string
+}
+
+// trashMu serializes index updates, as concurrent jobs may trash files.
+var trashMu sync.Mutex
+
+func NewTrash(outputDir string) *Trash {
+	return &Trash{dir: filepath.Join(outputDir, trashDirName)}
+}
+
+func (t *Trash) loadIndex() ([]TrashEntry, error) {
+	data, err := os.ReadFile(filepath.Join(t.dir, trashIndexName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []TrashEntry
+	return entries, json.Unmarshal(data, &entries)
+}
+
+func (t *Trash) saveIndex(entries []TrashEntry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(t.dir, trashIndexName), data, 0644)
+}
+
+// Move puts path, and its info.json sidecar if present, into the trash.
+func (t *Trash) Move(path string) error {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+	if err := os.MkdirAll(t.dir, 0755); err != nil {
+		return err
+	}
+	entries, err := t.loadIndex()
+	if err != nil {
+		return err
+	}
+
+	paths := []string{path}
+	if _, err := os.Stat(infoJSONPath(path)); err == nil {
+		paths = append(paths, infoJSONPath(path))
+	}
+
+	stamp := strconv.FormatInt(time.Now().UnixNano(), 36)
+	for i, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		id := stamp + strconv.Itoa(i)
+		dest := filepath.Join(t.dir, id+"-"+filepath.Base(p))
+		if err := os.Rename(abs, dest); err != nil {
+			return fmt.Errorf("failed to move %s to trash: %v", p, err)
+		}
+		entries = append(entries, TrashEntry{ID: id, Group: stamp, OriginalPath: abs, TrashPath: dest, DeletedAt: time.Now()})
+	}
+	return t.saveIndex(entries)
+}
+
+// Restore moves a trashed file, together with any sidecars trashed
+// alongside it, back to where it was deleted from.
+func (t *Trash) Restore(id string) ([]TrashEntry, error) {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+	entries, err := t.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	group := ""
+	for _, e := range entries {
+		if e.ID == id || filepath.Base(e.OriginalPath) == id {
+			group = e.Group
+			break
+		}
+	}
+	if group == "" {
+		return nil, fmt.Errorf("no trashed file matching %q", id)
+	}
+
+	var restored, kept []TrashEntry
+	for _, e := range entries {
+		if e.Group != group {
+			kept = append(kept, e)
+			continue
+		}
+		if _, err := os.Stat(e.OriginalPath); err == nil {
+			return restored, fmt.Errorf("%s already exists", e.OriginalPath)
+		}
+		if err := os.MkdirAll(filepath.Dir(e.OriginalPath), 0755); err != nil {
+			return restored, err
+		}
+		if err := os.Rename(e.TrashPath, e.OriginalPath); err != nil {
+			return restored, err
+		}
+		restored = append(restored, e)
+	}
+	return restored, t.saveIndex(kept)
+}
+
+// Empty permanently deletes trashed files older than retention.
+func (t *Trash) Empty(retention time.Duration) ([]TrashEntry, error) {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+	entries, err := t.loadIndex()
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+
+	var removed, kept []TrashEntry
+	cutoff := time.Now().Add(-retention)
+	for _, e := range entries {
+		if e.DeletedAt.After(cutoff) {
+			kept = append(kept, e)
+			continue
+		}
+		if err := os.Remove(e.TrashPath); err != nil && !os.IsNotExist(err) {
+			kept = append(kept, e)
+			continue
+		}
+		removed = append(removed, e)
+	}
+	return removed, t.saveIndex(kept)
+}
+
+func (t *Trash) List() ([]TrashEntry, error) {
+	return t.loadIndex()
+}
+
+// trashOutput moves a finished download that is about to be replaced,
+// with its info.json, to the trash of the output directory, from where
+// it can be restored until the trash is emptied.
+func (d *Downloader) trashOutput(path string) {
+	if d.config.Dest != nil || !fileExists(path) {
+		return
+	}
+	if err := NewTrash(d.config.OutputDir).Move(path); err != nil {
+		d.logger.Printf("Failed to move %s to the trash: %v", filepath.Base(path), err)
+	}
+}
+
+func runTrash(args []string) error {
+	usage := fmt.Errorf("usage: youtube-downloader trash list|empty|restore [-output dir] [args]")
+	if len(args) == 0 {
+		return usage
+	}
+
+	flags := flag.NewFlagSet("trash "+args[0], flag.ExitOnError)
+	outputDir := flags.String("output", "downloads", "Output directory containing the trash")
+	retention := flags.String("retention", defaultRetention, "Only empty files trashed longer ago than this (e.g. 30d, 12h)")
+	all := flags.Bool("all", false, "Empty the trash regardless of retention")
+	flags.Parse(args[1:])
+
+	trash := NewTrash(*outputDir)
+
+	switch args[0] {
+	case "list":
+		entries, err := trash.List()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			fmt.Printf("%s  %s  %s\n", e.ID, e.DeletedAt.Format(time.RFC3339), e.OriginalPath)
+		}
+	case "empty":
+		keep, err := parseDuration(*retention)
+		if err != nil {
+			return fmt.Errorf("invalid retention %q: %v", *retention, err)
+		}
+		if *all {
+			keep = 0
+		}
+		removed, err := trash.Empty(keep)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Permanently deleted %d files\n", len(removed))
+	case "restore":
+		if flags.NArg() == 0 {
+			return fmt.Errorf("usage: youtube-downloader trash restore [-output dir] <id|filename>...")
+		}
+		for _, id := range flags.Args() {
+			restored, err := trash.Restore(id)
+			for _, e := range restored {
+				fmt.Printf("Restored %s\n", e.OriginalPath)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return usage
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTrashMoveAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	video := filepath.Join(dir, "Channel", "Title.mp4")
+	info := infoJSONPath(video)
+	for _, path := range []string{video, info} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(filepath.Base(path)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trash := NewTrash(dir)
+	if err := trash.Move(video); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(video) || fileExists(info) {
+		t.Fatal("the video or its info.json is still in place")
+	}
+	entries, err := trash.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Group != entries[1].Group {
+		t.Fatalf("trash holds %+v, want the video and its info.json in one group", entries)
+	}
+
+	// A file back in the trashed one's place blocks the restore
+	if err := os.WriteFile(video, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := trash.Restore("Title.mp4"); err == nil {
+		t.Error("restored over an existing file")
+	}
+	os.Remove(video)
+
+	restored, err := trash.Restore("Title.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 2 {
+		t.Errorf("restored %d files, want 2", len(restored))
+	}
+	for _, path := range []string{video, info} {
+		if data, err := os.ReadFile(path); err != nil || string(data) != filepath.Base(path) {
+			t.Errorf("%s holds %q, %v after the restore", path, data, err)
+		}
+	}
+	if entries, _ := trash.List(); len(entries) != 0 {
+		t.Errorf("trash still lists %+v", entries)
+	}
+	if _, err := trash.Restore("Title.mp4"); err == nil {
+		t.Error("restored a file twice")
+	}
+}
+
+func TestTrashEmpty(t *testing.T) {
+	trash := NewTrash(t.TempDir())
+	if err := os.MkdirAll(trash.dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	var entries []TrashEntry
+	for i, age := range []time.Duration{time.Hour, 10 * 24 * time.Hour, 40 * 24 * time.Hour} {
+		path := filepath.Join(trash.dir, strconv.Itoa(i)+"-Title.mp4")
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, TrashEntry{ID: path, Group: path, TrashPath: path, DeletedAt: now.Add(-age)})
+	}
+	if err := trash.saveIndex(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := trash.Empty(30 * 24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0].TrashPath != entries[2].TrashPath || fileExists(entries[2].TrashPath) {
+		t.Errorf("emptying a 30 day retention removed %+v, want only the 40 day old file", removed)
+	}
+
+	if removed, err = trash.Empty(0); err != nil || len(removed) != 2 {
+		t.Errorf("emptying everything removed %d files, %v, want 2", len(removed), err)
+	}
+	if kept, _ := trash.List(); len(kept) != 0 {
+		t.Errorf("trash still lists %+v", kept)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tuiRefresh  = 250 * time.Millisecond
+	tuiLogLines = 5
+	tuiBarWidth = 20
+)
+
+type tui struct {
+	d        *Downloader
+	logs     *logBuffer
+	selected int
+	width    int
+}
+
+// runTUI runs process while drawing the job queue and handling keys.
+// Logger output is redirected into a small buffer shown under the queue.
+func runTUI(d *Downloader, process func() error) error {
+	restore, err := enableRawTerminal()
+	if err != nil {
+		return fmt.Errorf("failed to initialise terminal: %v", err)
+	}
+	defer restore()
+
+	ui := &tui{d: d, logs: &logBuffer{max: tuiLogLines}, width: terminalWidth()}
+	d.logger.SetOutput(ui.logs)
+	defer d.logger.SetOutput(os.Stdout)
+
+	done := make(chan error, 1)
+	go func() { done <- process() }()
+
+	// The reader stays blocked on stdin after the queue finishes, until
+	// the next key or EOF, so quit tells it to drop that key and stop
+	keys := make(chan string)
+	quit := make(chan struct{})
+	defer close(quit)
+	go readKeys(os.Stdin, keys, quit)
+
+	ticker := time.NewTicker(tuiRefresh)
+	defer ticker.Stop()
+
+	for {
+		ui.render(os.Stdout)
+		select {
+		case err := <-done:
+			ui.render(os.Stdout)
+			return err
+		case key := <-keys:
+			ui.handleKey(key)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *tui) handleKey(key string) {
+	jobs := t.d.Jobs()
+	if len(jobs) == 0 {
+		return
+	}
+	if t.selected >= len(jobs) {
+		t.selected = len(jobs) - 1
+	}
+	job := jobs[t.selected]
+
+	switch key {
+	case "up", "k":
+		if t.selected > 0 {
+			t.selected--
+		}
+	case "down", "j":
+		if t.selected < len(jobs)-1 {
+			t.selected++
+		}
+	case "p":
+		if job.Snapshot().Paused {
+			job.Resume()
+		} else {
+			job.Pause()
+		}
+	case "x":
+		job.Cancel()
+	case "[":
+		t.d.sched.move(job, -1)
+	case "]":
+		t.d.sched.move(job, 1)
+	case "P":
+		if t.d.Paused() {
+			t.d.ResumeAll()
+		} else {
+			t.d.PauseAll()
+		}
+	case "s":
+		t.d.StopAfterCurrent()
+	case "q":
+		t.d.CancelAll()
+	}
+}
+
+func (t *tui) render(w io.Writer) {
+	jobs := t.d.Jobs()
+	counts := make(map[JobStatus]int)
+	for _, job := range jobs {
+		counts[job.Snapshot().Status]++
+	}
+
+	paused := ""
+	if t.d.Paused() {
+		paused = " (paused)"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\033[H\033[2J")
+	fmt.Fprintf(&sb, "YouTube Downloader%s - %d running, %d queued, %d done, %d failed, %d canceled\r\n",
+		paused, counts[JobRunning], counts[JobQueued], counts[JobDone], counts[JobFailed], counts[JobCanceled])
+	sb.WriteString("up/down select  p pause/resume  P pause/resume all  x cancel  [ ] move in queue  s stop after current  q quit\r\n\r\n")
+
+	for i, job := range jobs {
+		snap := job.Snapshot()
+		cursor := " "
+		if i == t.selected {
+			cursor = ">"
+		}
+		status := string(snap.Status)
+		switch {
+		case snap.Paused && snap.Status == JobRunning:
+			status = "paused"
+		case snap.Phase != "":
+			status = string(snap.Phase)
+		}
+
+		bar := progressBar(snap.Downloaded, snap.Total)
+		progress := formatProgress(snap.Downloaded, snap.Total)
+		if snap.ProcessTotal > 0 {
+			// While merging or converting, show how far ffmpeg has got
+			bar = progressBar(int64(snap.Processed), int64(snap.ProcessTotal))
+			progress = formatDuration(snap.Processed) + "/" + formatDuration(snap.ProcessTotal)
+		}
+		line := fmt.Sprintf("%s %3d %-17s %s %21s %12s", cursor, snap.ID, status, bar, progress, formatSpeed(snap.Speed))
+		title := snap.Title
+		if snap.Err != nil && snap.Status == JobFailed {
+			title += ": " + snap.Err.Error()
+		}
+		sb.WriteString(truncate(line+"  "+title, t.width))
+		sb.WriteString("\r\n")
+	}
+
+	sb.WriteString("\r\n")
+	for _, l := range t.logs.Lines() {
+		sb.WriteString(truncate(l, t.width))
+		sb.WriteString("\r\n")
+	}
+	io.WriteString(w, sb.String())
+}
+
+func progressBar(done, total int64) string {
+	if total <= 0 {
+		return "[" + strings.Repeat(" ", tuiBarWidth) + "]    -  "
+	}
+	ratio := float64(done) / float64(total)
+	if ratio > 1 {
+		ratio = 1
+	}
+	filled := int(ratio * tuiBarWidth)
+	return fmt.Sprintf("[%s%s] %5.1f%%", strings.Repeat("#", filled), strings.Repeat("-", tuiBarWidth-filled), ratio*100)
+}
+
+func formatSpeed(bytesPerSec float64) string {
+	if bytesPerSec <= 0 {
+		return ""
+	}
+	return formatRate(bytesPerSec)
+}
+
+func formatProgress(done, total int64) string {
+	if total <= 0 {
+		return formatBytes(done)
+	}
+	return formatBytes(done) + "/" + formatBytes(total)
+}
+
+func truncate(s string, width int) string {
+	r := []rune(s)
+	if width <= 0 || len(r) <= width {
+		return s
+	}
+	return string(r[:width])
+}
+
+// readKeys translates raw terminal input into key names, decoding the
+// arrow key escape sequences, until in ends or quit is closed.
+func readKeys(in io.Reader, keys chan<- string, quit <-chan struct{}) {
+	send := func(key string) bool {
+		select {
+		case keys <- key:
+			return true
+		case <-quit:
+			return false
+		}
+	}
+	r := bufio.NewReader(in)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		key := string(b)
+		if b == 0x1b {
+			if next, _ := r.ReadByte(); next != '[' {
+				continue
+			}
+			switch code, _ := r.ReadByte(); code {
+			case 'A':
+				key = "up"
+			case 'B':
+				key = "down"
+			default:
+				continue
+			}
+		}
+		if !send(key) {
+			return
+		}
+	}
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// enableRawTerminal switches the terminal to unbuffered, non-echoing input
+// and returns a function that restores the previous state.
+func enableRawTerminal() (func(), error) {
+	state, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty("cbreak", "-echo"); err != nil {
+		return nil, err
+	}
+	fmt.Print("\033[?25l")
+	return func() {
+		stty(state)
+		fmt.Print("\033[?25h\r\n")
+	}, nil
+}
+
+func terminalWidth() int {
+	size, err := stty("size")
+	if err != nil {
+		return 120
+	}
+	fields := strings.Fields(size)
+	if len(fields) != 2 {
+		return 120
+	}
+	width, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 120
+	}
+	return width
+}
+
+// logBuffer keeps the last max lines written to it.
+type logBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	max   int
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		b.lines = append(b.lines, line)
+	}
+	if len(b.lines) > b.max {
+		b.lines = b.lines[len(b.lines)-b.max:]
+	}
+	return len(p), nil
+}
+
+func (b *logBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.lines...)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseDuration extends time.ParseDuration with a "d" (day) suffix, e.g. "30d".
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err == nil {
+			return time.Duration(n * float64(24*time.Hour)), nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// stringList is a flag that may be given more than once.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// rawUnits makes formatBytes and formatDuration print plain numbers for
+// scripts (-raw-units).
+var rawUnits bool
+
+// formatBytes formats a byte count with binary prefixes, e.g. "1.4 GiB".
+func formatBytes(n int64) string {
+	if rawUnits {
+		return strconv.FormatInt(n, 10)
+	}
+	const unit = 1024
+	if n < unit && n > -unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n)
+	prefix := 0
+	for math.Abs(value) >= unit && prefix < len("KMGTPE") {
+		value /= unit
+		prefix++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTPE"[prefix-1])
+}
+
+// formatRate formats a transfer rate in bytes per second.
+func formatRate(bytesPerSec float64) string {
+	return formatBytes(int64(bytesPerSec)) + "/s"
+}
+
+// formatDuration formats a duration to the second, e.g. "12m34s", or to
+// the millisecond when shorter than a second.
+func formatDuration(d time.Duration) string {
+	if rawUnits {
+		return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+	}
+	if d < time.Second && d > -time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
+
+// formatBitrate formats a bitrate in bits per second, e.g. "1.2 Mbps".
+func formatBitrate(bitsPerSec int) string {
+	if rawUnits {
+		return strconv.Itoa(bitsPerSec)
+	}
+	switch {
+	case bitsPerSec >= 1_000_000:
+		return fmt.Sprintf("%.1f Mbps", float64(bitsPerSec)/1_000_000)
+	case bitsPerSec >= 1_000:
+		return fmt.Sprintf("%d kbps", bitsPerSec/1_000)
+	}
+	return fmt.Sprintf("%d bps", bitsPerSec)
+}
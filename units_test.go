@@ -0,0 +1,119 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{1503238553, "1.4 GiB"},
+		{-2048, "-2.0 KiB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+	if got := formatRate(2.5 * 1024 * 1024); got != "2.5 MiB/s" {
+		t.Errorf("formatRate = %q, want %q", got, "2.5 MiB/s")
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0s"},
+		{1234567 * time.Microsecond, "1s"},
+		{754 * time.Second, "12m34s"},
+		{12*time.Minute + 34600*time.Millisecond, "12m35s"},
+		// Under a second it keeps the milliseconds
+		{1234567 * time.Nanosecond, "1ms"},
+		{345678 * time.Microsecond, "346ms"},
+	}
+	for _, tt := range tests {
+		if got := formatDuration(tt.d); got != tt.want {
+			t.Errorf("formatDuration(%s) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestFormatBitrate(t *testing.T) {
+	tests := []struct {
+		bps  int
+		want string
+	}{
+		{999, "999 bps"},
+		{128_000, "128 kbps"},
+		{128_999, "128 kbps"},
+		{1_250_000, "1.2 Mbps"},
+	}
+	for _, tt := range tests {
+		if got := formatBitrate(tt.bps); got != tt.want {
+			t.Errorf("formatBitrate(%d) = %q, want %q", tt.bps, got, tt.want)
+		}
+	}
+}
+
+func TestRawUnits(t *testing.T) {
+	rawUnits = true
+	t.Cleanup(func() { rawUnits = false })
+
+	if got := formatBytes(1503238553); got != "1503238553" {
+		t.Errorf("formatBytes = %q, want the plain byte count", got)
+	}
+	if got := formatDuration(754500 * time.Millisecond); got != "754.5" {
+		t.Errorf("formatDuration = %q, want seconds", got)
+	}
+	if got := formatBitrate(1_250_000); got != "1250000" {
+		t.Errorf("formatBitrate = %q, want the plain bitrate", got)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    time.Duration
+		wantErr bool
+	}{
+		{s: "90s", want: 90 * time.Second},
+		{s: "1h30m", want: 90 * time.Minute},
+		{s: "30d", want: 30 * 24 * time.Hour},
+		{s: "1.5d", want: 36 * time.Hour},
+		{s: "d", wantErr: true},
+		{s: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseDuration(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseDuration(%q) = %s, %v, want %s", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"", nil},
+		{"en", []string{"en"}},
+		{" en, de ,,fr ", []string{"en", "de", "fr"}},
+	}
+	for _, tt := range tests {
+		if got := splitList(tt.s); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitList(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
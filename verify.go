@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// verifyRangeSize is how much each -paranoid-ranges sample re-fetches.
+const verifyRangeSize = 256 << 10
+
+// verifyFormat re-fetches a downloaded stream and compares it with the
+// file at path, for -paranoid. By default the whole stream is fetched a
+// second time and the hashes compared; with -paranoid-ranges only that
+// many random ranges are.
+func (d *Downloader) verifyFormat(ctx context.Context, job *Job, video *youtube.Video, format *youtube.Format, path, label string) error {
+	job.setPhase(PhaseVerifying)
+	if d.config.ParanoidRanges > 0 {
+		d.logger.Printf("Verifying %d random ranges of %s", d.config.ParanoidRanges, label)
+		return d.verifyRanges(ctx, video, format, path)
+	}
+
+	d.logger.Printf("Verifying %s with a second download", label)
+	stream, _, err := d.client.GetStreamContext(ctx, video, format)
+	if err != nil {
+		return fmt.Errorf("failed to get stream to verify %s: %v", label, err)
+	}
+	defer stream.Close()
+
+	remote := sha256.New()
+	if _, err := io.Copy(remote, &limitedReader{ctx: ctx, r: stream, limiter: d.limiter}); err != nil {
+		return fmt.Errorf("failed to re-download %s: %v", label, err)
+	}
+	local, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+	if got := fmt.Sprintf("%x", remote.Sum(nil)); got != local {
+		return fmt.Errorf("verification of %s failed: downloads differ (sha256 %s vs %s)", label, local, got)
+	}
+	return nil
+}
+
+func (d *Downloader) verifyRanges(ctx context.Context, video *youtube.Video, format *youtube.Format, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := st.Size()
+	if format.ContentLength > 0 && size != format.ContentLength {
+		return fmt.Errorf("verification failed: %s is %d bytes, expected %d", path, size, format.ContentLength)
+	}
+
+	url, err := d.client.GetStreamURLContext(ctx, video, format)
+	if err != nil {
+		return err
+	}
+
+	// Always include the start and end, where truncation and bad headers show
+	offsets := []int64{0, max(size-verifyRangeSize, 0)}
+	for len(offsets) < d.config.ParanoidRanges {
+		offsets = append(offsets, rand.Int63n(max(size-verifyRangeSize, 0)+1))
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	for _, start := range offsets {
+		length := min(verifyRangeSize, size-start)
+		if length <= 0 {
+			continue
+		}
+		want := make([]byte, length)
+		if _, err := f.ReadAt(want, start); err != nil {
+			return err
+		}
+		got, err := d.fetchRange(ctx, video, url, start, length)
+		if err != nil {
+			return fmt.Errorf("failed to fetch range at %d: %v", start, err)
+		}
+		if !bytes.Equal(got, want) {
+			return fmt.Errorf("verification failed: bytes %d-%d of %s differ from the server", start, start+length-1, path)
+		}
+	}
+	return nil
+}
+
+func (d *Downloader) fetchRange(ctx context.Context, video *youtube.Video, url string, start, length int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, start+length-1))
+
+	resp, err := d.streamHTTP(video).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("server does not support ranges (status %d)", resp.StatusCode)
+	}
+	return io.ReadAll(&limitedReader{ctx: ctx, r: io.LimitReader(resp.Body, length), limiter: d.limiter})
+}
+
+// limitedReader applies the bandwidth limit to reads that are not part of
+// a job's progress, such as verification passes.
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (l *limitedReader) Read(b []byte) (int, error) {
+	n, err := l.r.Read(b)
+	if lerr := l.limiter.wait(l.ctx, n); lerr != nil && err == nil {
+		err = lerr
+	}
+	return n, err
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// watchArchiveName is the download archive watch uses when none is given,
+// since it is what tells new uploads from ones already fetched.
+const watchArchiveName = ".download-archive.txt"
+
+// watchSource is a channel, playlist or feed being watched.
+type watchSource struct {
+	spec    string
+	checked bool   // at least one successful check
+	feed    string // cheaper feed URL for checks after the first
+}
+
+// isPlaylistSpec tells playlist URLs and IDs apart from channels.
+func isPlaylistSpec(s string) bool {
+	if strings.Contains(s, "list=") {
+		return true
+	}
+	for _, prefix := range []string{"PL", "UU", "OL", "FL", "RD"} {
+		if strings.HasPrefix(s, prefix) && !strings.Contains(s, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceIDs lists a source's videos, newest first for channels. The
+// first check of a channel reads its whole uploads playlist; later ones
+// only need the feed of recent uploads.
+func (d *Downloader) sourceIDs(src *watchSource) ([]string, error) {
+	switch {
+	case src.feed != "":
+		return d.feedIDs(context.Background(), src.feed)
+	case isFeedURL(src.spec):
+		return d.feedIDs(context.Background(), src.spec)
+	case isPlaylistSpec(src.spec):
+		return d.playlistIDs(playlistURL(src.spec))
+	}
+	id, err := d.resolveChannelID(context.Background(), src.spec)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := d.channelUploadIDs(id)
+	if err == nil {
+		src.feed = channelFeedURL(id)
+	}
+	return ids, err
+}
+
+// checkSource downloads a source's videos that are not in the archive.
+// With markSeen, the first check records them as seen instead.
+func (d *Downloader) checkSource(src *watchSource, markSeen bool, maxPerCheck int) error {
+	ids, err := d.sourceIDs(src)
+	if err != nil {
+		return err
+	}
+	var fresh []string
+	for _, id := range ids {
+		if !d.archive.Has(id) && !d.watched[id] {
+			fresh = append(fresh, id)
+		}
+	}
+	first := !src.checked
+	src.checked = true
+
+	if first && markSeen {
+		for _, id := range fresh {
+			if err := d.archive.Add(id); err != nil {
+				return fmt.Errorf("failed to update download archive: %v", err)
+			}
+		}
+		d.logger.Printf("Watching %s: marked %d existing videos as seen", src.spec, len(fresh))
+		return nil
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+	if maxPerCheck > 0 && len(fresh) > maxPerCheck {
+		fresh = fresh[:maxPerCheck]
+	}
+	d.logger.Printf("Found %d new videos in %s", len(fresh), src.spec)
+	// Whatever else is queued goes first
+	return d.processBatch(batchSource{Priority: priorityLow}, fresh, nil).Err()
+}
+
+func setupWatch(flags *flag.FlagSet) func([]string) error {
+	opts := registerDownloadFlags(flags)
+	opts.registerJobRetention(flags)
+	interval := flags.Duration("interval", time.Hour, "How often to check for new uploads")
+	markSeen := flags.Bool("mark-seen", false, "On the first check, record existing uploads as seen instead of downloading them")
+	maxPerCheck := flags.Int("max-per-check", 0, "Download at most N new videos per source on each check (0 for no limit)")
+	once := flags.Bool("once", false, "Check each source once and exit, e.g. from cron")
+	opmlPath := flags.String("opml", "", "Also watch the YouTube feeds in this OPML subscription list")
+
+	return func(args []string) error {
+		if *opmlPath != "" {
+			feeds, err := loadOPML(*opmlPath)
+			if err != nil {
+				return fmt.Errorf("failed to load OPML: %v", err)
+			}
+			args = append(args, feeds...)
+		}
+		subs, err := loadSubscriptions(commandConfigPath(flags), *opts.outputDir)
+		if err != nil {
+			return fmt.Errorf("failed to load subscriptions: %v", err)
+		}
+		if len(args) == 0 && len(subs.list()) == 0 {
+			flags.Usage()
+			return &exitError{exitTotalFailure, fmt.Errorf("expected at least one channel, playlist or feed, or subscriptions in the config file")}
+		}
+		if *interval < time.Minute {
+			return fmt.Errorf("-interval must be at least 1m")
+		}
+		if *opts.archivePath == "" {
+			*opts.archivePath = filepath.Join(*opts.outputDir, watchArchiveName)
+		}
+		// Each check has to see playlists as they are now
+		if *opts.cacheTTL > *interval {
+			*opts.cacheTTL = *interval
+		}
+
+		sources := make([]*watchSource, len(args))
+		for i, arg := range args {
+			sources[i] = &watchSource{spec: arg}
+		}
+
+		return opts.runDownloads("", func(d *Downloader) error {
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			var nextCheck time.Time
+			for {
+				if len(sources) > 0 && !time.Now().Before(nextCheck) {
+					for _, src := range sources {
+						if ctx.Err() != nil || d.Stopping() {
+							return nil
+						}
+						// A source failing to load is retried at the next check
+						if err := d.checkSource(src, *markSeen, *maxPerCheck); err != nil {
+							d.logger.Printf("Checking %s: %v", src.spec, err)
+						}
+					}
+					nextCheck = time.Now().Add(*interval)
+				}
+				if ctx.Err() != nil || d.Stopping() {
+					return nil
+				}
+				// Subscriptions run on their own schedules, with -once
+				// only making the checks that are due
+				wake := d.runDueSubscriptions(subs, *markSeen, *maxPerCheck)
+				if *once {
+					return nil
+				}
+				if wake.IsZero() || (len(sources) > 0 && nextCheck.Before(wake)) {
+					wake = nextCheck
+				}
+				d.logger.Printf("Next check at %s", wake.Format("Jan 2 15:04"))
+				select {
+				case <-time.After(time.Until(wake)):
+				case <-d.stopped:
+					return nil
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		})
+	}
+}
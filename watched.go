@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// takeoutEntry is one item of a Google Takeout watch-history.json.
+// Removed videos and ads have no titleUrl.
+type takeoutEntry struct {
+	Header   string `json:"header"`
+	Title    string `json:"title"`
+	TitleURL string `json:"titleUrl"`
+}
+
+func watchedListPath() (string, error) {
+	dir, err := ytdlConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "watched.txt"), nil
+}
+
+// parseTakeoutHistory returns the IDs of the videos in a watch history.
+func parseTakeoutHistory(r io.Reader) ([]string, error) {
+	var entries []takeoutEntry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		// YouTube Music plays are recorded in the same file
+		if e.TitleURL == "" || !strings.Contains(e.TitleURL, "watch?v=") {
+			continue
+		}
+		if id, err := youtube.ExtractVideoID(e.TitleURL); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// loadWatched reads the imported watch history, one video ID per line.
+// A missing file is an empty history.
+func loadWatched(path string) (map[string]bool, error) {
+	watched := make(map[string]bool)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return watched, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if id := strings.TrimSpace(scanner.Text()); id != "" {
+			watched[id] = true
+		}
+	}
+	return watched, scanner.Err()
+}
+
+func runImportHistory(args []string) error {
+	flags := flag.NewFlagSet("import-history", flag.ExitOnError)
+	replace := flags.Bool("replace", false, "Replace the imported history instead of adding to it")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: youtube-downloader import-history [-replace] <watch-history.json>")
+	}
+	path, err := watchedListPath()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ids, err := parseTakeoutHistory(f)
+	if err != nil {
+		return fmt.Errorf("failed to read %s (expected Takeout's watch-history.json): %v", flags.Arg(0), err)
+	}
+
+	watched := make(map[string]bool)
+	if !*replace {
+		if watched, err = loadWatched(path); err != nil {
+			return err
+		}
+	}
+	before := len(watched)
+	for _, id := range ids {
+		watched[id] = true
+	}
+
+	sorted := make([]string, 0, len(watched))
+	for id := range watched {
+		sorted = append(sorted, id)
+	}
+	sort.Strings(sorted)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(sorted, "\n")+"\n"), 0600); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d watched videos (%d new, %d total) into %s\n", len(ids), len(watched)-before, len(watched), path)
+	return nil
+}
@@ -0,0 +1,139 @@
+"use strict";
+
+const rows = new Map();
+const tbody = document.querySelector("#jobs tbody");
+const form = document.querySelector("#submit");
+const message = document.querySelector("#message");
+
+function formatBytes(n) {
+  const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return (i === 0 ? n : n.toFixed(1)) + " " + units[i];
+}
+
+// A server with -api-keys wants a token, which is kept in a cookie so
+// that the event stream and file links carry it too.
+function askToken() {
+  const token = prompt("API token");
+  if (token === null || token.trim() === "") {
+    return false;
+  }
+  document.cookie = "ytdl_token=" + token.trim() + "; path=/; SameSite=Strict";
+  return true;
+}
+
+async function api(method, path, body) {
+  const resp = await fetch(path, {
+    method,
+    headers: body ? { "Content-Type": "application/json" } : {},
+    body: body ? JSON.stringify(body) : undefined,
+  });
+  if (resp.status === 401 && askToken()) {
+    return api(method, path, body);
+  }
+  const data = await resp.json().catch(() => ({}));
+  if (!resp.ok) {
+    throw new Error(data.error || resp.statusText);
+  }
+  return data;
+}
+
+function button(label, onClick) {
+  const b = document.createElement("button");
+  b.type = "button";
+  b.textContent = label;
+  b.addEventListener("click", () => onClick().catch((err) => (message.textContent = err.message)));
+  return b;
+}
+
+function progress(job) {
+  if (job.status === "done" || job.status === "skipped") {
+    return 1;
+  }
+  if (job.process_total > 0 && job.phase !== "" && !job.phase.startsWith("downloading")) {
+    return job.processed / job.process_total;
+  }
+  return job.total > 0 ? job.downloaded / job.total : 0;
+}
+
+function render(job) {
+  let row = rows.get(job.id);
+  if (!row) {
+    row = document.createElement("tr");
+    row.innerHTML = '<td class="id"></td><td class="title"></td><td class="status"></td>' +
+      '<td><div class="bar"><div></div></div><div class="detail"></div></td><td class="actions"></td>';
+    rows.set(job.id, row);
+    tbody.prepend(row);
+  }
+  row.className = job.status;
+  row.querySelector(".id").textContent = job.id;
+  row.querySelector(".title").textContent = job.title;
+
+  let status = job.status;
+  if (job.status === "running") {
+    status = job.paused ? "paused" : job.phase || status;
+  }
+  row.querySelector(".status").textContent = status;
+  row.querySelector(".bar > div").style.width = (progress(job) * 100).toFixed(1) + "%";
+
+  let detail = "";
+  if (job.error) {
+    detail = job.error;
+  } else if (job.total > 0) {
+    detail = (job.downloaded_text || formatBytes(job.downloaded)) + " / " + (job.total_text || formatBytes(job.total));
+    if (job.status === "running" && job.speed > 0) {
+      detail += " at " + (job.speed_text || formatBytes(job.speed) + "/s");
+    }
+  }
+  row.querySelector(".detail").textContent = detail;
+
+  const actions = row.querySelector(".actions");
+  actions.replaceChildren();
+  if (job.status === "queued" || job.status === "running") {
+    if (job.paused) {
+      actions.append(button("Resume", () => api("POST", `/jobs/${job.id}/resume`)));
+    } else {
+      actions.append(button("Pause", () => api("POST", `/jobs/${job.id}/pause`)));
+    }
+    actions.append(button("Cancel", () => api("DELETE", `/jobs/${job.id}`)));
+  } else if (job.output_path && (job.status === "done" || job.status === "skipped")) {
+    const link = document.createElement("a");
+    link.href = `/jobs/${job.id}/file`;
+    link.textContent = "Download";
+    actions.append(link);
+  }
+}
+
+form.preset.addEventListener("change", () => {
+  form.format.hidden = form.preset.value !== "custom";
+});
+
+form.addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const format = form.preset.value === "custom" ? form.format.value.trim() : form.preset.value;
+  message.textContent = "";
+  try {
+    await api("POST", "/jobs", { url: form.url.value.trim(), kind: form.kind.value, format });
+    form.url.value = "";
+  } catch (err) {
+    message.textContent = err.message;
+  }
+});
+
+for (const [id, path] of [["#pause-all", "/pause"], ["#resume-all", "/resume"]]) {
+  document.querySelector(id).addEventListener("click", () =>
+    api("POST", path).catch((err) => (message.textContent = err.message)));
+}
+
+// Listing the jobs first makes sure of the token; the event stream then
+// starts with every job and sends each change
+api("GET", "/jobs")
+  .then(() => {
+    const events = new EventSource("/events");
+    events.addEventListener("job", (event) => render(JSON.parse(event.data)));
+  })
+  .catch((err) => (message.textContent = err.message));
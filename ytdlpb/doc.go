@@ -0,0 +1,5 @@
+// Package ytdlpb holds the protobuf types and gRPC service of the serve
+// command's gRPC API.
+package ytdlpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative ytdl.proto
@@ -0,0 +1,257 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: ytdl.proto
+
+package ytdlpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Downloader_EnqueueDownload_FullMethodName = "/ytdl.v1.Downloader/EnqueueDownload"
+	Downloader_GetProgress_FullMethodName     = "/ytdl.v1.Downloader/GetProgress"
+	Downloader_Cancel_FullMethodName          = "/ytdl.v1.Downloader/Cancel"
+	Downloader_ListJobs_FullMethodName        = "/ytdl.v1.Downloader/ListJobs"
+)
+
+// DownloaderClient is the client API for Downloader service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Downloader is the gRPC form of the serve command's HTTP API.
+type DownloaderClient interface {
+	// EnqueueDownload starts downloading a video, playlist or channel in
+	// the background. Its jobs show up in ListJobs and GetProgress.
+	EnqueueDownload(ctx context.Context, in *EnqueueDownloadRequest, opts ...grpc.CallOption) (*EnqueueDownloadResponse, error)
+	// GetProgress streams jobs as they change. Given job IDs, it ends once
+	// all of them have finished; otherwise it follows every job, including
+	// ones started later, until the client goes away.
+	GetProgress(ctx context.Context, in *GetProgressRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Job], error)
+	// Cancel stops a job.
+	Cancel(ctx context.Context, in *CancelRequest, opts ...grpc.CallOption) (*Job, error)
+	// ListJobs returns every job in submission order.
+	ListJobs(ctx context.Context, in *ListJobsRequest, opts ...grpc.CallOption) (*ListJobsResponse, error)
+}
+
+type downloaderClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDownloaderClient(cc grpc.ClientConnInterface) DownloaderClient {
+	return &downloaderClient{cc}
+}
+
+func (c *downloaderClient) EnqueueDownload(ctx context.Context, in *EnqueueDownloadRequest, opts ...grpc.CallOption) (*EnqueueDownloadResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EnqueueDownloadResponse)
+	err := c.cc.Invoke(ctx, Downloader_EnqueueDownload_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *downloaderClient) GetProgress(ctx context.Context, in *GetProgressRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Job], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Downloader_ServiceDesc.Streams[0], Downloader_GetProgress_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[GetProgressRequest, Job]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Downloader_GetProgressClient = grpc.ServerStreamingClient[Job]
+
+func (c *downloaderClient) Cancel(ctx context.Context, in *CancelRequest, opts ...grpc.CallOption) (*Job, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Job)
+	err := c.cc.Invoke(ctx, Downloader_Cancel_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *downloaderClient) ListJobs(ctx context.Context, in *ListJobsRequest, opts ...grpc.CallOption) (*ListJobsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListJobsResponse)
+	err := c.cc.Invoke(ctx, Downloader_ListJobs_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DownloaderServer is the server API for Downloader service.
+// All implementations must embed UnimplementedDownloaderServer
+// for forward compatibility.
+//
+// Downloader is the gRPC form of the serve command's HTTP API.
+type DownloaderServer interface {
+	// EnqueueDownload starts downloading a video, playlist or channel in
+	// the background. Its jobs show up in ListJobs and GetProgress.
+	EnqueueDownload(context.Context, *EnqueueDownloadRequest) (*EnqueueDownloadResponse, error)
+	// GetProgress streams jobs as they change. Given job IDs, it ends once
+	// all of them have finished; otherwise it follows every job, including
+	// ones started later, until the client goes away.
+	GetProgress(*GetProgressRequest, grpc.ServerStreamingServer[Job]) error
+	// Cancel stops a job.
+	Cancel(context.Context, *CancelRequest) (*Job, error)
+	// ListJobs returns every job in submission order.
+	ListJobs(context.Context, *ListJobsRequest) (*ListJobsResponse, error)
+	mustEmbedUnimplementedDownloaderServer()
+}
+
+// UnimplementedDownloaderServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedDownloaderServer struct{}
+
+func (UnimplementedDownloaderServer) EnqueueDownload(context.Context, *EnqueueDownloadRequest) (*EnqueueDownloadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EnqueueDownload not implemented")
+}
+func (UnimplementedDownloaderServer) GetProgress(*GetProgressRequest, grpc.ServerStreamingServer[Job]) error {
+	return status.Errorf(codes.Unimplemented, "method GetProgress not implemented")
+}
+func (UnimplementedDownloaderServer) Cancel(context.Context, *CancelRequest) (*Job, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Cancel not implemented")
+}
+func (UnimplementedDownloaderServer) ListJobs(context.Context, *ListJobsRequest) (*ListJobsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListJobs not implemented")
+}
+func (UnimplementedDownloaderServer) mustEmbedUnimplementedDownloaderServer() {}
+func (UnimplementedDownloaderServer) testEmbeddedByValue()                    {}
+
+// UnsafeDownloaderServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DownloaderServer will
+// result in compilation errors.
+type UnsafeDownloaderServer interface {
+	mustEmbedUnimplementedDownloaderServer()
+}
+
+func RegisterDownloaderServer(s grpc.ServiceRegistrar, srv DownloaderServer) {
+	// If the following call pancis, it indicates UnimplementedDownloaderServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Downloader_ServiceDesc, srv)
+}
+
+func _Downloader_EnqueueDownload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnqueueDownloadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DownloaderServer).EnqueueDownload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Downloader_EnqueueDownload_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DownloaderServer).EnqueueDownload(ctx, req.(*EnqueueDownloadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Downloader_GetProgress_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetProgressRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DownloaderServer).GetProgress(m, &grpc.GenericServerStream[GetProgressRequest, Job]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Downloader_GetProgressServer = grpc.ServerStreamingServer[Job]
+
+func _Downloader_Cancel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DownloaderServer).Cancel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Downloader_Cancel_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DownloaderServer).Cancel(ctx, req.(*CancelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Downloader_ListJobs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListJobsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DownloaderServer).ListJobs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Downloader_ListJobs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DownloaderServer).ListJobs(ctx, req.(*ListJobsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Downloader_ServiceDesc is the grpc.ServiceDesc for Downloader service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Downloader_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ytdl.v1.Downloader",
+	HandlerType: (*DownloaderServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "EnqueueDownload",
+			Handler:    _Downloader_EnqueueDownload_Handler,
+		},
+		{
+			MethodName: "Cancel",
+			Handler:    _Downloader_Cancel_Handler,
+		},
+		{
+			MethodName: "ListJobs",
+			Handler:    _Downloader_ListJobs_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "GetProgress",
+			Handler:       _Downloader_GetProgress_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "ytdl.proto",
+}
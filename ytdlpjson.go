@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// The -J output follows yt-dlp's info dict closely enough for tools that
+// read "yt-dlp -j --no-simulate": one object per line, with the formats,
+// what was downloaded and where it was saved. Fields this downloader
+// doesn't know are left out rather than guessed.
+
+type ytdlpFormat struct {
+	FormatID      string  `json:"format_id"`
+	FormatNote    string  `json:"format_note,omitempty"`
+	Format        string  `json:"format"`
+	Ext           string  `json:"ext"`
+	Protocol      string  `json:"protocol"`
+	URL           string  `json:"url,omitempty"`
+	VCodec        string  `json:"vcodec"`
+	ACodec        string  `json:"acodec"`
+	Width         int     `json:"width,omitempty"`
+	Height        int     `json:"height,omitempty"`
+	FPS           int     `json:"fps,omitempty"`
+	Resolution    string  `json:"resolution"`
+	TBR           float64 `json:"tbr,omitempty"`
+	VBR           float64 `json:"vbr,omitempty"`
+	ABR           float64 `json:"abr,omitempty"`
+	ASR           int     `json:"asr,omitempty"`
+	AudioChannels int     `json:"audio_channels,omitempty"`
+	Filesize      int64   `json:"filesize,omitempty"`
+	Language      string  `json:"language,omitempty"`
+}
+
+type ytdlpThumbnail struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	ID     string `json:"id"`
+}
+
+type ytdlpDownload struct {
+	RequestedFormats []ytdlpFormat `json:"requested_formats,omitempty"`
+	FormatID         string        `json:"format_id"`
+	Format           string        `json:"format"`
+	Ext              string        `json:"ext"`
+	Filepath         string        `json:"filepath"`
+	Filename         string        `json:"_filename"`
+	Filesize         int64         `json:"filesize_approx,omitempty"`
+}
+
+type ytdlpInfo struct {
+	Type               string           `json:"_type"`
+	ID                 string           `json:"id"`
+	Title              string           `json:"title"`
+	FullTitle          string           `json:"fulltitle"`
+	Description        string           `json:"description"`
+	Duration           float64          `json:"duration"`
+	DurationString     string           `json:"duration_string"`
+	ViewCount          int              `json:"view_count"`
+	Channel            string           `json:"channel"`
+	ChannelID          string           `json:"channel_id,omitempty"`
+	ChannelURL         string           `json:"channel_url,omitempty"`
+	Uploader           string           `json:"uploader"`
+	UploaderID         string           `json:"uploader_id,omitempty"`
+	UploadDate         string           `json:"upload_date,omitempty"`
+	Timestamp          int64            `json:"timestamp,omitempty"`
+	Thumbnail          string           `json:"thumbnail,omitempty"`
+	Thumbnails         []ytdlpThumbnail `json:"thumbnails"`
+	WebpageURL         string           `json:"webpage_url"`
+	OriginalURL        string           `json:"original_url"`
+	WebpageURLBasename string           `json:"webpage_url_basename"`
+	WebpageURLDomain   string           `json:"webpage_url_domain"`
+	Extractor          string           `json:"extractor"`
+	ExtractorKey       string           `json:"extractor_key"`
+	Playlist           *string          `json:"playlist"`
+	PlaylistID         *string          `json:"playlist_id"`
+	PlaylistTitle      *string          `json:"playlist_title"`
+	PlaylistIndex      *int             `json:"playlist_index"`
+	NEntries           int              `json:"n_entries,omitempty"`
+	Formats            []ytdlpFormat    `json:"formats"`
+	RequestedFormats   []ytdlpFormat    `json:"requested_formats,omitempty"`
+	RequestedDownloads []ytdlpDownload  `json:"requested_downloads"`
+	FormatID           string           `json:"format_id"`
+	Format             string           `json:"format"`
+	Ext                string           `json:"ext"`
+	VCodec             string           `json:"vcodec,omitempty"`
+	ACodec             string           `json:"acodec,omitempty"`
+	Width              int              `json:"width,omitempty"`
+	Height             int              `json:"height,omitempty"`
+	FPS                int              `json:"fps,omitempty"`
+	Resolution         string           `json:"resolution,omitempty"`
+	Filename           string           `json:"filename"`
+	UnderscoreFilename string           `json:"_filename"`
+	Epoch              int64            `json:"epoch"`
+}
+
+func newYtdlpFormat(f *youtube.Format) ytdlpFormat {
+	out := ytdlpFormat{
+		FormatID:      strconv.Itoa(f.ItagNo),
+		FormatNote:    f.QualityLabel,
+		Ext:           streamExtension(f),
+		Protocol:      "https",
+		URL:           f.URL,
+		VCodec:        formatString(f, "vcodec"),
+		ACodec:        formatString(f, "acodec"),
+		Width:         f.Width,
+		Height:        f.Height,
+		FPS:           f.FPS,
+		TBR:           formatNumber(f, "tbr"),
+		VBR:           formatNumber(f, "vbr"),
+		ABR:           formatNumber(f, "abr"),
+		ASR:           int(formatNumber(f, "asr")),
+		AudioChannels: f.AudioChannels,
+		Filesize:      f.ContentLength,
+	}
+	out.Language = audioLanguage(f)
+	if !hasVideo(f) {
+		out.Resolution = "audio only"
+		if out.FormatNote == "" {
+			out.FormatNote = f.AudioQuality
+		}
+	} else {
+		out.Resolution = fmt.Sprintf("%dx%d", f.Width, f.Height)
+	}
+	out.Format = fmt.Sprintf("%s - %s", out.FormatID, out.Resolution)
+	if out.FormatNote != "" {
+		out.Format += " (" + out.FormatNote + ")"
+	}
+	return out
+}
+
+// ytdlpDuration formats d the way yt-dlp does, e.g. "1:02:03" or "4:05".
+func ytdlpDuration(d time.Duration) string {
+	secs := int(d.Seconds())
+	switch h, m, s := secs/3600, secs/60%60, secs%60; {
+	case h > 0:
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	case m > 0:
+		return fmt.Sprintf("%d:%02d", m, s)
+	default:
+		return strconv.Itoa(s)
+	}
+}
+
+// newYtdlpInfo describes a finished download. formatIDs are the itags it
+// was made from, as recorded on the job, e.g. "136+140".
+func newYtdlpInfo(video *youtube.Video, snap JobSnapshot, source batchSource, index int, formatIDs string) ytdlpInfo {
+	info := ytdlpInfo{
+		Type:               "video",
+		ID:                 video.ID,
+		Title:              video.Title,
+		FullTitle:          video.Title,
+		Description:        video.Description,
+		Duration:           video.Duration.Seconds(),
+		DurationString:     ytdlpDuration(video.Duration),
+		ViewCount:          video.Views,
+		Channel:            video.Author,
+		ChannelID:          video.ChannelID,
+		Uploader:           video.Author,
+		Thumbnail:          largestThumbnail(video.Thumbnails),
+		Thumbnails:         []ytdlpThumbnail{},
+		WebpageURL:         "https://www.youtube.com/watch?v=" + video.ID,
+		OriginalURL:        snap.URL,
+		WebpageURLBasename: "watch",
+		WebpageURLDomain:   "youtube.com",
+		Extractor:          "youtube",
+		ExtractorKey:       "Youtube",
+		Formats:            []ytdlpFormat{},
+		RequestedDownloads: []ytdlpDownload{},
+		Epoch:              time.Now().Unix(),
+	}
+	if video.ChannelID != "" {
+		info.ChannelURL = "https://www.youtube.com/channel/" + video.ChannelID
+	}
+	if video.ChannelHandle != "" {
+		info.UploaderID = video.ChannelHandle
+	}
+	if !video.PublishDate.IsZero() {
+		info.UploadDate = video.PublishDate.Format(compactDateLayout)
+		info.Timestamp = video.PublishDate.Unix()
+	}
+	for i, t := range video.Thumbnails {
+		info.Thumbnails = append(info.Thumbnails, ytdlpThumbnail{URL: t.URL, Width: int(t.Width), Height: int(t.Height), ID: strconv.Itoa(i)})
+	}
+	if source.URL != "" {
+		title := source.Title
+		info.Playlist, info.PlaylistTitle = &title, &title
+		if u, err := url.Parse(source.URL); err == nil {
+			if id := u.Query().Get("list"); id != "" {
+				info.PlaylistID = &id
+			}
+		}
+		if index > 0 {
+			info.PlaylistIndex = &index
+		}
+		info.NEntries = source.Size
+	}
+
+	byItag := make(map[string]*youtube.Format, len(video.Formats))
+	for i := range video.Formats {
+		f := &video.Formats[i]
+		byItag[strconv.Itoa(f.ItagNo)] = f
+		info.Formats = append(info.Formats, newYtdlpFormat(f))
+	}
+	var names []string
+	for _, id := range strings.Split(formatIDs, "+") {
+		f, ok := byItag[id]
+		if !ok {
+			continue
+		}
+		yf := newYtdlpFormat(f)
+		info.RequestedFormats = append(info.RequestedFormats, yf)
+		names = append(names, yf.Format)
+		if hasVideo(f) {
+			info.VCodec, info.Width, info.Height, info.FPS, info.Resolution = yf.VCodec, yf.Width, yf.Height, yf.FPS, yf.Resolution
+		}
+		if hasAudio(f) || info.ACodec == "" {
+			info.ACodec = yf.ACodec
+		}
+	}
+	if len(info.RequestedFormats) == 1 {
+		// A single format is described by itself, as yt-dlp does
+		info.RequestedFormats = nil
+	}
+	info.FormatID = formatIDs
+	info.Format = strings.Join(names, "+")
+
+	path := snap.OutputPath
+	info.Ext = strings.TrimPrefix(filepath.Ext(path), ".")
+	info.Filename, info.UnderscoreFilename = path, path
+	if path != "" {
+		info.RequestedDownloads = append(info.RequestedDownloads, ytdlpDownload{
+			RequestedFormats: info.RequestedFormats,
+			FormatID:         info.FormatID,
+			Format:           info.Format,
+			Ext:              info.Ext,
+			Filepath:         path,
+			Filename:         path,
+			Filesize:         snap.Total,
+		})
+	}
+	return info
+}
+
+// dumpJSON prints the -J line for a job that left a file behind, whether
+// it was downloaded now or already there.
+func (d *Downloader) dumpJSON(job *Job, video *youtube.Video) {
+	if d.config.DumpJSON == nil {
+		return
+	}
+	snap := job.Snapshot()
+	if snap.Status != JobDone && (snap.Status != JobSkipped || !fileExists(snap.OutputPath)) {
+		return
+	}
+	data, err := json.Marshal(newYtdlpInfo(video, snap, job.source, job.Index, snap.Format))
+	if err != nil {
+		d.logger.Printf("Failed to encode JSON for %s: %v", video.Title, err)
+		return
+	}
+	d.jsonMu.Lock()
+	defer d.jsonMu.Unlock()
+	d.config.DumpJSON.Write(append(data, '\n'))
+}